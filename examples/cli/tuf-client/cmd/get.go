@@ -25,6 +25,8 @@ import (
 
 var targetsURL string
 var useNonHashPrefixedTargetFiles bool
+var configFile string
+var printConfig bool
 
 type localConfig struct {
 	MetadataDir string
@@ -39,7 +41,7 @@ var getCmd = &cobra.Command{
 	Short:   "Download a target file",
 	Args:    cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if RepositoryURL == "" {
+		if RepositoryURL == "" && configFile == "" {
 			fmt.Println("Error: required flag(s) \"url\" not set")
 			os.Exit(1)
 		}
@@ -50,6 +52,8 @@ var getCmd = &cobra.Command{
 func init() {
 	getCmd.Flags().StringVarP(&targetsURL, "turl", "t", "", "URL of where the target files are hosted")
 	getCmd.Flags().BoolVarP(&useNonHashPrefixedTargetFiles, "nonprefixed", "", false, "Do not use hash-prefixed target files with consistent snapshots")
+	getCmd.Flags().StringVarP(&configFile, "config", "c", "", "Path to an updater configuration file (YAML or JSON)")
+	getCmd.Flags().BoolVarP(&printConfig, "print-config", "", false, "Print the resolved updater configuration before downloading")
 	rootCmd.AddCommand(getCmd)
 }
 
@@ -59,26 +63,14 @@ func GetCmd(target string) error {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	// verify the client environment was initialized and fetch path names
-	env, err := verifyEnv()
-	if err != nil {
-		return err
-	}
-	// read the trusted root metadata
-	rootBytes, err := os.ReadFile(filepath.Join(env.MetadataDir, "root.json"))
-	if err != nil {
-		return err
-	}
-
 	// updater configuration
-	cfg, err := config.New(env.MetadataURL, rootBytes) // default config
+	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
-	cfg.LocalMetadataDir = env.MetadataDir
-	cfg.LocalTargetsDir = env.DownloadDir
-	cfg.RemoteTargetsURL = env.TargetsURL
-	cfg.PrefixTargetsWithHash = !useNonHashPrefixedTargetFiles
+	if printConfig {
+		fmt.Println(cfg)
+	}
 
 	// create an Updater instance
 	up, err := updater.New(cfg)
@@ -120,6 +112,34 @@ func GetCmd(target string) error {
 	return nil
 }
 
+// loadConfig builds the updater configuration either from the configuration
+// file or from the initialized client environment and the command flags
+func loadConfig() (*config.UpdaterConfig, error) {
+	if configFile != "" {
+		return config.Load(configFile)
+	}
+	// verify the client environment was initialized and fetch path names
+	env, err := verifyEnv()
+	if err != nil {
+		return nil, err
+	}
+	// read the trusted root metadata
+	rootBytes, err := os.ReadFile(filepath.Join(env.MetadataDir, "root.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.New(env.MetadataURL, rootBytes) // default config
+	if err != nil {
+		return nil, err
+	}
+	cfg.LocalMetadataDir = env.MetadataDir
+	cfg.LocalTargetsDir = env.DownloadDir
+	cfg.RemoteTargetsURL = env.TargetsURL
+	cfg.PrefixTargetsWithHash = !useNonHashPrefixedTargetFiles
+	return cfg, nil
+}
+
 func verifyEnv() (*localConfig, error) {
 	// get working directory
 	cwd, err := os.Getwd()
@@ -13,19 +13,21 @@ package cmd
 
 import (
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
-	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/bootstrap"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var rootPath string
+var trustOnFirstUse bool
+var pinSHA256 string
+var pinKeyIDs []string
+var pinThreshold int
 
 var initCmd = &cobra.Command{
 	Use:     "init",
@@ -43,53 +45,59 @@ var initCmd = &cobra.Command{
 
 func init() {
 	initCmd.Flags().StringVarP(&rootPath, "file", "f", "", "location of the trusted root metadata file")
+	initCmd.Flags().BoolVarP(&trustOnFirstUse, "tofu", "", false, "download 1.root.json from the repository and trust it without verification (insecure)")
+	initCmd.Flags().StringVarP(&pinSHA256, "pin-sha256", "", "", "expected SHA-256 digest of the root metadata")
+	initCmd.Flags().StringSliceVarP(&pinKeyIDs, "pin-keyid", "", nil, "expected root key IDs, the root must be signed by a threshold of them")
+	initCmd.Flags().IntVarP(&pinThreshold, "pin-threshold", "", 1, "number of pinned root key IDs the root must be signed by")
 	rootCmd.AddCommand(initCmd)
 }
 
 func InitializeCmd() error {
-	copyTrusted := true
 	// handle verbosity level
 	if Verbosity {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	// prepare the local environment
-	localMetadataDir, err := prepareEnvironment()
-	if err != nil {
-		return err
+	// pick how the initial trusted root is obtained
+	var strategy bootstrap.Strategy
+	switch {
+	case rootPath != "":
+		strategy = bootstrap.StrategyFunc(func() ([]byte, error) {
+			rootBytes, err := ReadFile(rootPath)
+			if err != nil {
+				return nil, err
+			}
+			return bootstrap.FromBytes(rootBytes).TrustedRoot()
+		})
+	case trustOnFirstUse:
+		fmt.Printf("No root.json file was provided. Trusting the one downloaded from %s on first use\n", RepositoryURL)
+		strategy = bootstrap.TrustOnFirstUse(&fetcher.DefaultFetcher{}, RepositoryURL, 512000)
+	default:
+		return fmt.Errorf("no trusted root metadata provided, use --file or explicitly request trust-on-first-use with --tofu")
 	}
-
-	// if there's no root.json file passed, try to download the 1.root.json from the repository URL
-	if rootPath == "" {
-
-		fmt.Printf("No root.json file was provided. Trying to download one from %s\n", RepositoryURL)
-		rootPath, err = fetchTrustedRoot(localMetadataDir)
-		if err != nil {
-			return err
-		}
-		rootPath = fmt.Sprintf("%s/%s.json", rootPath, metadata.ROOT)
-		// no need to copy root.json to the metadata folder as we already download it in the expected location
-		copyTrusted = false
+	if pinSHA256 != "" {
+		strategy = bootstrap.PinDigest(strategy, pinSHA256)
+	}
+	if len(pinKeyIDs) > 0 {
+		strategy = bootstrap.PinKeys(strategy, pinKeyIDs, pinThreshold)
 	}
 
-	// read the content of root.json
-	rootBytes, err := ReadFile(rootPath)
+	// get and verify the trusted root
+	rootBytes, err := strategy.TrustedRoot()
 	if err != nil {
 		return err
 	}
 
-	// verify the content
-	_, err = trustedmetadata.New(rootBytes)
+	// prepare the local environment
+	localMetadataDir, err := prepareEnvironment()
 	if err != nil {
 		return err
 	}
 
-	// Save the trusted root.json file to the metadata folder so it is available for future operations (if we haven't downloaded it)
-	if copyTrusted {
-		err = os.WriteFile(filepath.Join(localMetadataDir, rootPath), rootBytes, 0644)
-		if err != nil {
-			return err
-		}
+	// Save the trusted root.json file to the metadata folder so it is available for future operations
+	err = os.WriteFile(filepath.Join(localMetadataDir, fmt.Sprintf("%s.json", metadata.ROOT)), rootBytes, 0644)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("Initialization successful")
@@ -120,38 +128,3 @@ func prepareEnvironment() (string, error) {
 	}
 	return metadataPath, nil
 }
-
-// fetchTrustedRoot downloads the initial root metadata
-func fetchTrustedRoot(metadataDir string) (string, error) {
-	// download the initial root metadata so we can bootstrap Trust-On-First-Use
-	rootURL, err := url.JoinPath(RepositoryURL, "1.root.json")
-	if err != nil {
-		return "", fmt.Errorf("failed to create URL path for 1.root.json: %w", err)
-	}
-
-	req, err := http.NewRequest("GET", rootURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create http request: %w", err)
-	}
-
-	client := http.DefaultClient
-
-	res, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to executed the http request: %w", err)
-	}
-
-	defer res.Body.Close()
-
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read the http request body: %w", err)
-	}
-
-	// write the downloaded root metadata to file
-	err = os.WriteFile(filepath.Join(metadataDir, "root.json"), data, 0644)
-	if err != nil {
-		return "", fmt.Errorf("failed to write root.json metadata: %w", err)
-	}
-	return metadataDir, nil
-}
@@ -0,0 +1,95 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var commitCmd = &cobra.Command{
+	Use:   "commit",
+	Short: "Publish the staged changes",
+	Long:  "Publish the staged changes once every staged role is signed and snapshot and timestamp are up to date",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return CommitCmd()
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the published and staged versions, signatures and state of every role",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return StatusCmd()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(commitCmd)
+	rootCmd.AddCommand(statusCmd)
+}
+
+func CommitCmd() error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	if len(w.stage.Staged()) == 0 {
+		fmt.Println("Nothing to commit")
+		return nil
+	}
+	err = w.stage.Commit()
+	if err != nil {
+		return fmt.Errorf("commit failed, the published repository is unchanged: %w", err)
+	}
+	fmt.Printf("Published snapshot v%d and timestamp v%d to %s\n", w.repo.Snapshot().Signed.Version, w.repo.Timestamp().Signed.Version, filepath.Join(RepoDir, RepositoryDir))
+	return nil
+}
+
+func StatusCmd() error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "ROLE\tPUBLISHED\tSTAGED\tSIGNATURES\tEXPIRES\tSTATE")
+	for _, status := range w.stage.Status() {
+		published, staged, state := "-", "-", "clean"
+		if status.PublishedVersion != 0 {
+			published = fmt.Sprintf("v%d", status.PublishedVersion)
+		}
+		if status.Staged {
+			staged = fmt.Sprintf("v%d", status.Version)
+			state = strings.Join(append([]string{"dirty"}, status.Problems...), ", ")
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%d/%d\t%s\t%s\n", status.Role, published, staged, status.Signatures, status.Threshold, status.Expires.Format(time.RFC3339), state)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var delegator string
+var delegatePaths []string
+var delegatePathHashPrefixes []string
+var delegateThreshold int
+var delegateTerminating bool
+var delegateKeyIDs []string
+var delegateGenerateKeys int
+
+var delegateCmd = &cobra.Command{
+	Use:   "delegate <role>",
+	Short: "Stage a new delegated targets role",
+	Long:  "Stage a new delegated targets role signed by existing keys (--key-id) or newly generated ones (--generate-keys, one by default)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return DelegateCmd(args[0])
+	},
+}
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke <role>",
+	Short: "Stage the removal of a delegated targets role",
+	Long:  "Stage the removal of a delegated targets role. Its metadata stays in the repository as clients expect every role listed in a snapshot they have seen before",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RevokeCmd(args[0])
+	},
+}
+
+func init() {
+	delegateCmd.Flags().StringVar(&delegator, "delegator", metadata.TARGETS, "targets role delegating to the new role")
+	delegateCmd.Flags().StringArrayVar(&delegatePaths, "path", []string{}, "path pattern delegated to the role")
+	delegateCmd.Flags().StringArrayVar(&delegatePathHashPrefixes, "path-hash-prefix", []string{}, "path hash prefix delegated to the role")
+	delegateCmd.Flags().IntVar(&delegateThreshold, "threshold", 1, "number of signatures required")
+	delegateCmd.Flags().BoolVar(&delegateTerminating, "terminating", false, "stop the search for a target at this role")
+	delegateCmd.Flags().StringArrayVar(&delegateKeyIDs, "key-id", []string{}, "ID of an existing key signing the role")
+	delegateCmd.Flags().IntVar(&delegateGenerateKeys, "generate-keys", 0, "number of new keys signing the role")
+	rootCmd.AddCommand(delegateCmd)
+	rootCmd.AddCommand(revokeCmd)
+}
+
+func DelegateCmd(roleName string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	if w.repo.Targets(roleName) != nil {
+		return fmt.Errorf("targets metadata for %s already exists", roleName)
+	}
+	delegatorMetadata := w.repo.Targets(delegator)
+	if delegatorMetadata == nil {
+		return fmt.Errorf("no targets metadata found for %s", delegator)
+	}
+	keys := []*metadata.Key{}
+	for _, keyID := range delegateKeyIDs {
+		key, err := w.key(keyID, roleName)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if delegateGenerateKeys == 0 && len(keys) == 0 {
+		delegateGenerateKeys = 1
+	}
+	for i := 0; i < delegateGenerateKeys; i++ {
+		key, err := w.generateKey(roleName)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	err = w.markChanged(delegator)
+	if err != nil {
+		return err
+	}
+	role := metadata.DelegatedRole{
+		Name:        roleName,
+		Threshold:   delegateThreshold,
+		Terminating: delegateTerminating,
+	}
+	if len(delegatePaths) > 0 {
+		role.Paths = delegatePaths
+	}
+	if len(delegatePathHashPrefixes) > 0 {
+		role.PathHashPrefixes = delegatePathHashPrefixes
+	}
+	err = delegatorMetadata.Signed.AddDelegatedRole(role, keys...)
+	if err != nil {
+		return err
+	}
+	w.repo.SetTargets(roleName, metadata.Targets(w.policy.Expires(roleName, time.Now())))
+	w.stage.SetStaged(roleName)
+	err = w.sign(roleName)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged the delegation from %s to %s\n", delegator, roleName)
+	return nil
+}
+
+func RevokeCmd(roleName string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	name, _ := w.delegator(roleName)
+	if name == "" {
+		return fmt.Errorf("no delegation found for %s", roleName)
+	}
+	err = w.markChanged(name)
+	if err != nil {
+		return err
+	}
+	err = w.repo.Targets(name).Signed.RemoveDelegatedRole(roleName)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged the removal of the delegation from %s to %s\n", name, roleName)
+	return nil
+}
@@ -13,15 +13,22 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var consistentSnapshot bool
+
 var initCmd = &cobra.Command{
 	Use:     "init",
 	Aliases: []string{"i"},
 	Short:   "Initialize a repository",
+	Long:    "Initialize a repository by generating a key for each top-level role and staging signed root, targets, snapshot and timestamp metadata",
 	Args:    cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return InitializeCmd()
@@ -29,6 +36,7 @@ var initCmd = &cobra.Command{
 }
 
 func init() {
+	initCmd.Flags().BoolVar(&consistentSnapshot, "consistent-snapshot", true, "enable consistent snapshots")
 	rootCmd.AddCommand(initCmd)
 }
 
@@ -38,7 +46,53 @@ func InitializeCmd() error {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	fmt.Println("Initialization successful")
+	for _, name := range []string{RepositoryDir, StagedDir} {
+		if _, err := os.Stat(filepath.Join(RepoDir, name)); err == nil {
+			return fmt.Errorf("a repository already exists in %s", RepoDir)
+		}
+	}
+	w, err := newWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	root := metadata.Root(w.policy.Expires(metadata.ROOT, now))
+	root.Signed.ConsistentSnapshot = consistentSnapshot
+	for _, name := range topLevelRoles {
+		key, err := w.generateKey(name)
+		if err != nil {
+			return err
+		}
+		err = root.Signed.AddKey(key, name)
+		if err != nil {
+			return err
+		}
+	}
+	w.repo.SetRoot(root)
+	w.repo.SetTargets(metadata.TARGETS, metadata.Targets(w.policy.Expires(metadata.TARGETS, now)))
+	w.repo.SetSnapshot(metadata.Snapshot(w.policy.Expires(metadata.SNAPSHOT, now)))
+	w.repo.SetTimestamp(metadata.Timestamp(w.policy.Expires(metadata.TIMESTAMP, now)))
+	err = w.addSigners(topLevelRoles...)
+	if err != nil {
+		return err
+	}
+	err = w.sign(metadata.ROOT)
+	if err != nil {
+		return err
+	}
+	err = w.repo.Publish(metadata.TARGETS)
+	if err != nil {
+		return err
+	}
+	for _, name := range topLevelRoles {
+		w.stage.SetStaged(name)
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Initialization successful, run tuf commit to publish the repository to %s\n", filepath.Join(RepoDir, RepositoryDir))
 
 	return nil
 }
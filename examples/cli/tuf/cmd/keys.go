@@ -0,0 +1,228 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keystore"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/signer"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	// PassphraseEnv is the environment variable holding the keystore passphrase
+	PassphraseEnv = "TUF_PASSPHRASE"
+	// NewPassphraseEnv is the environment variable holding the new keystore
+	// passphrase for keys change-passphrase
+	NewPassphraseEnv = "TUF_NEW_PASSPHRASE"
+)
+
+var keysRole string
+var importRoles []string
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the keystore holding the encrypted private keys",
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys in the keystore",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return KeysListCmd()
+	},
+}
+
+var keysImportCmd = &cobra.Command{
+	Use:   "import <key-file>",
+	Short: "Encrypt a PEM, PKCS#8, encrypted PEM or securesystemslib JSON private key into the keystore",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return KeysImportCmd(args[0])
+	},
+}
+
+var keysChangePassphraseCmd = &cobra.Command{
+	Use:   "change-passphrase",
+	Short: "Re-encrypt all keys in the keystore with a new passphrase",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return KeysChangePassphraseCmd()
+	},
+}
+
+func init() {
+	keysListCmd.Flags().StringVar(&keysRole, "role", "", "list only the keys of this role")
+	keysImportCmd.Flags().StringArrayVar(&importRoles, "role", []string{}, "role the key is used for, can be repeated")
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysImportCmd)
+	keysCmd.AddCommand(keysChangePassphraseCmd)
+	rootCmd.AddCommand(keysCmd)
+}
+
+func KeysListCmd() error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	store, err := keystore.Open(filepath.Join(RepoDir, KeysDir))
+	if err != nil {
+		return err
+	}
+	entries, err := store.List(keysRole)
+	if err != nil {
+		return err
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "KEY ID\tTYPE\tROLES\tCREATED\tSOURCE")
+	for _, entry := range entries {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\tkeystore\n", entry.KeyID, entry.Public.Type, strings.Join(entry.Roles, ","), entry.Created.Format("2006-01-02"))
+	}
+	// keys given with --key-ref are not stored in the keystore
+	for _, ref := range KeyRefs {
+		_, key, err := signer.Load(context.Background(), ref, signer.Options{})
+		if err != nil {
+			return fmt.Errorf("failed to load the signer of %s: %w", ref, err)
+		}
+		fmt.Fprintf(out, "%s\t%s\t-\t-\t%s\n", key.ID(), key.Type, ref)
+	}
+	return out.Flush()
+}
+
+func KeysImportCmd(name string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	data, err := ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var filePassphrase []byte
+	if keys.IsEncrypted(data) {
+		filePassphrase, err = promptPassphrase(fmt.Sprintf("Enter the passphrase of %s: ", name), false)
+		if err != nil {
+			return err
+		}
+	}
+	key, err := keys.Load(data, filePassphrase)
+	if err != nil {
+		return fmt.Errorf("failed to load private key %s: %w", name, err)
+	}
+	store, err := keystore.Open(filepath.Join(RepoDir, KeysDir))
+	if err != nil {
+		return err
+	}
+	entries, err := store.List("")
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase("Enter the keystore passphrase: ", len(entries) == 0)
+	if err != nil {
+		return err
+	}
+	// all keys of the keystore share the passphrase
+	if len(entries) > 0 {
+		session, err := store.Unlock(passphrase, entries[0].KeyID)
+		if err != nil {
+			return err
+		}
+		session.Close()
+	}
+	err = store.Add(key, passphrase, importRoles...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported key with ID %s, the original file %s can be removed\n", key.ID(), name)
+	return nil
+}
+
+func KeysChangePassphraseCmd() error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	store, err := keystore.Open(filepath.Join(RepoDir, KeysDir))
+	if err != nil {
+		return err
+	}
+	oldPassphrase, err := readPassphrase("Enter the current keystore passphrase: ", false)
+	if err != nil {
+		return err
+	}
+	newPassphrase := []byte(os.Getenv(NewPassphraseEnv))
+	if len(newPassphrase) == 0 {
+		newPassphrase, err = promptPassphrase("Enter the new keystore passphrase: ", true)
+		if err != nil {
+			return err
+		}
+	}
+	err = store.ChangePassphrase(oldPassphrase, newPassphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Changed the keystore passphrase")
+	return nil
+}
+
+// readPassphrase returns the passphrase from the TUF_PASSPHRASE environment
+// variable or asks for it on the terminal
+func readPassphrase(prompt string, confirm bool) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+	return promptPassphrase(prompt, confirm)
+}
+
+// promptPassphrase asks for a passphrase on the terminal, twice if confirm
+// is set
+func promptPassphrase(prompt string, confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to read the passphrase from, set %s", PassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("the passphrase can not be empty")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, fmt.Errorf("the passphrases don't match")
+		}
+	}
+	return passphrase, nil
+}
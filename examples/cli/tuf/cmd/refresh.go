@@ -0,0 +1,193 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keystore"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/signer"
+	"github.com/sigstore/sigstore/pkg/signature"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var refreshKeystore string
+var snapshotExpiry time.Duration
+var timestampExpiry time.Duration
+
+var expiringCmd = &cobra.Command{
+	Use:   "expiring <repository-dir>",
+	Short: "List the roles which are due for renewal",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ExpiringCmd(args[0])
+	},
+}
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh <repository-dir>",
+	Short: "Renew and re-sign snapshot and timestamp if they are due for renewal",
+	Long:  "Renew and re-sign snapshot and timestamp with their online keys if they are due for renewal, e.g. from a cron job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RefreshCmd(args[0])
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{expiringCmd, refreshCmd} {
+		c.Flags().DurationVar(&snapshotExpiry, "snapshot-expiry", 0, "validity of new snapshot versions (default 168h)")
+		c.Flags().DurationVar(&timestampExpiry, "timestamp-expiry", 0, "validity of new timestamp versions (default 24h)")
+	}
+	refreshCmd.Flags().StringVar(&refreshKeystore, "keystore", "", "keystore holding the snapshot and timestamp keys (default <dir>/keys)")
+	rootCmd.AddCommand(expiringCmd)
+	rootCmd.AddCommand(refreshCmd)
+}
+
+func ExpiringCmd(dir string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	repo, _, err := repository.Load(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(repo.ExpiringRoles(expiryPolicy(), time.Now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+	return nil
+}
+
+func RefreshCmd(dir string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	_, report, err := repository.Load(dir)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("the repository in %s has %d missing, %d orphaned and %d mismatching files, run tuf verify", dir, len(report.Missing), len(report.Orphaned), len(report.Mismatches))
+	}
+	// the refreshed roles are committed from a temporary staging directory
+	// so only their files are written
+	stagedDir, err := os.MkdirTemp("", "tuf-refresh")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagedDir)
+	stage, err := repository.OpenStage(dir, stagedDir)
+	if err != nil {
+		return err
+	}
+	repo := stage.Repository()
+	if refreshKeystore == "" {
+		refreshKeystore = filepath.Join(RepoDir, KeysDir)
+	}
+	store, err := keystore.Open(refreshKeystore)
+	if err != nil {
+		return err
+	}
+	// --key-ref signers are used for the keys they hold, the others are
+	// unlocked in the keystore
+	external := map[string]signature.Signer{}
+	for _, ref := range KeyRefs {
+		s, key, err := signer.Load(context.Background(), ref, signer.Options{})
+		if err != nil {
+			return fmt.Errorf("failed to load the signer of %s: %w", ref, err)
+		}
+		external[key.ID()] = s
+	}
+	keyIDs := map[string][]string{}
+	unlock := []string{}
+	for _, role := range []string{metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		found := false
+		for _, keyID := range repo.Root().Signed.Roles[role].KeyIDs {
+			if s, ok := external[keyID]; ok {
+				repo.AddSigner(role, s)
+				found = true
+			} else if _, err := store.Get(keyID); err == nil {
+				keyIDs[role] = append(keyIDs[role], keyID)
+				unlock = append(unlock, keyID)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no %s key found in the keystore %s or the --key-ref signers", role, refreshKeystore)
+		}
+	}
+	if len(unlock) > 0 {
+		passphrase, err := readPassphrase("Enter the keystore passphrase: ", false)
+		if err != nil {
+			return err
+		}
+		session, err := store.Unlock(passphrase, unlock...)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		for role, ids := range keyIDs {
+			for _, keyID := range ids {
+				s, err := session.Signer(keyID)
+				if err != nil {
+					return err
+				}
+				repo.AddSigner(role, s)
+			}
+		}
+	}
+	refreshed, err := repo.RefreshOnlineRoles(expiryPolicy(), time.Now())
+	if err != nil {
+		return err
+	}
+	if len(refreshed) == 0 {
+		fmt.Println("Nothing to refresh")
+		return nil
+	}
+	for _, role := range refreshed {
+		stage.SetStaged(role)
+	}
+	err = stage.Commit()
+	if err != nil {
+		return fmt.Errorf("refresh failed, the published repository is unchanged: %w", err)
+	}
+	fmt.Printf("Refreshed %s\n", strings.Join(refreshed, ", "))
+	return nil
+}
+
+// expiryPolicy returns the default policy with the overrides from the flags
+func expiryPolicy() repository.ExpiryPolicy {
+	policy := repository.DefaultExpiryPolicy()
+	for role, validity := range map[string]time.Duration{metadata.SNAPSHOT: snapshotExpiry, metadata.TIMESTAMP: timestampExpiry} {
+		if validity != 0 {
+			roleExpiry := policy.Roles[role]
+			roleExpiry.Validity = validity
+			policy.Roles[role] = roleExpiry
+		}
+	}
+	return policy
+}
@@ -0,0 +1,265 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keystore"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/signer"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+var requestOutput string
+var requestKeystore string
+
+var requestCmd = &cobra.Command{
+	Use:   "request",
+	Short: "Collect the signatures of offline keys with signing request files",
+}
+
+var requestCreateCmd = &cobra.Command{
+	Use:   "create <role>",
+	Short: "Write a signing request for the staged metadata of a role",
+	Long:  "Write a signing request for the staged metadata of a role. A role without staged changes is staged with a new version first",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RequestCreateCmd(args[0])
+	},
+}
+
+var requestSignCmd = &cobra.Command{
+	Use:   "sign <request-file>",
+	Short: "Sign a signing request with the required keys found in the keystore or the --key-ref signers",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RequestSignCmd(args[0])
+	},
+}
+
+var requestInspectCmd = &cobra.Command{
+	Use:   "inspect <request-file>",
+	Short: "Show the changes and the signatures still needed by a signing request",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RequestInspectCmd(args[0])
+	},
+}
+
+var requestFinalizeCmd = &cobra.Command{
+	Use:   "finalize <request-file>...",
+	Short: "Collect the signatures of signing requests into the staged metadata",
+	Long:  "Collect the signatures of one or more signed copies of a signing request into the staged metadata once the thresholds are met",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RequestFinalizeCmd(args)
+	},
+}
+
+func init() {
+	requestCreateCmd.Flags().StringVarP(&requestOutput, "output", "o", "", "signing request file (default <role>.request.json)")
+	requestSignCmd.Flags().StringVar(&requestKeystore, "keystore", "", "keystore holding the keys (default <dir>/keys)")
+	requestCmd.AddCommand(requestCreateCmd)
+	requestCmd.AddCommand(requestSignCmd)
+	requestCmd.AddCommand(requestInspectCmd)
+	requestCmd.AddCommand(requestFinalizeCmd)
+	rootCmd.AddCommand(requestCmd)
+}
+
+func RequestCreateCmd(roleName string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	if !w.stage.IsStaged(roleName) {
+		err = w.markChanged(roleName)
+		if err != nil {
+			return err
+		}
+		err = w.stage.Save()
+		if err != nil {
+			return err
+		}
+	}
+	req, err := w.repo.NewSigningRequest(roleName, w.stage.Published())
+	if err != nil {
+		return err
+	}
+	if requestOutput == "" {
+		requestOutput = roleName + ".request.json"
+	}
+	err = req.Save(requestOutput)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the signing request of %s v%d to %s\n", req.Role, req.Version, requestOutput)
+	return nil
+}
+
+func RequestSignCmd(name string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	req, err := repository.LoadSigningRequest(name)
+	if err != nil {
+		return err
+	}
+	signed := []string{}
+	// --key-ref signers sign for the keys they hold
+	for _, ref := range KeyRefs {
+		s, key, err := signer.Load(context.Background(), ref, signer.Options{})
+		if err != nil {
+			return fmt.Errorf("failed to load the signer of %s: %w", ref, err)
+		}
+		if err := req.Sign(s); err != nil {
+			log.Debugf("Skipped %s: %v", ref, err)
+			continue
+		}
+		signed = append(signed, key.ID())
+	}
+	// the required keys found in the keystore are unlocked together
+	if requestKeystore == "" {
+		requestKeystore = filepath.Join(RepoDir, KeysDir)
+	}
+	store, err := keystore.Open(requestKeystore)
+	if err != nil {
+		return err
+	}
+	unlock := []string{}
+	for _, signers := range req.Signers {
+		for keyID := range signers.Keys {
+			if _, err := store.Get(keyID); err == nil && !slices.Contains(unlock, keyID) && !slices.Contains(signed, keyID) {
+				unlock = append(unlock, keyID)
+			}
+		}
+	}
+	if len(unlock) > 0 {
+		passphrase, err := readPassphrase("Enter the keystore passphrase: ", false)
+		if err != nil {
+			return err
+		}
+		session, err := store.Unlock(passphrase, unlock...)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		for _, keyID := range unlock {
+			s, err := session.Signer(keyID)
+			if err != nil {
+				return err
+			}
+			err = req.Sign(s)
+			if err != nil {
+				return err
+			}
+			signed = append(signed, keyID)
+		}
+	}
+	if len(signed) == 0 {
+		return fmt.Errorf("no key required by the signing request found in the keystore %s or the --key-ref signers", requestKeystore)
+	}
+	err = req.Save(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed %s v%d with key IDs %s\n", req.Role, req.Version, strings.Join(signed, ", "))
+	return nil
+}
+
+func RequestInspectCmd(name string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	req, err := repository.LoadSigningRequest(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Role:    %s\nVersion: %d\nCreated: %s\n\n", req.Role, req.Version, req.Created.Format(time.RFC3339))
+	// the diff was checked against the payload when the request was loaded
+	if req.Diff == "" {
+		fmt.Print("No changes\n\n")
+	} else {
+		fmt.Printf("%s\n", req.Diff)
+	}
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "SIGNERS\tSIGNATURES\tNEEDED\tMISSING KEY IDS")
+	for _, status := range req.Status() {
+		missing := "-"
+		if len(status.Missing) > 0 {
+			missing = strings.Join(status.Missing, ", ")
+		}
+		fmt.Fprintf(out, "%s\t%d/%d\t%d\t%s\n", status.Name, len(status.Signed), status.Threshold, status.Needed(), missing)
+	}
+	err = out.Flush()
+	if err != nil {
+		return err
+	}
+	if req.Complete() {
+		fmt.Println("\nThe signing request is complete, run tuf request finalize")
+	}
+	return nil
+}
+
+func RequestFinalizeCmd(names []string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	req, err := repository.LoadSigningRequest(names[0])
+	if err != nil {
+		return err
+	}
+	for _, name := range names[1:] {
+		other, err := repository.LoadSigningRequest(name)
+		if err != nil {
+			return err
+		}
+		err = req.Merge(other)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	err = w.repo.ApplySigningRequest(req, w.stage.Published())
+	if err != nil {
+		return err
+	}
+	w.stage.SetStaged(req.Role)
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Collected the signatures of %s v%d\n", req.Role, req.Version)
+	return nil
+}
@@ -19,6 +19,8 @@ import (
 )
 
 var Verbosity bool
+var RepoDir string
+var KeyRefs []string
 
 var rootCmd = &cobra.Command{
 	Use:   "tuf",
@@ -31,6 +33,8 @@ var rootCmd = &cobra.Command{
 
 func Execute() {
 	rootCmd.PersistentFlags().BoolVarP(&Verbosity, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().StringVarP(&RepoDir, "dir", "d", ".", "directory holding the published repository, staged changes and keys")
+	rootCmd.PersistentFlags().StringArrayVar(&KeyRefs, "key-ref", []string{}, "KMS or PKCS#11 key to sign with in addition to the keystore, e.g. awskms:///alias/timestamp, can be repeated")
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
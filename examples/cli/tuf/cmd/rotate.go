@@ -0,0 +1,197 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+var rotateAddKeyIDs []string
+var rotateRemoveKeyIDs []string
+var rotateGenerateKeys int
+var rotateThreshold int
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key <role>",
+	Short: "Stage a change of the keys of a role",
+	Long:  "Stage a change of the keys of a role. Keys of top-level roles are rotated with a new root version signed by the current and the new root keys",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RotateKeyCmd(args[0])
+	},
+}
+
+func init() {
+	rotateKeyCmd.Flags().StringArrayVar(&rotateAddKeyIDs, "add-key-id", []string{}, "ID of an existing key to add")
+	rotateKeyCmd.Flags().StringArrayVar(&rotateRemoveKeyIDs, "remove-key-id", []string{}, "ID of a key to remove")
+	rotateKeyCmd.Flags().IntVar(&rotateGenerateKeys, "generate-keys", 0, "number of new keys to add")
+	rotateKeyCmd.Flags().IntVar(&rotateThreshold, "threshold", 0, "new threshold, unchanged if 0")
+	rootCmd.AddCommand(rotateKeyCmd)
+}
+
+func RotateKeyCmd(roleName string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	added := []*metadata.Key{}
+	for _, keyID := range rotateAddKeyIDs {
+		key, err := w.key(keyID, roleName)
+		if err != nil {
+			return err
+		}
+		added = append(added, key)
+	}
+	for i := 0; i < rotateGenerateKeys; i++ {
+		key, err := w.generateKey(roleName)
+		if err != nil {
+			return err
+		}
+		added = append(added, key)
+	}
+	if slices.Contains(topLevelRoles, roleName) {
+		err = rotateTopLevelKeys(w, roleName, added)
+	} else {
+		err = rotateDelegatedKeys(w, roleName, added)
+	}
+	if err != nil {
+		return err
+	}
+	// the role has to be signed with its new keys
+	if roleName != metadata.ROOT {
+		err = w.markChanged(roleName)
+		if err != nil {
+			return err
+		}
+		err = w.sign(roleName)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged the new keys of %s\n", roleName)
+	return nil
+}
+
+// rotateTopLevelKeys changes the keys of a top-level role in root
+func rotateTopLevelKeys(w *workspace, roleName string, added []*metadata.Key) error {
+	root := w.repo.Root()
+	roles := map[string]repository.RoleKeys{}
+	for _, name := range topLevelRoles {
+		role := root.Signed.Roles[name]
+		roleKeys := repository.RoleKeys{Threshold: role.Threshold}
+		for _, keyID := range role.KeyIDs {
+			if name == roleName && slices.Contains(rotateRemoveKeyIDs, keyID) {
+				continue
+			}
+			roleKeys.Keys = append(roleKeys.Keys, root.Signed.Keys[keyID])
+		}
+		if name == roleName {
+			roleKeys.Keys = append(roleKeys.Keys, added...)
+			if rotateThreshold != 0 {
+				roleKeys.Threshold = rotateThreshold
+			}
+		}
+		roles[name] = roleKeys
+	}
+	for _, keyID := range rotateRemoveKeyIDs {
+		if !slices.Contains(root.Signed.Roles[roleName].KeyIDs, keyID) {
+			return fmt.Errorf("key with id %s is not used by %s", keyID, roleName)
+		}
+	}
+	// nothing was published yet, so the staged root is changed in place
+	published := w.stage.Published()
+	if published == nil {
+		rotation, err := repository.RotateRoot(root, roles, time.Time{})
+		if err != nil {
+			return err
+		}
+		rotation.Next().Signed.Version = root.Signed.Version
+		w.repo.SetRoot(rotation.Next())
+		return w.sign(metadata.ROOT)
+	}
+	rotation, err := repository.RotateRoot(published.Root(), roles, w.policy.Expires(metadata.ROOT, time.Now()))
+	if err != nil {
+		return err
+	}
+	keyIDs := append([]string{}, published.Root().Signed.Roles[metadata.ROOT].KeyIDs...)
+	for _, keyID := range append(keyIDs, rotation.Next().Signed.Roles[metadata.ROOT].KeyIDs...) {
+		signer, err := w.signer(keyID)
+		if err != nil {
+			return err
+		}
+		if signer != nil {
+			err := rotation.Sign(signer)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	next, err := rotation.Finalize()
+	if err != nil {
+		status := rotation.Status()
+		return fmt.Errorf("%w, missing keys of the current root: %v, missing keys of the new root: %v", err, status.Old.Missing, status.New.Missing)
+	}
+	w.repo.SetRoot(next)
+	w.stage.SetStaged(metadata.ROOT)
+	return nil
+}
+
+// rotateDelegatedKeys changes the keys of a delegated role in its delegator
+func rotateDelegatedKeys(w *workspace, roleName string, added []*metadata.Key) error {
+	name, _ := w.delegator(roleName)
+	if name == "" {
+		return fmt.Errorf("no delegation found for %s", roleName)
+	}
+	err := w.markChanged(name)
+	if err != nil {
+		return err
+	}
+	targets := w.repo.Targets(name)
+	for _, key := range added {
+		err := targets.Signed.AddKey(key, roleName)
+		if err != nil {
+			return err
+		}
+	}
+	for _, keyID := range rotateRemoveKeyIDs {
+		err := targets.Signed.RevokeKey(keyID, roleName)
+		if err != nil {
+			return err
+		}
+	}
+	if rotateThreshold != 0 {
+		if targets.Signed.Delegations.SuccinctRoles != nil {
+			targets.Signed.Delegations.SuccinctRoles.Threshold = rotateThreshold
+		} else {
+			err := targets.Signed.SetDelegationThreshold(roleName, rotateThreshold)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return targets.Signed.Delegations.Validate()
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var signCmd = &cobra.Command{
+	Use:   "sign <role>",
+	Short: "Sign the staged metadata of a role with the local keys",
+	Long:  "Sign the staged metadata of a role with the local keys. A role without staged changes is staged with a new version first",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return SignCmd(args[0])
+	},
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Stage a new snapshot listing the staged targets metadata",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return SnapshotCmd()
+	},
+}
+
+var timestampCmd = &cobra.Command{
+	Use:   "timestamp",
+	Short: "Stage a new timestamp pointing to the staged snapshot",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return TimestampCmd()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(timestampCmd)
+}
+
+func SignCmd(roleName string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	if !w.stage.IsStaged(roleName) {
+		err = w.markChanged(roleName)
+		if err != nil {
+			return err
+		}
+	}
+	err = w.sign(roleName)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed %s\n", roleName)
+	return nil
+}
+
+func SnapshotCmd() error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	w.repo.SetGenerateOptions(repository.DefaultGenerateOptions())
+	changed, err := w.repo.UpdateSnapshot()
+	if err != nil {
+		return err
+	}
+	snapshot := w.repo.Snapshot()
+	if !changed && len(snapshot.Signatures) > 0 {
+		fmt.Println("Snapshot is up to date")
+		return nil
+	}
+	snapshot.Signed.Expires = w.policy.Expires(metadata.SNAPSHOT, time.Now())
+	err = w.sign(metadata.SNAPSHOT)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged snapshot v%d\n", snapshot.Signed.Version)
+	return nil
+}
+
+func TimestampCmd() error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	w.repo.SetGenerateOptions(repository.DefaultGenerateOptions())
+	changed, err := w.repo.UpdateTimestamp()
+	if err != nil {
+		return err
+	}
+	timestamp := w.repo.Timestamp()
+	if !changed && len(timestamp.Signatures) > 0 {
+		fmt.Println("Timestamp is up to date")
+		return nil
+	}
+	timestamp.Signed.Expires = w.policy.Expires(metadata.TIMESTAMP, time.Now())
+	err = w.sign(metadata.TIMESTAMP)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged timestamp v%d\n", timestamp.Signed.Version)
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var targetsRole string
+
+var addTargetCmd = &cobra.Command{
+	Use:   "add-target <file> [<target-path>]",
+	Short: "Stage a target file",
+	Long:  "Stage a target file in a targets role. The target path defaults to the base name of the file",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetPath := filepath.Base(args[0])
+		if len(args) == 2 {
+			targetPath = args[1]
+		}
+		return AddTargetCmd(args[0], targetPath)
+	},
+}
+
+var removeTargetCmd = &cobra.Command{
+	Use:   "remove-target <target-path>",
+	Short: "Stage the removal of a target file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RemoveTargetCmd(args[0])
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{addTargetCmd, removeTargetCmd} {
+		c.Flags().StringVar(&targetsRole, "role", metadata.TARGETS, "targets role listing the target file")
+		rootCmd.AddCommand(c)
+	}
+}
+
+func AddTargetCmd(file, targetPath string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	data, err := ReadFile(file)
+	if err != nil {
+		return err
+	}
+	err = w.markChanged(targetsRole)
+	if err != nil {
+		return err
+	}
+	_, err = w.repo.AddTarget(targetsRole, targetPath, data)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged %s in %s\n", targetPath, targetsRole)
+	return nil
+}
+
+func RemoveTargetCmd(targetPath string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	w, err := openWorkspace(RepoDir)
+	if err != nil {
+		return err
+	}
+	err = w.markChanged(targetsRole)
+	if err != nil {
+		return err
+	}
+	err = w.repo.RemoveTarget(targetsRole, targetPath)
+	if err != nil {
+		return err
+	}
+	err = w.stage.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Staged the removal of %s from %s\n", targetPath, targetsRole)
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var verifyAt string
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <repository-dir>",
+	Short: "Verify a repository the way clients do and print a JSON report",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return VerifyCmd(args[0])
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyAt, "at", "", "verify expiration at this RFC 3339 time instead of now")
+	rootCmd.AddCommand(verifyCmd)
+}
+
+func VerifyCmd(dir string) error {
+	// handle verbosity level
+	if Verbosity {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	refTime := time.Time{}
+	if verifyAt != "" {
+		var err error
+		refTime, err = time.Parse(time.RFC3339, verifyAt)
+		if err != nil {
+			return err
+		}
+	}
+	report, err := repository.Verify(dir, refTime)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+	if !report.OK {
+		return fmt.Errorf("verification of %s failed", dir)
+	}
+	return nil
+}
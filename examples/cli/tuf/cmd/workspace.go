@@ -0,0 +1,291 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keystore"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/signer"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// RepositoryDir holds the published repository as served to clients
+	RepositoryDir = "repository"
+	// StagedDir holds the metadata and target files changed since the last commit
+	StagedDir = "staged"
+	// KeysDir holds the keystore with the encrypted private keys
+	KeysDir = "keys"
+)
+
+var topLevelRoles = []string{metadata.ROOT, metadata.TARGETS, metadata.SNAPSHOT, metadata.TIMESTAMP}
+
+// workspace is the published repository together with the staged changes
+// and the keystore found in a directory
+type workspace struct {
+	dir   string
+	stage *repository.Stage
+	// repo is the published repository with the staged changes applied
+	repo *repository.Repository
+	// store holds the encrypted private keys
+	store *keystore.Keystore
+	// signers are the private keys unlocked so far by key ID
+	signers map[string]signature.Signer
+	// external are the keys of the --key-ref signers by key ID
+	external map[string]*metadata.Key
+	// configured lists the key IDs of the signers added to each role
+	configured map[string]map[string]bool
+	passphrase []byte
+	policy     repository.ExpiryPolicy
+}
+
+// newWorkspace returns the workspace in dir
+func newWorkspace(dir string) (*workspace, error) {
+	stage, err := repository.OpenStage(filepath.Join(dir, RepositoryDir), filepath.Join(dir, StagedDir))
+	if err != nil {
+		return nil, err
+	}
+	store, err := keystore.Open(filepath.Join(dir, KeysDir))
+	if err != nil {
+		return nil, err
+	}
+	w := &workspace{
+		dir:        dir,
+		stage:      stage,
+		repo:       stage.Repository(),
+		store:      store,
+		signers:    map[string]signature.Signer{},
+		external:   map[string]*metadata.Key{},
+		configured: map[string]map[string]bool{},
+		policy:     repository.DefaultExpiryPolicy(),
+	}
+	err = w.loadKeyRefs(KeyRefs)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// loadKeyRefs adds the signers of KMS and PKCS#11 key references, they sign
+// the roles their keys are assigned to like the keys of the keystore
+func (w *workspace) loadKeyRefs(refs []string) error {
+	for _, ref := range refs {
+		s, key, err := signer.Load(context.Background(), ref, signer.Options{})
+		if err != nil {
+			return fmt.Errorf("failed to load the signer of %s: %w", ref, err)
+		}
+		w.signers[key.ID()] = s
+		w.external[key.ID()] = key
+	}
+	return nil
+}
+
+// openWorkspace loads the workspace in dir
+func openWorkspace(dir string) (*workspace, error) {
+	w, err := newWorkspace(dir)
+	if err != nil {
+		return nil, err
+	}
+	if w.repo.Root() == nil {
+		return nil, fmt.Errorf("no repository found in %s, run tuf init first", dir)
+	}
+	return w, nil
+}
+
+// signer returns the signer of keyID, unlocking it in the keystore the
+// first time. It returns nil if the key is not in the keystore
+func (w *workspace) signer(keyID string) (signature.Signer, error) {
+	if signer, ok := w.signers[keyID]; ok {
+		return signer, nil
+	}
+	// keys which are not in the keystore are not available for signing
+	if _, err := w.store.Get(keyID); err != nil {
+		return nil, nil
+	}
+	passphrase, err := w.keystorePassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	session, err := w.store.Unlock(passphrase, keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	signer, err := session.Signer(keyID)
+	if err != nil {
+		return nil, err
+	}
+	w.signers[keyID] = signer
+	return signer, nil
+}
+
+// addSigners configures the keys of roleNames found in the keystore. It is
+// called whenever a role is about to be signed as its keys may have changed
+func (w *workspace) addSigners(roleNames ...string) error {
+	for _, name := range roleNames {
+		if w.configured[name] == nil {
+			w.configured[name] = map[string]bool{}
+		}
+		for _, keyID := range w.roleKeyIDs(name) {
+			if w.configured[name][keyID] {
+				continue
+			}
+			signer, err := w.signer(keyID)
+			if err != nil {
+				return err
+			}
+			if signer != nil {
+				w.repo.AddSigner(name, signer)
+				w.configured[name][keyID] = true
+			}
+		}
+	}
+	return nil
+}
+
+// keystorePassphrase returns the passphrase of the keystore, asking for it
+// once, see readPassphrase
+func (w *workspace) keystorePassphrase(confirm bool) ([]byte, error) {
+	if w.passphrase == nil {
+		passphrase, err := readPassphrase("Enter the keystore passphrase: ", confirm)
+		if err != nil {
+			return nil, err
+		}
+		w.passphrase = passphrase
+	}
+	return w.passphrase, nil
+}
+
+// roles returns the names of all roles in the working copy
+func (w *workspace) roles() []string {
+	res := []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT}
+	return append(res, w.repo.TargetsRoles()...)
+}
+
+// roleKeyIDs returns the key IDs which may sign roleName. For root the ones
+// of the published root are included as a new root is signed by both
+func (w *workspace) roleKeyIDs(roleName string) []string {
+	if slices.Contains(topLevelRoles, roleName) {
+		res := append([]string{}, w.repo.Root().Signed.Roles[roleName].KeyIDs...)
+		if published := w.stage.Published(); roleName == metadata.ROOT && published != nil {
+			res = append(res, published.Root().Signed.Roles[metadata.ROOT].KeyIDs...)
+		}
+		return res
+	}
+	_, delegations := w.delegator(roleName)
+	if delegations == nil {
+		return nil
+	}
+	for _, role := range delegations.Roles {
+		if role.Name == roleName {
+			return role.KeyIDs
+		}
+	}
+	if delegations.SuccinctRoles != nil {
+		return delegations.SuccinctRoles.KeyIDs
+	}
+	return nil
+}
+
+// delegator returns the name and delegations of the targets role which
+// delegates to roleName
+func (w *workspace) delegator(roleName string) (string, *metadata.Delegations) {
+	for _, name := range w.repo.TargetsRoles() {
+		delegations := w.repo.Targets(name).Signed.Delegations
+		if delegations == nil {
+			continue
+		}
+		for _, role := range delegations.Roles {
+			if role.Name == roleName {
+				return name, delegations
+			}
+		}
+		if delegations.SuccinctRoles != nil && delegations.SuccinctRoles.IsDelegatedRole(roleName) {
+			return name, delegations
+		}
+	}
+	return "", nil
+}
+
+// markChanged stages roleName and removes its signatures as its content is
+// about to change, see Stage.MarkChanged
+func (w *workspace) markChanged(roleName string) error {
+	return w.stage.MarkChanged(roleName, w.policy.Expires(roleName, time.Now()))
+}
+
+// generateKey generates a new ed25519 key for roleName, adds it to the
+// keystore and returns its public key
+func (w *workspace) generateKey(roleName string) (*metadata.Key, error) {
+	key, err := keys.Generate(metadata.KeyTypeEd25519)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := w.store.List("")
+	if err != nil {
+		return nil, err
+	}
+	// a new keystore gets its passphrase with the first key
+	passphrase, err := w.keystorePassphrase(len(entries) == 0)
+	if err != nil {
+		return nil, err
+	}
+	err = w.store.Add(key, passphrase, roleName)
+	if err != nil {
+		return nil, err
+	}
+	w.signers[key.ID()], err = key.Signer()
+	if err != nil {
+		return nil, err
+	}
+	return key.Public, nil
+}
+
+// key returns the public key of a key in the keystore and records that it
+// is used by roleName. Keys of --key-ref signers are returned as they are
+func (w *workspace) key(keyID string, roleName string) (*metadata.Key, error) {
+	if key, ok := w.external[keyID]; ok {
+		return key, nil
+	}
+	entry, err := w.store.Get(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(entry.Roles, roleName) {
+		passphrase, err := w.keystorePassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		err = w.store.SetRoles(passphrase, keyID, append(entry.Roles, roleName)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry.Public, nil
+}
+
+// sign replaces the signatures of roleName with the ones of the local keys
+func (w *workspace) sign(roleName string) error {
+	err := w.addSigners(roleName)
+	if err != nil {
+		return err
+	}
+	w.stage.SetStaged(roleName)
+	return w.repo.Sign(roleName)
+}
@@ -12,14 +12,9 @@
 package main
 
 import (
-	"fmt"
-	"os"
-
 	tuf "github.com/rdimitrov/go-tuf-metadata/examples/cli/tuf/cmd"
 )
 
 func main() {
-	fmt.Println("Not implemented")
-	os.Exit(1)
 	tuf.Execute()
 }
@@ -12,6 +12,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -51,7 +52,11 @@ func main() {
 	// Refresh all repositories
 	fmt.Printf("Refreshing each TUF client (updating metadata/client update workflow)\n\n")
 	err = client.Refresh()
-	if err != nil {
+	var report *multirepo.Report
+	if errors.As(err, &report) {
+		// the failed repositories only block the mappings which need them
+		fmt.Printf("Failed to refresh %s: %v\n\n", strings.Join(report.Failed(), ", "), report)
+	} else if err != nil {
 		panic(err)
 	}
 
@@ -56,8 +56,9 @@ func main() {
 	// protect, i.e. target files.
 
 	// Define containers for metadata objects and cryptographic keys created below. This
-	// allows us to sign and write metadata in a batch more easily. The repository.New() instance
-	// doesn't provide anything else yet other than serving as a placeholder for all metadata.
+	// allows us to sign and write metadata in a batch more easily. This example does every step
+	// by hand, the repository.Repository type also provides high-level operations for adding
+	// targets, regenerating snapshot and timestamp, signing and writing the repository to disk.
 	roles := repository.New()
 	keys := map[string]ed25519.PrivateKey{}
 
@@ -0,0 +1,218 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bootstrap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// Strategy provides the trusted root metadata an Updater starts from,
+// i.e. the LocalTrustedRoot of its configuration
+type Strategy interface {
+	TrustedRoot() ([]byte, error)
+}
+
+// StrategyFunc adapts a function to the Strategy interface
+type StrategyFunc func() ([]byte, error)
+
+// TrustedRoot calls f()
+func (f StrategyFunc) TrustedRoot() ([]byte, error) {
+	return f()
+}
+
+// FromBytes returns a Strategy using the given root metadata as is
+func FromBytes(rootBytes []byte) Strategy {
+	return StrategyFunc(func() ([]byte, error) {
+		return checkRoot(rootBytes)
+	})
+}
+
+// FromFS returns a Strategy reading the root metadata from a file system.
+// It is meant to be used with a root bundle shipped inside the binary, e.g.
+//
+//	//go:embed root.json
+//	var bundle embed.FS
+//	strategy := bootstrap.FromFS(bundle, "root.json")
+func FromFS(fsys fs.FS, name string) Strategy {
+	return StrategyFunc(func() ([]byte, error) {
+		rootBytes, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root bundle %s: %w", name, err)
+		}
+		return checkRoot(rootBytes)
+	})
+}
+
+// PinDigest returns a Strategy which accepts the root provided by from only
+// if its SHA-256 digest matches the hex encoded sha256Hex
+func PinDigest(from Strategy, sha256Hex string) Strategy {
+	return StrategyFunc(func() ([]byte, error) {
+		rootBytes, err := from.TrustedRoot()
+		if err != nil {
+			return nil, err
+		}
+		if got := digest(rootBytes); !strings.EqualFold(got, sha256Hex) {
+			return nil, metadata.ErrUnsignedMetadata{Msg: fmt.Sprintf("root digest mismatch, expected sha256 %s, got %s", sha256Hex, got)}
+		}
+		return rootBytes, nil
+	})
+}
+
+// PinKeys returns a Strategy which accepts the root provided by from only
+// if it is signed by at least threshold of the given root key IDs. The key
+// IDs must be listed as root keys in the root metadata itself
+func PinKeys(from Strategy, keyIDs []string, threshold int) Strategy {
+	return StrategyFunc(func() ([]byte, error) {
+		if threshold < 1 || threshold > len(keyIDs) {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("pinned threshold must be between 1 and %d, got %d", len(keyIDs), threshold)}
+		}
+		rootBytes, err := from.TrustedRoot()
+		if err != nil {
+			return nil, err
+		}
+		root, err := metadata.Root().FromBytes(rootBytes)
+		if err != nil {
+			return nil, err
+		}
+		rootRole, ok := root.Signed.Roles[metadata.ROOT]
+		if !ok {
+			return nil, metadata.ErrValue{Msg: "no root role found in root metadata"}
+		}
+		for _, keyID := range keyIDs {
+			if !slices.Contains(rootRole.KeyIDs, keyID) {
+				return nil, metadata.ErrUnsignedMetadata{Msg: fmt.Sprintf("pinned key %s is not a root key", keyID)}
+			}
+		}
+		// verify the root against a copy of itself delegating only to the pinned keys
+		pinned := metadata.Root(root.Signed.Expires)
+		pinned.Signed.Keys = root.Signed.Keys
+		pinned.Signed.Roles[metadata.ROOT] = &metadata.Role{KeyIDs: keyIDs, Threshold: threshold}
+		err = pinned.VerifyDelegate(metadata.ROOT, root)
+		if err != nil {
+			return nil, fmt.Errorf("root is not signed by the pinned keys: %w", err)
+		}
+		return rootBytes, nil
+	})
+}
+
+// FromNewerRoot returns a Strategy which starts from the root provided by
+// newer after cross-checking it against the older, already trusted root
+// (e.g. an embedded one). Every version between the two is fetched with
+// intermediate and verified as in the client root update workflow, so the
+// newer root is only accepted if it can be reached by a valid chain of
+// root rotations
+func FromNewerRoot(trusted Strategy, newer Strategy, intermediate func(version int64) ([]byte, error)) Strategy {
+	return StrategyFunc(func() ([]byte, error) {
+		trustedBytes, err := trusted.TrustedRoot()
+		if err != nil {
+			return nil, err
+		}
+		newerBytes, err := newer.TrustedRoot()
+		if err != nil {
+			return nil, err
+		}
+		newerRoot, err := metadata.Root().FromBytes(newerBytes)
+		if err != nil {
+			return nil, err
+		}
+		trustedMetadata, err := trustedmetadata.New(trustedBytes)
+		if err != nil {
+			return nil, err
+		}
+		if newerRoot.Signed.Version < trustedMetadata.Root.Signed.Version {
+			return nil, metadata.ErrBadVersionNumber{Msg: fmt.Sprintf("root version %d is older than the trusted version %d", newerRoot.Signed.Version, trustedMetadata.Root.Signed.Version)}
+		}
+		// walk the version chain
+		for version := trustedMetadata.Root.Signed.Version + 1; version < newerRoot.Signed.Version; version++ {
+			data, err := intermediate(version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get root version %d: %w", version, err)
+			}
+			_, err = trustedMetadata.UpdateRoot(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify root version %d: %w", version, err)
+			}
+		}
+		if newerRoot.Signed.Version > trustedMetadata.Root.Signed.Version {
+			_, err = trustedMetadata.UpdateRoot(newerBytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify root version %d: %w", newerRoot.Signed.Version, err)
+			}
+		} else if digest(newerBytes) != digest(trustedBytes) {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("root version %d differs from the trusted one", newerRoot.Signed.Version)}
+		}
+		log.Debugf("Bootstrapped from root version %d", newerRoot.Signed.Version)
+		return newerBytes, nil
+	})
+}
+
+// RemoteVersions returns a function fetching the versioned root metadata
+// files (<version>.root.json) from remoteMetadataURL. It can be used as the
+// intermediate argument of FromNewerRoot
+func RemoteVersions(f fetcher.Fetcher, remoteMetadataURL string, maxLength int64) func(version int64) ([]byte, error) {
+	return func(version int64) ([]byte, error) {
+		rootURL, err := url.JoinPath(remoteMetadataURL, fmt.Sprintf("%d.%s.json", version, metadata.ROOT))
+		if err != nil {
+			return nil, err
+		}
+		return f.DownloadFile(rootURL, maxLength)
+	}
+}
+
+// TrustOnFirstUse returns a Strategy downloading the initial root metadata
+// (1.root.json) from remoteMetadataURL without verifying it against anything.
+// An attacker controlling the network or the repository at that moment can
+// impersonate the repository forever, so this must only be used when
+// explicitly requested by the user
+func TrustOnFirstUse(f fetcher.Fetcher, remoteMetadataURL string, maxLength int64) Strategy {
+	return StrategyFunc(func() ([]byte, error) {
+		log.Warnf("!!! TRUST ON FIRST USE: the initial root metadata is downloaded from %s and is NOT verified. "+
+			"Anyone able to tamper with this download can take over all future updates. "+
+			"Provide a trusted root metadata file or pin its digest or keys instead !!!", remoteMetadataURL)
+		rootBytes, err := RemoteVersions(f, remoteMetadataURL, maxLength)(1)
+		if err != nil {
+			return nil, err
+		}
+		rootBytes, err = checkRoot(rootBytes)
+		if err != nil {
+			return nil, err
+		}
+		log.Warnf("!!! TRUST ON FIRST USE: trusting root metadata with sha256 %s, verify it out of band !!!", digest(rootBytes))
+		return rootBytes, nil
+	})
+}
+
+// checkRoot makes sure rootBytes is a root metadata signed by its own keys
+func checkRoot(rootBytes []byte) ([]byte, error) {
+	_, err := trustedmetadata.New(rootBytes)
+	if err != nil {
+		return nil, err
+	}
+	return rootBytes, nil
+}
+
+// digest returns the hex encoded SHA-256 digest of data
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
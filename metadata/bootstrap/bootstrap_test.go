@@ -0,0 +1,115 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package bootstrap
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+type helperRootKey struct {
+	key    *metadata.Key
+	signer signature.Signer
+}
+
+func helperNewRootKey(t *testing.T) helperRootKey {
+	_, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	key, err := metadata.KeyFromPublicKey(private.Public())
+	assert.NoError(t, err)
+	signer, err := signature.LoadSigner(private, crypto.Hash(0))
+	assert.NoError(t, err)
+	return helperRootKey{key: key, signer: signer}
+}
+
+// helperRootChain returns three root versions, the root key is rotated
+// between each of them
+func helperRootChain(t *testing.T) ([][]byte, []helperRootKey) {
+	keys := []helperRootKey{helperNewRootKey(t), helperNewRootKey(t), helperNewRootKey(t)}
+	root := metadata.Root(time.Now().AddDate(0, 0, 7).UTC())
+	chain := [][]byte{}
+	for i, k := range keys {
+		if i > 0 {
+			root.Signed.Version++
+			assert.NoError(t, root.Signed.RevokeKey(keys[i-1].key.ID(), metadata.ROOT))
+		}
+		assert.NoError(t, root.Signed.AddKey(k.key, metadata.ROOT))
+		root.ClearSignatures()
+		if i > 0 {
+			_, err := root.Sign(keys[i-1].signer)
+			assert.NoError(t, err)
+		}
+		_, err := root.Sign(k.signer)
+		assert.NoError(t, err)
+		data, err := root.ToBytes(false)
+		assert.NoError(t, err)
+		chain = append(chain, data)
+	}
+	return chain, keys
+}
+
+func TestFromFS(t *testing.T) {
+	chain, _ := helperRootChain(t)
+	bundle := fstest.MapFS{"root.json": &fstest.MapFile{Data: chain[0]}}
+	rootBytes, err := FromFS(bundle, "root.json").TrustedRoot()
+	assert.NoError(t, err)
+	assert.Equal(t, chain[0], rootBytes)
+
+	_, err = FromFS(bundle, "missing.json").TrustedRoot()
+	assert.ErrorContains(t, err, "failed to read root bundle missing.json")
+}
+
+func TestPinDigest(t *testing.T) {
+	chain, _ := helperRootChain(t)
+	_, err := PinDigest(FromBytes(chain[0]), digest(chain[0])).TrustedRoot()
+	assert.NoError(t, err)
+	_, err = PinDigest(FromBytes(chain[1]), digest(chain[0])).TrustedRoot()
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: fmt.Sprintf("root digest mismatch, expected sha256 %s, got %s", digest(chain[0]), digest(chain[1]))})
+}
+
+func TestPinKeys(t *testing.T) {
+	chain, keys := helperRootChain(t)
+	_, err := PinKeys(FromBytes(chain[0]), []string{keys[0].key.ID()}, 1).TrustedRoot()
+	assert.NoError(t, err)
+	// version 2 is signed by the old key too, but it's not a root key anymore
+	_, err = PinKeys(FromBytes(chain[1]), []string{keys[0].key.ID()}, 1).TrustedRoot()
+	assert.ErrorContains(t, err, "is not a root key")
+	_, err = PinKeys(FromBytes(chain[0]), []string{keys[0].key.ID()}, 2).TrustedRoot()
+	assert.ErrorContains(t, err, "pinned threshold must be between 1 and 1")
+}
+
+func TestFromNewerRoot(t *testing.T) {
+	chain, _ := helperRootChain(t)
+	intermediate := func(version int64) ([]byte, error) {
+		return chain[version-1], nil
+	}
+	rootBytes, err := FromNewerRoot(FromBytes(chain[0]), FromBytes(chain[2]), intermediate).TrustedRoot()
+	assert.NoError(t, err)
+	assert.Equal(t, chain[2], rootBytes)
+
+	// a newer root which does not chain up to the trusted one is rejected
+	other, _ := helperRootChain(t)
+	_, err = FromNewerRoot(FromBytes(chain[0]), FromBytes(other[2]), intermediate).TrustedRoot()
+	assert.ErrorContains(t, err, "failed to verify root version 3")
+
+	// an older root is rejected
+	_, err = FromNewerRoot(FromBytes(chain[1]), FromBytes(chain[0]), intermediate).TrustedRoot()
+	assert.ErrorIs(t, err, metadata.ErrBadVersionNumber{Msg: "root version 1 is older than the trusted version 2"})
+}
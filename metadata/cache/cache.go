@@ -0,0 +1,440 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cache
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// hashAlgorithms lists the hash algorithms which can be used as a cache key
+// in order of preference. A target file is stored under the digest of its
+// content for the first algorithm and hard linked under the digests for the
+// others, so the same content is stored only once regardless of which
+// repository, target path or hashes it came with
+var hashAlgorithms = []string{"sha256", "sha512"}
+
+// digests returns the hex digest of data for each of hashAlgorithms
+var digests = map[string]func(data []byte) string{
+	"sha256": func(data []byte) string {
+		digest := sha256.Sum256(data)
+		return hex.EncodeToString(digest[:])
+	},
+	"sha512": func(data []byte) string {
+		digest := sha512.Sum512(data)
+		return hex.EncodeToString(digest[:])
+	},
+}
+
+// Options configures the eviction policy of a Cache
+type Options struct {
+	// MaxSize is the total size in bytes of all cached target files,
+	// least recently used ones are evicted first. Zero means no limit
+	MaxSize int64
+	// MaxAge is how long a target file can stay in the cache without
+	// being accessed. Zero means no limit
+	MaxAge time.Duration
+}
+
+// Stats represents a snapshot of the cache statistics
+type Stats struct {
+	Entries   int   `json:"entries"`
+	Size      int64 `json:"size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Collected int64 `json:"collected"`
+}
+
+// entry describes a single cached target file
+type entry struct {
+	size       int64
+	lastAccess time.Time
+	// links are the keys of the hard links under the other hash algorithms
+	links []string
+}
+
+// Cache is a content-addressed store for target files. Target files are
+// stored as <dir>/<hash-algorithm>/<hex-digest> for every hash algorithm,
+// as hard links of the same file, so identical content coming from
+// different repositories or target paths is deduplicated. The last access
+// time of each target file is kept as its modification time on disk.
+// A Cache is safe for concurrent use
+type Cache struct {
+	dir  string
+	opts Options
+	mu   sync.Mutex
+	// entries are keyed by the first hash algorithm
+	entries map[string]*entry
+	// links maps the keys under the other hash algorithms to the entry key
+	links map[string]string
+	stats Stats
+	now   func() time.Time
+}
+
+// New creates a Cache at dir and loads the index of the target files
+// which are already present there
+func New(dir string, opts Options) (*Cache, error) {
+	if dir == "" {
+		return nil, metadata.ErrValue{Msg: "cache directory must be set"}
+	}
+	c := &Cache{
+		dir:     dir,
+		opts:    opts,
+		entries: map[string]*entry{},
+		links:   map[string]string{},
+		now:     time.Now,
+	}
+	// the entries by size, to find the file a hard link belongs to
+	bySize := map[int64][]string{}
+	infos := map[string]fs.FileInfo{}
+	for i, alg := range hashAlgorithms {
+		algDir := filepath.Join(dir, alg)
+		err := os.MkdirAll(algDir, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+		files, err := os.ReadDir(algDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			info, err := f.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			key := filepath.Join(alg, f.Name())
+			if i == 0 {
+				c.entries[key] = &entry{
+					size:       info.Size(),
+					lastAccess: info.ModTime(),
+				}
+				bySize[info.Size()] = append(bySize[info.Size()], key)
+				infos[key] = info
+				continue
+			}
+			if !c.linkLocked(key, info, bySize[info.Size()], infos) {
+				// left behind by an interrupted Put or removal
+				log.Debugf("Removing cached target file %s without an entry", key)
+				if err := os.Remove(filepath.Join(dir, key)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	log.Debugf("Loaded %d target files from cache %s", len(c.entries), dir)
+	return c, nil
+}
+
+// linkLocked registers the file for key as a hard link of the one of the
+// candidate entries it is the same file as and reports whether there is one
+func (c *Cache) linkLocked(key string, info fs.FileInfo, candidates []string, infos map[string]fs.FileInfo) bool {
+	for _, candidate := range candidates {
+		if os.SameFile(info, infos[candidate]) {
+			c.entries[candidate].links = append(c.entries[candidate].links, key)
+			c.links[key] = candidate
+			return true
+		}
+	}
+	return false
+}
+
+// Key returns the cache key for a target file with the given hashes, the
+// one of the first supported hash algorithm
+func Key(hashes metadata.Hashes) (string, error) {
+	keys := keys(hashes)
+	if len(keys) == 0 {
+		return "", metadata.ErrValue{Msg: "no supported hash algorithm found for caching the target file"}
+	}
+	return keys[0], nil
+}
+
+// keys returns the cache keys for all supported hash algorithms in hashes
+func keys(hashes metadata.Hashes) []string {
+	res := []string{}
+	for _, alg := range hashAlgorithms {
+		if digest, ok := hashes[alg]; ok && len(digest) > 0 {
+			res = append(res, filepath.Join(alg, digest.String()))
+		}
+	}
+	return res
+}
+
+// Location returns the path of the cached target file for the given hashes
+func (c *Cache) Location(hashes metadata.Hashes) (string, error) {
+	key, err := Key(hashes)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the target file may be cached under another of its hashes only
+	for _, k := range keys(hashes) {
+		if _, ok := c.entries[k]; ok || c.links[k] != "" {
+			return filepath.Join(c.dir, k), nil
+		}
+	}
+	return filepath.Join(c.dir, key), nil
+}
+
+// lookupLocked returns the entry key of the target file with the given
+// hashes, looked up by each of them
+func (c *Cache) lookupLocked(hashes metadata.Hashes) (string, bool) {
+	for _, key := range keys(hashes) {
+		if _, ok := c.entries[key]; ok {
+			return key, true
+		}
+		if entryKey, ok := c.links[key]; ok {
+			return entryKey, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the cached target file matching targetFile. The content is
+// verified against the target file length and hashes, a mismatching
+// cached file is removed and reported as missing
+func (c *Cache) Get(targetFile *metadata.TargetFiles) ([]byte, error) {
+	key, err := Key(targetFile.Hashes)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entryKey, ok := c.lookupLocked(targetFile.Hashes)
+	if !ok {
+		c.stats.Misses++
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	key = entryKey
+	name := filepath.Join(c.dir, key)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		c.stats.Misses++
+		c.removeLocked(key)
+		return nil, err
+	}
+	err = targetFile.VerifyLengthHashes(data)
+	if err != nil {
+		// the cached file was modified or corrupted, so we drop it
+		log.Debugf("Removing invalid cached target file %s", key)
+		c.stats.Misses++
+		c.removeLocked(key)
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	c.touchLocked(key)
+	c.stats.Hits++
+	return data, nil
+}
+
+// Put stores the content of an already verified target file in the cache
+// under the digests of data for all hash algorithms and applies the
+// eviction policy
+func (c *Cache) Put(targetFile *metadata.TargetFiles, data []byte) error {
+	if _, err := Key(targetFile.Hashes); err != nil {
+		return err
+	}
+	key := filepath.Join(hashAlgorithms[0], digests[hashAlgorithms[0]](data))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		// same content is already cached, just mark it as used
+		c.touchLocked(key)
+		return nil
+	}
+	name := filepath.Join(c.dir, key)
+	err := storage.WriteFileAtomic(name, data, 0644)
+	if err != nil {
+		return err
+	}
+	e := &entry{
+		size:       int64(len(data)),
+		lastAccess: c.now(),
+	}
+	for _, alg := range hashAlgorithms[1:] {
+		link := filepath.Join(alg, digests[alg](data))
+		// replace a file left behind by an interrupted Put
+		err := os.Remove(filepath.Join(c.dir, link))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = os.Link(name, filepath.Join(c.dir, link))
+		if err != nil {
+			// not critical, the target file is found by its other hashes
+			log.Debugf("Failed to link cached target file %s as %s: %v", key, link, err)
+			continue
+		}
+		e.links = append(e.links, link)
+		c.links[link] = key
+	}
+	c.entries[key] = e
+	_, err = c.evictLocked(key)
+	return err
+}
+
+// Evict applies the size and age eviction policy and returns the number of
+// removed target files
+func (c *Cache) Evict() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictLocked("")
+}
+
+// GC removes every cached target file which is not listed in referenced.
+// The referenced keys are usually collected with References from the
+// targets metadata which is currently trusted. Returns the number of
+// removed target files
+func (c *Cache) GC(referenced map[string]bool) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, e := range c.entries {
+		if referenced[key] || slices.ContainsFunc(e.links, func(link string) bool { return referenced[link] }) {
+			continue
+		}
+		err := c.removeLocked(key)
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	c.stats.Collected += int64(removed)
+	log.Debugf("Garbage collected %d target files from cache %s", removed, c.dir)
+	return removed, nil
+}
+
+// Stats returns the current cache statistics
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.stats
+	res.Entries = len(c.entries)
+	for _, e := range c.entries {
+		res.Size += e.size
+	}
+	return res
+}
+
+// References returns the cache keys for all hashes of all target files
+// listed in the given targets metadata. Note that delegated targets metadata is loaded on
+// demand by the Updater, so Updater.LoadAllTargets has to be called before
+// collecting the references for GC, otherwise the target files of roles
+// which were not loaded are removed
+func References(targets ...*metadata.Metadata[metadata.TargetsType]) map[string]bool {
+	res := map[string]bool{}
+	for _, t := range targets {
+		if t == nil {
+			continue
+		}
+		for _, targetFile := range t.Signed.Targets {
+			for _, key := range keys(targetFile.Hashes) {
+				res[key] = true
+			}
+		}
+	}
+	return res
+}
+
+// evictLocked removes target files which have not been accessed within
+// MaxAge and then the least recently used ones until the total size fits
+// into MaxSize. The target file for keep is never evicted
+func (c *Cache) evictLocked(keep string) (int, error) {
+	removed := 0
+	// age based eviction
+	if c.opts.MaxAge > 0 {
+		oldest := c.now().Add(-c.opts.MaxAge)
+		for key, e := range c.entries {
+			if key != keep && e.lastAccess.Before(oldest) {
+				err := c.removeLocked(key)
+				if err != nil {
+					return removed, err
+				}
+				removed++
+			}
+		}
+	}
+	// size based eviction
+	if c.opts.MaxSize > 0 {
+		total := int64(0)
+		keys := []string{}
+		for key, e := range c.entries {
+			total += e.size
+			keys = append(keys, key)
+		}
+		// least recently used first
+		sort.Slice(keys, func(i, j int) bool {
+			return c.entries[keys[i]].lastAccess.Before(c.entries[keys[j]].lastAccess)
+		})
+		for _, key := range keys {
+			if total <= c.opts.MaxSize {
+				break
+			}
+			if key == keep {
+				continue
+			}
+			total -= c.entries[key].size
+			err := c.removeLocked(key)
+			if err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	c.stats.Evictions += int64(removed)
+	return removed, nil
+}
+
+// touchLocked marks the target file for key as just accessed
+func (c *Cache) touchLocked(key string) {
+	now := c.now()
+	c.entries[key].lastAccess = now
+	// persist the access time so it survives restarts, not critical if it fails
+	err := os.Chtimes(filepath.Join(c.dir, key), now, now)
+	if err != nil {
+		log.Debugf("Failed to update access time of cached target file %s: %v", key, err)
+	}
+}
+
+// removeLocked deletes the target file for key and its hard links from
+// disk and the index
+func (c *Cache) removeLocked(key string) error {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(c.entries, key)
+	for _, link := range e.links {
+		delete(c.links, link)
+		err := os.Remove(filepath.Join(c.dir, link))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err := os.Remove(filepath.Join(c.dir, key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package cache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func helperTargetFile(t *testing.T, path string, data []byte) *metadata.TargetFiles {
+	targetFile, err := metadata.TargetFile().FromBytes(path, data)
+	assert.NoError(t, err)
+	return targetFile
+}
+
+func TestCacheDeduplication(t *testing.T) {
+	c, err := New(t.TempDir(), Options{})
+	assert.NoError(t, err)
+
+	// same content under two different target paths is stored once
+	data := []byte("same content")
+	first := helperTargetFile(t, "repo-a/file.txt", data)
+	second := helperTargetFile(t, "repo-b/other.txt", data)
+	assert.NoError(t, c.Put(first, data))
+	assert.NoError(t, c.Put(second, data))
+	assert.Equal(t, 1, c.Stats().Entries)
+	assert.Equal(t, int64(len(data)), c.Stats().Size)
+
+	// both can be read back
+	got, err := c.Get(second)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// a target file which was never cached is a miss
+	_, err = c.Get(helperTargetFile(t, "missing.txt", []byte("missing")))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, int64(1), c.Stats().Hits)
+	assert.Equal(t, int64(1), c.Stats().Misses)
+}
+
+func TestCacheHashAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, Options{})
+	assert.NoError(t, err)
+
+	// the same content listed with different hashes is stored once
+	data := []byte("same content")
+	both, err := metadata.TargetFile().FromBytes("both.txt", data, "sha256", "sha512")
+	assert.NoError(t, err)
+	sha512Only, err := metadata.TargetFile().FromBytes("sha512.txt", data, "sha512")
+	assert.NoError(t, err)
+	assert.NoError(t, c.Put(sha512Only, data))
+	assert.NoError(t, c.Put(both, data))
+	assert.Equal(t, 1, c.Stats().Entries)
+	assert.Equal(t, int64(len(data)), c.Stats().Size)
+	got, err := c.Get(both)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	location, err := c.Location(sha512Only.Hashes)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "sha512", sha512Only.Hashes["sha512"].String()), location)
+
+	// it is kept as long as it is referenced by any of its hashes
+	targets := metadata.Targets()
+	targets.Signed.Targets["sha512.txt"] = sha512Only
+	removed, err := c.GC(References(targets))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	// a new cache instance finds it by each of its hashes
+	c, err = New(dir, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Stats().Entries)
+	_, err = c.Get(sha512Only)
+	assert.NoError(t, err)
+	removed, err = c.GC(map[string]bool{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoFileExists(t, location)
+	assert.NoFileExists(t, filepath.Join(dir, "sha256", both.Hashes["sha256"].String()))
+}
+
+func TestCacheCorruptedEntry(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, Options{})
+	assert.NoError(t, err)
+
+	data := []byte("original")
+	targetFile := helperTargetFile(t, "file.txt", data)
+	assert.NoError(t, c.Put(targetFile, data))
+
+	// tamper with the cached file
+	location, err := c.Location(targetFile.Hashes)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(location, []byte("tampered"), 0644))
+
+	_, err = c.Get(targetFile)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, 0, c.Stats().Entries)
+	assert.NoFileExists(t, location)
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, err := New(t.TempDir(), Options{MaxSize: 10, MaxAge: time.Hour})
+	assert.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	first := helperTargetFile(t, "first", []byte("12345"))
+	second := helperTargetFile(t, "second", []byte("67890"))
+	third := helperTargetFile(t, "third", []byte("abcde"))
+
+	assert.NoError(t, c.Put(first, []byte("12345")))
+	now = now.Add(time.Minute)
+	assert.NoError(t, c.Put(second, []byte("67890")))
+	now = now.Add(time.Minute)
+	// access first so second becomes the least recently used
+	_, err = c.Get(first)
+	assert.NoError(t, err)
+	now = now.Add(time.Minute)
+	assert.NoError(t, c.Put(third, []byte("abcde")))
+
+	_, err = c.Get(second)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = c.Get(first)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), c.Stats().Evictions)
+
+	// age based eviction
+	now = now.Add(2 * time.Hour)
+	removed, err := c.Evict()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, 0, c.Stats().Entries)
+}
+
+func TestCacheGC(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, Options{})
+	assert.NoError(t, err)
+
+	kept := helperTargetFile(t, "kept", []byte("kept"))
+	dropped := helperTargetFile(t, "dropped", []byte("dropped"))
+	assert.NoError(t, c.Put(kept, []byte("kept")))
+	assert.NoError(t, c.Put(dropped, []byte("dropped")))
+
+	targets := metadata.Targets()
+	targets.Signed.Targets["kept"] = kept
+	removed, err := c.GC(References(targets))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, int64(1), c.Stats().Collected)
+
+	// a new cache instance picks up what is left on disk
+	c, err = New(dir, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Stats().Entries)
+	location, err := c.Location(kept.Hashes)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "sha256", kept.Hashes["sha256"].String()), location)
+}
@@ -15,7 +15,10 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/cache"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
 )
 
 type UpdaterConfig struct {
@@ -26,13 +29,22 @@ type UpdaterConfig struct {
 	TimestampMaxLength int64
 	SnapshotMaxLength  int64
 	TargetsMaxLength   int64
+	// Verification policy
+	KeyIDMode        metadata.KeyIDMode         // how the key IDs of the keys of a role are checked
+	TlogPolicy       *metadata.TlogPolicy       // transparency log verification, disabled if nil
+	KeylessTrustRoot *metadata.KeylessTrustRoot // required to verify keyless signatures
 	// Updater configuration
 	Fetcher               fetcher.Fetcher
+	FetcherMiddlewares    []fetcher.Middleware // wrapped around Fetcher, the first one is the outermost
+	Storage               storage.Storage      // local cache, defaults to the file system at LocalMetadataDir and LocalTargetsDir
+	TargetCache           *cache.Cache         // optional content-addressed cache used for target files instead of Storage
 	LocalTrustedRoot      []byte
 	LocalMetadataDir      string
 	LocalTargetsDir       string
 	RemoteMetadataURL     string
 	RemoteTargetsURL      string
+	RemoteMetadataMirrors []string // tried in order if downloading from RemoteMetadataURL fails
+	RemoteTargetsMirrors  []string // tried in order if downloading from RemoteTargetsURL fails
 	DisableLocalCache     bool
 	PrefixTargetsWithHash bool
 }
@@ -63,8 +75,20 @@ func New(remoteURL string, rootBytes []byte) (*UpdaterConfig, error) {
 	}, nil
 }
 
+// VerifyOptions returns the options of the verification policy the
+// signatures of all metadata are checked with
+func (cfg *UpdaterConfig) VerifyOptions() []metadata.VerifyOption {
+	return []metadata.VerifyOption{
+		metadata.WithKeyIDMode(cfg.KeyIDMode),
+		metadata.WithTlogPolicy(cfg.TlogPolicy),
+		metadata.WithKeylessTrustRoot(cfg.KeylessTrustRoot),
+	}
+}
+
+// EnsurePathsExist creates the local metadata and targets directories.
+// It does nothing if caching is disabled or a custom Storage is used
 func (cfg *UpdaterConfig) EnsurePathsExist() error {
-	if cfg.DisableLocalCache {
+	if cfg.DisableLocalCache || cfg.Storage != nil {
 		return nil
 	}
 	for _, path := range []string{cfg.LocalMetadataDir, cfg.LocalTargetsDir} {
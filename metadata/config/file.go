@@ -0,0 +1,539 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/cache"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of all environment variables overriding values
+// from the configuration file, e.g. TUF_METADATA_URL
+const EnvPrefix = "TUF_"
+
+// FileConfig represents the declarative configuration file of an Updater.
+// It can be written in YAML or JSON. Zero values mean the default of New()
+type FileConfig struct {
+	Limits      LimitsConfig      `json:"limits" yaml:"limits"`
+	Remote      RemoteConfig      `json:"remote" yaml:"remote"`
+	Cache       CacheConfig       `json:"cache" yaml:"cache"`
+	Fetcher     FetcherConfig     `json:"fetcher" yaml:"fetcher"`
+	TrustedRoot TrustedRootConfig `json:"trusted_root" yaml:"trusted_root"`
+}
+
+// LimitsConfig holds the TUF configuration limits
+type LimitsConfig struct {
+	MaxRootRotations   int64 `json:"max_root_rotations" yaml:"max_root_rotations"`
+	MaxDelegations     int   `json:"max_delegations" yaml:"max_delegations"`
+	RootMaxLength      int64 `json:"root_max_length" yaml:"root_max_length"`
+	TimestampMaxLength int64 `json:"timestamp_max_length" yaml:"timestamp_max_length"`
+	SnapshotMaxLength  int64 `json:"snapshot_max_length" yaml:"snapshot_max_length"`
+	TargetsMaxLength   int64 `json:"targets_max_length" yaml:"targets_max_length"`
+}
+
+// RemoteConfig holds where the metadata and target files are downloaded from
+type RemoteConfig struct {
+	MetadataURL           string   `json:"metadata_url" yaml:"metadata_url"`
+	TargetsURL            string   `json:"targets_url" yaml:"targets_url"`
+	MetadataMirrors       []string `json:"metadata_mirrors" yaml:"metadata_mirrors"`
+	TargetsMirrors        []string `json:"targets_mirrors" yaml:"targets_mirrors"`
+	PrefixTargetsWithHash *bool    `json:"prefix_targets_with_hash" yaml:"prefix_targets_with_hash"`
+}
+
+// CacheConfig holds the local cache configuration
+type CacheConfig struct {
+	Disable     bool              `json:"disable" yaml:"disable"`
+	MetadataDir string            `json:"metadata_dir" yaml:"metadata_dir"`
+	TargetsDir  string            `json:"targets_dir" yaml:"targets_dir"`
+	TargetCache TargetCacheConfig `json:"target_cache" yaml:"target_cache"`
+}
+
+// TargetCacheConfig configures the optional content-addressed target cache
+type TargetCacheConfig struct {
+	Dir     string   `json:"dir" yaml:"dir"`
+	MaxSize int64    `json:"max_size" yaml:"max_size"`
+	MaxAge  Duration `json:"max_age" yaml:"max_age"`
+}
+
+// FetcherConfig configures the default fetcher and its middlewares
+type FetcherConfig struct {
+	UserAgent   string            `json:"user_agent" yaml:"user_agent"`
+	Timeout     Duration          `json:"timeout" yaml:"timeout"`
+	Headers     map[string]string `json:"headers" yaml:"headers"`
+	BearerToken string            `json:"bearer_token" yaml:"bearer_token"`
+	// HeaderHosts are the hosts which get Headers and BearerToken, the
+	// hosts of the metadata and targets URLs if empty
+	HeaderHosts []string        `json:"header_hosts" yaml:"header_hosts"`
+	Trace       bool            `json:"trace" yaml:"trace"`
+	RateLimit   RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+}
+
+// RateLimitConfig limits how fast the fetcher downloads files
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             int     `json:"burst" yaml:"burst"`
+}
+
+// TrustedRootConfig tells where the initial trusted root metadata comes
+// from. Exactly one of Path or Inline must be set
+type TrustedRootConfig struct {
+	Path   string `json:"path" yaml:"path"`
+	Inline string `json:"inline" yaml:"inline"`
+}
+
+// Duration is a time.Duration written as a string like "30s" or "24h"
+type Duration time.Duration
+
+// Load reads the configuration file at path, applies the environment
+// variable overrides, validates it and returns the resulting UpdaterConfig.
+// Relative paths in the file are resolved against the file's directory
+func Load(path string) (*UpdaterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileCfg, err := ParseFile(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	err = fileCfg.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return fileCfg.UpdaterConfig(filepath.Dir(path))
+}
+
+// ParseFile parses a configuration file. The format is picked based on the
+// file extension (".json", ".yaml" or ".yml"). Unknown fields are rejected
+func ParseFile(data []byte, ext string) (*FileConfig, error) {
+	res := &FileConfig{}
+	switch strings.ToLower(ext) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(res); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(res); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported configuration file format %q, expected .json, .yaml or .yml", ext)}
+	}
+	return res, nil
+}
+
+// ApplyEnv overrides the configuration with the TUF_* environment
+// variables returned by lookup (usually os.LookupEnv)
+func (f *FileConfig) ApplyEnv(lookup func(string) (string, bool)) error {
+	strVars := map[string]*string{
+		"METADATA_URL":      &f.Remote.MetadataURL,
+		"TARGETS_URL":       &f.Remote.TargetsURL,
+		"METADATA_DIR":      &f.Cache.MetadataDir,
+		"TARGETS_DIR":       &f.Cache.TargetsDir,
+		"TARGET_CACHE_DIR":  &f.Cache.TargetCache.Dir,
+		"USER_AGENT":        &f.Fetcher.UserAgent,
+		"BEARER_TOKEN":      &f.Fetcher.BearerToken,
+		"TRUSTED_ROOT_PATH": &f.TrustedRoot.Path,
+		"TRUSTED_ROOT":      &f.TrustedRoot.Inline,
+	}
+	for name, dst := range strVars {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			*dst = value
+		}
+	}
+	listVars := map[string]*[]string{
+		"METADATA_MIRRORS": &f.Remote.MetadataMirrors,
+		"TARGETS_MIRRORS":  &f.Remote.TargetsMirrors,
+		"HEADER_HOSTS":     &f.Fetcher.HeaderHosts,
+	}
+	for name, dst := range listVars {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			*dst = splitList(value)
+		}
+	}
+	intVars := map[string]*int64{
+		"MAX_ROOT_ROTATIONS":    &f.Limits.MaxRootRotations,
+		"ROOT_MAX_LENGTH":       &f.Limits.RootMaxLength,
+		"TIMESTAMP_MAX_LENGTH":  &f.Limits.TimestampMaxLength,
+		"SNAPSHOT_MAX_LENGTH":   &f.Limits.SnapshotMaxLength,
+		"TARGETS_MAX_LENGTH":    &f.Limits.TargetsMaxLength,
+		"TARGET_CACHE_MAX_SIZE": &f.Cache.TargetCache.MaxSize,
+	}
+	for name, dst := range intVars {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return metadata.ErrValue{Msg: fmt.Sprintf("%s%s: expected an integer, got %q", EnvPrefix, name, value)}
+			}
+			*dst = v
+		}
+	}
+	if value, ok := lookup(EnvPrefix + "MAX_DELEGATIONS"); ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return metadata.ErrValue{Msg: fmt.Sprintf("%sMAX_DELEGATIONS: expected an integer, got %q", EnvPrefix, value)}
+		}
+		f.Limits.MaxDelegations = v
+	}
+	boolVars := map[string]func(bool){
+		"DISABLE_LOCAL_CACHE":      func(v bool) { f.Cache.Disable = v },
+		"PREFIX_TARGETS_WITH_HASH": func(v bool) { f.Remote.PrefixTargetsWithHash = &v },
+	}
+	for name, set := range boolVars {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return metadata.ErrValue{Msg: fmt.Sprintf("%s%s: expected a boolean, got %q", EnvPrefix, name, value)}
+			}
+			set(v)
+		}
+	}
+	durationVars := map[string]*Duration{
+		"TIMEOUT":              &f.Fetcher.Timeout,
+		"TARGET_CACHE_MAX_AGE": &f.Cache.TargetCache.MaxAge,
+	}
+	for name, dst := range durationVars {
+		if value, ok := lookup(EnvPrefix + name); ok {
+			v, err := time.ParseDuration(value)
+			if err != nil {
+				return metadata.ErrValue{Msg: fmt.Sprintf("%s%s: expected a duration like \"30s\", got %q", EnvPrefix, name, value)}
+			}
+			*dst = Duration(v)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration and returns an error listing every
+// problem found together with the name of the offending field
+func (f *FileConfig) Validate() error {
+	problems := []string{}
+	addProblem := func(field, format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	// limits
+	limits := []struct {
+		field string
+		value int64
+	}{
+		{"limits.max_root_rotations", f.Limits.MaxRootRotations},
+		{"limits.max_delegations", int64(f.Limits.MaxDelegations)},
+		{"limits.root_max_length", f.Limits.RootMaxLength},
+		{"limits.timestamp_max_length", f.Limits.TimestampMaxLength},
+		{"limits.snapshot_max_length", f.Limits.SnapshotMaxLength},
+		{"limits.targets_max_length", f.Limits.TargetsMaxLength},
+	}
+	for _, l := range limits {
+		if l.value < 0 {
+			addProblem(l.field, "must not be negative, got %d", l.value)
+		}
+	}
+	// remote
+	if f.Remote.MetadataURL == "" {
+		addProblem("remote.metadata_url", "is required")
+	} else if err := validateURL(f.Remote.MetadataURL); err != nil {
+		addProblem("remote.metadata_url", "%v", err)
+	}
+	if f.Remote.TargetsURL != "" {
+		if err := validateURL(f.Remote.TargetsURL); err != nil {
+			addProblem("remote.targets_url", "%v", err)
+		}
+	}
+	for i, mirror := range f.Remote.MetadataMirrors {
+		if err := validateURL(mirror); err != nil {
+			addProblem(fmt.Sprintf("remote.metadata_mirrors[%d]", i), "%v", err)
+		}
+	}
+	for i, mirror := range f.Remote.TargetsMirrors {
+		if err := validateURL(mirror); err != nil {
+			addProblem(fmt.Sprintf("remote.targets_mirrors[%d]", i), "%v", err)
+		}
+	}
+	// cache
+	if !f.Cache.Disable {
+		if f.Cache.MetadataDir == "" {
+			addProblem("cache.metadata_dir", "is required unless cache.disable is set")
+		}
+		if f.Cache.TargetsDir == "" && f.Cache.TargetCache.Dir == "" {
+			addProblem("cache.targets_dir", "is required unless cache.disable or cache.target_cache.dir is set")
+		}
+	}
+	if f.Cache.TargetCache.MaxSize < 0 {
+		addProblem("cache.target_cache.max_size", "must not be negative, got %d", f.Cache.TargetCache.MaxSize)
+	}
+	if f.Cache.TargetCache.MaxAge < 0 {
+		addProblem("cache.target_cache.max_age", "must not be negative, got %s", time.Duration(f.Cache.TargetCache.MaxAge))
+	}
+	if f.Cache.TargetCache.Dir == "" && (f.Cache.TargetCache.MaxSize != 0 || f.Cache.TargetCache.MaxAge != 0) {
+		addProblem("cache.target_cache.dir", "is required when an eviction policy is set")
+	}
+	// fetcher
+	if f.Fetcher.Timeout < 0 {
+		addProblem("fetcher.timeout", "must not be negative, got %s", time.Duration(f.Fetcher.Timeout))
+	}
+	for name := range f.Fetcher.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			addProblem(fmt.Sprintf("fetcher.headers[%q]", name), "invalid header name")
+		}
+	}
+	for i, host := range f.Fetcher.HeaderHosts {
+		if host == "" || strings.ContainsAny(host, "/ \r\n") {
+			addProblem(fmt.Sprintf("fetcher.header_hosts[%d]", i), "expected a host like \"example.com\" or \"example.com:8443\", got %q", host)
+		}
+	}
+	if f.Fetcher.RateLimit.RequestsPerSecond < 0 {
+		addProblem("fetcher.rate_limit.requests_per_second", "must not be negative, got %g", f.Fetcher.RateLimit.RequestsPerSecond)
+	}
+	if f.Fetcher.RateLimit.Burst < 0 {
+		addProblem("fetcher.rate_limit.burst", "must not be negative, got %d", f.Fetcher.RateLimit.Burst)
+	}
+	// trusted root
+	if f.TrustedRoot.Path == "" && f.TrustedRoot.Inline == "" {
+		addProblem("trusted_root", "one of path or inline is required")
+	}
+	if f.TrustedRoot.Path != "" && f.TrustedRoot.Inline != "" {
+		addProblem("trusted_root", "only one of path or inline can be set")
+	}
+	if len(problems) > 0 {
+		return metadata.ErrValue{Msg: fmt.Sprintf("invalid updater configuration: %s", strings.Join(problems, "; "))}
+	}
+	return nil
+}
+
+// UpdaterConfig validates the configuration file and builds an
+// UpdaterConfig from it. Relative paths are resolved against baseDir
+func (f *FileConfig) UpdaterConfig(baseDir string) (*UpdaterConfig, error) {
+	err := f.Validate()
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+	// read the trusted root
+	rootBytes := []byte(f.TrustedRoot.Inline)
+	if f.TrustedRoot.Path != "" {
+		rootBytes, err = os.ReadFile(resolve(f.TrustedRoot.Path))
+		if err != nil {
+			return nil, fmt.Errorf("trusted_root.path: %w", err)
+		}
+	}
+	// make sure the trusted root is at least a root metadata, it's verified by the Updater
+	_, err = metadata.Root().FromBytes(rootBytes)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_root: not a valid root metadata: %w", err)
+	}
+	// start from the defaults
+	cfg, err := New(f.Remote.MetadataURL, rootBytes)
+	if err != nil {
+		return nil, err
+	}
+	// limits
+	setIfNotZero(&cfg.MaxRootRotations, f.Limits.MaxRootRotations)
+	setIfNotZero(&cfg.MaxDelegations, f.Limits.MaxDelegations)
+	setIfNotZero(&cfg.RootMaxLength, f.Limits.RootMaxLength)
+	setIfNotZero(&cfg.TimestampMaxLength, f.Limits.TimestampMaxLength)
+	setIfNotZero(&cfg.SnapshotMaxLength, f.Limits.SnapshotMaxLength)
+	setIfNotZero(&cfg.TargetsMaxLength, f.Limits.TargetsMaxLength)
+	// remote
+	setIfNotZero(&cfg.RemoteTargetsURL, f.Remote.TargetsURL)
+	cfg.RemoteMetadataMirrors = f.Remote.MetadataMirrors
+	cfg.RemoteTargetsMirrors = f.Remote.TargetsMirrors
+	if f.Remote.PrefixTargetsWithHash != nil {
+		cfg.PrefixTargetsWithHash = *f.Remote.PrefixTargetsWithHash
+	}
+	// cache
+	cfg.DisableLocalCache = f.Cache.Disable
+	cfg.LocalMetadataDir = resolve(f.Cache.MetadataDir)
+	cfg.LocalTargetsDir = resolve(f.Cache.TargetsDir)
+	if f.Cache.TargetCache.Dir != "" && !f.Cache.Disable {
+		cfg.TargetCache, err = cache.New(resolve(f.Cache.TargetCache.Dir), cache.Options{
+			MaxSize: f.Cache.TargetCache.MaxSize,
+			MaxAge:  time.Duration(f.Cache.TargetCache.MaxAge),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cache.target_cache: %w", err)
+		}
+	}
+	// fetcher
+	defaultFetcher := &fetcher.DefaultFetcher{}
+	defaultFetcher.SetHTTPUserAgent(f.Fetcher.UserAgent)
+	defaultFetcher.SetTimeout(time.Duration(f.Fetcher.Timeout))
+	cfg.Fetcher = defaultFetcher
+	// fetcher middlewares
+	if f.Fetcher.Trace {
+		cfg.FetcherMiddlewares = append(cfg.FetcherMiddlewares, fetcher.WithTracing(nil))
+	}
+	if f.Fetcher.RateLimit.RequestsPerSecond > 0 {
+		cfg.FetcherMiddlewares = append(cfg.FetcherMiddlewares, fetcher.WithRateLimit(f.Fetcher.RateLimit.RequestsPerSecond, f.Fetcher.RateLimit.Burst))
+	}
+	// headers are only sent to the repository, not to mirrors or other hosts
+	headerHosts := f.Fetcher.HeaderHosts
+	if len(headerHosts) == 0 {
+		headerHosts, err = urlHosts(cfg.RemoteMetadataURL, cfg.RemoteTargetsURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(f.Fetcher.Headers) > 0 {
+		header := http.Header{}
+		for name, value := range f.Fetcher.Headers {
+			header.Set(name, value)
+		}
+		cfg.FetcherMiddlewares = append(cfg.FetcherMiddlewares, fetcher.WithHeaders(headerHosts, header))
+	}
+	if f.Fetcher.BearerToken != "" {
+		cfg.FetcherMiddlewares = append(cfg.FetcherMiddlewares, fetcher.WithBearerToken(headerHosts, f.Fetcher.BearerToken))
+	}
+	return cfg, nil
+}
+
+// String returns a human-readable description of the resolved
+// configuration which is safe to share, e.g. in a support ticket.
+// The trusted root is described by its version and digest
+func (cfg *UpdaterConfig) String() string {
+	trustedRoot := "none"
+	if len(cfg.LocalTrustedRoot) > 0 {
+		digest := sha256.Sum256(cfg.LocalTrustedRoot)
+		trustedRoot = fmt.Sprintf("sha256:%s", hex.EncodeToString(digest[:]))
+		root, err := metadata.Root().FromBytes(cfg.LocalTrustedRoot)
+		if err == nil {
+			trustedRoot = fmt.Sprintf("version %d, %s", root.Signed.Version, trustedRoot)
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("max root rotations: %d", cfg.MaxRootRotations),
+		fmt.Sprintf("max delegations: %d", cfg.MaxDelegations),
+		fmt.Sprintf("root max length: %d", cfg.RootMaxLength),
+		fmt.Sprintf("timestamp max length: %d", cfg.TimestampMaxLength),
+		fmt.Sprintf("snapshot max length: %d", cfg.SnapshotMaxLength),
+		fmt.Sprintf("targets max length: %d", cfg.TargetsMaxLength),
+		fmt.Sprintf("remote metadata URL: %s", cfg.RemoteMetadataURL),
+		fmt.Sprintf("remote metadata mirrors: %s", strings.Join(cfg.RemoteMetadataMirrors, ", ")),
+		fmt.Sprintf("remote targets URL: %s", cfg.RemoteTargetsURL),
+		fmt.Sprintf("remote targets mirrors: %s", strings.Join(cfg.RemoteTargetsMirrors, ", ")),
+		fmt.Sprintf("prefix targets with hash: %t", cfg.PrefixTargetsWithHash),
+		fmt.Sprintf("local cache disabled: %t", cfg.DisableLocalCache),
+		fmt.Sprintf("local metadata dir: %s", cfg.LocalMetadataDir),
+		fmt.Sprintf("local targets dir: %s", cfg.LocalTargetsDir),
+		fmt.Sprintf("storage: %T", cfg.Storage),
+		fmt.Sprintf("target cache: %t", cfg.TargetCache != nil),
+		fmt.Sprintf("fetcher: %T", cfg.Fetcher),
+		fmt.Sprintf("fetcher middlewares: %d", len(cfg.FetcherMiddlewares)),
+		fmt.Sprintf("trusted root: %s", trustedRoot),
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UnmarshalJSON parses a duration string like "30s"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a duration like \"30s\": %w", err)
+	}
+	return d.parse(s)
+}
+
+// UnmarshalYAML parses a duration string like "30s"
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return fmt.Errorf("line %d: expected a duration like \"30s\": %w", value.Line, err)
+	}
+	return d.parse(s)
+}
+
+// parse sets d from a duration string, an empty string means zero
+func (d *Duration) parse(s string) error {
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("expected a duration like \"30s\", got %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// validateURL makes sure rawURL is an absolute http(s) URL
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("expected an http or https URL, got %q", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in URL %q", rawURL)
+	}
+	return nil
+}
+
+// urlHosts returns the distinct hosts of the given URLs
+func urlHosts(rawURLs ...string) ([]string, error) {
+	res := []string{}
+	for _, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+		}
+		if !slices.Contains(res, u.Host) {
+			res = append(res, u.Host)
+		}
+	}
+	return res, nil
+}
+
+// splitList splits a comma separated list and drops empty elements
+func splitList(value string) []string {
+	res := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// setIfNotZero sets dst to value unless value is the zero value
+func setIfNotZero[T comparable](dst *T, value T) {
+	var zero T
+	if value != zero {
+		*dst = value
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	rootBytes, err := metadata.Root().ToBytes(false)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "root.json"), rootBytes, 0644))
+	configFile := filepath.Join(dir, "updater.yaml")
+	assert.NoError(t, os.WriteFile(configFile, []byte(`
+limits:
+  max_delegations: 8
+remote:
+  metadata_url: https://example.com/metadata
+  metadata_mirrors:
+    - https://mirror.example.com/metadata
+  prefix_targets_with_hash: false
+cache:
+  metadata_dir: metadata
+  targets_dir: targets
+fetcher:
+  timeout: 30s
+trusted_root:
+  path: root.json
+`), 0644))
+
+	t.Setenv("TUF_TARGETS_URL", "https://cdn.example.com/targets")
+	cfg, err := Load(configFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, cfg.MaxDelegations)
+	assert.Equal(t, int64(32), cfg.MaxRootRotations)
+	assert.Equal(t, "https://example.com/metadata", cfg.RemoteMetadataURL)
+	assert.Equal(t, "https://cdn.example.com/targets", cfg.RemoteTargetsURL)
+	assert.Equal(t, []string{"https://mirror.example.com/metadata"}, cfg.RemoteMetadataMirrors)
+	assert.False(t, cfg.PrefixTargetsWithHash)
+	assert.Equal(t, filepath.Join(dir, "metadata"), cfg.LocalMetadataDir)
+	assert.Equal(t, filepath.Join(dir, "targets"), cfg.LocalTargetsDir)
+	assert.Equal(t, rootBytes, cfg.LocalTrustedRoot)
+	assert.Contains(t, cfg.String(), "trusted root: version 1, sha256:")
+}
+
+func TestParseFileStrict(t *testing.T) {
+	_, err := ParseFile([]byte(`{"remote": {"metadata_ulr": "https://example.com"}}`), ".json")
+	assert.ErrorContains(t, err, "metadata_ulr")
+
+	_, err = ParseFile([]byte("fetcher:\n  timeout: soon\n"), ".yaml")
+	assert.ErrorContains(t, err, "expected a duration")
+
+	_, err = ParseFile([]byte("{}"), ".toml")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "unsupported configuration file format \".toml\", expected .json, .yaml or .yml"})
+}
+
+func TestValidate(t *testing.T) {
+	f := &FileConfig{}
+	f.Remote.MetadataURL = "ftp://example.com"
+	f.Remote.TargetsMirrors = []string{"https://ok.example.com", "not a url"}
+	f.Fetcher.Timeout = Duration(-time.Second)
+	f.Fetcher.HeaderHosts = []string{"https://example.com"}
+	f.TrustedRoot.Path = "root.json"
+	f.TrustedRoot.Inline = "{}"
+	err := f.Validate()
+	assert.ErrorContains(t, err, "remote.metadata_url: expected an http or https URL")
+	assert.ErrorContains(t, err, "remote.targets_mirrors[1]")
+	assert.ErrorContains(t, err, "cache.metadata_dir: is required")
+	assert.ErrorContains(t, err, "fetcher.timeout: must not be negative")
+	assert.ErrorContains(t, err, "fetcher.header_hosts[0]: expected a host")
+	assert.ErrorContains(t, err, "trusted_root: only one of path or inline can be set")
+
+	// environment overrides are validated too
+	err = f.ApplyEnv(func(name string) (string, bool) {
+		if name == "TUF_MAX_DELEGATIONS" {
+			return "many", true
+		}
+		return "", false
+	})
+	assert.ErrorContains(t, err, "TUF_MAX_DELEGATIONS: expected an integer")
+}
+
+func TestHeaderHosts(t *testing.T) {
+	rootBytes, err := metadata.Root().ToBytes(false)
+	assert.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+	mirror := httptest.NewServer(srv.Config.Handler)
+	defer mirror.Close()
+
+	f := &FileConfig{}
+	f.Remote.MetadataURL = srv.URL + "/metadata"
+	f.Remote.MetadataMirrors = []string{mirror.URL + "/metadata"}
+	f.Cache.Disable = true
+	f.Fetcher.BearerToken = "token"
+	f.TrustedRoot.Inline = string(rootBytes)
+	cfg, err := f.UpdaterConfig("")
+	assert.NoError(t, err)
+	download := fetcher.Chain(cfg.Fetcher, cfg.FetcherMiddlewares...)
+	// the token is sent to the repository only
+	data, err := download.DownloadFile(srv.URL+"/metadata/root.json", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token", string(data))
+	data, err = download.DownloadFile(mirror.URL+"/metadata/root.json", 100)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	// unless the mirror is listed
+	f.Fetcher.HeaderHosts = []string{strings.TrimPrefix(mirror.URL, "http://")}
+	cfg, err = f.UpdaterConfig("")
+	assert.NoError(t, err)
+	download = fetcher.Chain(cfg.Fetcher, cfg.FetcherMiddlewares...)
+	data, err = download.DownloadFile(mirror.URL+"/metadata/root.json", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token", string(data))
+}
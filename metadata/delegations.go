@@ -0,0 +1,271 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// AddDelegatedRole appends the delegated role “role“ signed by “keys“.
+// The key IDs of “keys“ are added to the ones already set in “role“.
+// Delegated roles are searched in order, so the new role has the lowest
+// priority, see MoveDelegatedRole.
+func (signed *TargetsType) AddDelegatedRole(role DelegatedRole, keys ...*Key) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		if d.SuccinctRoles != nil {
+			return ErrValue{Msg: "delegated roles can not be added to succinct roles delegations"}
+		}
+		role.KeyIDs = append([]string{}, role.KeyIDs...)
+		for _, key := range keys {
+			if !slices.Contains(role.KeyIDs, key.ID()) {
+				role.KeyIDs = append(role.KeyIDs, key.ID())
+			}
+			d.Keys[key.ID()] = key
+		}
+		d.Roles = append(d.Roles, role)
+		return nil
+	})
+}
+
+// RemoveDelegatedRole removes the delegated role “name“ and the keys which
+// are no longer used by any other delegated role
+func (signed *TargetsType) RemoveDelegatedRole(name string) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		i, err := d.roleIndex(name)
+		if err != nil {
+			return err
+		}
+		d.Roles = slices.Delete(d.Roles, i, i+1)
+		return nil
+	})
+}
+
+// SetDelegatedPaths sets the path patterns of the delegated role “name“.
+// Path hash prefixes are removed as a role uses either of them.
+func (signed *TargetsType) SetDelegatedPaths(name string, paths []string) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		i, err := d.roleIndex(name)
+		if err != nil {
+			return err
+		}
+		d.Roles[i].Paths = append([]string{}, paths...)
+		d.Roles[i].PathHashPrefixes = nil
+		return nil
+	})
+}
+
+// SetDelegatedPathHashPrefixes sets the path hash prefixes of the
+// delegated role “name“. Paths are removed as a role uses either of them.
+func (signed *TargetsType) SetDelegatedPathHashPrefixes(name string, prefixes []string) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		i, err := d.roleIndex(name)
+		if err != nil {
+			return err
+		}
+		d.Roles[i].PathHashPrefixes = append([]string{}, prefixes...)
+		d.Roles[i].Paths = nil
+		return nil
+	})
+}
+
+// SetDelegationTerminating sets whether the search for a target stops at
+// the delegated role “name“
+func (signed *TargetsType) SetDelegationTerminating(name string, terminating bool) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		i, err := d.roleIndex(name)
+		if err != nil {
+			return err
+		}
+		d.Roles[i].Terminating = terminating
+		return nil
+	})
+}
+
+// SetDelegationThreshold sets the signature threshold of the delegated
+// role “name“
+func (signed *TargetsType) SetDelegationThreshold(name string, threshold int) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		i, err := d.roleIndex(name)
+		if err != nil {
+			return err
+		}
+		d.Roles[i].Threshold = threshold
+		return nil
+	})
+}
+
+// MoveDelegatedRole moves the delegated role “name“ to position “index“.
+// Delegated roles are searched in order, i.e. index 0 is the first one
+// clients look for a target in.
+func (signed *TargetsType) MoveDelegatedRole(name string, index int) error {
+	return signed.updateDelegations(func(d *Delegations) error {
+		i, err := d.roleIndex(name)
+		if err != nil {
+			return err
+		}
+		if index < 0 || index >= len(d.Roles) {
+			return ErrValue{Msg: fmt.Sprintf("index %d is out of range for %d delegated roles", index, len(d.Roles))}
+		}
+		role := d.Roles[i]
+		d.Roles = slices.Delete(d.Roles, i, i+1)
+		d.Roles = slices.Insert(d.Roles, index, role)
+		return nil
+	})
+}
+
+// Validate checks the delegations are well-formed: either delegated roles
+// or succinct roles are used, role names are unique and not names of
+// top-level roles, each role uses either paths or path hash prefixes, all
+// key IDs are present in Keys and thresholds can be met
+func (d *Delegations) Validate() error {
+	if d.Roles != nil && d.SuccinctRoles != nil {
+		return ErrValue{Msg: "delegations can not use both roles and succinct roles"}
+	}
+	checkKeys := func(name string, keyIDs []string, threshold int) error {
+		for i, keyID := range keyIDs {
+			if _, ok := d.Keys[keyID]; !ok {
+				return ErrValue{Msg: fmt.Sprintf("key with ID %s of %s not found in delegation keys", keyID, name)}
+			}
+			if slices.Contains(keyIDs[:i], keyID) {
+				return ErrValue{Msg: fmt.Sprintf("duplicate key ID %s in %s", keyID, name)}
+			}
+		}
+		if threshold < 1 || threshold > len(keyIDs) {
+			return ErrValue{Msg: fmt.Sprintf("threshold of %s must be between 1 and %d, got %d", name, len(keyIDs), threshold)}
+		}
+		return nil
+	}
+	names := map[string]bool{}
+	for _, role := range d.Roles {
+		if role.Name == "" {
+			return ErrValue{Msg: "delegated role name can not be empty"}
+		}
+		if slices.Contains([]string{ROOT, SNAPSHOT, TARGETS, TIMESTAMP}, role.Name) {
+			return ErrValue{Msg: fmt.Sprintf("delegated role name %s is reserved for a top-level role", role.Name)}
+		}
+		if names[role.Name] {
+			return ErrValue{Msg: fmt.Sprintf("duplicate delegated role name %s", role.Name)}
+		}
+		names[role.Name] = true
+		if (len(role.Paths) == 0) == (len(role.PathHashPrefixes) == 0) {
+			return ErrValue{Msg: fmt.Sprintf("delegated role %s must set either paths or path hash prefixes", role.Name)}
+		}
+		if err := checkKeys(role.Name, role.KeyIDs, role.Threshold); err != nil {
+			return err
+		}
+	}
+	if d.SuccinctRoles != nil {
+		if err := checkBitLength(d.SuccinctRoles.BitLength); err != nil {
+			return err
+		}
+		if d.SuccinctRoles.NamePrefix == "" {
+			return ErrValue{Msg: "succinct roles name prefix can not be empty"}
+		}
+		if err := checkKeys("succinct roles", d.SuccinctRoles.KeyIDs, d.SuccinctRoles.Threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneKeys removes the keys which are not used by any delegated role
+func (d *Delegations) PruneKeys() {
+	used := map[string]bool{}
+	for _, role := range d.Roles {
+		for _, keyID := range role.KeyIDs {
+			used[keyID] = true
+		}
+	}
+	if d.SuccinctRoles != nil {
+		for _, keyID := range d.SuccinctRoles.KeyIDs {
+			used[keyID] = true
+		}
+	}
+	for keyID := range d.Keys {
+		if !used[keyID] {
+			log.Debugf("Removed unused delegation key with ID %s", keyID)
+			delete(d.Keys, keyID)
+		}
+	}
+}
+
+// updateDelegations applies update to a copy of the delegations and keeps
+// the result only if it is valid. Unused keys are removed and delegations
+// without any roles are dropped altogether
+func (signed *TargetsType) updateDelegations(update func(d *Delegations) error) error {
+	d := &Delegations{Keys: map[string]*Key{}}
+	if signed.Delegations != nil {
+		d = signed.Delegations.clone()
+	}
+	if err := update(d); err != nil {
+		return err
+	}
+	d.PruneKeys()
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	if len(d.Roles) == 0 && d.SuccinctRoles == nil && len(d.UnrecognizedFields) == 0 {
+		d = nil
+	}
+	signed.Delegations = d
+	return nil
+}
+
+// roleIndex returns the index of the delegated role “name“
+func (d *Delegations) roleIndex(name string) (int, error) {
+	for i, role := range d.Roles {
+		if role.Name == name {
+			return i, nil
+		}
+	}
+	return -1, ErrValue{Msg: fmt.Sprintf("delegated role %s doesn't exist", name)}
+}
+
+// clone returns a copy of the delegations which can be modified without
+// changing the original. Keys are shared as they are never modified
+func (d *Delegations) clone() *Delegations {
+	res := *d
+	res.Keys = make(map[string]*Key, len(d.Keys))
+	for keyID, key := range d.Keys {
+		res.Keys[keyID] = key
+	}
+	if d.Roles != nil {
+		res.Roles = make([]DelegatedRole, len(d.Roles))
+		for i, role := range d.Roles {
+			role.KeyIDs = append([]string{}, role.KeyIDs...)
+			if role.Paths != nil {
+				role.Paths = append([]string{}, role.Paths...)
+			}
+			if role.PathHashPrefixes != nil {
+				role.PathHashPrefixes = append([]string{}, role.PathHashPrefixes...)
+			}
+			res.Roles[i] = role
+		}
+	}
+	if d.SuccinctRoles != nil {
+		succinctRoles := *d.SuccinctRoles
+		succinctRoles.KeyIDs = append([]string{}, d.SuccinctRoles.KeyIDs...)
+		res.SuccinctRoles = &succinctRoles
+	}
+	return &res
+}
+
+// checkBitLength checks that bitLength is between 1 and MaxBitLength
+func checkBitLength(bitLength int) error {
+	if bitLength < 1 || bitLength > MaxBitLength {
+		return ErrValue{Msg: fmt.Sprintf("bit length must be between 1 and %d, got %d", MaxBitLength, bitLength)}
+	}
+	return nil
+}
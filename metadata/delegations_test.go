@@ -0,0 +1,114 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helperNewKey returns a new ed25519 public key
+func helperNewKey(t *testing.T) *Key {
+	public, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	key, err := KeyFromPublicKey(public)
+	assert.NoError(t, err)
+	return key
+}
+
+func TestDelegatedRoleOperations(t *testing.T) {
+	targets := Targets()
+	key1 := helperNewKey(t)
+	key2 := helperNewKey(t)
+
+	assert.NoError(t, targets.Signed.AddDelegatedRole(DelegatedRole{Name: "a", Threshold: 1, Paths: []string{"a/*"}}, key1))
+	assert.NoError(t, targets.Signed.AddDelegatedRole(DelegatedRole{Name: "b", Threshold: 2, Paths: []string{"b/*"}}, key1, key2))
+	assert.NoError(t, targets.Signed.AddDelegatedRole(DelegatedRole{Name: "c", Threshold: 1, PathHashPrefixes: []string{"8f"}}, key2))
+	assert.Len(t, targets.Signed.Delegations.Keys, 2)
+
+	// invalid results are rejected and leave the delegations untouched
+	err := targets.Signed.AddDelegatedRole(DelegatedRole{Name: "a", Threshold: 1, Paths: []string{"*"}}, key1)
+	assert.ErrorIs(t, err, ErrValue{Msg: "duplicate delegated role name a"})
+	err = targets.Signed.AddDelegatedRole(DelegatedRole{Name: SNAPSHOT, Threshold: 1, Paths: []string{"*"}}, key1)
+	assert.ErrorIs(t, err, ErrValue{Msg: "delegated role name snapshot is reserved for a top-level role"})
+	err = targets.Signed.AddDelegatedRole(DelegatedRole{Name: "d", KeyIDs: []string{"unknown"}, Threshold: 1, Paths: []string{"*"}})
+	assert.ErrorIs(t, err, ErrValue{Msg: "key with ID unknown of d not found in delegation keys"})
+	err = targets.Signed.AddDelegatedRole(DelegatedRole{Name: "d", Threshold: 1}, key1)
+	assert.ErrorIs(t, err, ErrValue{Msg: "delegated role d must set either paths or path hash prefixes"})
+	err = targets.Signed.SetDelegationThreshold("b", 3)
+	assert.ErrorIs(t, err, ErrValue{Msg: "threshold of b must be between 1 and 2, got 3"})
+	err = targets.Signed.SetDelegationTerminating("missing", true)
+	assert.ErrorIs(t, err, ErrValue{Msg: "delegated role missing doesn't exist"})
+	assert.Len(t, targets.Signed.Delegations.Roles, 3)
+	assert.Equal(t, 2, targets.Signed.Delegations.Roles[1].Threshold)
+
+	assert.NoError(t, targets.Signed.SetDelegatedPathHashPrefixes("a", []string{"00", "01"}))
+	assert.Nil(t, targets.Signed.Delegations.Roles[0].Paths)
+	assert.NoError(t, targets.Signed.SetDelegatedPaths("a", []string{"a/**"}))
+	assert.Nil(t, targets.Signed.Delegations.Roles[0].PathHashPrefixes)
+	assert.NoError(t, targets.Signed.SetDelegationTerminating("a", true))
+	assert.True(t, targets.Signed.Delegations.Roles[0].Terminating)
+
+	// reorder
+	assert.NoError(t, targets.Signed.MoveDelegatedRole("c", 0))
+	assert.Equal(t, []string{"c", "a", "b"}, []string{targets.Signed.Delegations.Roles[0].Name, targets.Signed.Delegations.Roles[1].Name, targets.Signed.Delegations.Roles[2].Name})
+	assert.ErrorIs(t, targets.Signed.MoveDelegatedRole("c", 3), ErrValue{Msg: "index 3 is out of range for 3 delegated roles"})
+
+	// unused keys are removed together with the roles
+	assert.NoError(t, targets.Signed.RemoveDelegatedRole("b"))
+	assert.Len(t, targets.Signed.Delegations.Keys, 2)
+	assert.NoError(t, targets.Signed.RemoveDelegatedRole("c"))
+	assert.Len(t, targets.Signed.Delegations.Keys, 1)
+	assert.Contains(t, targets.Signed.Delegations.Keys, key1.ID())
+	assert.NoError(t, targets.Signed.RemoveDelegatedRole("a"))
+	assert.Nil(t, targets.Signed.Delegations)
+}
+
+func TestDelegationsValidateSuccinctRoles(t *testing.T) {
+	key := helperNewKey(t)
+	delegations := &Delegations{
+		Keys: map[string]*Key{key.ID(): key},
+		SuccinctRoles: &SuccinctRoles{
+			KeyIDs:     []string{key.ID()},
+			Threshold:  1,
+			BitLength:  17,
+			NamePrefix: "bin",
+		},
+	}
+	assert.ErrorIs(t, delegations.Validate(), ErrValue{Msg: "bit length must be between 1 and 16, got 17"})
+	delegations.SuccinctRoles.BitLength = 8
+	assert.NoError(t, delegations.Validate())
+
+	targets := Targets()
+	targets.Signed.Delegations = delegations
+	err := targets.Signed.AddDelegatedRole(DelegatedRole{Name: "a", Threshold: 1, Paths: []string{"*"}}, key)
+	assert.ErrorIs(t, err, ErrValue{Msg: "delegated roles can not be added to succinct roles delegations"})
+}
+
+func TestSuccinctRolesBitLength(t *testing.T) {
+	// metadata can't delegate to more than 2^MaxBitLength roles
+	var role SuccinctRoles
+	err := json.Unmarshal([]byte(`{"keyids": [], "threshold": 1, "bit_length": 32, "name_prefix": "bin"}`), &role)
+	assert.ErrorIs(t, err, ErrValue{Msg: "bit length must be between 1 and 16, got 32"})
+	assert.NoError(t, json.Unmarshal([]byte(`{"keyids": [], "threshold": 1, "bit_length": 16, "name_prefix": "bin"}`), &role))
+	assert.Len(t, role.GetRoles(), 1<<16)
+	assert.True(t, role.IsDelegatedRole("bin-ffff"))
+
+	// nor are they enumerated if the bit length was set in code
+	role.BitLength = 32
+	assert.Empty(t, role.GetRoles())
+	assert.Empty(t, role.GetRolesForTarget("file.txt"))
+	assert.False(t, role.IsDelegatedRole("bin-00000000"))
+}
@@ -102,6 +102,20 @@ func (e ErrLengthOrHashMismatch) Is(target error) bool {
 	return target == ErrRepository{} || target == ErrLengthOrHashMismatch{}
 }
 
+// ErrKeyIDMismatch - The key ID declared for a key does not match the key
+type ErrKeyIDMismatch struct {
+	Msg string
+}
+
+func (e ErrKeyIDMismatch) Error() string {
+	return fmt.Sprintf("key ID mismatch error: %s", e.Msg)
+}
+
+// ErrKeyIDMismatch is a subset of ErrRepository
+func (e ErrKeyIDMismatch) Is(target error) bool {
+	return target == ErrRepository{} || target == ErrKeyIDMismatch{}
+}
+
 // Download errors
 
 // ErrDownload - An error occurred while attempting to download a file
@@ -12,8 +12,11 @@
 package fetcher
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
 )
@@ -23,18 +26,61 @@ type Fetcher interface {
 	DownloadFile(urlPath string, maxLength int64) ([]byte, error)
 }
 
+// HeaderFetcher is a Fetcher which can send additional request headers
+type HeaderFetcher interface {
+	Fetcher
+	DownloadFileWithHeader(urlPath string, maxLength int64, header http.Header) ([]byte, error)
+}
+
 // Default fetcher
 type DefaultFetcher struct {
 	httpUserAgent string
+	timeout       time.Duration
+}
+
+// SetHTTPUserAgent sets the User-Agent header used for all requests
+func (d *DefaultFetcher) SetHTTPUserAgent(httpUserAgent string) {
+	d.httpUserAgent = httpUserAgent
+}
+
+// SetTimeout sets the time limit for each request, zero means no timeout
+func (d *DefaultFetcher) SetTimeout(timeout time.Duration) {
+	d.timeout = timeout
 }
 
 // DownloadFile downloads a file from urlPath, errors out if it failed or its length is larger than maxLength
 func (d *DefaultFetcher) DownloadFile(urlPath string, maxLength int64) ([]byte, error) {
-	client := http.DefaultClient
+	return d.DownloadFileWithHeader(urlPath, maxLength, nil)
+}
+
+// DownloadFileWithHeader is like DownloadFile but also sends the given
+// request headers. They are dropped if the request is redirected to
+// another host
+func (d *DefaultFetcher) DownloadFileWithHeader(urlPath string, maxLength int64, header http.Header) ([]byte, error) {
+	client := &http.Client{
+		Timeout: d.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// same limit as the default policy of http.Client
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+				for name := range header {
+					req.Header.Del(name)
+				}
+			}
+			return nil
+		},
+	}
 	req, err := http.NewRequest("GET", urlPath, nil)
 	if err != nil {
 		return nil, err
 	}
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
 	// use in case of multiple sessions
 	if d.httpUserAgent != "" {
 		req.Header.Set("User-Agent", d.httpUserAgent)
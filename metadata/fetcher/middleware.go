@@ -0,0 +1,294 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// Middleware decorates a Fetcher with additional behaviour
+type Middleware func(next Fetcher) Fetcher
+
+// DownloadFunc is a single download with the given request headers. It
+// implements HeaderFetcher so middlewares can be written as plain functions
+type DownloadFunc func(urlPath string, maxLength int64, header http.Header) ([]byte, error)
+
+// DownloadFile calls f without additional request headers
+func (f DownloadFunc) DownloadFile(urlPath string, maxLength int64) ([]byte, error) {
+	return f(urlPath, maxLength, nil)
+}
+
+// DownloadFileWithHeader calls f
+func (f DownloadFunc) DownloadFileWithHeader(urlPath string, maxLength int64, header http.Header) ([]byte, error) {
+	return f(urlPath, maxLength, header)
+}
+
+// Chain wraps f with the given middlewares. The first middleware is the
+// outermost one, i.e. it sees each download first
+func Chain(f Fetcher, middlewares ...Middleware) Fetcher {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f = middlewares[i](f)
+	}
+	return f
+}
+
+// Download downloads urlPath using f and passes the request headers along
+// if f supports them
+func Download(f Fetcher, urlPath string, maxLength int64, header http.Header) ([]byte, error) {
+	if hf, ok := f.(HeaderFetcher); ok {
+		return hf.DownloadFileWithHeader(urlPath, maxLength, header)
+	}
+	if len(header) > 0 {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("fetcher %T does not support request headers", f)}
+	}
+	return f.DownloadFile(urlPath, maxLength)
+}
+
+// WithHeaders returns a Middleware adding static request headers to the
+// requests to hosts. Hosts are given as "host" or "host:port"
+func WithHeaders(hosts []string, header http.Header) Middleware {
+	return func(next Fetcher) Fetcher {
+		return DownloadFunc(func(urlPath string, maxLength int64, h http.Header) ([]byte, error) {
+			if !HostAllowed(urlPath, hosts) {
+				return Download(next, urlPath, maxLength, h)
+			}
+			return Download(next, urlPath, maxLength, mergeHeaders(h, header))
+		})
+	}
+}
+
+// WithBearerToken returns a Middleware authenticating each request to
+// hosts with a static bearer token
+func WithBearerToken(hosts []string, token string) Middleware {
+	return WithHeaders(hosts, http.Header{"Authorization": []string{"Bearer " + token}})
+}
+
+// HostAllowed reports whether the host of urlPath is one of hosts. A host
+// without a port matches any port
+func HostAllowed(urlPath string, hosts []string) bool {
+	u, err := url.Parse(urlPath)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenSource returns a bearer token. refresh is set if the previously
+// returned token was rejected by the server and a new one is needed
+type TokenSource func(refresh bool) (string, error)
+
+// WithTokenSource returns a Middleware authenticating each request to hosts
+// with a bearer token from source. If the server responds with 401
+// Unauthorized the token is refreshed and the request is retried once
+func WithTokenSource(hosts []string, source TokenSource) Middleware {
+	return func(next Fetcher) Fetcher {
+		var mu sync.Mutex
+		token := ""
+		getToken := func(refresh bool) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if token != "" && !refresh {
+				return token, nil
+			}
+			t, err := source(refresh)
+			if err != nil {
+				return "", fmt.Errorf("failed to get bearer token: %w", err)
+			}
+			token = t
+			return token, nil
+		}
+		return DownloadFunc(func(urlPath string, maxLength int64, h http.Header) ([]byte, error) {
+			if !HostAllowed(urlPath, hosts) {
+				return Download(next, urlPath, maxLength, h)
+			}
+			t, err := getToken(false)
+			if err != nil {
+				return nil, err
+			}
+			data, err := Download(next, urlPath, maxLength, mergeHeaders(h, http.Header{"Authorization": []string{"Bearer " + t}}))
+			var errHTTP metadata.ErrDownloadHTTP
+			if err == nil || !errors.As(err, &errHTTP) || errHTTP.StatusCode != http.StatusUnauthorized {
+				return data, err
+			}
+			log.Debugf("Refreshing the bearer token for %s", urlPath)
+			t, err = getToken(true)
+			if err != nil {
+				return nil, err
+			}
+			return Download(next, urlPath, maxLength, mergeHeaders(h, http.Header{"Authorization": []string{"Bearer " + t}}))
+		})
+	}
+}
+
+// Trace describes a single finished download
+type Trace struct {
+	URL      string
+	Start    time.Time
+	Duration time.Duration
+	Bytes    int
+	Err      error
+}
+
+// WithTracing returns a Middleware calling trace after each download.
+// If trace is nil the downloads are logged at debug level
+func WithTracing(trace func(Trace)) Middleware {
+	if trace == nil {
+		trace = func(t Trace) {
+			if t.Err != nil {
+				log.Debugf("GET %s failed after %s: %v", t.URL, t.Duration, t.Err)
+				return
+			}
+			log.Debugf("GET %s: %d bytes in %s", t.URL, t.Bytes, t.Duration)
+		}
+	}
+	return func(next Fetcher) Fetcher {
+		return DownloadFunc(func(urlPath string, maxLength int64, h http.Header) ([]byte, error) {
+			start := time.Now()
+			data, err := Download(next, urlPath, maxLength, h)
+			trace(Trace{
+				URL:      urlPath,
+				Start:    start,
+				Duration: time.Since(start),
+				Bytes:    len(data),
+				Err:      err,
+			})
+			return data, err
+		})
+	}
+}
+
+// URLStats holds the download counters of a single URL
+type URLStats struct {
+	Requests int64 `json:"requests"`
+	Failures int64 `json:"failures"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Metrics counts requests, failures and downloaded bytes per URL.
+// It is safe for concurrent use
+type Metrics struct {
+	mu   sync.Mutex
+	urls map[string]*URLStats
+}
+
+// NewMetrics creates an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{urls: map[string]*URLStats{}}
+}
+
+// Middleware returns a Middleware updating m with each download
+func (m *Metrics) Middleware() Middleware {
+	return func(next Fetcher) Fetcher {
+		return DownloadFunc(func(urlPath string, maxLength int64, h http.Header) ([]byte, error) {
+			data, err := Download(next, urlPath, maxLength, h)
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			stats, ok := m.urls[urlPath]
+			if !ok {
+				stats = &URLStats{}
+				m.urls[urlPath] = stats
+			}
+			stats.Requests++
+			stats.Bytes += int64(len(data))
+			if err != nil {
+				stats.Failures++
+			}
+			return data, err
+		})
+	}
+}
+
+// Snapshot returns a copy of the current counters per URL
+func (m *Metrics) Snapshot() map[string]URLStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[string]URLStats, len(m.urls))
+	for url, stats := range m.urls {
+		res[url] = *stats
+	}
+	return res
+}
+
+// Total returns the sum of the counters of all URLs
+func (m *Metrics) Total() URLStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := URLStats{}
+	for _, stats := range m.urls {
+		res.Requests += stats.Requests
+		res.Failures += stats.Failures
+		res.Bytes += stats.Bytes
+	}
+	return res
+}
+
+// WithRateLimit returns a Middleware allowing at most requestsPerSecond
+// downloads on average with bursts of up to burst downloads. Downloads over
+// the limit wait for their turn
+func WithRateLimit(requestsPerSecond float64, burst int) Middleware {
+	if burst < 1 {
+		burst = 1
+	}
+	var mu sync.Mutex
+	tokens := float64(burst)
+	last := time.Now()
+	// wait returns how long the caller must sleep before downloading
+	wait := func() time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		now := time.Now()
+		tokens += now.Sub(last).Seconds() * requestsPerSecond
+		if tokens > float64(burst) {
+			tokens = float64(burst)
+		}
+		last = now
+		tokens--
+		if tokens >= 0 {
+			return 0
+		}
+		return time.Duration(-tokens / requestsPerSecond * float64(time.Second))
+	}
+	return func(next Fetcher) Fetcher {
+		return DownloadFunc(func(urlPath string, maxLength int64, h http.Header) ([]byte, error) {
+			if requestsPerSecond > 0 {
+				time.Sleep(wait())
+			}
+			return Download(next, urlPath, maxLength, h)
+		})
+	}
+}
+
+// mergeHeaders returns a copy of header with the values of extra set on top
+func mergeHeaders(header, extra http.Header) http.Header {
+	res := header.Clone()
+	if res == nil {
+		res = http.Header{}
+	}
+	for name, values := range extra {
+		res[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+	}
+	return res
+}
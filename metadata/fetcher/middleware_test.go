@@ -0,0 +1,132 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package fetcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("X-Repository")))
+	}))
+	defer srv.Close()
+
+	refreshed := 0
+	source := func(refresh bool) (string, error) {
+		if !refresh {
+			return "stale", nil
+		}
+		refreshed++
+		return "fresh", nil
+	}
+	traces := []Trace{}
+	metrics := NewMetrics()
+	f := Chain(&DefaultFetcher{},
+		metrics.Middleware(),
+		WithTracing(func(trace Trace) { traces = append(traces, trace) }),
+		WithHeaders([]string{"127.0.0.1"}, http.Header{"X-Repository": []string{"main"}}),
+		WithTokenSource([]string{"127.0.0.1"}, source),
+	)
+
+	data, err := f.DownloadFile(srv.URL+"/root.json", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", string(data))
+	// the refreshed token is reused
+	_, err = f.DownloadFile(srv.URL+"/root.json", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, refreshed)
+
+	assert.Len(t, traces, 2)
+	assert.Equal(t, 4, traces[0].Bytes)
+	assert.Equal(t, URLStats{Requests: 2, Bytes: 8}, metrics.Snapshot()[srv.URL+"/root.json"])
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	metrics := NewMetrics()
+	f := Chain(&DefaultFetcher{}, metrics.Middleware(), WithBearerToken([]string{"127.0.0.1"}, "token"))
+	_, err := f.DownloadFile(srv.URL+"/missing.json", 100)
+	assert.ErrorIs(t, err, metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: srv.URL + "/missing.json"})
+	assert.Equal(t, URLStats{Requests: 1, Failures: 1}, metrics.Total())
+
+	// headers can't be silently dropped by a fetcher which doesn't support them
+	plain := struct{ Fetcher }{&DefaultFetcher{}}
+	_, err = Chain(plain, WithBearerToken([]string{"127.0.0.1"}, "token")).DownloadFile(srv.URL, 100)
+	assert.ErrorContains(t, err, "does not support request headers")
+}
+
+func TestHeadersScopedToHosts(t *testing.T) {
+	// other is a different host because of its port
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization") + r.Header.Get("X-Repository")))
+	}))
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, other.URL+"/root.json", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization") + r.Header.Get("X-Repository")))
+	}))
+	defer srv.Close()
+
+	hosts := []string{strings.TrimPrefix(srv.URL, "http://")}
+	f := Chain(&DefaultFetcher{},
+		WithHeaders(hosts, http.Header{"X-Repository": []string{"main"}}),
+		WithBearerToken(hosts, "token"),
+	)
+	data, err := f.DownloadFile(srv.URL+"/root.json", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer tokenmain", string(data))
+	// not sent to other hosts
+	data, err = f.DownloadFile(other.URL+"/root.json", 100)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+	// and dropped when redirected to other hosts
+	data, err = f.DownloadFile(srv.URL+"/redirect", 100)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	assert.True(t, HostAllowed("https://Example.com:8443/root.json", []string{"example.com"}))
+	assert.True(t, HostAllowed("https://example.com:8443/root.json", []string{"example.com:8443"}))
+	assert.False(t, HostAllowed("https://example.com/root.json", []string{"example.com:8443"}))
+	assert.False(t, HostAllowed("https://example.com.evil.org/root.json", []string{"example.com"}))
+}
+
+func TestRateLimit(t *testing.T) {
+	calls := 0
+	f := Chain(DownloadFunc(func(string, int64, http.Header) ([]byte, error) {
+		calls++
+		return nil, nil
+	}), WithRateLimit(50, 1))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := f.DownloadFile("https://example.com", 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, calls)
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
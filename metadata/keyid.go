@@ -0,0 +1,163 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"golang.org/x/exp/slices"
+)
+
+// KeyIDMode selects how the key IDs declared in metadata are checked
+// against the content of the keys they name
+type KeyIDMode int
+
+const (
+	// KeyIDModeUnchecked accepts any declared key ID, key IDs are only
+	// used to look up keys
+	KeyIDModeUnchecked KeyIDMode = iota
+	// KeyIDModeStrict requires key IDs to be the SHA-256 of the canonical
+	// JSON of the key as computed by Key.ID
+	KeyIDModeStrict
+	// KeyIDModeCompatible also accepts the key IDs of older securesystemslib
+	// and python-tuf versions: hashed with any of the keyid_hash_algorithms
+	// of the key or computed without the keyid_hash_algorithms field
+	KeyIDModeCompatible
+)
+
+// keyIDHashAlgorithms are the keyid_hash_algorithms supported by
+// KeyIDModeCompatible
+var keyIDHashAlgorithms = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// WithKeyIDMode configures how Metadata[T].VerifyDelegate checks the key
+// IDs of the keys of a role, KeyIDModeUnchecked by default
+func WithKeyIDMode(mode KeyIDMode) VerifyOption {
+	return func(opts *verifyOptions) {
+		opts.keyIDMode = mode
+	}
+}
+
+// String returns the name of the mode
+func (mode KeyIDMode) String() string {
+	switch mode {
+	case KeyIDModeUnchecked:
+		return "unchecked"
+	case KeyIDModeStrict:
+		return "strict"
+	case KeyIDModeCompatible:
+		return "compatible"
+	}
+	return fmt.Sprintf("KeyIDMode(%d)", int(mode))
+}
+
+// IDs returns the key IDs accepted for the key in mode, nil if any key ID
+// is accepted
+func (k *Key) IDs(mode KeyIDMode) ([]string, error) {
+	switch mode {
+	case KeyIDModeUnchecked:
+		return nil, nil
+	case KeyIDModeStrict:
+		return []string{k.ID()}, nil
+	case KeyIDModeCompatible:
+		return k.compatibleIDs()
+	}
+	return nil, ErrValue{Msg: fmt.Sprintf("unknown key ID mode %d", int(mode))}
+}
+
+// CheckID returns ErrKeyIDMismatch if keyID is not accepted for the key in
+// mode
+func (k *Key) CheckID(keyID string, mode KeyIDMode) error {
+	ids, err := k.IDs(mode)
+	if err != nil {
+		return err
+	}
+	if ids == nil || slices.Contains(ids, keyID) {
+		return nil
+	}
+	return ErrKeyIDMismatch{Msg: fmt.Sprintf("key ID %s does not match the %s key, expected %s (%s mode)", keyID, k.Type, strings.Join(ids, " or "), mode)}
+}
+
+// compatibleIDs returns the key ID of the key and the ones older
+// securesystemslib versions computed with each keyid_hash_algorithms
+// listed by the key, with and without the field
+func (k *Key) compatibleIDs() ([]string, error) {
+	ids := []string{k.ID()}
+	hashes := []crypto.Hash{crypto.SHA256}
+	if algorithms, ok := k.UnrecognizedFields["keyid_hash_algorithms"].([]any); ok {
+		for _, algorithm := range algorithms {
+			name, _ := algorithm.(string)
+			if hash, ok := keyIDHashAlgorithms[name]; ok && !slices.Contains(hashes, hash) {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	// the key without keyid_hash_algorithms, if it has the field
+	stripped := &Key{Type: k.Type, Scheme: k.Scheme, Value: k.Value, UnrecognizedFields: map[string]any{}}
+	for name, value := range k.UnrecognizedFields {
+		if name != "keyid_hash_algorithms" {
+			stripped.UnrecognizedFields[name] = value
+		}
+	}
+	for _, key := range []*Key{k, stripped} {
+		data, err := cjson.EncodeCanonical(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			h := hash.New()
+			h.Write(data)
+			if id := hex.EncodeToString(h.Sum(nil)); !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// findSignature returns the signature of the key declared as keyID. In
+// KeyIDModeCompatible signatures made under any accepted key ID of the key
+// match, in the other modes such a signature results in ErrKeyIDMismatch
+func (k *Key) findSignature(keyID string, signatures []Signature, mode KeyIDMode) (Signature, error) {
+	sign := Signature{}
+	for _, signature := range signatures {
+		if signature.KeyID == keyID {
+			sign = signature
+		}
+	}
+	if sign.KeyID != "" {
+		return sign, nil
+	}
+	ids, err := k.compatibleIDs()
+	if err != nil {
+		return sign, nil
+	}
+	for _, signature := range signatures {
+		if signature.KeyID == "" || !slices.Contains(ids, signature.KeyID) {
+			continue
+		}
+		if mode == KeyIDModeCompatible {
+			return signature, nil
+		}
+		return sign, ErrKeyIDMismatch{Msg: fmt.Sprintf("signature of key ID %s is made under key ID %s", keyID, signature.KeyID)}
+	}
+	return sign, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperLegacyKey returns an ed25519 key with keyid_hash_algorithms as
+// written by securesystemslib, its signer and its legacy key IDs: SHA-512
+// and SHA-256 without keyid_hash_algorithms
+func helperLegacyKey(t *testing.T) (*Key, signature.Signer, string, string) {
+	public, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	signer, err := signature.LoadSigner(private, crypto.Hash(0))
+	assert.NoError(t, err)
+	key := &Key{
+		Type:               KeyTypeEd25519,
+		Scheme:             KeySchemeEd25519,
+		Value:              KeyVal{PublicKey: hex.EncodeToString(public)},
+		UnrecognizedFields: map[string]any{"keyid_hash_algorithms": []any{"sha256", "sha512"}},
+	}
+	data, err := cjson.EncodeCanonical(key)
+	assert.NoError(t, err)
+	sha512ID := sha512.Sum512(data)
+	plain, err := KeyFromPublicKey(public)
+	assert.NoError(t, err)
+	data, err = cjson.EncodeCanonical(plain)
+	assert.NoError(t, err)
+	plainID := sha256.Sum256(data)
+	assert.Equal(t, plain.ID(), hex.EncodeToString(plainID[:]))
+	return key, signer, hex.EncodeToString(sha512ID[:]), plain.ID()
+}
+
+// helperDelegate returns root delegating targets to key declared as each of
+// keyIDs and targets signed by signer under sigKeyID
+func helperDelegate(t *testing.T, key *Key, keyIDs []string, threshold int, signer signature.Signer, sigKeyID string) (*Metadata[RootType], *Metadata[TargetsType]) {
+	root := Root(time.Now().AddDate(0, 0, 1))
+	for _, keyID := range keyIDs {
+		root.Signed.Keys[keyID] = key
+	}
+	root.Signed.Roles[TARGETS] = &Role{KeyIDs: keyIDs, Threshold: threshold}
+	targets := Targets(time.Now().AddDate(0, 0, 1))
+	_, err := targets.Sign(signer)
+	assert.NoError(t, err)
+	targets.Signatures[0].KeyID = sigKeyID
+	return root, targets
+}
+
+func TestKeyIDs(t *testing.T) {
+	key, _, sha512ID, plainID := helperLegacyKey(t)
+
+	ids, err := key.IDs(KeyIDModeUnchecked)
+	assert.NoError(t, err)
+	assert.Nil(t, ids)
+	ids, err = key.IDs(KeyIDModeStrict)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key.ID()}, ids)
+	ids, err = key.IDs(KeyIDModeCompatible)
+	assert.NoError(t, err)
+	assert.Contains(t, ids, key.ID())
+	assert.Contains(t, ids, sha512ID)
+	assert.Contains(t, ids, plainID)
+	assert.Len(t, ids, 4)
+	_, err = key.IDs(KeyIDMode(42))
+	assert.ErrorContains(t, err, "unknown key ID mode 42")
+
+	// keys without keyid_hash_algorithms only have their key ID
+	plain := &Key{Type: key.Type, Scheme: key.Scheme, Value: key.Value}
+	ids, err = plain.IDs(KeyIDModeCompatible)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{plainID}, ids)
+}
+
+func TestKeyCheckID(t *testing.T) {
+	key, _, sha512ID, plainID := helperLegacyKey(t)
+
+	assert.NoError(t, key.CheckID("anything", KeyIDModeUnchecked))
+	assert.NoError(t, key.CheckID(key.ID(), KeyIDModeStrict))
+	for _, keyID := range []string{sha512ID, plainID, "anything"} {
+		err := key.CheckID(keyID, KeyIDModeStrict)
+		assert.ErrorIs(t, err, ErrKeyIDMismatch{})
+		assert.ErrorIs(t, err, ErrRepository{})
+		assert.ErrorContains(t, err, "key ID "+keyID+" does not match the ed25519 key")
+	}
+	for _, keyID := range []string{key.ID(), sha512ID, plainID} {
+		assert.NoError(t, key.CheckID(keyID, KeyIDModeCompatible))
+	}
+	assert.ErrorIs(t, key.CheckID("anything", KeyIDModeCompatible), ErrKeyIDMismatch{})
+}
+
+func TestVerifyDelegateKeyIDMode(t *testing.T) {
+	key, signer, sha512ID, _ := helperLegacyKey(t)
+
+	// a key declared and signed under its legacy key ID
+	root, targets := helperDelegate(t, key, []string{sha512ID}, 1, signer, sha512ID)
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets))
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeCompatible)))
+	assert.ErrorIs(t, root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeStrict)), ErrKeyIDMismatch{})
+
+	// a key declared under an unrelated key ID
+	root, targets = helperDelegate(t, key, []string{"abcd"}, 1, signer, "abcd")
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeUnchecked)))
+	assert.ErrorIs(t, root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeCompatible)), ErrKeyIDMismatch{})
+}
+
+func TestVerifyDelegateKeyIDMismatchSkipped(t *testing.T) {
+	key, signer, _, _ := helperLegacyKey(t)
+
+	// another key declared under an unrelated key ID doesn't count but
+	// doesn't fail the verification either
+	root, targets := helperDelegate(t, key, []string{key.ID()}, 1, signer, key.ID())
+	root.Signed.Keys["abcd"] = helperNewKey(t)
+	root.Signed.Roles[TARGETS].KeyIDs = []string{"abcd", key.ID()}
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeStrict)))
+
+	// the mismatch is reported if the threshold is not met
+	root.Signed.Roles[TARGETS].Threshold = 2
+	err := root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeStrict))
+	assert.ErrorIs(t, err, ErrKeyIDMismatch{})
+	assert.ErrorContains(t, err, "not enough signatures, got 1, want 2")
+	assert.ErrorContains(t, err, "key ID abcd does not match")
+}
+
+func TestVerifyDelegateSignatureKeyID(t *testing.T) {
+	key, signer, sha512ID, _ := helperLegacyKey(t)
+
+	// the key is declared under its legacy key ID, the signature under its
+	// key ID
+	root, targets := helperDelegate(t, key, []string{sha512ID}, 1, signer, key.ID())
+	err := root.VerifyDelegate(TARGETS, targets)
+	assert.ErrorIs(t, err, ErrKeyIDMismatch{})
+	assert.ErrorContains(t, err, "signature of key ID "+sha512ID+" is made under key ID "+key.ID())
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeCompatible)))
+
+	// an unrelated signature is still only missing
+	targets.Signatures[0].KeyID = "abcd"
+	err = root.VerifyDelegate(TARGETS, targets, WithKeyIDMode(KeyIDModeCompatible))
+	assert.ErrorIs(t, err, ErrUnsignedMetadata{})
+	assert.False(t, errors.Is(err, ErrKeyIDMismatch{}))
+}
+
+func TestVerifyDelegateDuplicateKey(t *testing.T) {
+	key, signer, sha512ID, _ := helperLegacyKey(t)
+	compatible := WithKeyIDMode(KeyIDModeCompatible)
+
+	// the same key under two key IDs counts once towards the threshold
+	root, targets := helperDelegate(t, key, []string{key.ID(), sha512ID}, 2, signer, key.ID())
+	assert.ErrorIs(t, root.VerifyDelegate(TARGETS, targets, compatible), ErrUnsignedMetadata{})
+	root.Signed.Roles[TARGETS].Threshold = 1
+	assert.NoError(t, root.VerifyDelegate(TARGETS, targets, compatible))
+}
@@ -0,0 +1,326 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"golang.org/x/exp/slices"
+)
+
+// Keyless signatures follow TAP 18: the key of a sigstore-oidc role is the
+// OIDC identity and issuer of its signer, each signature is made with an
+// ephemeral key certified by Fulcio and recorded in a Rekor transparency
+// log. The certificate and the log entry are stored in the bundle field of
+// the signature
+
+var (
+	// oidcIssuerV1 is the Fulcio extension holding the OIDC issuer as raw string
+	oidcIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	// oidcIssuerV2 is the Fulcio extension holding the OIDC issuer as DER UTF8String
+	oidcIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// KeylessTrustRoot holds the certificate authorities and transparency logs
+// keyless signatures are verified against
+type KeylessTrustRoot struct {
+	// Roots are the Fulcio root certificates
+	Roots *x509.CertPool
+	// Intermediates are Fulcio intermediate certificates in addition to the
+	// ones included in the signatures
+	Intermediates *x509.CertPool
+	// TransparencyLogs are the public keys of the trusted logs by log ID
+	TransparencyLogs map[string]crypto.PublicKey
+}
+
+// KeylessBundle is the verification material of a keyless signature
+type KeylessBundle struct {
+	// Certificate is the PEM encoded signing certificate, optionally followed
+	// by intermediate certificates
+	Certificate string `json:"certificate"`
+	// TlogEntry is the entry of the signature in the transparency log
+	TlogEntry *TlogEntry `json:"tlog_entry"`
+}
+
+// TlogEntry is a Rekor transparency log entry together with the signed
+// entry timestamp, the promise of the log to include the entry
+type TlogEntry struct {
+	LogID                string          `json:"log_id"`
+	LogIndex             int64           `json:"log_index"`
+	IntegratedTime       int64           `json:"integrated_time"`
+	Body                 []byte          `json:"body"`
+	SignedEntryTimestamp []byte          `json:"signed_entry_timestamp"`
+	InclusionProof       *InclusionProof `json:"inclusion_proof,omitempty"`
+}
+
+// HashedRekord is the hashedrekord v0.0.1 Rekor entry of a signature
+type HashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// NewHashedRekord returns the hashedrekord entry of sig over payload made
+// with the key of the PEM encoded certificate
+func NewHashedRekord(payload []byte, sig []byte, certificate []byte) *HashedRekord {
+	digest := sha256.Sum256(payload)
+	entry := &HashedRekord{APIVersion: "0.0.1", Kind: "hashedrekord"}
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(digest[:])
+	entry.Spec.Signature.Content = sig
+	entry.Spec.Signature.PublicKey.Content = certificate
+	return entry
+}
+
+// KeylessKey returns the sigstore-oidc key of the signer with identity,
+// e.g. an email address or a workflow URI, authenticated by issuer
+func KeylessKey(identity string, issuer string) *Key {
+	return &Key{
+		Type:   KeyTypeSigstoreOIDC,
+		Scheme: KeySchemeSigstoreFulcio,
+		Value:  KeyVal{Identity: identity, Issuer: issuer},
+	}
+}
+
+// WithKeylessTrustRoot configures the trust root of the keyless signatures
+// checked by Metadata[T].VerifyDelegate. Keyless signatures don't verify
+// without one
+func WithKeylessTrustRoot(root *KeylessTrustRoot) VerifyOption {
+	return func(opts *verifyOptions) {
+		opts.keylessTrustRoot = root
+	}
+}
+
+// AddTransparencyLog trusts the log with the public key
+func (root *KeylessTrustRoot) AddTransparencyLog(publicKey crypto.PublicKey) error {
+	logID, err := LogID(publicKey)
+	if err != nil {
+		return err
+	}
+	if root.TransparencyLogs == nil {
+		root.TransparencyLogs = map[string]crypto.PublicKey{}
+	}
+	root.TransparencyLogs[logID] = publicKey
+	return nil
+}
+
+// LogID returns the ID of the transparency log with the public key, the
+// hex encoded SHA-256 of its DER encoding
+func LogID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// SignedEntryTimestampPayload returns the payload of the signed entry
+// timestamp of entry, signed by the log
+func SignedEntryTimestampPayload(entry *TlogEntry) ([]byte, error) {
+	return cjson.EncodeCanonical(map[string]any{
+		"body":           base64.StdEncoding.EncodeToString(entry.Body),
+		"integratedTime": entry.IntegratedTime,
+		"logID":          entry.LogID,
+		"logIndex":       entry.LogIndex,
+	})
+}
+
+// KeylessBundle returns the bundle of a keyless signature
+func (s *Signature) KeylessBundle() (*KeylessBundle, error) {
+	value, ok := s.UnrecognizedFields["bundle"]
+	if !ok {
+		return nil, ErrValue{Msg: fmt.Sprintf("signature of key ID %s has no bundle", s.KeyID)}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &KeylessBundle{}
+	err = json.Unmarshal(data, bundle)
+	if err != nil {
+		return nil, ErrValue{Msg: fmt.Sprintf("invalid bundle in signature of key ID %s: %s", s.KeyID, err)}
+	}
+	return bundle, nil
+}
+
+// SetKeylessBundle stores bundle in the signature
+func (s *Signature) SetKeylessBundle(bundle *KeylessBundle) error {
+	return s.setField("bundle", bundle)
+}
+
+// verifyKeyless checks sig is a keyless signature over payload by the
+// identity of key, certified by root
+func verifyKeyless(root *KeylessTrustRoot, key *Key, sig Signature, payload []byte) error {
+	if root == nil {
+		return ErrValue{Msg: "no trust root configured for keyless signatures"}
+	}
+	bundle, err := sig.KeylessBundle()
+	if err != nil {
+		return err
+	}
+	if bundle.TlogEntry == nil {
+		return ErrValue{Msg: "keyless signature has no transparency log entry"}
+	}
+	// the log entry proves the signature was made while the certificate was valid
+	integratedTime, err := root.verifyTlogEntry(bundle.TlogEntry)
+	if err != nil {
+		return err
+	}
+	certs, err := ParseCertificates([]byte(bundle.Certificate))
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	if root.Intermediates != nil {
+		intermediates = root.Intermediates.Clone()
+	}
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := certs[0]
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         root.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   integratedTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return ErrValue{Msg: fmt.Sprintf("untrusted keyless signing certificate: %s", err)}
+	}
+	if !slices.Contains(CertificateIdentities(leaf), key.Value.Identity) {
+		return ErrValue{Msg: fmt.Sprintf("keyless signing certificate is not issued to %s", key.Value.Identity)}
+	}
+	if issuer := CertificateIssuer(leaf); issuer != key.Value.Issuer {
+		return ErrValue{Msg: fmt.Sprintf("keyless signing certificate is issued by %q instead of %s", issuer, key.Value.Issuer)}
+	}
+	verifier, err := signature.LoadVerifier(leaf.PublicKey, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	err = verifier.VerifySignature(bytes.NewReader(sig.Signature), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	// the log entry has to be the one of this signature
+	var entry HashedRekord
+	err = json.Unmarshal(bundle.TlogEntry.Body, &entry)
+	if err != nil {
+		return ErrValue{Msg: fmt.Sprintf("invalid transparency log entry: %s", err)}
+	}
+	expected := NewHashedRekord(payload, sig.Signature, nil)
+	logged, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	if entry.Kind != expected.Kind || entry.Spec.Data.Hash != expected.Spec.Data.Hash ||
+		!bytes.Equal(entry.Spec.Signature.Content, sig.Signature) || logged == nil || !bytes.Equal(logged.Bytes, leaf.Raw) {
+		return ErrValue{Msg: "transparency log entry does not match the keyless signature"}
+	}
+	return nil
+}
+
+// verifyTlogEntry checks the signed entry timestamp of a trusted log and
+// returns the time the entry was integrated into the log
+func (root *KeylessTrustRoot) verifyTlogEntry(entry *TlogEntry) (time.Time, error) {
+	publicKey, ok := root.TransparencyLogs[entry.LogID]
+	if !ok {
+		return time.Time{}, ErrValue{Msg: fmt.Sprintf("transparency log %s is not trusted", entry.LogID)}
+	}
+	payload, err := SignedEntryTimestampPayload(entry)
+	if err != nil {
+		return time.Time{}, err
+	}
+	verifier, err := signature.LoadVerifier(publicKey, crypto.SHA256)
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = verifier.VerifySignature(bytes.NewReader(entry.SignedEntryTimestamp), bytes.NewReader(payload))
+	if err != nil {
+		return time.Time{}, ErrValue{Msg: fmt.Sprintf("invalid signed entry timestamp of transparency log %s", entry.LogID)}
+	}
+	return time.Unix(entry.IntegratedTime, 0), nil
+}
+
+// ParseCertificates parses PEM encoded certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, ErrValue{Msg: "no PEM encoded certificate found"}
+	}
+	return certs, nil
+}
+
+// CertificateIdentities returns the email and URI subject alternative names
+// of a Fulcio certificate
+func CertificateIdentities(cert *x509.Certificate) []string {
+	res := append([]string{}, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		res = append(res, uri.String())
+	}
+	return res
+}
+
+// CertificateIssuer returns the OIDC issuer of a Fulcio certificate
+func CertificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidcIssuerV2) {
+			var issuer string
+			if rest, err := asn1.Unmarshal(ext.Value, &issuer); err == nil && len(rest) == 0 {
+				return issuer
+			}
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidcIssuerV1) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
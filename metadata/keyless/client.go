@@ -0,0 +1,163 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keyless
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/tlog"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+)
+
+// FulcioURL is the address of the public good Fulcio instance
+const FulcioURL = "https://fulcio.sigstore.dev"
+
+// Fulcio is a client of the Fulcio certificate authority
+type Fulcio struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// Signer returns a keyless signer with a new ephemeral key certified for
+// the identity of the OIDC identity token
+func (f *Fulcio) Signer(ctx context.Context, idToken string, log tlog.Log) (*Signer, error) {
+	private, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := f.RequestCertificate(ctx, idToken, private)
+	if err != nil {
+		return nil, err
+	}
+	return New(ctx, private, certificate, log)
+}
+
+// RequestCertificate asks Fulcio to certify the public key of private for
+// the identity of the OIDC identity token and returns the PEM encoded
+// certificate chain
+func (f *Fulcio) RequestCertificate(ctx context.Context, idToken string, private crypto.Signer) ([]byte, error) {
+	subject, err := tokenSubject(idToken)
+	if err != nil {
+		return nil, err
+	}
+	// the proof of possession is the signature of the token subject
+	digest := sha256.Sum256([]byte(subject))
+	proof, err := private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := cryptoutils.MarshalPublicKeyToPEM(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	in := map[string]any{
+		"credentials": map[string]string{"oidcIdentityToken": idToken},
+		"publicKeyRequest": map[string]any{
+			"publicKey":         map[string]string{"content": string(publicKey)},
+			"proofOfPossession": proof,
+		},
+	}
+	type chain struct {
+		Chain struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chain"`
+	}
+	var out struct {
+		Embedded *chain `json:"signedCertificateEmbeddedSct"`
+		Detached *chain `json:"signedCertificateDetachedSct"`
+	}
+	err = postJSON(ctx, f.HTTPClient, strings.TrimSuffix(f.URL, "/")+"/api/v2/signingCert", in, &out)
+	if err != nil {
+		return nil, err
+	}
+	res := out.Embedded
+	if res == nil {
+		res = out.Detached
+	}
+	if res == nil || len(res.Chain.Certificates) == 0 {
+		return nil, metadata.ErrValue{Msg: "no certificate in the Fulcio response"}
+	}
+	var certificate []byte
+	for _, cert := range res.Chain.Certificates {
+		certificate = append(certificate, strings.TrimSpace(cert)+"\n"...)
+	}
+	return certificate, nil
+}
+
+// tokenSubject returns the email claim of an OIDC identity token, or its
+// subject if it has none. The token is verified by Fulcio
+func tokenSubject(idToken string) (string, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", metadata.ErrValue{Msg: "invalid OIDC identity token"}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", metadata.ErrValue{Msg: fmt.Sprintf("invalid OIDC identity token: %s", err)}
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}
+	err = json.Unmarshal(data, &claims)
+	if err != nil {
+		return "", metadata.ErrValue{Msg: fmt.Sprintf("invalid OIDC identity token: %s", err)}
+	}
+	if claims.Email != "" {
+		return claims.Email, nil
+	}
+	if claims.Subject == "" {
+		return "", metadata.ErrValue{Msg: "OIDC identity token without subject"}
+	}
+	return claims.Subject, nil
+}
+
+// postJSON posts in as JSON to url and decodes the JSON response into out
+func postJSON(ctx context.Context, client *http.Client, url string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("POST %s failed with status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,319 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keyless
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/tlog"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/stretchr/testify/assert"
+)
+
+const testIssuer = "https://issuer.example.com"
+
+// testCA is a local certificate authority with a root and an intermediate
+type testCA struct {
+	root            *x509.Certificate
+	intermediate    *x509.Certificate
+	intermediateKey *ecdsa.PrivateKey
+}
+
+// testLog is an in-memory transparency log
+type testLog struct {
+	mu      sync.Mutex
+	key     *ecdsa.PrivateKey
+	entries int64
+	// offset shifts the integrated time of new entries
+	offset time.Duration
+}
+
+// helperCertificate creates a certificate for public signed by parent
+func helperCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, public crypto.PublicKey, signer crypto.Signer) *x509.Certificate {
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, public, signer)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+// helperCA creates a local certificate authority
+func helperCA(t *testing.T) *testCA {
+	rootKey, err := generateKey()
+	assert.NoError(t, err)
+	intermediateKey, err := generateKey()
+	assert.NoError(t, err)
+	ca := &testCA{intermediateKey: intermediateKey}
+	ca.root = helperCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, rootKey.Public(), rootKey)
+	ca.intermediate = helperCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, ca.root, intermediateKey.Public(), rootKey)
+	return ca
+}
+
+// issue returns the PEM encoded chain of a short-lived Fulcio style
+// certificate of public for email
+func (ca *testCA) issue(t *testing.T, public crypto.PublicKey, email string, issuer string) []byte {
+	issuerValue, err := asn1.Marshal(issuer)
+	assert.NoError(t, err)
+	leaf := helperCertificate(t, &x509.Certificate{
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{email},
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}, Value: issuerValue}},
+	}, ca.intermediate, public, ca.intermediateKey)
+	res := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	return append(res, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.intermediate.Raw})...)
+}
+
+// helperToken returns an unsigned OIDC identity token
+func helperToken(email string, issuer string) string {
+	claims, _ := json.Marshal(map[string]string{"iss": issuer, "sub": "123", "email": email})
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+}
+
+// helperServers starts a Fulcio issuing certificates of ca and a Rekor
+// recording entries in log
+func helperServers(t *testing.T, ca *testCA, log *testLog) (*Fulcio, *tlog.Rekor) {
+	fulcio := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Credentials struct {
+				Token string `json:"oidcIdentityToken"`
+			} `json:"credentials"`
+			PublicKeyRequest struct {
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+				ProofOfPossession []byte `json:"proofOfPossession"`
+			} `json:"publicKeyRequest"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		data, err := base64.RawURLEncoding.DecodeString(strings.Split(in.Credentials.Token, ".")[1])
+		assert.NoError(t, err)
+		var claims map[string]string
+		assert.NoError(t, json.Unmarshal(data, &claims))
+		public, err := cryptoutils.UnmarshalPEMToPublicKey([]byte(in.PublicKeyRequest.PublicKey.Content))
+		assert.NoError(t, err)
+		digest := sha256.Sum256([]byte(claims["email"]))
+		if !ecdsa.VerifyASN1(public.(*ecdsa.PublicKey), digest[:], in.PublicKeyRequest.ProofOfPossession) {
+			http.Error(w, "invalid proof of possession", http.StatusBadRequest)
+			return
+		}
+		chain := []string{}
+		for _, part := range strings.SplitAfter(string(ca.issue(t, public, claims["email"], claims["iss"])), "-----END CERTIFICATE-----\n") {
+			if part != "" {
+				chain = append(chain, part)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"signedCertificateEmbeddedSct": map[string]any{"chain": map[string]any{"certificates": chain}}})
+	}))
+	t.Cleanup(fulcio.Close)
+	rekor := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&entry))
+		body, err := cjson.EncodeCanonical(entry)
+		assert.NoError(t, err)
+		logID, err := metadata.LogID(log.key.Public())
+		assert.NoError(t, err)
+		log.mu.Lock()
+		logged := &metadata.TlogEntry{LogID: logID, LogIndex: log.entries, IntegratedTime: time.Now().Add(log.offset).Unix(), Body: body}
+		log.entries++
+		log.mu.Unlock()
+		payload, err := metadata.SignedEntryTimestampPayload(logged)
+		assert.NoError(t, err)
+		digest := sha256.Sum256(payload)
+		set, err := ecdsa.SignASN1(rand.Reader, log.key, digest[:])
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"24296fb24b8ad77a": map[string]any{
+			"body":           logged.Body,
+			"integratedTime": logged.IntegratedTime,
+			"logID":          logged.LogID,
+			"logIndex":       logged.LogIndex,
+			"verification":   map[string]any{"signedEntryTimestamp": set},
+		}})
+	}))
+	t.Cleanup(rekor.Close)
+	return &Fulcio{URL: fulcio.URL, HTTPClient: fulcio.Client()}, &tlog.Rekor{URL: rekor.URL, HTTPClient: rekor.Client()}
+}
+
+// helperSetup creates a local CA and log and returns their clients and the
+// trust root trusting them
+func helperSetup(t *testing.T) (*testCA, *testLog, *Fulcio, *tlog.Rekor, *metadata.KeylessTrustRoot) {
+	ca := helperCA(t)
+	logKey, err := generateKey()
+	assert.NoError(t, err)
+	log := &testLog{key: logKey}
+	fulcio, rekor := helperServers(t, ca, log)
+	root := &metadata.KeylessTrustRoot{Roots: x509.NewCertPool()}
+	root.Roots.AddCert(ca.root)
+	assert.NoError(t, root.AddTransparencyLog(logKey.Public()))
+	return ca, log, fulcio, rekor, root
+}
+
+// helperVerify signs targets metadata for a role delegated to key and
+// verifies it against trustRoot. The signature claims to be made by key,
+// whatever the identity of the signer is
+func helperVerify(t *testing.T, signer *Signer, key *metadata.Key, trustRoot *metadata.KeylessTrustRoot) error {
+	root := metadata.Root(time.Now().AddDate(0, 0, 1))
+	assert.NoError(t, root.Signed.AddKey(key, metadata.TARGETS))
+	targets := metadata.Targets(time.Now().AddDate(0, 0, 1))
+	_, err := targets.Sign(signer)
+	assert.NoError(t, err)
+	targets.Signatures[0].KeyID = key.ID()
+	return root.VerifyDelegate(metadata.TARGETS, targets, metadata.WithKeylessTrustRoot(trustRoot))
+}
+
+func TestKeylessKey(t *testing.T) {
+	key := metadata.KeylessKey("dev@example.com", testIssuer)
+	data, err := json.Marshal(key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"keytype":"sigstore-oidc","scheme":"Fulcio","keyval":{"identity":"dev@example.com","issuer":"https://issuer.example.com"}}`, string(data))
+	loaded := &metadata.Key{}
+	assert.NoError(t, json.Unmarshal(data, loaded))
+	assert.Equal(t, key.ID(), loaded.ID())
+	assert.Empty(t, loaded.Value.UnrecognizedFields)
+}
+
+func TestKeylessSignAndVerify(t *testing.T) {
+	_, _, fulcio, rekor, trustRoot := helperSetup(t)
+	trusted := metadata.WithKeylessTrustRoot(trustRoot)
+	signer, err := fulcio.Signer(context.Background(), helperToken("dev@example.com", testIssuer), rekor)
+	assert.NoError(t, err)
+	key := metadata.KeylessKey("dev@example.com", testIssuer)
+	assert.Equal(t, key.ID(), signer.Key().ID())
+
+	root := metadata.Root(time.Now().AddDate(0, 0, 1))
+	assert.NoError(t, root.Signed.AddKey(key, metadata.TARGETS))
+	targets := metadata.Targets(time.Now().AddDate(0, 0, 1))
+	sig, err := targets.Sign(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID(), sig.KeyID)
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, trusted))
+	assert.Error(t, root.VerifyDelegate(metadata.TARGETS, targets))
+
+	// the bundle survives serialization
+	data, err := targets.ToBytes(false)
+	assert.NoError(t, err)
+	loaded, err := metadata.Targets().FromBytes(data)
+	assert.NoError(t, err)
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, loaded, trusted))
+	bundle, err := loaded.Signatures[0].KeylessBundle()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), bundle.TlogEntry.LogIndex)
+
+	// the signature covers the content
+	loaded.Signed.Version++
+	assert.Error(t, root.VerifyDelegate(metadata.TARGETS, loaded, trusted))
+
+	// the log entry has to be the one of the signature
+	loaded, err = metadata.Targets().FromBytes(data)
+	assert.NoError(t, err)
+	other, err := targets.Sign(signer)
+	assert.NoError(t, err)
+	otherBundle, err := other.KeylessBundle()
+	assert.NoError(t, err)
+	bundle.TlogEntry = otherBundle.TlogEntry
+	assert.NoError(t, loaded.Signatures[0].SetKeylessBundle(bundle))
+	assert.Error(t, root.VerifyDelegate(metadata.TARGETS, loaded, trusted))
+
+	// the signature has to be made by the identity of the key
+	for _, key := range []*metadata.Key{
+		metadata.KeylessKey("other@example.com", testIssuer),
+		metadata.KeylessKey("dev@example.com", "https://other.example.com"),
+	} {
+		assert.Error(t, helperVerify(t, signer, key, trustRoot))
+	}
+}
+
+func TestKeylessTrustRoot(t *testing.T) {
+	ca, log, fulcio, rekor, trustRoot := helperSetup(t)
+	signer, err := fulcio.Signer(context.Background(), helperToken("dev@example.com", testIssuer), rekor)
+	assert.NoError(t, err)
+	key := metadata.KeylessKey("dev@example.com", testIssuer)
+	assert.NoError(t, helperVerify(t, signer, key, trustRoot))
+
+	// the certificate has to be valid when the signature was logged
+	log.offset = time.Hour
+	assert.Error(t, helperVerify(t, signer, key, trustRoot))
+	log.offset = 0
+
+	// the log has to be trusted
+	root := &metadata.KeylessTrustRoot{Roots: x509.NewCertPool()}
+	root.Roots.AddCert(ca.root)
+	assert.Error(t, helperVerify(t, signer, key, root))
+
+	// the certificate authority has to be trusted
+	assert.NoError(t, root.AddTransparencyLog(log.key.Public()))
+	assert.NoError(t, helperVerify(t, signer, key, root))
+	other := helperCA(t)
+	root.Roots = x509.NewCertPool()
+	root.Roots.AddCert(other.root)
+	assert.Error(t, helperVerify(t, signer, key, root))
+
+	assert.Error(t, helperVerify(t, signer, key, nil))
+}
+
+func TestKeylessNew(t *testing.T) {
+	ca := helperCA(t)
+	private, err := generateKey()
+	assert.NoError(t, err)
+	other, err := generateKey()
+	assert.NoError(t, err)
+	_, err = New(context.Background(), other, ca.issue(t, private.Public(), "dev@example.com", testIssuer), &tlog.Rekor{})
+	assert.ErrorContains(t, err, "does not certify the private key")
+	signer, err := New(context.Background(), private, ca.issue(t, private.Public(), "dev@example.com", testIssuer), &tlog.Rekor{})
+	assert.NoError(t, err)
+	assert.Equal(t, "dev@example.com", signer.Key().Value.Identity)
+	assert.Equal(t, testIssuer, signer.Key().Value.Issuer)
+	_, err = (&Fulcio{}).RequestCertificate(context.Background(), "not-a-token", private)
+	assert.ErrorContains(t, err, "invalid OIDC identity token")
+}
@@ -0,0 +1,111 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keyless
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/tlog"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// Signer makes keyless signatures with an ephemeral key certified by
+// Fulcio. Each signature is recorded in the transparency log and carries
+// the certificate and the log entry in its bundle
+type Signer struct {
+	ctx         context.Context
+	private     crypto.Signer
+	certificate []byte
+	leaf        []byte
+	key         *metadata.Key
+	log         tlog.Log
+}
+
+// New returns the keyless signer of private, the key certified by the PEM
+// encoded certificate chain. The certificate has to be issued to exactly
+// one identity
+func New(ctx context.Context, private crypto.Signer, certificate []byte, log tlog.Log) (*Signer, error) {
+	certs, err := metadata.ParseCertificates(certificate)
+	if err != nil {
+		return nil, err
+	}
+	leaf := certs[0]
+	if err := cryptoutils.EqualKeys(leaf.PublicKey, private.Public()); err != nil {
+		return nil, metadata.ErrValue{Msg: "the certificate does not certify the private key"}
+	}
+	identities := metadata.CertificateIdentities(leaf)
+	if len(identities) != 1 {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("the certificate has to be issued to exactly one identity, got %d", len(identities))}
+	}
+	issuer := metadata.CertificateIssuer(leaf)
+	if issuer == "" {
+		return nil, metadata.ErrValue{Msg: "the certificate has no OIDC issuer"}
+	}
+	return &Signer{
+		ctx:         ctx,
+		private:     private,
+		certificate: certificate,
+		leaf:        pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw}),
+		key:         metadata.KeylessKey(identities[0], issuer),
+		log:         log,
+	}, nil
+}
+
+// Key returns the sigstore-oidc key of the certified identity
+func (s *Signer) Key() *metadata.Key {
+	return s.key
+}
+
+// PublicKey returns the ephemeral public key
+func (s *Signer) PublicKey(_ ...signature.PublicKeyOption) (crypto.PublicKey, error) {
+	return s.private.Public(), nil
+}
+
+// SignMessage signs the SHA-256 digest of message with the ephemeral key
+func (s *Signer) SignMessage(message io.Reader, _ ...signature.SignOption) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+	return s.private.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+}
+
+// DecorateSignature records sig in the transparency log and adds the
+// bundle to it
+func (s *Signer) DecorateSignature(sig *metadata.Signature, payload []byte) error {
+	entry, err := s.log.Upload(s.ctx, metadata.NewHashedRekord(payload, sig.Signature, s.leaf))
+	if err != nil {
+		return fmt.Errorf("failed to record the signature in the transparency log: %w", err)
+	}
+	return sig.SetKeylessBundle(&metadata.KeylessBundle{Certificate: string(s.certificate), TlogEntry: entry})
+}
+
+// generateKey returns a new ephemeral key
+func generateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// ensure the Signer satisfies the interfaces Metadata[T].Sign uses
+var (
+	_ metadata.KeyedSigner        = (*Signer)(nil)
+	_ metadata.SignatureDecorator = (*Signer)(nil)
+)
@@ -12,6 +12,7 @@
 package metadata
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
@@ -23,6 +24,7 @@ import (
 
 	"github.com/secure-systems-lab/go-securesystemslib/cjson"
 	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
 )
 
 const (
@@ -30,9 +32,11 @@ const (
 	KeyTypeECDSA_SHA2_P256       = "ecdsa-sha2-nistp256"
 	KeyTypeECDSA_SHA2_P256_SSLIB = "ecdsa"
 	KeyTypeRSASSA_PSS_SHA256     = "rsa"
+	KeyTypeSigstoreOIDC          = "sigstore-oidc"
 	KeySchemeEd25519             = "ed25519"
 	KeySchemeECDSA_SHA2_P256     = "ecdsa-sha2-nistp256"
 	KeySchemeRSASSA_PSS_SHA256   = "rsassa-pss-sha256"
+	KeySchemeSigstoreFulcio      = "Fulcio"
 )
 
 // ToPublicKey generate crypto.PublicKey from metadata type Key
@@ -111,6 +115,69 @@ func KeyFromPublicKey(k crypto.PublicKey) (*Key, error) {
 	return key, nil
 }
 
+// verifier returns the function checking signatures of the key over a
+// payload with the policy of opts
+func (k *Key) verifier(opts *verifyOptions) (func(sig Signature, payload []byte) error, error) {
+	if k.Type == KeyTypeSigstoreOIDC {
+		if k.Value.Identity == "" || k.Value.Issuer == "" {
+			return nil, ErrValue{Msg: "sigstore-oidc key without identity or issuer"}
+		}
+		return func(sig Signature, payload []byte) error {
+			if err := verifyKeyless(opts.keylessTrustRoot, k, sig, payload); err != nil {
+				return err
+			}
+			return verifyTlog(opts.tlogPolicy, k, sig, payload)
+		}, nil
+	}
+	// convert to a PublicKey type
+	publicKey, err := k.ToPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	// use corresponding hash function for key type
+	hash := crypto.Hash(0)
+	if k.Type != KeyTypeEd25519 {
+		hash = crypto.SHA256
+	}
+	// load a verifier based on that key
+	verifier, err := signature.LoadVerifier(publicKey, hash)
+	if err != nil {
+		return nil, err
+	}
+	return func(sig Signature, payload []byte) error {
+		if err := verifier.VerifySignature(bytes.NewReader(sig.Signature), bytes.NewReader(payload)); err != nil {
+			return err
+		}
+		return verifyTlog(opts.tlogPolicy, k, sig, payload)
+	}, nil
+}
+
+// SignatureDecorator is a signer which adds fields to its signatures, e.g.
+// the certificate and transparency log entry of keyless signatures
+type SignatureDecorator interface {
+	DecorateSignature(sig *Signature, payload []byte) error
+}
+
+// KeyedSigner is a signer which knows its TUF key. Its key is used instead
+// of the one derived by KeyFromPublicKey, e.g. for securesystemslib keys
+// with a different key type or additional fields which change the key ID
+type KeyedSigner interface {
+	signature.Signer
+	Key() *Key
+}
+
+// SignerKey returns the TUF key of signer
+func SignerKey(signer signature.Signer) (*Key, error) {
+	if keyed, ok := signer.(KeyedSigner); ok {
+		return keyed.Key(), nil
+	}
+	publicKey, err := signer.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return KeyFromPublicKey(publicKey)
+}
+
 // ID returns the keyID value for the given Key
 func (k *Key) ID() string {
 	k.idOnce.Do(func() {
@@ -0,0 +1,296 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// Format is a private key file format
+type Format string
+
+const (
+	// FormatPEM is PEM encoded PKCS#1 for RSA, SEC 1 for ECDSA and PKCS#8 for
+	// ed25519 keys which have no other PEM encoding
+	FormatPEM Format = "pem"
+	// FormatPKCS8 is PEM encoded PKCS#8
+	FormatPKCS8 Format = "pkcs8"
+	// FormatEncryptedPEM is PEM encoded PKCS#8 encrypted with a passphrase
+	// (scrypt and secretbox) as used by sigstore and go-tuf
+	FormatEncryptedPEM Format = "encrypted-pem"
+	// FormatSSLib is the securesystemslib JSON key format
+	FormatSSLib Format = "sslib"
+)
+
+// RSAKeyBits is the size of generated RSA keys
+const RSAKeyBits = 3072
+
+// encryptedCosignPrivateKeyPEMType is the PEM type of encrypted cosign keys
+// which use the same encryption as FormatEncryptedPEM
+const encryptedCosignPrivateKeyPEMType = "ENCRYPTED COSIGN PRIVATE KEY"
+
+// PrivateKey is a private key together with its TUF public key
+type PrivateKey struct {
+	Private crypto.Signer
+	Public  *metadata.Key
+}
+
+// Generate generates a new key of keyType, one of metadata.KeyTypeEd25519,
+// metadata.KeyTypeECDSA_SHA2_P256 and metadata.KeyTypeRSASSA_PSS_SHA256
+func Generate(keyType string) (*PrivateKey, error) {
+	var private crypto.Signer
+	var err error
+	switch keyType {
+	case metadata.KeyTypeEd25519:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case metadata.KeyTypeECDSA_SHA2_P256, metadata.KeyTypeECDSA_SHA2_P256_SSLIB:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case metadata.KeyTypeRSASSA_PSS_SHA256:
+		private, err = rsa.GenerateKey(rand.Reader, RSAKeyBits)
+	default:
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported key type %s", keyType)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return New(private)
+}
+
+// New returns the PrivateKey of private with the public key as returned by
+// metadata.KeyFromPublicKey
+func New(private crypto.PrivateKey) (*PrivateKey, error) {
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported private key type %T", private)}
+	}
+	if ecdsaKey, ok := private.(*ecdsa.PrivateKey); ok && ecdsaKey.Curve != elliptic.P256() {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported ecdsa curve %s", ecdsaKey.Curve.Params().Name)}
+	}
+	public, err := metadata.KeyFromPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{Private: signer, Public: public}, nil
+}
+
+// Load parses a private key in any of the supported formats. The
+// passphrase is only used for encrypted keys
+func Load(data []byte, passphrase []byte) (*PrivateKey, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return loadSSLib(trimmed)
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return nil, metadata.ErrValue{Msg: "no PEM or securesystemslib JSON key found"}
+	}
+	if IsEncrypted(data) && len(passphrase) == 0 {
+		return nil, metadata.ErrValue{Msg: "a passphrase is required for an encrypted private key"}
+	}
+	private, err := cryptoutils.UnmarshalPEMToPrivateKey(data, cryptoutils.StaticPasswordFunc(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	return New(private)
+}
+
+// IsEncrypted reports whether data is a private key encrypted with a
+// passphrase
+func IsEncrypted(data []byte) bool {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	return block.Type == string(cryptoutils.EncryptedSigstorePrivateKeyPEMType) || block.Type == encryptedCosignPrivateKeyPEMType
+}
+
+// LoadFile reads and parses the private key file name, see Load
+func LoadFile(name string, passphrase []byte) (*PrivateKey, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := Load(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// ID returns the key ID of the public key
+func (k *PrivateKey) ID() string {
+	return k.Public.ID()
+}
+
+// Signer returns a signer for Metadata[T].Sign using the same hash as
+// Metadata[T].VerifyDelegate does for the key type. The signer implements
+// metadata.KeyedSigner so signatures carry the key ID of the public key
+func (k *PrivateKey) Signer() (signature.Signer, error) {
+	hash := crypto.SHA256
+	if _, ok := k.Private.(ed25519.PrivateKey); ok {
+		hash = crypto.Hash(0)
+	}
+	signer, err := signature.LoadSigner(k.Private, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &keyedSigner{Signer: signer, key: k.Public}, nil
+}
+
+// keyedSigner is a signer together with its TUF key
+type keyedSigner struct {
+	signature.Signer
+	key *metadata.Key
+}
+
+// Key returns the TUF key of the signer
+func (s *keyedSigner) Key() *metadata.Key {
+	return s.key
+}
+
+// Export encodes the private key in format. The passphrase is required for
+// FormatEncryptedPEM and ignored otherwise
+func (k *PrivateKey) Export(format Format, passphrase []byte) ([]byte, error) {
+	switch format {
+	case FormatPEM:
+		return marshalPEM(k.Private)
+	case FormatPKCS8:
+		return cryptoutils.MarshalPrivateKeyToPEM(k.Private)
+	case FormatEncryptedPEM:
+		if len(passphrase) == 0 {
+			return nil, metadata.ErrValue{Msg: "a passphrase is required to encrypt a private key"}
+		}
+		der, err := cryptoutils.MarshalPrivateKeyToEncryptedDER(k.Private, cryptoutils.StaticPasswordFunc(passphrase))
+		if err != nil {
+			return nil, err
+		}
+		return cryptoutils.PEMEncode(cryptoutils.EncryptedSigstorePrivateKeyPEMType, der), nil
+	case FormatSSLib:
+		return k.marshalSSLib()
+	}
+	return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported private key format %s", format)}
+}
+
+// marshalPEM encodes private as PKCS#1 or SEC 1 where those exist
+func marshalPEM(private crypto.PrivateKey) ([]byte, error) {
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		return cryptoutils.PEMEncode(cryptoutils.PKCS1PrivateKeyPEMType, x509.MarshalPKCS1PrivateKey(private)), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(private)
+		if err != nil {
+			return nil, err
+		}
+		return cryptoutils.PEMEncode(cryptoutils.ECPrivateKeyPEMType, der), nil
+	}
+	return cryptoutils.MarshalPrivateKeyToPEM(private)
+}
+
+// marshalSSLib encodes the key in the securesystemslib JSON format, i.e.
+// the public key with the key ID and the private key added. Ed25519
+// private keys are hex encoded seeds, all other keys PEM encoded
+func (k *PrivateKey) marshalSSLib() ([]byte, error) {
+	var private string
+	if ed25519Key, ok := k.Private.(ed25519.PrivateKey); ok {
+		private = hex.EncodeToString(ed25519Key.Seed())
+	} else {
+		data, err := marshalPEM(k.Private)
+		if err != nil {
+			return nil, err
+		}
+		private = string(data)
+	}
+	data, err := json.Marshal(k.Public)
+	if err != nil {
+		return nil, err
+	}
+	dict := map[string]any{}
+	if err := json.Unmarshal(data, &dict); err != nil {
+		return nil, err
+	}
+	dict["keyid"] = k.ID()
+	dict["keyval"].(map[string]any)["private"] = private
+	return json.MarshalIndent(dict, "", "  ")
+}
+
+// loadSSLib parses a securesystemslib JSON key. The public key keeps the
+// key type, scheme and any other fields of the file so its key ID matches
+// the one computed by securesystemslib
+func loadSSLib(data []byte) (*PrivateKey, error) {
+	public := &metadata.Key{}
+	if err := json.Unmarshal(data, public); err != nil {
+		return nil, err
+	}
+	encoded, ok := public.Value.UnrecognizedFields["private"].(string)
+	if !ok || encoded == "" {
+		return nil, metadata.ErrValue{Msg: "no private key found in securesystemslib JSON key"}
+	}
+	delete(public.Value.UnrecognizedFields, "private")
+	delete(public.UnrecognizedFields, "keyid")
+	var private crypto.Signer
+	switch public.Type {
+	case metadata.KeyTypeEd25519:
+		seed, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		switch len(seed) {
+		case ed25519.SeedSize:
+			private = ed25519.NewKeyFromSeed(seed)
+		case ed25519.PrivateKeySize:
+			// some tools store the seed together with the public key
+			private = ed25519.PrivateKey(seed)
+		default:
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid ed25519 private key size %d", len(seed))}
+		}
+	default:
+		key, err := cryptoutils.UnmarshalPEMToPrivateKey([]byte(encoded), cryptoutils.SkipPassword)
+		if err != nil {
+			return nil, err
+		}
+		private, ok = key.(crypto.Signer)
+		if !ok {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported private key type %T", key)}
+		}
+	}
+	// the private key must belong to the public key of the file
+	derived, err := New(private)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := public.ToPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	actual, err := derived.Public.ToPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := cryptoutils.EqualKeys(expected, actual); err != nil {
+		return nil, metadata.ErrValue{Msg: "private key doesn't match the public key of the securesystemslib JSON key"}
+	}
+	return &PrivateKey{Private: private, Public: public}, nil
+}
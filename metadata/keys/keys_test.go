@@ -0,0 +1,102 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keys
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperVerifySigner checks metadata signed by the key verifies with its
+// public key
+func helperVerifySigner(t *testing.T, key *PrivateKey) {
+	signer, err := key.Signer()
+	assert.NoError(t, err)
+	root := metadata.Root(time.Now().AddDate(0, 0, 1))
+	assert.NoError(t, root.Signed.AddKey(key.Public, metadata.ROOT))
+	_, err = root.Sign(signer)
+	assert.NoError(t, err)
+	assert.NoError(t, root.VerifyDelegate(metadata.ROOT, root))
+}
+
+func TestGenerateExportAndLoad(t *testing.T) {
+	passphrase := []byte("secret")
+	for _, keyType := range []string{metadata.KeyTypeEd25519, metadata.KeyTypeECDSA_SHA2_P256, metadata.KeyTypeRSASSA_PSS_SHA256} {
+		key, err := Generate(keyType)
+		assert.NoError(t, err)
+		assert.Equal(t, keyType, key.Public.Type)
+		helperVerifySigner(t, key)
+
+		for _, format := range []Format{FormatPEM, FormatPKCS8, FormatEncryptedPEM, FormatSSLib} {
+			data, err := key.Export(format, passphrase)
+			assert.NoError(t, err, "%s %s", keyType, format)
+			loaded, err := Load(data, passphrase)
+			assert.NoError(t, err, "%s %s", keyType, format)
+			assert.Equal(t, key.ID(), loaded.ID(), "%s %s", keyType, format)
+			helperVerifySigner(t, loaded)
+		}
+
+		// encrypted keys need the right passphrase
+		data, err := key.Export(FormatEncryptedPEM, passphrase)
+		assert.NoError(t, err)
+		_, err = Load(data, nil)
+		assert.ErrorContains(t, err, "passphrase is required")
+		_, err = Load(data, []byte("wrong"))
+		assert.Error(t, err)
+		_, err = key.Export(FormatEncryptedPEM, nil)
+		assert.Error(t, err)
+	}
+
+	_, err := Generate("dsa")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "unsupported key type dsa"})
+}
+
+func TestLoadSSLib(t *testing.T) {
+	key, err := Generate(metadata.KeyTypeEd25519)
+	assert.NoError(t, err)
+	data, err := key.Export(FormatSSLib, nil)
+	assert.NoError(t, err)
+
+	// the key ID of securesystemslib keys covers keyid_hash_algorithms
+	dict := map[string]any{}
+	assert.NoError(t, json.Unmarshal(data, &dict))
+	assert.Equal(t, key.ID(), dict["keyid"])
+	dict["keyid_hash_algorithms"] = []string{"sha256", "sha512"}
+	data, err = json.Marshal(dict)
+	assert.NoError(t, err)
+	name := filepath.Join(t.TempDir(), "key.json")
+	assert.NoError(t, os.WriteFile(name, data, 0600))
+	loaded, err := LoadFile(name, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key.ID(), loaded.ID())
+	assert.Equal(t, []any{"sha256", "sha512"}, loaded.Public.UnrecognizedFields["keyid_hash_algorithms"])
+	assert.NotContains(t, loaded.Public.Value.UnrecognizedFields, "private")
+	helperVerifySigner(t, loaded)
+
+	// the private key must match the public key
+	other, err := Generate(metadata.KeyTypeEd25519)
+	assert.NoError(t, err)
+	dict["keyval"].(map[string]any)["public"] = other.Public.Value.PublicKey
+	data, err = json.Marshal(dict)
+	assert.NoError(t, err)
+	_, err = Load(data, nil)
+	assert.ErrorContains(t, err, "doesn't match")
+
+	_, err = Load([]byte("not a key"), nil)
+	assert.Error(t, err)
+}
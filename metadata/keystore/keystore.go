@@ -0,0 +1,430 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keystore
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
+	"github.com/sigstore/sigstore/pkg/signature"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// KDFArgon2id is the only supported key derivation function
+	KDFArgon2id = "argon2id"
+	// CipherXChaCha20Poly1305 is the only supported AEAD
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+	// fileSuffix is the suffix of the key files in a keystore directory
+	fileSuffix = ".key.json"
+	// MaxKDFTime is the largest number of argon2id passes accepted
+	MaxKDFTime = 16
+	// MaxKDFMemory is the largest argon2id memory size in KiB accepted, the
+	// 2 GiB recommended first by RFC 9106
+	MaxKDFMemory = 2 * 1024 * 1024
+	// minSaltSize is the smallest salt size in bytes accepted
+	minSaltSize = 16
+)
+
+// KDFParams are the argon2id parameters used to derive the encryption key
+// of a private key from the passphrase
+type KDFParams struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt,omitempty"`
+	// Time is the number of passes over the memory
+	Time uint32 `json:"time"`
+	// Memory is the size of the memory in KiB
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// DefaultKDFParams returns the argon2id parameters recommended by RFC 9106
+// for memory constrained environments
+func DefaultKDFParams() KDFParams {
+	return KDFParams{Name: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+}
+
+// Validate checks the parameters are within bounds, so key files with
+// tampered or corrupted parameters can't crash or exhaust the process
+func (p KDFParams) Validate() error {
+	if p.Name != KDFArgon2id {
+		return metadata.ErrValue{Msg: fmt.Sprintf("unsupported key derivation function %s", p.Name)}
+	}
+	if p.Time < 1 || p.Time > MaxKDFTime {
+		return metadata.ErrValue{Msg: fmt.Sprintf("invalid key derivation time %d, expected between 1 and %d", p.Time, MaxKDFTime)}
+	}
+	if p.Threads < 1 {
+		return metadata.ErrValue{Msg: "invalid key derivation threads 0, expected at least 1"}
+	}
+	// argon2id needs at least 8 KiB per thread
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > MaxKDFMemory {
+		return metadata.ErrValue{Msg: fmt.Sprintf("invalid key derivation memory %d KiB, expected between %d and %d", p.Memory, 8*uint32(p.Threads), MaxKDFMemory)}
+	}
+	return nil
+}
+
+// Entry describes a key in the keystore without its private key
+type Entry struct {
+	KeyID   string        `json:"keyid"`
+	Public  *metadata.Key `json:"public"`
+	Roles   []string      `json:"roles"`
+	Created time.Time     `json:"created"`
+}
+
+// keyFile is the content of a key file, the private key is encrypted in
+// the securesystemslib JSON format with the key ID, public key and roles
+// as additional data
+type keyFile struct {
+	Entry
+	KDF        KDFParams `json:"kdf"`
+	Cipher     string    `json:"cipher"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// Keystore is a directory of private keys encrypted with a passphrase
+type Keystore struct {
+	dir string
+	// KDF are the parameters used for keys added or re-encrypted from now on
+	KDF KDFParams
+}
+
+// Open opens the keystore in dir, creating the directory if needed
+func Open(dir string) (*Keystore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir, KDF: DefaultKDFParams()}, nil
+}
+
+// Add encrypts key with passphrase and stores it for roles. An existing
+// key with the same key ID is replaced
+func (s *Keystore) Add(key *keys.PrivateKey, passphrase []byte, roles ...string) error {
+	if len(passphrase) == 0 {
+		return metadata.ErrValue{Msg: "a passphrase is required to add a key to the keystore"}
+	}
+	entry := Entry{KeyID: key.ID(), Public: key.Public, Roles: []string{}, Created: time.Now().UTC().Truncate(time.Second)}
+	for _, role := range roles {
+		if !slices.Contains(entry.Roles, role) {
+			entry.Roles = append(entry.Roles, role)
+		}
+	}
+	sort.Strings(entry.Roles)
+	f, err := s.encrypt(entry, key, passphrase)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Added key with ID %s to the keystore", key.ID())
+	return s.write(f)
+}
+
+// Get returns the entry of keyID
+func (s *Keystore) Get(keyID string) (*Entry, error) {
+	f, err := s.read(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &f.Entry, nil
+}
+
+// List returns the entries of all keys sorted by key ID. With role set only
+// the keys of that role are returned
+func (s *Keystore) List(role string) ([]Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	res := []Entry{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), fileSuffix) {
+			continue
+		}
+		f, err := s.read(strings.TrimSuffix(file.Name(), fileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		if role == "" || slices.Contains(f.Roles, role) {
+			res = append(res, f.Entry)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].KeyID < res[j].KeyID })
+	return res, nil
+}
+
+// SetRoles replaces the roles keyID is used for. The roles are
+// authenticated by the encryption, so the key is re-encrypted with
+// passphrase
+func (s *Keystore) SetRoles(passphrase []byte, keyID string, roles ...string) error {
+	f, err := s.read(keyID)
+	if err != nil {
+		return err
+	}
+	key, err := s.decrypt(f, passphrase)
+	if err != nil {
+		return err
+	}
+	entry := f.Entry
+	entry.Roles = []string{}
+	for _, role := range roles {
+		if !slices.Contains(entry.Roles, role) {
+			entry.Roles = append(entry.Roles, role)
+		}
+	}
+	sort.Strings(entry.Roles)
+	f, err = s.encrypt(entry, key, passphrase)
+	if err != nil {
+		return err
+	}
+	return s.write(f)
+}
+
+// Remove deletes keyID from the keystore
+func (s *Keystore) Remove(keyID string) error {
+	if _, err := s.read(keyID); err != nil {
+		return err
+	}
+	return os.Remove(s.path(keyID))
+}
+
+// Unlock decrypts the keys with keyIDs, all keys if none are given, for a
+// signing session. Nothing is unlocked if any of them fails to decrypt
+func (s *Keystore) Unlock(passphrase []byte, keyIDs ...string) (*Session, error) {
+	if len(keyIDs) == 0 {
+		entries, err := s.List("")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			keyIDs = append(keyIDs, entry.KeyID)
+		}
+	}
+	session := &Session{keys: map[string]*keys.PrivateKey{}, roles: map[string][]string{}}
+	for _, keyID := range keyIDs {
+		f, err := s.read(keyID)
+		if err != nil {
+			return nil, err
+		}
+		key, err := s.decrypt(f, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		session.keys[keyID] = key
+		session.roles[keyID] = f.Roles
+	}
+	log.Debugf("Unlocked %d keys", len(session.keys))
+	return session, nil
+}
+
+// ChangePassphrase re-encrypts the keys with keyIDs, all keys if none are
+// given, with a new passphrase. Every key is decrypted before any of them
+// is rewritten so a wrong passphrase leaves the keystore unchanged
+func (s *Keystore) ChangePassphrase(oldPassphrase, newPassphrase []byte, keyIDs ...string) error {
+	if len(newPassphrase) == 0 {
+		return metadata.ErrValue{Msg: "the new passphrase can not be empty"}
+	}
+	session, err := s.Unlock(oldPassphrase, keyIDs...)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	files := []*keyFile{}
+	for _, keyID := range session.KeyIDs() {
+		f, err := s.read(keyID)
+		if err != nil {
+			return err
+		}
+		f, err = s.encrypt(f.Entry, session.keys[keyID], newPassphrase)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		if err := s.write(f); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Changed the passphrase of %d keys", len(files))
+	return nil
+}
+
+// encrypt returns the key file of key encrypted with passphrase
+func (s *Keystore) encrypt(entry Entry, key *keys.PrivateKey, passphrase []byte) (*keyFile, error) {
+	if err := s.KDF.Validate(); err != nil {
+		return nil, err
+	}
+	additionalData, err := entry.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := key.Export(keys.FormatSSLib, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := &keyFile{Entry: entry, KDF: s.KDF, Cipher: CipherXChaCha20Poly1305}
+	f.KDF.Salt = make([]byte, 2*minSaltSize)
+	if _, err := rand.Read(f.KDF.Salt); err != nil {
+		return nil, err
+	}
+	f.Nonce = make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(deriveKey(f.KDF, passphrase))
+	if err != nil {
+		return nil, err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, additionalData)
+	return f, nil
+}
+
+// decrypt returns the private key of a key file
+func (s *Keystore) decrypt(f *keyFile, passphrase []byte) (*keys.PrivateKey, error) {
+	if f.KDF.Name != KDFArgon2id || f.Cipher != CipherXChaCha20Poly1305 {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported encryption %s with %s of key %s", f.Cipher, f.KDF.Name, f.KeyID)}
+	}
+	// the parameters are checked before they are used
+	if err := f.KDF.Validate(); err != nil {
+		return nil, fmt.Errorf("key file of %s: %w", f.KeyID, err)
+	}
+	if len(f.KDF.Salt) < minSaltSize || len(f.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("key file of %s has an invalid salt or nonce size", f.KeyID)}
+	}
+	additionalData, err := f.Entry.additionalData()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(deriveKey(f.KDF, passphrase))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, additionalData)
+	if err != nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("wrong passphrase for key %s or modified key file", f.KeyID)}
+	}
+	key, err := keys.Load(plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+	if key.ID() != f.KeyID {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("private key doesn't match key ID %s", f.KeyID)}
+	}
+	return key, nil
+}
+
+// additionalData returns the parts of the entry authenticated by the
+// encryption of the private key
+func (e Entry) additionalData() ([]byte, error) {
+	return json.Marshal(struct {
+		KeyID  string        `json:"keyid"`
+		Public *metadata.Key `json:"public"`
+		Roles  []string      `json:"roles"`
+	}{e.KeyID, e.Public, e.Roles})
+}
+
+// deriveKey derives the encryption key from passphrase
+func deriveKey(params KDFParams, passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, params.Salt, params.Time, params.Memory, params.Threads, chacha20poly1305.KeySize)
+}
+
+// read reads the key file of keyID
+func (s *Keystore) read(keyID string) (*keyFile, error) {
+	data, err := os.ReadFile(s.path(keyID))
+	if os.IsNotExist(err) {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("key with ID %s not found in the keystore", keyID)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &keyFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("failed to parse key file of %s: %w", keyID, err)
+	}
+	if f.KeyID != keyID || f.Public == nil || f.Public.ID() != keyID {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("key file of %s is for a different key", keyID)}
+	}
+	return f, nil
+}
+
+// write atomically writes a key file readable only by its owner
+func (s *Keystore) write(f *keyFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.path(f.KeyID), data, 0600)
+}
+
+// path returns the name of the key file of keyID
+func (s *Keystore) path(keyID string) string {
+	return filepath.Join(s.dir, filepath.Base(keyID)+fileSuffix)
+}
+
+// Session holds the keys unlocked for a signing session
+type Session struct {
+	keys  map[string]*keys.PrivateKey
+	roles map[string][]string
+}
+
+// KeyIDs returns the IDs of the unlocked keys
+func (s *Session) KeyIDs() []string {
+	res := make([]string, 0, len(s.keys))
+	for keyID := range s.keys {
+		res = append(res, keyID)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Signer returns the signer of an unlocked key
+func (s *Session) Signer(keyID string) (signature.Signer, error) {
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("key with ID %s is not unlocked", keyID)}
+	}
+	return key.Signer()
+}
+
+// Signers returns the signers of the unlocked keys of role
+func (s *Session) Signers(role string) ([]signature.Signer, error) {
+	res := []signature.Signer{}
+	for _, keyID := range s.KeyIDs() {
+		if !slices.Contains(s.roles[keyID], role) {
+			continue
+		}
+		signer, err := s.Signer(keyID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, signer)
+	}
+	return res, nil
+}
+
+// Close ends the session, the keys can't be used afterwards
+func (s *Session) Close() {
+	s.keys = map[string]*keys.PrivateKey{}
+	s.roles = map[string][]string{}
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperOpen opens a keystore with cheap key derivation for tests
+func helperOpen(t *testing.T) *Keystore {
+	s, err := Open(filepath.Join(t.TempDir(), "keys"))
+	assert.NoError(t, err)
+	s.KDF.Time, s.KDF.Memory, s.KDF.Threads = 1, 64, 1
+	return s
+}
+
+func TestKeystore(t *testing.T) {
+	s := helperOpen(t)
+	passphrase := []byte("secret")
+	rootKey, err := keys.Generate(metadata.KeyTypeEd25519)
+	assert.NoError(t, err)
+	onlineKey, err := keys.Generate(metadata.KeyTypeECDSA_SHA2_P256)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Add(rootKey, passphrase, metadata.ROOT))
+	assert.NoError(t, s.Add(onlineKey, passphrase, metadata.TIMESTAMP, metadata.SNAPSHOT, metadata.TIMESTAMP))
+	assert.Error(t, s.Add(rootKey, nil, metadata.ROOT))
+
+	// the private keys are not stored in plain text
+	info, err := os.Stat(s.path(rootKey.ID()))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(s.path(rootKey.ID()))
+	assert.NoError(t, err)
+	exported, err := rootKey.Export(keys.FormatSSLib, nil)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), string(exported[len(exported)-80:]))
+
+	entries, err := s.List("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	entries, err = s.List(metadata.SNAPSHOT)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, onlineKey.ID(), entries[0].KeyID)
+	assert.Equal(t, []string{metadata.SNAPSHOT, metadata.TIMESTAMP}, entries[0].Roles)
+	entry, err := s.Get(rootKey.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, rootKey.Public.Value.PublicKey, entry.Public.Value.PublicKey)
+	_, err = s.Get("missing")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "key with ID missing not found in the keystore"})
+
+	// unlocked keys sign for their roles
+	_, err = s.Unlock([]byte("wrong"))
+	assert.ErrorContains(t, err, "wrong passphrase")
+	session, err := s.Unlock(passphrase)
+	assert.NoError(t, err)
+	assert.Len(t, session.KeyIDs(), 2)
+	signers, err := session.Signers(metadata.TIMESTAMP)
+	assert.NoError(t, err)
+	assert.Len(t, signers, 1)
+	timestamp := metadata.Timestamp(time.Now().AddDate(0, 0, 1))
+	signature, err := timestamp.Sign(signers[0])
+	assert.NoError(t, err)
+	assert.Equal(t, onlineKey.ID(), signature.KeyID)
+	session.Close()
+	_, err = session.Signer(onlineKey.ID())
+	assert.Error(t, err)
+
+	assert.Error(t, s.SetRoles([]byte("wrong"), rootKey.ID(), metadata.ROOT, metadata.TARGETS))
+	assert.NoError(t, s.SetRoles(passphrase, rootKey.ID(), metadata.ROOT, metadata.TARGETS))
+	entries, err = s.List(metadata.TARGETS)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.NoError(t, s.Remove(rootKey.ID()))
+	entries, err = s.List("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestKeystoreChangePassphrase(t *testing.T) {
+	s := helperOpen(t)
+	for _, passphrase := range []string{"first", "second"} {
+		key, err := keys.Generate(metadata.KeyTypeEd25519)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Add(key, []byte(passphrase), metadata.TARGETS))
+	}
+
+	// keys are re-encrypted only if all of them can be decrypted
+	assert.ErrorContains(t, s.ChangePassphrase([]byte("first"), []byte("new")), "wrong passphrase")
+	_, err := s.Unlock([]byte("new"))
+	assert.Error(t, err)
+
+	entries, err := s.List("")
+	assert.NoError(t, err)
+	for _, entry := range entries {
+		for _, passphrase := range []string{"first", "second"} {
+			if _, err := s.Unlock([]byte(passphrase), entry.KeyID); err == nil {
+				assert.NoError(t, s.ChangePassphrase([]byte(passphrase), []byte("new"), entry.KeyID))
+			}
+		}
+	}
+	session, err := s.Unlock([]byte("new"))
+	assert.NoError(t, err)
+	assert.Len(t, session.KeyIDs(), 2)
+	assert.Error(t, s.ChangePassphrase([]byte("new"), nil))
+}
+
+func TestKeystoreTamperedKeyFile(t *testing.T) {
+	s := helperOpen(t)
+	passphrase := []byte("secret")
+	key, err := keys.Generate(metadata.KeyTypeEd25519)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Add(key, passphrase, metadata.TIMESTAMP))
+	original, err := s.read(key.ID())
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		name   string
+		tamper func(f *keyFile)
+		err    string
+	}{
+		{name: "roles", tamper: func(f *keyFile) { f.Roles = []string{metadata.ROOT} }, err: "modified key file"},
+		{name: "no threads", tamper: func(f *keyFile) { f.KDF.Threads = 0 }, err: "invalid key derivation threads"},
+		{name: "no time", tamper: func(f *keyFile) { f.KDF.Time = 0 }, err: "invalid key derivation time"},
+		{name: "huge memory", tamper: func(f *keyFile) { f.KDF.Memory = 1 << 31 }, err: "invalid key derivation memory"},
+		{name: "short nonce", tamper: func(f *keyFile) { f.Nonce = f.Nonce[:12] }, err: "invalid salt or nonce size"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := *original
+			f.Nonce = append([]byte{}, original.Nonce...)
+			tt.tamper(&f)
+			assert.NoError(t, s.write(&f))
+			_, err := s.Unlock(passphrase, key.ID())
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	// unsafe parameters aren't used for new keys either
+	s.KDF.Threads = 0
+	assert.ErrorContains(t, s.Add(key, passphrase), "invalid key derivation threads")
+}
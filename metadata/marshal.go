@@ -253,8 +253,10 @@ func (key *Key) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &a); err != nil {
 		return err
 	}
-	// nolint
-	*key = Key(a)
+	// copy the fields one by one so we don't copy the lock guarding the key ID
+	key.Type = a.Type
+	key.Scheme = a.Scheme
+	key.Value = a.Value
 
 	var dict map[string]any
 	if err := json.Unmarshal(data, &dict); err != nil {
@@ -370,7 +372,16 @@ func (kv KeyVal) MarshalJSON() ([]byte, error) {
 	if len(kv.UnrecognizedFields) != 0 {
 		copyMapValues(kv.UnrecognizedFields, dict)
 	}
-	dict["public"] = kv.PublicKey
+	// sigstore-oidc keys have an identity instead of a public key
+	if kv.PublicKey != "" || (kv.Identity == "" && kv.Issuer == "") {
+		dict["public"] = kv.PublicKey
+	}
+	if kv.Identity != "" {
+		dict["identity"] = kv.Identity
+	}
+	if kv.Issuer != "" {
+		dict["issuer"] = kv.Issuer
+	}
 	return json.Marshal(dict)
 }
 
@@ -387,6 +398,8 @@ func (kv *KeyVal) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	delete(dict, "public")
+	delete(dict, "identity")
+	delete(dict, "issuer")
 	kv.UnrecognizedFields = dict
 	return nil
 }
@@ -514,6 +527,10 @@ func (role *SuccinctRoles) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &a); err != nil {
 		return err
 	}
+	// the bit length bounds the number of delegated roles of untrusted metadata
+	if err := checkBitLength(a.BitLength); err != nil {
+		return err
+	}
 	*role = SuccinctRoles(a)
 
 	var dict map[string]any
@@ -13,7 +13,6 @@ package metadata
 
 import (
 	"bytes"
-	"crypto"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -203,7 +202,11 @@ func (meta *Metadata[T]) ToFile(name string, pretty bool) error {
 }
 
 // Sign create signature over Signed and assign it to Signatures
-func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
+func (meta *Metadata[T]) Sign(signer signature.Signer, opts ...SignOption) (*Signature, error) {
+	options := &signOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
 	// encode the Signed part to canonical JSON so signatures are consistent
 	payload, err := cjson.EncodeCanonical(meta.Signed)
 	if err != nil {
@@ -214,13 +217,8 @@ func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
 	if err != nil {
 		return nil, ErrUnsignedMetadata{Msg: "problem signing metadata"}
 	}
-	// get the signer's PublicKey
-	publ, err := signer.PublicKey()
-	if err != nil {
-		return nil, err
-	}
-	// convert to TUF Key type to get keyID
-	key, err := KeyFromPublicKey(publ)
+	// get the signer's TUF Key to get keyID
+	key, err := SignerKey(signer)
 	if err != nil {
 		return nil, err
 	}
@@ -229,6 +227,19 @@ func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
 		KeyID:     key.ID(),
 		Signature: sb,
 	}
+	// add the fields of signers which need more than the signature
+	if decorator, ok := signer.(SignatureDecorator); ok {
+		err = decorator.DecorateSignature(sig, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, recorder := range options.recorders {
+		err = recorder.RecordSignature(sig, payload, key)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// update the Signatures part
 	meta.Signatures = append(meta.Signatures, *sig)
 	// return the new signature
@@ -236,11 +247,26 @@ func (meta *Metadata[T]) Sign(signer signature.Signer) (*Signature, error) {
 	return sig, nil
 }
 
+// VerifyOption configures Metadata[T].VerifyDelegate
+type VerifyOption func(*verifyOptions)
+
+// verifyOptions is the verification policy of a VerifyDelegate call
+type verifyOptions struct {
+	keyIDMode        KeyIDMode
+	tlogPolicy       *TlogPolicy
+	keylessTrustRoot *KeylessTrustRoot
+}
+
 // VerifyDelegate verifies that delegatedMetadata is signed with the required
 // threshold of keys for the delegated role delegatedRole
-func (meta *Metadata[T]) VerifyDelegate(delegatedRole string, delegatedMetadata any) error {
+func (meta *Metadata[T]) VerifyDelegate(delegatedRole string, delegatedMetadata any, opts ...VerifyOption) error {
+	options := &verifyOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
 	i := any(meta)
 	signingKeys := map[string]bool{}
+	mismatches := []string{}
 	var keys map[string]*Key
 	var roleKeyIDs []string
 	var roleThreshold int
@@ -296,82 +322,64 @@ func (meta *Metadata[T]) VerifyDelegate(delegatedRole string, delegatedMetadata
 		if !ok {
 			return ErrValue{Msg: fmt.Sprintf("key with ID %s not found in %s keyids", keyID, delegatedRole)}
 		}
-		sign := Signature{}
-		var payload []byte
-		// convert to a PublicKey type
-		publicKey, err := key.ToPublicKey()
-		if err != nil {
-			return err
-		}
-		// use corresponding hash function for key type
-		hash := crypto.Hash(0)
-		if key.Type != KeyTypeEd25519 {
-			hash = crypto.SHA256
+		// the key ID has to match the key under the configured mode, a key
+		// which doesn't match doesn't count toward the threshold
+		if err := key.CheckID(keyID, options.keyIDMode); err != nil {
+			log.Debugf("Skipping key ID %s of %s: %s", keyID, delegatedRole, err)
+			mismatches = append(mismatches, err.Error())
+			continue
 		}
+		var signatures []Signature
+		var payload []byte
 		// load a verifier based on that key
-		verifier, err := signature.LoadVerifier(publicKey, hash)
+		verify, err := key.verifier(options)
 		if err != nil {
 			return err
 		}
-		// collect the signature for that key and build the payload we'll verify
-		// based on the Signed part of the delegated metadata
+		// collect the signatures and build the payload we'll verify based on
+		// the Signed part of the delegated metadata
 		switch d := delegatedMetadata.(type) {
 		case *Metadata[RootType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
+			signatures = d.Signatures
 			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
-			}
 		case *Metadata[SnapshotType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
+			signatures = d.Signatures
 			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
-			}
 		case *Metadata[TimestampType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
+			signatures = d.Signatures
 			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
-			}
 		case *Metadata[TargetsType]:
-			for _, signature := range d.Signatures {
-				if signature.KeyID == keyID {
-					sign = signature
-				}
-			}
+			signatures = d.Signatures
 			payload, err = cjson.EncodeCanonical(d.Signed)
-			if err != nil {
-				return err
-			}
 		default:
 			return ErrType{Msg: "unknown delegated metadata type"}
 		}
+		if err != nil {
+			return err
+		}
+		// collect the signature for that key
+		sign, err := key.findSignature(keyID, signatures, options.keyIDMode)
+		if err != nil {
+			mismatches = append(mismatches, err.Error())
+		}
 		// verify if the signature for that payload corresponds to the given key
-		if err := verifier.VerifySignature(bytes.NewReader(sign.Signature), bytes.NewReader(payload)); err != nil {
+		if err := verify(sign, payload); err != nil {
 			// failed to verify the metadata with that key ID
-			log.Debugf("Failed to verify %s with key ID %s", delegatedRole, keyID)
+			log.Debugf("Failed to verify %s with key ID %s: %s", delegatedRole, keyID, err)
 		} else {
-			// save the verified keyID only if verification passed
-			signingKeys[keyID] = true
+			// save the verified key only if verification passed, by its
+			// content so a key listed under several key IDs counts once
+			signingKeys[key.ID()] = true
 			log.Debugf("Verified %s with key ID %s", delegatedRole, keyID)
 		}
 	}
 	// check if the amount of valid signatures is enough
 	if len(signingKeys) < roleThreshold {
 		log.Infof("Verifying %s failed, not enough signatures, got %d, want %d", delegatedRole, len(signingKeys), roleThreshold)
+		// signatures made under another key ID of a key explain the failure
+		if len(mismatches) > 0 {
+			return ErrKeyIDMismatch{Msg: fmt.Sprintf("Verifying %s failed, not enough signatures, got %d, want %d: %s", delegatedRole, len(signingKeys), roleThreshold, strings.Join(mismatches, ", "))}
+		}
 		return ErrUnsignedMetadata{Msg: fmt.Sprintf("Verifying %s failed, not enough signatures, got %d, want %d", delegatedRole, len(signingKeys), roleThreshold)}
 	}
 	log.Infof("Verified %s successfully", delegatedRole)
@@ -546,6 +554,9 @@ func (role *Delegations) GetRolesForTarget(targetFilepath string) map[string]boo
 // the left-most "BitLength" of bits of the file path hash digest to
 // int, using it as bin index between 0 and “2**BitLength - 1“.
 func (role *SuccinctRoles) GetRolesForTarget(targetFilepath string) map[string]bool {
+	if checkBitLength(role.BitLength) != nil {
+		return map[string]bool{}
+	}
 	// calculate the suffixLen value based on the total number of bins in
 	// hex. If bit_length = 10 then numberOfBins = 1024 or bin names will
 	// have a suffix between "000" and "3ff" in hex and suffixLen will be 3
@@ -570,9 +581,13 @@ func (role *SuccinctRoles) GetRolesForTarget(targetFilepath string) map[string]b
 	return map[string]bool{fmt.Sprintf("%s-%s", role.NamePrefix, suffix): true}
 }
 
-// GetRoles returns the names of all different delegated roles
+// GetRoles returns the names of all different delegated roles, none if
+// the bit length is not between 1 and MaxBitLength
 func (role *SuccinctRoles) GetRoles() []string {
 	res := []string{}
+	if checkBitLength(role.BitLength) != nil {
+		return res
+	}
 	numberOfBins := int(math.Pow(2, float64(role.BitLength)))
 	suffixLen := len(strconv.FormatInt(int64(numberOfBins-1), 16))
 
@@ -586,6 +601,9 @@ func (role *SuccinctRoles) GetRoles() []string {
 // IsDelegatedRole returns whether the given roleName is in one of
 // the delegated roles that “SuccinctRoles“ represents
 func (role *SuccinctRoles) IsDelegatedRole(roleName string) bool {
+	if checkBitLength(role.BitLength) != nil {
+		return false
+	}
 	numberOfBins := int64(math.Pow(2, float64(role.BitLength)))
 	suffixLen := len(strconv.FormatInt(int64(numberOfBins-1), 16))
 
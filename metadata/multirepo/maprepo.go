@@ -0,0 +1,132 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package multirepo
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/updater"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMapTargetPath is the target path of the map file in a map repository
+const DefaultMapTargetPath = "map.json"
+
+// MapRepository is a TUF repository which distributes the map file as one
+// of its targets, so the mappings can be changed without a client release
+type MapRepository struct {
+	// Config is the configuration of the TUF client of the map repository
+	Config *config.UpdaterConfig
+	// TargetPath is the target path of the map file, DefaultMapTargetPath if empty
+	TargetPath string
+	mu         sync.Mutex
+	// tufClient is the client of the last fetch, the next one starts from
+	// the root it trusts
+	tufClient *updater.Updater
+}
+
+// NewConfigFromRepository returns configuration for a multi-repo TUF client
+// using the map file fetched from mapRepo and verified by its metadata.
+// The map file is fetched again on each refresh of the client
+func NewConfigFromRepository(mapRepo *MapRepository, roots map[string][]byte) (*MultiRepoConfig, error) {
+	if mapRepo == nil || mapRepo.Config == nil {
+		return nil, fmt.Errorf("failed to create multi-repository config: no map repository is provided")
+	}
+	targetInfo, data, err := mapRepo.fetch()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := NewConfig(data, roots)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MapRepository = mapRepo
+	cfg.mapTarget = targetInfo
+	return cfg, nil
+}
+
+// targetPath returns the target path of the map file
+func (mapRepo *MapRepository) targetPath() string {
+	if mapRepo.TargetPath == "" {
+		return DefaultMapTargetPath
+	}
+	return mapRepo.TargetPath
+}
+
+// fetch refreshes the map repository and returns the target info and the
+// content of the map file
+func (mapRepo *MapRepository) fetch() (*metadata.TargetFiles, []byte, error) {
+	mapRepo.mu.Lock()
+	defer mapRepo.mu.Unlock()
+	// ensure paths exist, doesn't do anything if caching is disabled
+	if err := mapRepo.Config.EnsurePathsExist(); err != nil {
+		return nil, nil, err
+	}
+	// a TUF client is refreshed once, so each fetch needs a new one which
+	// trusts the root the previous one did
+	tufClient, err := newUpdater(mapRepo.Config, mapRepo.tufClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Updater instance for the map repository: %w", err)
+	}
+	mapRepo.tufClient = tufClient
+	if err := tufClient.Refresh(); err != nil {
+		return nil, nil, fmt.Errorf("failed to refresh the map repository: %w", err)
+	}
+	targetInfo, err := tufClient.GetTargetInfo(mapRepo.targetPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the map file from the map repository: %w", err)
+	}
+	// see if the map file is already present locally
+	_, data, err := tufClient.FindCachedTarget(targetInfo, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		_, data, err = tufClient.DownloadTarget(targetInfo, "", "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to download the map file from the map repository: %w", err)
+		}
+	}
+	return targetInfo, data, nil
+}
+
+// fetchMap fetches the map file from the map repository and returns its
+// mappings and target info if it changed since the map file in use, or the
+// ones in use otherwise. It fails if the new map file is not valid
+func (client *MultiRepoClient) fetchMap() (*MultiRepoMapType, *metadata.TargetFiles, error) {
+	client.mu.Lock()
+	repoMap, mapTarget := client.Config.RepoMap, client.Config.mapTarget
+	client.mu.Unlock()
+	mapRepo := client.Config.MapRepository
+	targetInfo, data, err := mapRepo.fetch()
+	if err != nil {
+		return nil, nil, err
+	}
+	if mapTarget != nil && mapTarget.Equal(*targetInfo) {
+		// the map file didn't change
+		return repoMap, mapTarget, nil
+	}
+	log.Infof("Map file %s changed, updating the mappings", mapRepo.targetPath())
+	mapFile, err := ParseMapFile(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	for repo := range mapFile.Repositories {
+		if _, ok := client.Config.TrustedRoots[repo]; !ok {
+			return nil, nil, fmt.Errorf("no trusted root metadata provided for repository - %s", repo)
+		}
+	}
+	return mapFile, targetInfo, nil
+}
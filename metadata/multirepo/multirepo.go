@@ -12,13 +12,22 @@
 package multirepo
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/cache"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/updater"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/exp/slices"
@@ -37,6 +46,82 @@ type MultiRepoMapType struct {
 	Mapping      []*Mapping          `json:"mapping"`
 }
 
+// ParseMapFile parses and validates a map file. Unknown fields and
+// trailing data are rejected
+func ParseMapFile(data []byte) (*MultiRepoMapType, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	mapFile := &MultiRepoMapType{}
+	if err := decoder.Decode(mapFile); err != nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid map file: %s", err)}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, metadata.ErrValue{Msg: "invalid map file: unexpected data after the map"}
+	}
+	if err := mapFile.Validate(); err != nil {
+		return nil, err
+	}
+	return mapFile, nil
+}
+
+// Validate checks the map file: every repository has a name and at least
+// one URL, every mapping has valid path patterns and lists known
+// repositories without duplicates and its threshold is between 1 and the
+// number of its repositories
+func (m *MultiRepoMapType) Validate() error {
+	invalid := func(format string, a ...any) error {
+		return metadata.ErrValue{Msg: "invalid map file: " + fmt.Sprintf(format, a...)}
+	}
+	if len(m.Repositories) == 0 {
+		return invalid("no repositories")
+	}
+	for name, urls := range m.Repositories {
+		if name == "" {
+			return invalid("repository with an empty name")
+		}
+		if len(urls) == 0 {
+			return invalid("repository %s has no URL", name)
+		}
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				return invalid("repository %s has an invalid URL %q", name, u)
+			}
+		}
+	}
+	if len(m.Mapping) == 0 {
+		return invalid("no mappings")
+	}
+	for i, mapping := range m.Mapping {
+		if mapping == nil {
+			return invalid("mapping %d is empty", i)
+		}
+		if len(mapping.Paths) == 0 {
+			return invalid("mapping %d has no paths", i)
+		}
+		for _, pattern := range mapping.Paths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return invalid("mapping %d has an invalid path pattern %q", i, pattern)
+			}
+		}
+		if len(mapping.Repositories) == 0 {
+			return invalid("mapping %d has no repositories", i)
+		}
+		for j, name := range mapping.Repositories {
+			if _, ok := m.Repositories[name]; !ok {
+				return invalid("mapping %d references unknown repository %s", i, name)
+			}
+			if slices.Contains(mapping.Repositories[:j], name) {
+				return invalid("mapping %d lists repository %s more than once", i, name)
+			}
+		}
+		if mapping.Threshold < 1 || mapping.Threshold > len(mapping.Repositories) {
+			return invalid("mapping %d has threshold %d, expected between 1 and %d", i, mapping.Threshold, len(mapping.Repositories))
+		}
+	}
+	return nil
+}
+
 // MultiRepoConfig represents the configuration for a set of trusted TUF clients
 type MultiRepoConfig struct {
 	RepoMap           *MultiRepoMapType
@@ -44,12 +129,34 @@ type MultiRepoConfig struct {
 	LocalMetadataDir  string
 	LocalTargetsDir   string
 	DisableLocalCache bool
+	TargetCache       *cache.Cache   // optional content-addressed cache shared by all repositories
+	MapRepository     *MapRepository // optional repository which distributes the map file
+	// Verification policy of all repositories, see config.UpdaterConfig
+	KeyIDMode        metadata.KeyIDMode
+	TlogPolicy       *metadata.TlogPolicy
+	KeylessTrustRoot *metadata.KeylessTrustRoot
+	// mapTarget is the target info of the map file in use from MapRepository
+	mapTarget *metadata.TargetFiles
 }
 
-// MultiRepoClient represents a multi-repository TUF client
+// MultiRepoClient represents a multi-repository TUF client. TUFClients and
+// Config.RepoMap are replaced on each refresh while holding the client lock
 type MultiRepoClient struct {
 	TUFClients map[string]*updater.Updater
 	Config     *MultiRepoConfig
+	mu         sync.Mutex
+	// failed are the errors of the repositories which failed the last refresh
+	failed map[string]error
+	// idle are the repositories whose client was created by New and was
+	// not refreshed yet, so the first refresh can use it
+	idle map[string]bool
+}
+
+// state is the map file and the repository clients an operation works on
+type state struct {
+	repoMap    *MultiRepoMapType
+	tufClients map[string]*updater.Updater
+	failed     map[string]error
 }
 
 type targetMatch struct {
@@ -64,9 +171,9 @@ func NewConfig(repoMap []byte, roots map[string][]byte) (*MultiRepoConfig, error
 		return nil, fmt.Errorf("failed to create multi-repository config: no map file and/or trusted root metadata is provided")
 	}
 
-	// unmarshal the map file (note: should we expect/support unrecognized values here?)
-	var mapFile *MultiRepoMapType
-	if err := json.Unmarshal(repoMap, &mapFile); err != nil {
+	// parse and validate the map file
+	mapFile, err := ParseMapFile(repoMap)
+	if err != nil {
 		return nil, err
 	}
 
@@ -87,10 +194,18 @@ func NewConfig(repoMap []byte, roots map[string][]byte) (*MultiRepoConfig, error
 
 // New returns a multi-repository TUF client. All repositories described in the provided map file are initialized too
 func New(config *MultiRepoConfig) (*MultiRepoClient, error) {
+	// configurations which were not made by NewConfig are validated too
+	if config.RepoMap == nil {
+		return nil, metadata.ErrValue{Msg: "invalid map file: no map file"}
+	}
+	if err := config.RepoMap.Validate(); err != nil {
+		return nil, err
+	}
 	// create a multi repo client instance
 	client := &MultiRepoClient{
 		Config:     config,
 		TUFClients: map[string]*updater.Updater{},
+		idle:       map[string]bool{},
 	}
 
 	// create TUF clients for each repository listed in the map file
@@ -105,66 +220,177 @@ func (client *MultiRepoClient) initTUFClients() error {
 
 	// loop through each repository listed in the map file and initialize it
 	for repoName, repoURL := range client.Config.RepoMap.Repositories {
-		log.Infof("Initializing %s - %s", repoName, repoURL[0])
-
-		// get the trusted root file from the location specified in the map file relevant to its path
-		// NOTE: the root.json file is expected to be in a folder named after the repository it corresponds to placed in the same folder as the map file
-		// i.e <client.cfg.BootstrapDir>/<repo-name>/root.json
-		rootBytes, ok := client.Config.TrustedRoots[repoName]
-		if !ok {
-			return fmt.Errorf("failed to get trusted root metadata from config for repository - %s", repoName)
+		repoTUFClient, err := client.newTUFClient(repoName, repoURL, nil)
+		if err != nil {
+			return err
 		}
+		// save the client
+		client.TUFClients[repoName] = repoTUFClient
+		client.idle[repoName] = true
+	}
+	return nil
+}
 
-		// path of where each of the repository's metadata files will be persisted
-		metadataDir := filepath.Join(client.Config.LocalMetadataDir, repoName)
+// newTUFClient creates a TUF client for the repository repoName at repoURL.
+// It trusts the newest of the bootstrap root, the root in the local cache
+// and the root trusted by prev, the previous client of the repository
+func (client *MultiRepoClient) newTUFClient(repoName string, repoURL []string, prev *updater.Updater) (*updater.Updater, error) {
+	log.Infof("Initializing %s - %s", repoName, repoURL[0])
 
-		// location of where the target files will be downloaded (propagated to each client from the multi-repo config)
-		// WARNING: Do note that using a single folder for storing targets from various repositories as it might lead to a conflict
-		targetsDir := client.Config.LocalTargetsDir
-		if len(client.Config.LocalTargetsDir) == 0 {
-			// if it was not set, create a targets folder under each repository so there's no chance of conflict
-			targetsDir = filepath.Join(metadataDir, "targets")
-		}
+	// get the trusted root file from the location specified in the map file relevant to its path
+	// NOTE: the root.json file is expected to be in a folder named after the repository it corresponds to placed in the same folder as the map file
+	// i.e <client.cfg.BootstrapDir>/<repo-name>/root.json
+	rootBytes, ok := client.Config.TrustedRoots[repoName]
+	if !ok {
+		return nil, fmt.Errorf("failed to get trusted root metadata from config for repository - %s", repoName)
+	}
+
+	// path of where each of the repository's metadata files will be persisted
+	metadataDir := filepath.Join(client.Config.LocalMetadataDir, repoName)
 
-		// ensure paths exist, doesn't do anything if caching is disabled
-		err := client.Config.EnsurePathsExist()
+	// location of where the target files will be downloaded (propagated to each client from the multi-repo config)
+	// WARNING: Do note that using a single folder for storing targets from various repositories as it might lead to a conflict
+	targetsDir := client.Config.LocalTargetsDir
+	if len(client.Config.LocalTargetsDir) == 0 {
+		// if it was not set, create a targets folder under each repository so there's no chance of conflict
+		targetsDir = filepath.Join(metadataDir, "targets")
+	}
+
+	// ensure paths exist, doesn't do anything if caching is disabled
+	err := client.Config.EnsurePathsExist()
+	if err != nil {
+		return nil, err
+	}
+
+	// default config for a TUF Client
+	cfg, err := config.New(repoURL[0], rootBytes)
+	if err != nil {
+		return nil, err
+	}
+	// the other URLs of the repository are its mirrors, tried in order
+	for _, mirror := range repoURL[1:] {
+		targetsMirror, err := url.JoinPath(mirror, "targets")
 		if err != nil {
-			return err
+			return nil, err
 		}
+		cfg.RemoteMetadataMirrors = append(cfg.RemoteMetadataMirrors, mirror)
+		cfg.RemoteTargetsMirrors = append(cfg.RemoteTargetsMirrors, targetsMirror)
+	}
+	cfg.LocalMetadataDir = metadataDir
+	cfg.LocalTargetsDir = targetsDir
+	cfg.DisableLocalCache = client.Config.DisableLocalCache // propagate global cache policy
+	cfg.TargetCache = client.Config.TargetCache             // share the target cache so identical target files are stored once
+	cfg.KeyIDMode = client.Config.KeyIDMode
+	cfg.TlogPolicy = client.Config.TlogPolicy
+	cfg.KeylessTrustRoot = client.Config.KeylessTrustRoot
 
-		// default config for a TUF Client
-		cfg, err := config.New(repoURL[0], rootBytes) // support only one mirror for the time being
+	// create a new Updater instance for the repository
+	repoTUFClient, err := newUpdater(cfg, prev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Updater instance: %w", err)
+	}
+	log.Debugf("Successfully initialized %s - %s", repoName, repoURL)
+	return repoTUFClient, nil
+}
+
+// Refresh refreshes all repositories in parallel with new clients, as a
+// TUF client is refreshed once. The new clients start from the root the
+// previous ones trusted, so accepted root rotations are kept, and the
+// first refresh uses the clients created by New. If any of them fails it returns a *Report
+// with the error of each failed repository. Failed repositories are left
+// out of target lookups until a later refresh succeeds, so they only block
+// the mappings which need them to reach their threshold. If the
+// configuration has a map repository the map file is fetched first and,
+// if it changed, the repositories of the new map file are used
+func (client *MultiRepoClient) Refresh() error {
+	client.mu.Lock()
+	repoMap, mapTarget := client.Config.RepoMap, client.Config.mapTarget
+	prev, idle := client.TUFClients, client.idle
+	client.mu.Unlock()
+	prevMap := repoMap
+	if client.Config.MapRepository != nil {
+		var err error
+		repoMap, mapTarget, err = client.fetchMap()
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to update the map file: %w", err)
 		}
-		cfg.LocalMetadataDir = metadataDir
-		cfg.LocalTargetsDir = targetsDir
-		cfg.DisableLocalCache = client.Config.DisableLocalCache // propagate global cache policy
-
-		// create a new Updater instance for each repository
-		repoTUFClient, err := updater.New(cfg)
+	}
+	tufClients := map[string]*updater.Updater{}
+	failed := map[string]error{}
+	for repoName, repoURL := range repoMap.Repositories {
+		if tufClient := prev[repoName]; idle[repoName] && tufClient.GetTrustedMetadataSet().Timestamp == nil &&
+			slices.Equal(prevMap.Repositories[repoName], repoURL) {
+			// the client created by New was not refreshed, not even by a lookup
+			tufClients[repoName] = tufClient
+			continue
+		}
+		tufClient, err := client.newTUFClient(repoName, repoURL, prev[repoName])
 		if err != nil {
-			return fmt.Errorf("failed to create Updater instance: %w", err)
+			failed[repoName] = err
+			continue
 		}
-
-		// save the client
-		client.TUFClients[repoName] = repoTUFClient
-		log.Debugf("Successfully initialized %s - %s", repoName, repoURL)
+		tufClients[repoName] = tufClient
+	}
+	errs := forEachRepository(tufClients, repositoryNames(tufClients), func(name string, tufClient *updater.Updater) error {
+		log.Infof("Refreshing %s", name)
+		return tufClient.Refresh()
+	})
+	for repoName, err := range errs {
+		failed[repoName] = err
+	}
+	// switch to the map file and the refreshed clients at once so lookups
+	// see either the previous or the new ones
+	client.mu.Lock()
+	client.Config.RepoMap = repoMap
+	client.Config.mapTarget = mapTarget
+	client.TUFClients = tufClients
+	client.failed = failed
+	client.idle = nil
+	client.mu.Unlock()
+	if len(failed) > 0 {
+		return &Report{Errors: failed}
 	}
 	return nil
 }
 
-// Refresh refreshes all repository clients
-func (client *MultiRepoClient) Refresh() error {
-	// loop through each initialized TUF client and refresh it
-	for name, repoTUFClient := range client.TUFClients {
-		log.Infof("Refreshing %s", name)
-		err := repoTUFClient.Refresh()
+// newUpdater creates a TUF client with cfg which trusts the newest of the
+// root of cfg, the root persisted in the local cache of cfg and the root
+// trusted by prev, if any. Otherwise updater.New would persist the root of
+// cfg over a newer one and the accepted root rotations would be lost
+func newUpdater(cfg *config.UpdaterConfig, prev *updater.Updater) (*updater.Updater, error) {
+	trusted, err := trustedmetadata.New(cfg.LocalTrustedRoot, cfg.VerifyOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	rootBytes, version := cfg.LocalTrustedRoot, trusted.Root.Signed.Version
+	var candidates [][]byte
+	if !cfg.DisableLocalCache {
+		local := cfg.Storage
+		if local == nil {
+			local = &storage.FileSystem{MetadataDir: cfg.LocalMetadataDir}
+		}
+		if data, err := local.ReadMetadata(metadata.ROOT); err == nil {
+			candidates = append(candidates, data)
+		}
+	}
+	if prev != nil {
+		if data, err := prev.GetTrustedMetadataSet().Root.ToBytes(false); err == nil {
+			candidates = append(candidates, data)
+		}
+	}
+	for _, data := range candidates {
+		trusted, err := trustedmetadata.New(data, cfg.VerifyOptions()...)
 		if err != nil {
-			return err
+			log.Debugf("Ignoring the local trusted root metadata: %v", err)
+			continue
+		}
+		if trusted.Root.Signed.Version > version {
+			rootBytes, version = data, trusted.Root.Signed.Version
 		}
 	}
-	return nil
+	seeded := *cfg
+	seeded.LocalTrustedRoot = rootBytes
+	return updater.New(&seeded)
 }
 
 // GetTopLevelTargets returns the top-level target files for all repositories
@@ -172,12 +398,16 @@ func (client *MultiRepoClient) GetTopLevelTargets() (map[string]*metadata.Target
 	// collection of all target files for all clients
 	result := map[string]*metadata.TargetFiles{}
 
-	// loop through each repository
-	for _, tufClient := range client.TUFClients {
+	// loop through each repository which didn't fail to refresh
+	s := client.current()
+	for name, tufClient := range s.tufClients {
+		if s.failed[name] != nil {
+			continue
+		}
 		// loop through the top level targets for each repository
 		for targetName := range tufClient.GetTopLevelTargets() {
 			// see if this target should be kept, this goes through the TAP4 search algorithm
-			targetInfo, _, err := client.GetTargetInfo(targetName)
+			targetInfo, _, err := s.getTargetInfo(targetName)
 			if err != nil {
 				// we skip saving this target since there's no way/policy do download it with this map.json file
 				// possible causes like not enough repositories for that threshold, target info mismatch, etc.
@@ -204,11 +434,22 @@ func (client *MultiRepoClient) GetTopLevelTargets() (map[string]*metadata.Target
 
 // GetTargetInfo returns metadata.TargetFiles instance with information
 // for targetPath and a list of repositories that serve the matching target.
-// It implements the TAP 4 search algorithm.
+// It implements the TAP 4 search algorithm. The repositories of a mapping
+// are queried in parallel, each at most once per call. If no target info
+// is found the error wraps a *Report with the errors of the repositories
+// which failed to refresh or to look up targetPath
 func (client *MultiRepoClient) GetTargetInfo(targetPath string) (*metadata.TargetFiles, []string, error) {
+	return client.current().getTargetInfo(targetPath)
+}
+
+// getTargetInfo implements GetTargetInfo on the state s
+func (s state) getTargetInfo(targetPath string) (*metadata.TargetFiles, []string, error) {
+	// results of the repositories queried so far
+	lookups := map[string]*metadata.TargetFiles{}
+	report := &Report{Errors: map[string]error{}}
 	terminated := false
 	// loop through each mapping
-	for _, eachMap := range client.Config.RepoMap.Mapping {
+	for _, eachMap := range s.repoMap.Mapping {
 		// loop through each path for this mapping
 		for _, pathPattern := range eachMap.Paths {
 			// check if the targetPath matches each path mapping
@@ -216,78 +457,109 @@ func (client *MultiRepoClient) GetTargetInfo(targetPath string) (*metadata.Targe
 			if err != nil {
 				// error looking for a match
 				return nil, nil, err
-			} else {
-				if patternMatched {
-					// if there's a pattern match, loop through all of the repositories listed for that mapping
-					// and see if we can find a consensus among them to cover the threshold for that mapping
-					var matchedTargetGroups []targetMatch
-					for _, repoName := range eachMap.Repositories {
-						// get target info from that repository
-						newTargetInfo, err := client.TUFClients[repoName].GetTargetInfo(targetPath)
-						if err != nil {
-							// failed to get target info for the given target
-							// there's probably no such target
-							// skip the rest and proceed trying to get target info from the next repository
-							continue
-						}
-						found := false
-						// loop through all target infos we found so far
-						for i, target := range matchedTargetGroups {
-							// see if we already have found one like that
-							if target.targetInfo.Equal(*newTargetInfo) {
-								found = true
-								// if so, update its repository list
-								if slices.Contains(target.repositories, repoName) {
-									// we have a duplicate repository listed in the mapping
-									// decide if we should error out here
-									// nevertheless we won't take it into account when we calculate the threshold
-								} else {
-									// a new repository vouched for this target
-									matchedTargetGroups[i].repositories = append(target.repositories, repoName)
-								}
-							}
-						}
-						// this target as not part of the list so far, so we should add it
-						if !found {
-							matchedTargetGroups = append(matchedTargetGroups, targetMatch{
-								targetInfo:   newTargetInfo,
-								repositories: []string{repoName},
-							})
-						}
-						// proceed with searching for this target in the next repository
-					}
-					// we went through all repositories listed in that mapping
-					// lets see if we have matched the threshold consensus for the given target file
-					var result *targetMatch
-					for _, target := range matchedTargetGroups {
-						// compare thresholds for each target info we found with the value stated for its mapping
-						if len(target.repositories) >= eachMap.Threshold {
-							// this target has enough repositories signed for it
-							if result != nil {
-								// it seems there's more than one target info matching the threshold for this mapping
-								// it is a conflict since it's impossible to establish a consensus which of the found targets
-								// we should actually trust, so we error out
-								return nil, nil, fmt.Errorf("more than one target info matching the necessary threshold value")
-							} else {
-								// this is the first target we found matching the necessary threshold so save it
-								result = &target
-							}
-						}
+			}
+			if !patternMatched {
+				// no match, continue looking at the next path pattern from this mapping
+				continue
+			}
+			// if there's a pattern match, query the repositories listed for that mapping
+			// which weren't queried yet and see if we can find a consensus among them
+			// to cover the threshold for that mapping
+			query := []string{}
+			for i, repoName := range eachMap.Repositories {
+				// a repository counts once towards the threshold and has to be known,
+				// the map may have been changed since it was validated
+				if slices.Contains(eachMap.Repositories[:i], repoName) {
+					return nil, nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid map file: mapping lists repository %s more than once", repoName)}
+				}
+				if _, ok := s.repoMap.Repositories[repoName]; !ok {
+					return nil, nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid map file: mapping references unknown repository %s", repoName)}
+				}
+				if _, queried := lookups[repoName]; queried {
+					continue
+				}
+				if _, failed := report.Errors[repoName]; failed {
+					continue
+				}
+				// repositories which failed to refresh can't vouch for any target
+				if err := s.failed[repoName]; err != nil {
+					report.Errors[repoName] = err
+					continue
+				}
+				query = append(query, repoName)
+			}
+			var mu sync.Mutex
+			errs := forEachRepository(s.tufClients, query, func(repoName string, tufClient *updater.Updater) error {
+				// get target info from that repository
+				targetInfo, err := tufClient.GetTargetInfo(targetPath)
+				if err != nil {
+					// failed to get target info for the given target, there's probably no such target
+					return err
+				}
+				mu.Lock()
+				lookups[repoName] = targetInfo
+				mu.Unlock()
+				return nil
+			})
+			for repoName, err := range errs {
+				report.Errors[repoName] = err
+			}
+			// group the repositories by the target info they vouch for, in the order
+			// they are listed in the mapping
+			var matchedTargetGroups []targetMatch
+			for _, repoName := range eachMap.Repositories {
+				newTargetInfo, ok := lookups[repoName]
+				if !ok {
+					// this repository doesn't serve the target
+					continue
+				}
+				found := false
+				// loop through all target infos we found so far
+				for i, target := range matchedTargetGroups {
+					// see if we already have found one like that
+					if target.targetInfo.Equal(*newTargetInfo) {
+						found = true
+						// if so, a new repository vouched for this target
+						matchedTargetGroups[i].repositories = append(target.repositories, repoName)
 					}
-					// search finished, see if we have found a matching target
+				}
+				// this target as not part of the list so far, so we should add it
+				if !found {
+					matchedTargetGroups = append(matchedTargetGroups, targetMatch{
+						targetInfo:   newTargetInfo,
+						repositories: []string{repoName},
+					})
+				}
+			}
+			// we went through all repositories listed in that mapping
+			// lets see if we have matched the threshold consensus for the given target file
+			var result *targetMatch
+			for i, target := range matchedTargetGroups {
+				// compare thresholds for each target info we found with the value stated for its mapping
+				if len(target.repositories) >= eachMap.Threshold {
+					// this target has enough repositories signed for it
 					if result != nil {
-						return result.targetInfo, result.repositories, nil
-					}
-					// if we are here, we haven't found enough target infos to match the threshold number
-					// for this mapping
-					if eachMap.Terminating {
-						// stop the search if this was a terminating map
-						terminated = eachMap.Terminating
-						break
+						// it seems there's more than one target info matching the threshold for this mapping
+						// it is a conflict since it's impossible to establish a consensus which of the found targets
+						// we should actually trust, so we error out
+						return nil, nil, fmt.Errorf("more than one target info matching the necessary threshold value")
 					}
+					// this is the first target we found matching the necessary threshold so save it
+					result = &matchedTargetGroups[i]
 				}
 			}
-			// no match, continue looking at the next path pattern from this mapping
+			// search finished, see if we have found a matching target
+			if result != nil {
+				return result.targetInfo, result.repositories, nil
+			}
+			// if we are here, we haven't found enough target infos to match the threshold number
+			// for this mapping
+			if eachMap.Terminating {
+				// stop the search if this was a terminating map
+				terminated = true
+			}
+			// the other path patterns of this mapping would query the same repositories
+			break
 		}
 		// stop the search if this was a terminating map, otherwise continue with the next mapping
 		if terminated {
@@ -295,14 +567,23 @@ func (client *MultiRepoClient) GetTargetInfo(targetPath string) (*metadata.Targe
 		}
 	}
 	// looped through all mappings and there was nothing, not even a terminating one
+	if len(report.Errors) > 0 {
+		return nil, nil, fmt.Errorf("target info not found: %w", report)
+	}
 	return nil, nil, fmt.Errorf("target info not found")
 }
 
 // DownloadTarget downloads the target file specified by targetFile
 func (client *MultiRepoClient) DownloadTarget(repos []string, targetFile *metadata.TargetFiles, filePath, targetBaseURL string) (string, []byte, error) {
+	tufClients := client.current().tufClients
 	for _, repoName := range repos {
+		tufClient, ok := tufClients[repoName]
+		if !ok {
+			// the repository isn't in the map file anymore
+			continue
+		}
 		// see if the target is already present locally
-		targetPath, targetBytes, err := client.TUFClients[repoName].FindCachedTarget(targetFile, filePath)
+		targetPath, targetBytes, err := tufClient.FindCachedTarget(targetFile, filePath)
 		if err != nil {
 			return "", nil, err
 		}
@@ -312,7 +593,7 @@ func (client *MultiRepoClient) DownloadTarget(repos []string, targetFile *metada
 			return targetPath, targetBytes, nil
 		}
 		// not present locally, so let's try to download it
-		targetPath, targetBytes, err = client.TUFClients[repoName].DownloadTarget(targetFile, filePath, targetBaseURL)
+		targetPath, targetBytes, err = tufClient.DownloadTarget(targetFile, filePath, targetBaseURL)
 		if err != nil {
 			// TODO: decide if we should error if one repository serves the expected target info, but we fail to download the actual target
 			// try downloading the target from the next available repository
@@ -326,6 +607,40 @@ func (client *MultiRepoClient) DownloadTarget(repos []string, targetFile *metada
 	return "", nil, fmt.Errorf("failed to download target file %s", targetFile.Path)
 }
 
+// CollectGarbage removes all target files from the shared target cache
+// which are not referenced by the targets metadata of any of the
+// repositories. The targets metadata of all delegated roles is loaded
+// first and nothing is removed unless all repositories were refreshed and
+// walked successfully. Returns the number of removed target files
+func (client *MultiRepoClient) CollectGarbage() (int, error) {
+	if client.Config.TargetCache == nil {
+		return 0, nil
+	}
+	s := client.current()
+	if s.failed == nil {
+		return 0, fmt.Errorf("failed to collect garbage: the repositories were not refreshed")
+	}
+	if len(s.failed) > 0 {
+		return 0, fmt.Errorf("failed to collect garbage: %w", &Report{Errors: s.failed})
+	}
+	errs := forEachRepository(s.tufClients, repositoryNames(s.tufClients), func(name string, tufClient *updater.Updater) error {
+		return tufClient.LoadAllTargets()
+	})
+	if len(errs) > 0 {
+		return 0, fmt.Errorf("failed to collect garbage: %w", &Report{Errors: errs})
+	}
+	referenced := map[string]bool{}
+	for _, repoTUFClient := range s.tufClients {
+		trusted := repoTUFClient.GetTrustedMetadataSet()
+		for _, targets := range trusted.Targets {
+			for key := range cache.References(targets) {
+				referenced[key] = true
+			}
+		}
+	}
+	return client.Config.TargetCache.GC(referenced)
+}
+
 func (cfg *MultiRepoConfig) EnsurePathsExist() error {
 	if cfg.DisableLocalCache {
 		return nil
@@ -338,3 +653,70 @@ func (cfg *MultiRepoConfig) EnsurePathsExist() error {
 	}
 	return nil
 }
+
+// Report collects the errors of the repositories which failed during an
+// operation of a MultiRepoClient
+type Report struct {
+	// Errors are the errors by repository name
+	Errors map[string]error
+}
+
+func (r *Report) Error() string {
+	msgs := []string{}
+	for _, name := range r.Failed() {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, r.Errors[name]))
+	}
+	return fmt.Sprintf("%d repositories failed: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Failed returns the names of the failed repositories in order
+func (r *Report) Failed() []string {
+	res := make([]string, 0, len(r.Errors))
+	for name := range r.Errors {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// current returns the map file and the repository clients in use
+func (client *MultiRepoClient) current() state {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return state{
+		repoMap:    client.Config.RepoMap,
+		tufClients: client.TUFClients,
+		failed:     client.failed,
+	}
+}
+
+// repositoryNames returns the names of the repositories of tufClients
+func repositoryNames(tufClients map[string]*updater.Updater) []string {
+	res := make([]string, 0, len(tufClients))
+	for name := range tufClients {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// forEachRepository calls fn for the client in tufClients of each repository
+// in names in parallel and returns the errors by repository name
+func forEachRepository(tufClients map[string]*updater.Updater, names []string, fn func(name string, tufClient *updater.Updater) error) map[string]error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := map[string]error{}
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string, tufClient *updater.Updater) {
+			defer wg.Done()
+			if err := fn(name, tufClient); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name, tufClients[name])
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,604 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package multirepo
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/cache"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+// validMap is a map file with two repositories
+const validMap = `{
+  "repositories": {
+    "a": ["https://a.example.com"],
+    "b": ["https://b.example.com", "https://mirror.b.example.com"]
+  },
+  "mapping": [
+    {"paths": ["*.pub"], "repositories": ["a", "b"], "threshold": 2, "terminating": true},
+    {"paths": ["*"], "repositories": ["a"], "threshold": 1}
+  ]
+}`
+
+func TestParseMapFile(t *testing.T) {
+	mapFile, err := ParseMapFile([]byte(validMap))
+	assert.NoError(t, err)
+	assert.Len(t, mapFile.Repositories, 2)
+	assert.Len(t, mapFile.Mapping, 2)
+	assert.True(t, mapFile.Mapping[0].Terminating)
+	assert.False(t, mapFile.Mapping[1].Terminating)
+
+	// the map file of the example
+	data, err := os.ReadFile("../../examples/multirepo/repository/targets/map.json")
+	assert.NoError(t, err)
+	_, err = ParseMapFile(data)
+	assert.NoError(t, err)
+}
+
+func TestParseMapFileInvalid(t *testing.T) {
+	tests := map[string]struct {
+		mapFile string
+		err     string
+	}{
+		"not json":         {`{"repositories":`, "invalid map file: unexpected EOF"},
+		"unknown field":    {strings.Replace(validMap, `"mapping"`, `"extra": 1, "mapping"`, 1), `unknown field "extra"`},
+		"unknown mapping":  {strings.Replace(validMap, `"threshold": 1}`, `"threshold": 1, "priority": 1}`, 1), `unknown field "priority"`},
+		"trailing data":    {validMap + "{}", "invalid map file: unexpected data after the map"},
+		"no repositories":  {`{"repositories": {}, "mapping": []}`, "invalid map file: no repositories"},
+		"empty name":       {strings.Replace(validMap, `"a": [`, `"": [`, 1), "repository with an empty name"},
+		"no url":           {strings.Replace(validMap, `["https://a.example.com"]`, `[]`, 1), "repository a has no URL"},
+		"invalid url":      {strings.Replace(validMap, `"https://a.example.com"`, `"a.example.com"`, 1), `repository a has an invalid URL "a.example.com"`},
+		"no mappings":      {`{"repositories": {"a": ["https://a.example.com"]}, "mapping": []}`, "invalid map file: no mappings"},
+		"null mapping":     {strings.Replace(validMap, `{"paths": ["*"], "repositories": ["a"], "threshold": 1}`, `null`, 1), "mapping 1 is empty"},
+		"no paths":         {strings.Replace(validMap, `["*.pub"]`, `[]`, 1), "mapping 0 has no paths"},
+		"invalid pattern":  {strings.Replace(validMap, `["*.pub"]`, `["[a-"]`, 1), `mapping 0 has an invalid path pattern "[a-"`},
+		"no repos":         {strings.Replace(validMap, `"repositories": ["a"], "threshold": 1`, `"repositories": [], "threshold": 1`, 1), "mapping 1 has no repositories"},
+		"unknown repo":     {strings.Replace(validMap, `["a", "b"]`, `["a", "c"]`, 1), "mapping 0 references unknown repository c"},
+		"duplicate repo":   {strings.Replace(validMap, `["a", "b"]`, `["a", "a"]`, 1), "mapping 0 lists repository a more than once"},
+		"zero threshold":   {strings.Replace(validMap, `"threshold": 1}`, `"threshold": 0}`, 1), "mapping 1 has threshold 0, expected between 1 and 1"},
+		"no threshold":     {strings.Replace(validMap, `, "threshold": 1}`, `}`, 1), "mapping 1 has threshold 0, expected between 1 and 1"},
+		"high threshold":   {strings.Replace(validMap, `"threshold": 2`, `"threshold": 3`, 1), "mapping 0 has threshold 3, expected between 1 and 2"},
+		"wrong field type": {strings.Replace(validMap, `"threshold": 2`, `"threshold": "2"`, 1), "invalid map file: json: cannot unmarshal string"},
+	}
+	for name, test := range tests {
+		_, err := ParseMapFile([]byte(test.mapFile))
+		assert.ErrorContains(t, err, test.err, name)
+		var valueErr metadata.ErrValue
+		assert.ErrorAs(t, err, &valueErr, name)
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	roots := map[string][]byte{"a": []byte("{}"), "b": []byte("{}")}
+	cfg, err := NewConfig([]byte(validMap), roots)
+	assert.NoError(t, err)
+	assert.Len(t, cfg.RepoMap.Mapping, 2)
+
+	_, err = NewConfig([]byte(validMap), map[string][]byte{"a": []byte("{}")})
+	assert.ErrorContains(t, err, "no trusted root metadata provided for repository - b")
+	_, err = NewConfig([]byte(strings.Replace(validMap, `"threshold": 2`, `"threshold": 3`, 1)), roots)
+	assert.ErrorContains(t, err, "mapping 0 has threshold 3")
+	_, err = NewConfig(nil, roots)
+	assert.Error(t, err)
+
+	// configurations made without NewConfig are validated by New
+	_, err = New(&MultiRepoConfig{RepoMap: &MultiRepoMapType{}, TrustedRoots: roots})
+	assert.ErrorContains(t, err, "invalid map file: no repositories")
+	_, err = New(&MultiRepoConfig{TrustedRoots: roots})
+	assert.ErrorContains(t, err, "invalid map file: no map file")
+}
+
+func TestGetTargetInfoUnknownRepository(t *testing.T) {
+	mapFile, err := ParseMapFile([]byte(validMap))
+	assert.NoError(t, err)
+	// the map is changed after it was validated
+	mapFile.Mapping[1].Repositories = []string{"c"}
+	client := &MultiRepoClient{Config: &MultiRepoConfig{RepoMap: mapFile}}
+	_, _, err = client.GetTargetInfo("file.txt")
+	assert.ErrorContains(t, err, "mapping references unknown repository c")
+}
+
+// testServer serves a repository and fails all requests while it is broken
+type testServer struct {
+	*httptest.Server
+	repo   *repository.Repository
+	dir    string
+	root   []byte
+	broken atomic.Bool
+}
+
+// helperServer publishes a repository with the target files in files and
+// serves it
+func helperServer(t *testing.T, files map[string]string) *testServer {
+	repo := helperRepository(t)
+	for targetPath, content := range files {
+		_, err := repo.AddTarget(metadata.TARGETS, targetPath, []byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	return helperServe(t, repo)
+}
+
+// helperRepository returns a repository with one ed25519 signer per
+// top-level role and signed root
+func helperRepository(t *testing.T) *repository.Repository {
+	expires := time.Now().AddDate(0, 0, 7).UTC()
+	repo := repository.New()
+	repo.SetRoot(metadata.Root(expires))
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(expires))
+	repo.SetSnapshot(metadata.Snapshot(expires))
+	repo.SetTimestamp(metadata.Timestamp(expires))
+	for _, name := range []string{metadata.ROOT, metadata.TARGETS, metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		key, signer := helperNewKey(t)
+		assert.NoError(t, repo.Root().Signed.AddKey(key, name))
+		repo.AddSigner(name, signer)
+	}
+	assert.NoError(t, repo.Sign(metadata.ROOT))
+	return repo
+}
+
+// helperNewKey returns a new ed25519 key and its signer
+func helperNewKey(t *testing.T) (*metadata.Key, signature.Signer) {
+	_, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	key, err := metadata.KeyFromPublicKey(private.Public())
+	assert.NoError(t, err)
+	signer, err := signature.LoadSigner(private, crypto.Hash(0))
+	assert.NoError(t, err)
+	return key, signer
+}
+
+// helperServe writes the published repository and serves it
+func helperServe(t *testing.T, repo *repository.Repository) *testServer {
+	dir := t.TempDir()
+	assert.NoError(t, repo.Write(dir))
+	root, err := os.ReadFile(filepath.Join(dir, repository.MetadataDir, "1.root.json"))
+	assert.NoError(t, err)
+	srv := &testServer{repo: repo, dir: dir, root: root}
+	fileServer := http.FileServer(http.Dir(dir))
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if srv.broken.Load() {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// helperMapFile returns a map file of the servers with the mappings and
+// their trusted roots
+func helperMapFile(t *testing.T, servers map[string]*testServer, mappings ...*Mapping) ([]byte, map[string][]byte) {
+	mapFile := &MultiRepoMapType{Repositories: map[string][]string{}, Mapping: mappings}
+	roots := map[string][]byte{}
+	for name, srv := range servers {
+		mapFile.Repositories[name] = []string{srv.URL + "/" + repository.MetadataDir}
+		roots[name] = srv.root
+	}
+	data, err := json.Marshal(mapFile)
+	assert.NoError(t, err)
+	return data, roots
+}
+
+// helperClient returns a client of the servers with the mappings
+func helperClient(t *testing.T, servers map[string]*testServer, mappings ...*Mapping) *MultiRepoClient {
+	data, roots := helperMapFile(t, servers, mappings...)
+	cfg, err := NewConfig(data, roots)
+	assert.NoError(t, err)
+	cfg.DisableLocalCache = true
+	cfg.LocalMetadataDir = t.TempDir()
+	client, err := New(cfg)
+	assert.NoError(t, err)
+	return client
+}
+
+func TestRefreshReport(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"key.pub": "key", "file.txt": "file"}),
+		"b": helperServer(t, map[string]string{"key.pub": "key", "file.txt": "file"}),
+		"c": helperServer(t, map[string]string{"key.pub": "key", "file.txt": "file"}),
+	}
+	client := helperClient(t, servers,
+		&Mapping{Paths: []string{"*.pub"}, Repositories: []string{"a", "b"}, Threshold: 2, Terminating: true},
+		&Mapping{Paths: []string{"*.txt"}, Repositories: []string{"a", "c"}, Threshold: 2, Terminating: true},
+	)
+	servers["c"].broken.Store(true)
+	err := client.Refresh()
+	var report *Report
+	assert.True(t, errors.As(err, &report))
+	assert.Equal(t, []string{"c"}, report.Failed())
+	assert.ErrorContains(t, err, "1 repositories failed: c: ")
+
+	// the broken repository only blocks the mappings which need it
+	targetInfo, repos, err := client.GetTargetInfo("key.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, "key.pub", targetInfo.Path)
+	assert.Equal(t, []string{"a", "b"}, repos)
+	_, _, err = client.GetTargetInfo("file.txt")
+	assert.ErrorContains(t, err, "target info not found")
+	report = nil
+	assert.True(t, errors.As(err, &report))
+	assert.Equal(t, []string{"c"}, report.Failed())
+	topLevel, err := client.GetTopLevelTargets()
+	assert.Error(t, err)
+	assert.Nil(t, topLevel)
+
+	// the repository is used again once it refreshes
+	servers["c"].broken.Store(false)
+	assert.NoError(t, client.Refresh())
+	_, repos, err = client.GetTargetInfo("file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, repos)
+	topLevel, err = client.GetTopLevelTargets()
+	assert.NoError(t, err)
+	assert.Len(t, topLevel, 2)
+}
+
+func TestRefreshNewTargets(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"key.pub": "key"}),
+	}
+	client := helperClient(t, servers,
+		&Mapping{Paths: []string{"*"}, Repositories: []string{"a"}, Threshold: 1},
+	)
+	assert.NoError(t, client.Refresh())
+	_, _, err := client.GetTargetInfo("new.pub")
+	assert.ErrorContains(t, err, "target info not found")
+
+	// each refresh sees what was published since the previous one
+	srv := servers["a"]
+	_, err = srv.repo.AddTarget(metadata.TARGETS, "new.pub", []byte("new"))
+	assert.NoError(t, err)
+	assert.NoError(t, srv.repo.Publish(metadata.TARGETS))
+	assert.NoError(t, srv.repo.Write(srv.dir))
+	assert.NoError(t, client.Refresh())
+	targetInfo, repos, err := client.GetTargetInfo("new.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, "new.pub", targetInfo.Path)
+	assert.Equal(t, []string{"a"}, repos)
+	assert.NoError(t, client.Refresh())
+	_, _, err = client.GetTargetInfo("new.pub")
+	assert.NoError(t, err)
+}
+
+// helperRotateTimestampKey replaces the timestamp key of the server in a
+// new root version and returns the signer of the new key
+func helperRotateTimestampKey(t *testing.T, srv *testServer) signature.Signer {
+	key, signer := helperNewKey(t)
+	root := srv.repo.Root()
+	assert.NoError(t, root.Signed.RevokeKey(root.Signed.Roles[metadata.TIMESTAMP].KeyIDs[0], metadata.TIMESTAMP))
+	assert.NoError(t, root.Signed.AddKey(key, metadata.TIMESTAMP))
+	root.Signed.Version++
+	assert.NoError(t, srv.repo.Sign(metadata.ROOT))
+	return signer
+}
+
+// helperPublish publishes a new target file on the server with the
+// timestamp signed by signer
+func helperPublish(t *testing.T, srv *testServer, targetPath string, signer signature.Signer) {
+	_, err := srv.repo.AddTarget(metadata.TARGETS, targetPath, []byte(targetPath))
+	assert.NoError(t, err)
+	assert.NoError(t, srv.repo.Publish(metadata.TARGETS))
+	timestamp := srv.repo.Timestamp()
+	timestamp.ClearSignatures()
+	_, err = timestamp.Sign(signer)
+	assert.NoError(t, err)
+	assert.NoError(t, srv.repo.Write(srv.dir))
+}
+
+func TestRefreshRootRotation(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"key.pub": "key"}),
+	}
+	client := helperClient(t, servers,
+		&Mapping{Paths: []string{"*"}, Repositories: []string{"a"}, Threshold: 1},
+	)
+	// the first refresh uses the client created by New
+	tufClient := client.TUFClients["a"]
+	assert.NoError(t, client.Refresh())
+	assert.Same(t, tufClient, client.TUFClients["a"])
+
+	srv := servers["a"]
+	signer := helperRotateTimestampKey(t, srv)
+	helperPublish(t, srv, "1.pub", signer)
+	assert.NoError(t, client.Refresh())
+	assert.Equal(t, int64(2), client.TUFClients["a"].GetTrustedMetadataSet().Root.Signed.Version)
+
+	// the next refresh trusts the rotated root, not the bootstrap one
+	helperPublish(t, srv, "2.pub", signer)
+	assert.NoError(t, os.Remove(filepath.Join(srv.dir, repository.MetadataDir, "2.root.json")))
+	assert.NoError(t, client.Refresh())
+	_, _, err := client.GetTargetInfo("2.pub")
+	assert.NoError(t, err)
+
+	// so does a new client with the root in the local cache
+	data, roots := helperMapFile(t, servers,
+		&Mapping{Paths: []string{"*"}, Repositories: []string{"a"}, Threshold: 1},
+	)
+	cfg, err := NewConfig(data, roots)
+	assert.NoError(t, err)
+	cfg.LocalMetadataDir = t.TempDir()
+	cfg.LocalTargetsDir = t.TempDir()
+	client, err = New(cfg)
+	assert.NoError(t, err)
+	helperPublish(t, srv, "3.pub", signer)
+	assert.NoError(t, client.Refresh())
+	assert.NoError(t, os.Remove(filepath.Join(srv.dir, repository.MetadataDir, "2.root.json")))
+	client, err = New(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), client.TUFClients["a"].GetTrustedMetadataSet().Root.Signed.Version)
+	assert.NoError(t, client.Refresh())
+	_, _, err = client.GetTargetInfo("3.pub")
+	assert.NoError(t, err)
+}
+
+func TestRefreshMirrors(t *testing.T) {
+	srv := helperServer(t, map[string]string{"key.pub": "key"})
+	broken := helperServer(t, nil)
+	broken.broken.Store(true)
+	// the repository is downloaded from the second URL if the first one fails
+	mapFile := &MultiRepoMapType{
+		Repositories: map[string][]string{"a": {broken.URL + "/" + repository.MetadataDir, srv.URL + "/" + repository.MetadataDir}},
+		Mapping:      []*Mapping{{Paths: []string{"*"}, Repositories: []string{"a"}, Threshold: 1}},
+	}
+	data, err := json.Marshal(mapFile)
+	assert.NoError(t, err)
+	cfg, err := NewConfig(data, map[string][]byte{"a": srv.root})
+	assert.NoError(t, err)
+	cfg.DisableLocalCache = true
+	client, err := New(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Refresh())
+	_, repos, err := client.GetTargetInfo("key.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, repos)
+}
+
+func TestRefreshConcurrentLookups(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"key.pub": "key"}),
+		"b": helperServer(t, map[string]string{"key.pub": "key"}),
+	}
+	client := helperClient(t, servers,
+		&Mapping{Paths: []string{"*"}, Repositories: []string{"a", "b"}, Threshold: 2},
+	)
+	assert.NoError(t, client.Refresh())
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 5; i++ {
+			if err := client.Refresh(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 5; i++ {
+		_, repos, err := client.GetTargetInfo("key.pub")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, repos)
+		_, err = client.GetTopLevelTargets()
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, <-done)
+}
+
+func TestGetTargetInfoThreshold(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"file.txt": "file", "other.txt": "a"}),
+		"b": helperServer(t, map[string]string{"file.txt": "file", "other.txt": "b"}),
+		"c": helperServer(t, map[string]string{"file.txt": "changed"}),
+	}
+	client := helperClient(t, servers,
+		&Mapping{Paths: []string{"file.txt"}, Repositories: []string{"a", "b", "c"}, Threshold: 2, Terminating: true},
+		&Mapping{Paths: []string{"other.txt"}, Repositories: []string{"a", "b"}, Threshold: 1, Terminating: true},
+		&Mapping{Paths: []string{"missing.txt"}, Repositories: []string{"a"}, Threshold: 1},
+		&Mapping{Paths: []string{"*"}, Repositories: []string{"c"}, Threshold: 1},
+	)
+	assert.NoError(t, client.Refresh())
+
+	// a and b agree, c serves another file
+	targetInfo, repos, err := client.GetTargetInfo("file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, repos)
+	assert.Equal(t, int64(len("file")), targetInfo.Length)
+
+	// both target infos meet the threshold
+	_, _, err = client.GetTargetInfo("other.txt")
+	assert.ErrorContains(t, err, "more than one target info matching the necessary threshold value")
+
+	// the lookup errors of the repositories are reported
+	_, _, err = client.GetTargetInfo("missing.txt")
+	var report *Report
+	assert.True(t, errors.As(err, &report))
+	assert.Equal(t, []string{"a", "c"}, report.Failed())
+}
+
+func TestMapRepository(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"key.pub": "a"}),
+		"b": helperServer(t, map[string]string{"key.pub": "b"}),
+	}
+	mapData, roots := helperMapFile(t, servers,
+		&Mapping{Paths: []string{"*.pub"}, Repositories: []string{"a"}, Threshold: 1, Terminating: true},
+	)
+	mapServer := helperServer(t, map[string]string{DefaultMapTargetPath: string(mapData)})
+	mapCfg, err := config.New(mapServer.URL+"/"+repository.MetadataDir, mapServer.root)
+	assert.NoError(t, err)
+	mapCfg.RemoteTargetsURL = mapServer.URL + "/" + repository.TargetsDir
+	mapCfg.DisableLocalCache = true
+
+	cfg, err := NewConfigFromRepository(&MapRepository{Config: mapCfg}, roots)
+	assert.NoError(t, err)
+	cfg.DisableLocalCache = true
+	cfg.LocalMetadataDir = t.TempDir()
+	client, err := New(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Refresh())
+	_, repos, err := client.GetTargetInfo("key.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, repos)
+
+	// a new map file is used on the next refresh
+	mapData, _ = helperMapFile(t, servers,
+		&Mapping{Paths: []string{"*.pub"}, Repositories: []string{"b"}, Threshold: 1, Terminating: true},
+	)
+	_, err = mapServer.repo.AddTarget(metadata.TARGETS, DefaultMapTargetPath, mapData)
+	assert.NoError(t, err)
+	assert.NoError(t, mapServer.repo.Publish(metadata.TARGETS))
+	assert.NoError(t, mapServer.repo.Write(mapServer.dir))
+	done := make(chan struct{})
+	go func() {
+		// lookups during the refresh use either map file
+		defer close(done)
+		_, repos, err := client.GetTargetInfo("key.pub")
+		assert.NoError(t, err)
+		assert.Len(t, repos, 1)
+	}()
+	assert.NoError(t, client.Refresh())
+	<-done
+	_, repos, err = client.GetTargetInfo("key.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, repos)
+
+	// an invalid map file is rejected and the previous one stays in use
+	_, err = mapServer.repo.AddTarget(metadata.TARGETS, DefaultMapTargetPath, []byte(`{"repositories": {}}`))
+	assert.NoError(t, err)
+	assert.NoError(t, mapServer.repo.Publish(metadata.TARGETS))
+	assert.NoError(t, mapServer.repo.Write(mapServer.dir))
+	assert.ErrorContains(t, client.Refresh(), "failed to update the map file: value error: invalid map file: no repositories")
+	_, repos, err = client.GetTargetInfo("key.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, repos)
+
+	// the map file has to be verified by the map repository
+	mapServer.broken.Store(true)
+	_, err = NewConfigFromRepository(&MapRepository{Config: mapCfg}, roots)
+	assert.ErrorContains(t, err, "failed to refresh the map repository")
+}
+
+func TestMapRepositoryRootRotation(t *testing.T) {
+	servers := map[string]*testServer{
+		"a": helperServer(t, map[string]string{"key.pub": "a"}),
+		"b": helperServer(t, map[string]string{"key.pub": "b"}),
+	}
+	mapData, roots := helperMapFile(t, servers,
+		&Mapping{Paths: []string{"*.pub"}, Repositories: []string{"a"}, Threshold: 1, Terminating: true},
+	)
+	mapServer := helperServer(t, map[string]string{DefaultMapTargetPath: string(mapData)})
+	mapCfg, err := config.New(mapServer.URL+"/"+repository.MetadataDir, mapServer.root)
+	assert.NoError(t, err)
+	mapCfg.RemoteTargetsURL = mapServer.URL + "/" + repository.TargetsDir
+	mapCfg.DisableLocalCache = true
+	cfg, err := NewConfigFromRepository(&MapRepository{Config: mapCfg}, roots)
+	assert.NoError(t, err)
+	cfg.DisableLocalCache = true
+	cfg.LocalMetadataDir = t.TempDir()
+	client, err := New(cfg)
+	assert.NoError(t, err)
+
+	// the map repository rotates its timestamp key
+	signer := helperRotateTimestampKey(t, mapServer)
+	helperPublish(t, mapServer, "rotated.txt", signer)
+	assert.NoError(t, client.Refresh())
+
+	// the next fetch trusts the rotated root, not the bootstrap one
+	mapData, _ = helperMapFile(t, servers,
+		&Mapping{Paths: []string{"*.pub"}, Repositories: []string{"b"}, Threshold: 1, Terminating: true},
+	)
+	_, err = mapServer.repo.AddTarget(metadata.TARGETS, DefaultMapTargetPath, mapData)
+	assert.NoError(t, err)
+	helperPublish(t, mapServer, "changed.txt", signer)
+	assert.NoError(t, os.Remove(filepath.Join(mapServer.dir, repository.MetadataDir, "2.root.json")))
+	assert.NoError(t, client.Refresh())
+	_, repos, err := client.GetTargetInfo("key.pub")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, repos)
+}
+
+func TestCollectGarbage(t *testing.T) {
+	// the target files are spread over delegated hash bins
+	repo := helperRepository(t)
+	_, signer := helperNewKey(t)
+	opts := repository.BinsOptions{BitLength: 2, NamePrefix: "bin", Threshold: 1, Expires: time.Now().AddDate(0, 0, 7).UTC()}
+	assert.NoError(t, repo.SetupBins(metadata.TARGETS, opts, signer))
+	for i := 0; i < 8; i++ {
+		_, _, err := repo.AddBinnedTarget(metadata.TARGETS, fmt.Sprintf("%d.txt", i), []byte(fmt.Sprintf("content %d", i)))
+		assert.NoError(t, err)
+	}
+	_, err := repo.PublishBins()
+	assert.NoError(t, err)
+	srv := helperServe(t, repo)
+
+	targetCache, err := cache.New(t.TempDir(), cache.Options{})
+	assert.NoError(t, err)
+	data, roots := helperMapFile(t, map[string]*testServer{"a": srv},
+		&Mapping{Paths: []string{"*"}, Repositories: []string{"a"}, Threshold: 1},
+	)
+	cfg, err := NewConfig(data, roots)
+	assert.NoError(t, err)
+	cfg.LocalMetadataDir = t.TempDir()
+	cfg.LocalTargetsDir = t.TempDir()
+	cfg.TargetCache = targetCache
+	client, err := New(cfg)
+	assert.NoError(t, err)
+	_, err = client.CollectGarbage()
+	assert.ErrorContains(t, err, "the repositories were not refreshed")
+	assert.NoError(t, client.Refresh())
+	for i := 0; i < 8; i++ {
+		targetInfo, repos, err := client.GetTargetInfo(fmt.Sprintf("%d.txt", i))
+		assert.NoError(t, err)
+		_, _, err = client.DownloadTarget(repos, targetInfo, "", srv.URL+"/"+repository.TargetsDir)
+		assert.NoError(t, err)
+	}
+	stale, err := metadata.TargetFile().FromBytes("stale.txt", []byte("stale"))
+	assert.NoError(t, err)
+	assert.NoError(t, targetCache.Put(stale, []byte("stale")))
+
+	// a new client loads the delegated roles before collecting
+	client, err = New(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Refresh())
+	removed, err := client.CollectGarbage()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, 8, targetCache.Stats().Entries)
+
+	// nothing is collected if a repository can't be walked
+	assert.NoError(t, targetCache.Put(stale, []byte("stale")))
+	client, err = New(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Refresh())
+	srv.broken.Store(true)
+	assert.NoError(t, os.RemoveAll(cfg.LocalMetadataDir))
+	_, err = client.CollectGarbage()
+	assert.ErrorContains(t, err, "failed to collect garbage: 1 repositories failed: a: ")
+	assert.Equal(t, 9, targetCache.Stats().Entries)
+}
@@ -0,0 +1,231 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// BinsOptions describes a succinct hash bin delegation (TAP 15)
+type BinsOptions struct {
+	// BitLength sets the number of bins to 2^BitLength
+	BitLength int
+	// NamePrefix is the prefix of the bin role names
+	NamePrefix string
+	// Threshold is the number of signatures required for each bin
+	Threshold int
+	// Expires is the expiration date of the bin metadata
+	Expires time.Time
+}
+
+// SetupBins delegates from delegator to 2^BitLength hash bins which share
+// the keys of signers. The metadata of all bins is created and the signers
+// are added for every bin. Nothing is signed until PublishBins is called
+func (r *Repository) SetupBins(delegator string, opts BinsOptions, signers ...signature.Signer) error {
+	targets, ok := r.targets[delegator]
+	if !ok {
+		return metadata.ErrValue{Msg: fmt.Sprintf("no targets metadata found for %s", delegator)}
+	}
+	if targets.Signed.Delegations != nil {
+		return metadata.ErrValue{Msg: fmt.Sprintf("%s already has delegations", delegator)}
+	}
+	if opts.BitLength < 1 || opts.BitLength > metadata.MaxBitLength {
+		return metadata.ErrValue{Msg: fmt.Sprintf("bit length must be between 1 and %d, got %d", metadata.MaxBitLength, opts.BitLength)}
+	}
+	if opts.NamePrefix == "" {
+		return metadata.ErrValue{Msg: "a name prefix is required for hash bins"}
+	}
+	if opts.Threshold < 1 || opts.Threshold > len(signers) {
+		return metadata.ErrValue{Msg: fmt.Sprintf("threshold must be between 1 and %d, got %d", len(signers), opts.Threshold)}
+	}
+	if opts.Expires.IsZero() {
+		return metadata.ErrValue{Msg: "an expiration date is required for hash bins"}
+	}
+	delegations := &metadata.Delegations{
+		Keys: map[string]*metadata.Key{},
+		SuccinctRoles: &metadata.SuccinctRoles{
+			KeyIDs:     []string{},
+			Threshold:  opts.Threshold,
+			BitLength:  opts.BitLength,
+			NamePrefix: opts.NamePrefix,
+		},
+	}
+	for _, signer := range signers {
+		key, err := metadata.SignerKey(signer)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(delegations.SuccinctRoles.KeyIDs, key.ID()) {
+			delegations.SuccinctRoles.KeyIDs = append(delegations.SuccinctRoles.KeyIDs, key.ID())
+		}
+		delegations.Keys[key.ID()] = key
+	}
+	bins := delegations.SuccinctRoles.GetRoles()
+	for _, name := range bins {
+		if _, ok := r.targets[name]; ok {
+			return metadata.ErrValue{Msg: fmt.Sprintf("targets metadata for %s already exists", name)}
+		}
+	}
+	targets.Signed.Delegations = delegations
+	r.changed[delegator] = true
+	for _, name := range bins {
+		r.targets[name] = metadata.Targets(opts.Expires)
+		r.signers[name] = append([]signature.Signer{}, signers...)
+		r.changed[name] = true
+	}
+	log.Debugf("Delegated from %s to %d hash bins", delegator, len(bins))
+	return nil
+}
+
+// BinForTarget returns the name of the hash bin of delegator which is
+// responsible for targetPath
+func (r *Repository) BinForTarget(delegator, targetPath string) (string, error) {
+	succinctRoles, err := r.succinctRoles(delegator)
+	if err != nil {
+		return "", err
+	}
+	for name := range succinctRoles.GetRolesForTarget(targetPath) {
+		return name, nil
+	}
+	return "", metadata.ErrValue{Msg: fmt.Sprintf("no hash bin found for %s", targetPath)}
+}
+
+// AddBinnedTarget adds a target file to the hash bin of delegator which is
+// responsible for it and returns the name of the bin
+func (r *Repository) AddBinnedTarget(delegator, targetPath string, data []byte, hashAlgorithms ...string) (string, *metadata.TargetFiles, error) {
+	bin, err := r.BinForTarget(delegator, targetPath)
+	if err != nil {
+		return "", nil, err
+	}
+	targetFile, err := r.AddTarget(bin, targetPath, data, hashAlgorithms...)
+	if err != nil {
+		return "", nil, err
+	}
+	r.changed[bin] = true
+	return bin, targetFile, nil
+}
+
+// RemoveBinnedTarget removes a target file from its hash bin of delegator
+// and returns the name of the bin
+func (r *Repository) RemoveBinnedTarget(delegator, targetPath string) (string, error) {
+	bin, err := r.BinForTarget(delegator, targetPath)
+	if err != nil {
+		return "", err
+	}
+	err = r.RemoveTarget(bin, targetPath)
+	if err != nil {
+		return "", err
+	}
+	r.changed[bin] = true
+	return bin, nil
+}
+
+// RebinTargets changes the bit length of the hash bins of delegator and
+// moves every target file to its new bin. New bins take over the expiration
+// date and signers of the previous ones. Bins which are no longer delegated
+// are kept empty, as clients reject a snapshot which drops metadata listed
+// in a snapshot they have seen before
+func (r *Repository) RebinTargets(delegator string, bitLength int) error {
+	succinctRoles, err := r.succinctRoles(delegator)
+	if err != nil {
+		return err
+	}
+	if bitLength < 1 || bitLength > metadata.MaxBitLength {
+		return metadata.ErrValue{Msg: fmt.Sprintf("bit length must be between 1 and %d, got %d", metadata.MaxBitLength, bitLength)}
+	}
+	if bitLength == succinctRoles.BitLength {
+		return nil
+	}
+	oldBins := succinctRoles.GetRoles()
+	template := r.targets[oldBins[0]]
+	signers := r.signers[oldBins[0]]
+	targetFiles := map[string]*metadata.TargetFiles{}
+	targetData := map[string][]byte{}
+	for _, name := range oldBins {
+		bin, ok := r.targets[name]
+		if !ok {
+			return metadata.ErrValue{Msg: fmt.Sprintf("no metadata found for %s delegated by %s", name, delegator)}
+		}
+		for targetPath, targetFile := range bin.Signed.Targets {
+			targetFiles[targetPath] = targetFile
+		}
+		for targetPath, data := range r.targetData[name] {
+			targetData[targetPath] = data
+		}
+		bin.Signed.Targets = map[string]*metadata.TargetFiles{}
+		delete(r.targetData, name)
+		r.changed[name] = true
+	}
+	succinctRoles.BitLength = bitLength
+	r.changed[delegator] = true
+	for _, name := range succinctRoles.GetRoles() {
+		if _, ok := r.targets[name]; !ok {
+			r.targets[name] = metadata.Targets(template.Signed.Expires)
+		}
+		if len(r.signers[name]) == 0 {
+			r.signers[name] = append([]signature.Signer{}, signers...)
+		}
+		r.changed[name] = true
+	}
+	for targetPath, targetFile := range targetFiles {
+		for name := range succinctRoles.GetRolesForTarget(targetPath) {
+			r.targets[name].Signed.Targets[targetPath] = targetFile
+			if data, ok := targetData[targetPath]; ok {
+				r.SetTargetData(name, targetPath, data)
+			}
+		}
+	}
+	log.Debugf("Moved %d target files of %s to %d hash bins", len(targetFiles), delegator, len(succinctRoles.GetRoles()))
+	return nil
+}
+
+// PublishBins signs the hash bins and delegating roles changed since the
+// last call, bumping the versions of the ones published before, and then
+// updates and signs snapshot and timestamp as Publish does. Returns the
+// names of the signed targets roles
+func (r *Repository) PublishBins() ([]string, error) {
+	names := make([]string, 0, len(r.changed))
+	for name := range r.changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := r.targets[name]; !ok {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("no metadata found for %s", name)}
+		}
+	}
+	err := r.Publish(names...)
+	if err != nil {
+		return nil, err
+	}
+	r.changed = map[string]bool{}
+	return names, nil
+}
+
+// succinctRoles returns the hash bin delegation of delegator
+func (r *Repository) succinctRoles(delegator string) (*metadata.SuccinctRoles, error) {
+	targets, ok := r.targets[delegator]
+	if !ok {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("no targets metadata found for %s", delegator)}
+	}
+	if targets.Signed.Delegations == nil || targets.Signed.Delegations.SuccinctRoles == nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("%s doesn't delegate to hash bins", delegator)}
+	}
+	return targets.Signed.Delegations.SuccinctRoles, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashBins(t *testing.T) {
+	dir := t.TempDir()
+	expires := time.Now().AddDate(0, 0, 7).UTC()
+	repo := helperNewRepository(t)
+	_, signer := helperNewKey(t)
+	opts := BinsOptions{BitLength: 2, NamePrefix: "bin", Threshold: 1, Expires: expires}
+	assert.NoError(t, repo.SetupBins(metadata.TARGETS, opts, signer))
+	assert.ErrorIs(t, repo.SetupBins(metadata.TARGETS, opts, signer), metadata.ErrValue{Msg: "targets already has delegations"})
+	for i := 0; i < 10; i++ {
+		_, _, err := repo.AddBinnedTarget(metadata.TARGETS, fmt.Sprintf("files/%d.txt", i), []byte(fmt.Sprintf("content %d", i)))
+		assert.NoError(t, err)
+	}
+	published, err := repo.PublishBins()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bin-0", "bin-1", "bin-2", "bin-3", metadata.TARGETS}, published)
+	assert.Len(t, repo.Snapshot().Signed.Meta, 5)
+	assert.NoError(t, repo.Write(dir))
+	data, err := helperRefresh(t, dir, "files/7.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "content 7", string(data))
+
+	// only the bin of a new target file is signed again
+	bin, _, err := repo.AddBinnedTarget(metadata.TARGETS, "files/new.txt", []byte("new"))
+	assert.NoError(t, err)
+	published, err = repo.PublishBins()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{bin}, published)
+	assert.Equal(t, int64(2), repo.Targets(bin).Signed.Version)
+	assert.Equal(t, int64(1), repo.Targets(metadata.TARGETS).Signed.Version)
+	assert.Equal(t, int64(2), repo.Snapshot().Signed.Version)
+
+	// re-binning moves every target file and keeps the retired bins in snapshot
+	assert.NoError(t, repo.RebinTargets(metadata.TARGETS, 5))
+	published, err = repo.PublishBins()
+	assert.NoError(t, err)
+	assert.Len(t, published, 4+32+1)
+	assert.Len(t, repo.Snapshot().Signed.Meta, 4+32+1)
+	assert.Empty(t, repo.Targets("bin-0").Signed.Targets)
+	total := 0
+	for _, name := range repo.Targets(metadata.TARGETS).Signed.Delegations.SuccinctRoles.GetRoles() {
+		for targetPath := range repo.Targets(name).Signed.Targets {
+			expected, err := repo.BinForTarget(metadata.TARGETS, targetPath)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, name)
+			total++
+		}
+	}
+	assert.Equal(t, 11, total)
+	assert.NoError(t, repo.Write(dir))
+	report, err := Verify(dir, time.Time{})
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+	data, err = helperRefresh(t, dir, "files/new.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	_, err = repo.RemoveBinnedTarget(metadata.TARGETS, "files/missing.txt")
+	assert.ErrorContains(t, err, "target file files/missing.txt not found")
+	_, err = repo.BinForTarget("bin-00", "files/new.txt")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "bin-00 doesn't delegate to hash bins"})
+}
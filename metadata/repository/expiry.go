@@ -0,0 +1,154 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// RoleExpiry is the expiration policy of a single role
+type RoleExpiry struct {
+	// Validity is how long a new version of the role is valid
+	Validity time.Duration
+	// Threshold is how long before its expiration a role is due for renewal
+	Threshold time.Duration
+}
+
+// ExpiryPolicy is the expiration policy of a repository
+type ExpiryPolicy struct {
+	// Roles maps role names to their policy
+	Roles map[string]RoleExpiry
+	// Default is used for roles not listed in Roles, i.e. delegated roles
+	Default RoleExpiry
+}
+
+// DefaultExpiryPolicy returns the policy of the top-level roles as commonly
+// used by TUF repositories, delegated roles use the one of targets
+func DefaultExpiryPolicy() ExpiryPolicy {
+	day := 24 * time.Hour
+	return ExpiryPolicy{
+		Roles: map[string]RoleExpiry{
+			metadata.ROOT:      {Validity: 365 * day, Threshold: 30 * day},
+			metadata.TARGETS:   {Validity: 90 * day, Threshold: 14 * day},
+			metadata.SNAPSHOT:  {Validity: 7 * day, Threshold: day},
+			metadata.TIMESTAMP: {Validity: day, Threshold: 6 * time.Hour},
+		},
+		Default: RoleExpiry{Validity: 90 * day, Threshold: 14 * day},
+	}
+}
+
+// For returns the policy of roleName
+func (p ExpiryPolicy) For(roleName string) RoleExpiry {
+	if roleExpiry, ok := p.Roles[roleName]; ok {
+		return roleExpiry
+	}
+	return p.Default
+}
+
+// Expires returns the expiration date of a new version of roleName
+// created at now
+func (p ExpiryPolicy) Expires(roleName string, now time.Time) time.Time {
+	return now.Add(p.For(roleName).Validity).UTC().Truncate(time.Second)
+}
+
+// ExpiringRole describes a role which is due for renewal
+type ExpiringRole struct {
+	Role    string    `json:"role"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+	Expired bool      `json:"expired"`
+}
+
+// ExpiringRoles returns the roles which expire within the threshold of
+// their policy at now, soonest first
+func (r *Repository) ExpiringRoles(policy ExpiryPolicy, now time.Time) []ExpiringRole {
+	res := []ExpiringRole{}
+	check := func(roleName string, version int64, expires time.Time) {
+		if now.Add(policy.For(roleName).Threshold).Before(expires) {
+			return
+		}
+		res = append(res, ExpiringRole{
+			Role:    roleName,
+			Version: version,
+			Expires: expires,
+			Expired: !now.Before(expires),
+		})
+	}
+	if r.root != nil {
+		check(metadata.ROOT, r.root.Signed.Version, r.root.Signed.Expires)
+	}
+	if r.snapshot != nil {
+		check(metadata.SNAPSHOT, r.snapshot.Signed.Version, r.snapshot.Signed.Expires)
+	}
+	if r.timestamp != nil {
+		check(metadata.TIMESTAMP, r.timestamp.Signed.Version, r.timestamp.Signed.Expires)
+	}
+	for _, name := range r.TargetsRoles() {
+		check(name, r.targets[name].Signed.Version, r.targets[name].Signed.Expires)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Expires.Before(res[j].Expires) })
+	return res
+}
+
+// RefreshOnlineRoles renews snapshot and timestamp, the roles usually
+// signed with online keys, if they are due for renewal according to policy
+// at now. A renewed role gets a new version, the expiration date of the
+// policy and is signed with its configured signers. The timestamp is always
+// renewed along with the snapshot. Returns the names of the renewed roles
+func (r *Repository) RefreshOnlineRoles(policy ExpiryPolicy, now time.Time) ([]string, error) {
+	if r.snapshot == nil || r.timestamp == nil {
+		return nil, metadata.ErrValue{Msg: "snapshot and timestamp metadata must be set before refreshing them"}
+	}
+	due := map[string]bool{}
+	for _, role := range r.ExpiringRoles(policy, now) {
+		due[role.Role] = true
+	}
+	res := []string{}
+	snapshotChanged := false
+	if due[metadata.SNAPSHOT] {
+		opts := r.opts
+		opts.Expires = policy.Expires(metadata.SNAPSHOT, now)
+		snapshot, changed, err := GenerateSnapshot(r.snapshot, r.targets, opts)
+		if err != nil {
+			return nil, err
+		}
+		r.snapshot = snapshot
+		if changed {
+			if err := r.Sign(metadata.SNAPSHOT); err != nil {
+				return nil, err
+			}
+			snapshotChanged = true
+			res = append(res, metadata.SNAPSHOT)
+		}
+	}
+	if due[metadata.TIMESTAMP] || snapshotChanged {
+		opts := r.opts
+		opts.Expires = policy.Expires(metadata.TIMESTAMP, now)
+		timestamp, changed, err := GenerateTimestamp(r.timestamp, r.snapshot, opts)
+		if err != nil {
+			return nil, err
+		}
+		r.timestamp = timestamp
+		if changed {
+			if err := r.Sign(metadata.TIMESTAMP); err != nil {
+				return nil, err
+			}
+			res = append(res, metadata.TIMESTAMP)
+		}
+	}
+	log.Debugf("Refreshed online roles: %v", res)
+	return res, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiringRolesAndRefresh(t *testing.T) {
+	dir := t.TempDir()
+	policy := DefaultExpiryPolicy()
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+
+	// all roles expire in 7 days which is within the root and targets threshold
+	now := time.Now().UTC()
+	expiring := repo.ExpiringRoles(policy, now)
+	assert.Len(t, expiring, 2)
+	assert.ElementsMatch(t, []string{metadata.ROOT, metadata.TARGETS}, []string{expiring[0].Role, expiring[1].Role})
+	refreshed, err := repo.RefreshOnlineRoles(policy, now)
+	assert.NoError(t, err)
+	assert.Empty(t, refreshed)
+
+	// a cron job shortly before the snapshot expires
+	later := now.Add(6*24*time.Hour + 12*time.Hour)
+	assert.Len(t, repo.ExpiringRoles(policy, later), 3)
+	refreshed, err = repo.RefreshOnlineRoles(policy, later)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{metadata.SNAPSHOT, metadata.TIMESTAMP}, refreshed)
+	assert.Equal(t, int64(2), repo.Snapshot().Signed.Version)
+	assert.Equal(t, int64(2), repo.Timestamp().Signed.Version)
+	assert.Equal(t, policy.Expires(metadata.TIMESTAMP, later), repo.Timestamp().Signed.Expires)
+
+	assert.NoError(t, repo.Write(dir))
+	report, err := Verify(dir, later)
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+
+	// only the timestamp a day later
+	refreshed, err = repo.RefreshOnlineRoles(policy, later.Add(20*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{metadata.TIMESTAMP}, refreshed)
+	assert.Equal(t, int64(3), repo.Timestamp().Signed.Version)
+	assert.Equal(t, int64(2), repo.Snapshot().Signed.Version)
+
+	expired := repo.ExpiringRoles(policy, now.AddDate(0, 0, 8))
+	assert.Equal(t, metadata.ROOT, expired[0].Role)
+	assert.True(t, expired[0].Expired)
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// GenerateOptions controls how snapshot and timestamp metadata is generated
+type GenerateOptions struct {
+	// Lengths fills in the length of the referenced metadata files
+	Lengths bool
+	// Hashes lists the hash algorithms of the referenced metadata files,
+	// e.g. "sha256". No hashes are filled in if it's empty
+	Hashes []string
+	// Expires sets a new expiration date, the previous one is kept if zero
+	Expires time.Time
+}
+
+// DefaultGenerateOptions returns the options used by Repository, i.e.
+// lengths and sha256 hashes are filled in
+func DefaultGenerateOptions() GenerateOptions {
+	return GenerateOptions{
+		Lengths: true,
+		Hashes:  []string{"sha256"},
+	}
+}
+
+// GenerateSnapshot returns the snapshot which follows previous given the
+// current state of all targets roles (the top-level one, delegated roles and
+// succinct hash bins) keyed by role name. Every role delegated by one of them
+// must be part of targets. The lengths and hashes are calculated over the
+// compact JSON serialization of the targets metadata, so it should be signed
+// already if they are requested.
+// If nothing changed compared to previous, previous is returned as is and
+// changed is false. Otherwise a new unsigned snapshot with a bumped version is
+// returned. A previous snapshot without signatures is treated as an
+// unpublished draft and its version is kept
+func GenerateSnapshot(previous *metadata.Metadata[metadata.SnapshotType], targets map[string]*metadata.Metadata[metadata.TargetsType], opts GenerateOptions) (*metadata.Metadata[metadata.SnapshotType], bool, error) {
+	if _, ok := targets[metadata.TARGETS]; !ok {
+		return nil, false, metadata.ErrValue{Msg: "no top-level targets metadata found"}
+	}
+	err := checkDelegatedRoles(targets)
+	if err != nil {
+		return nil, false, err
+	}
+	meta := map[string]*metadata.MetaFiles{}
+	for name, targetsMetadata := range targets {
+		data, err := targetsMetadata.ToBytes(false)
+		if err != nil {
+			return nil, false, err
+		}
+		meta[fmt.Sprintf("%s.json", name)], err = generateMetaFile(targetsMetadata.Signed.Version, data, opts)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if previous == nil {
+		if opts.Expires.IsZero() {
+			return nil, false, metadata.ErrValue{Msg: "an expiration date is required to generate the first snapshot"}
+		}
+		previous = metadata.Snapshot(opts.Expires)
+		previous.Signed.Meta = nil
+	}
+	if metaEqual(previous.Signed.Meta, meta) && (opts.Expires.IsZero() || opts.Expires.Equal(previous.Signed.Expires)) {
+		log.Debugf("Snapshot v%d is up to date", previous.Signed.Version)
+		return previous, false, nil
+	}
+	next, err := clone(previous)
+	if err != nil {
+		return nil, false, err
+	}
+	// only a published, i.e. signed, version needs a new version number
+	if len(previous.Signatures) > 0 {
+		next.Signed.Version++
+	}
+	next.Signed.Meta = meta
+	if !opts.Expires.IsZero() {
+		next.Signed.Expires = opts.Expires
+	}
+	log.Debugf("Generated snapshot v%d", next.Signed.Version)
+	return next, true, nil
+}
+
+// GenerateTimestamp returns the timestamp which follows previous and points
+// to snapshot. The length and hashes are calculated over the compact JSON
+// serialization of snapshot, so it should be signed already if they are
+// requested. The version is bumped following the same rules as in
+// GenerateSnapshot
+func GenerateTimestamp(previous *metadata.Metadata[metadata.TimestampType], snapshot *metadata.Metadata[metadata.SnapshotType], opts GenerateOptions) (*metadata.Metadata[metadata.TimestampType], bool, error) {
+	if snapshot == nil {
+		return nil, false, metadata.ErrValue{Msg: "no snapshot metadata found"}
+	}
+	data, err := snapshot.ToBytes(false)
+	if err != nil {
+		return nil, false, err
+	}
+	metaFile, err := generateMetaFile(snapshot.Signed.Version, data, opts)
+	if err != nil {
+		return nil, false, err
+	}
+	meta := map[string]*metadata.MetaFiles{
+		fmt.Sprintf("%s.json", metadata.SNAPSHOT): metaFile,
+	}
+	if previous == nil {
+		if opts.Expires.IsZero() {
+			return nil, false, metadata.ErrValue{Msg: "an expiration date is required to generate the first timestamp"}
+		}
+		previous = metadata.Timestamp(opts.Expires)
+		previous.Signed.Meta = nil
+	}
+	if metaEqual(previous.Signed.Meta, meta) && (opts.Expires.IsZero() || opts.Expires.Equal(previous.Signed.Expires)) {
+		log.Debugf("Timestamp v%d is up to date", previous.Signed.Version)
+		return previous, false, nil
+	}
+	next, err := clone(previous)
+	if err != nil {
+		return nil, false, err
+	}
+	// only a published, i.e. signed, version needs a new version number
+	if len(previous.Signatures) > 0 {
+		next.Signed.Version++
+	}
+	next.Signed.Meta = meta
+	if !opts.Expires.IsZero() {
+		next.Signed.Expires = opts.Expires
+	}
+	log.Debugf("Generated timestamp v%d", next.Signed.Version)
+	return next, true, nil
+}
+
+// checkDelegatedRoles makes sure every role delegated by one of the targets
+// roles has metadata as otherwise clients can't find it in the snapshot
+func checkDelegatedRoles(targets map[string]*metadata.Metadata[metadata.TargetsType]) error {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, delegator := range names {
+		delegations := targets[delegator].Signed.Delegations
+		if delegations == nil {
+			continue
+		}
+		delegated := []string{}
+		for _, role := range delegations.Roles {
+			delegated = append(delegated, role.Name)
+		}
+		if delegations.SuccinctRoles != nil {
+			delegated = append(delegated, delegations.SuccinctRoles.GetRoles()...)
+		}
+		for _, name := range delegated {
+			if _, ok := targets[name]; !ok {
+				return metadata.ErrValue{Msg: fmt.Sprintf("no metadata found for %s delegated by %s", name, delegator)}
+			}
+		}
+	}
+	return nil
+}
+
+// generateMetaFile returns a MetaFiles describing data at version
+func generateMetaFile(version int64, data []byte, opts GenerateOptions) (*metadata.MetaFiles, error) {
+	metaFile := metadata.MetaFile(version)
+	if opts.Lengths {
+		metaFile.Length = int64(len(data))
+	}
+	if len(opts.Hashes) > 0 {
+		// reuse the hashing of target files as it supports the same algorithms
+		fileInfo, err := metadata.TargetFile().FromBytes("", data, opts.Hashes...)
+		if err != nil {
+			return nil, err
+		}
+		metaFile.Hashes = fileInfo.Hashes
+	}
+	return metaFile, nil
+}
+
+// metaEqual reports whether two METAFILES maps describe the same files
+func metaEqual(a, b map[string]*metadata.MetaFiles) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, x := range a {
+		y, ok := b[name]
+		if !ok || x.Version != y.Version || x.Length != y.Length || !hashesEqual(x.Hashes, y.Hashes) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashesEqual reports whether a and b contain exactly the same hashes
+func hashesEqual(a, b metadata.Hashes) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || a.Equal(b)
+}
+
+// clone returns an unsigned deep copy of meta
+func clone[T metadata.Roles](meta *metadata.Metadata[T]) (*metadata.Metadata[T], error) {
+	data, err := meta.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	res, err := (&metadata.Metadata[T]{}).FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	res.ClearSignatures()
+	return res, nil
+}
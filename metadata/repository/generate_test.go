@@ -0,0 +1,95 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSnapshotWithSuccinctRoles(t *testing.T) {
+	expires := time.Now().AddDate(0, 0, 7).UTC()
+	targets := map[string]*metadata.Metadata[metadata.TargetsType]{
+		metadata.TARGETS: metadata.Targets(expires),
+	}
+	targets[metadata.TARGETS].Signed.Delegations = &metadata.Delegations{
+		Keys: map[string]*metadata.Key{},
+		SuccinctRoles: &metadata.SuccinctRoles{
+			KeyIDs:     []string{"keyid"},
+			Threshold:  1,
+			BitLength:  2,
+			NamePrefix: "bin",
+		},
+	}
+
+	// every bin must be present
+	_, _, err := GenerateSnapshot(nil, targets, GenerateOptions{Expires: expires})
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "no metadata found for bin-0 delegated by targets"})
+	for _, name := range targets[metadata.TARGETS].Signed.Delegations.SuccinctRoles.GetRoles() {
+		targets[name] = metadata.Targets(expires)
+	}
+
+	snapshot, changed, err := GenerateSnapshot(nil, targets, GenerateOptions{Expires: expires})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, int64(1), snapshot.Signed.Version)
+	assert.Len(t, snapshot.Signed.Meta, 5)
+	assert.Equal(t, int64(0), snapshot.Signed.Meta["bin-3.json"].Length)
+	assert.Empty(t, snapshot.Signed.Meta["bin-3.json"].Hashes)
+
+	// a published snapshot is not bumped if nothing changed
+	snapshot.Signatures = []metadata.Signature{{KeyID: "keyid"}}
+	next, changed, err := GenerateSnapshot(snapshot, targets, GenerateOptions{})
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Same(t, snapshot, next)
+
+	// but it is if one of the bins changed
+	targets["bin-2"].Signed.Version++
+	next, changed, err = GenerateSnapshot(snapshot, targets, DefaultGenerateOptions())
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, int64(2), next.Signed.Version)
+	assert.Equal(t, int64(2), next.Signed.Meta["bin-2.json"].Version)
+	assert.NotZero(t, next.Signed.Meta["bin-2.json"].Length)
+	assert.Len(t, next.Signed.Meta["bin-2.json"].Hashes["sha256"], 32)
+	assert.Empty(t, next.Signatures)
+	assert.Equal(t, int64(1), snapshot.Signed.Version)
+}
+
+func TestGenerateTimestamp(t *testing.T) {
+	expires := time.Now().AddDate(0, 0, 1).UTC()
+	snapshot := metadata.Snapshot(expires)
+
+	_, _, err := GenerateTimestamp(nil, snapshot, GenerateOptions{})
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "an expiration date is required to generate the first timestamp"})
+
+	timestamp, changed, err := GenerateTimestamp(nil, snapshot, GenerateOptions{Expires: expires, Hashes: []string{"sha512"}})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Len(t, timestamp.Signed.Meta["snapshot.json"].Hashes["sha512"], 64)
+
+	// only a new expiration date
+	timestamp.Signatures = []metadata.Signature{{KeyID: "keyid"}}
+	later := expires.Add(time.Hour)
+	next, changed, err := GenerateTimestamp(timestamp, snapshot, GenerateOptions{Expires: later, Hashes: []string{"sha512"}})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, int64(2), next.Signed.Version)
+	assert.Equal(t, later, next.Signed.Expires)
+
+	_, _, err = GenerateTimestamp(timestamp, snapshot, GenerateOptions{Hashes: []string{"md5"}})
+	assert.ErrorContains(t, err, "unsupported hashing algorithm - md5")
+}
@@ -0,0 +1,398 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// Finding describes a single problem found while loading a repository
+type Finding struct {
+	Path   string `json:"path"`
+	Role   string `json:"role,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// LoadReport describes the state of a repository directory
+type LoadReport struct {
+	// Loaded maps each loaded role to the metadata file it was loaded from
+	Loaded map[string]string `json:"loaded"`
+	// Missing lists metadata and target files which are referenced but not present
+	Missing []Finding `json:"missing"`
+	// Orphaned lists files which are not referenced by the latest metadata
+	Orphaned []Finding `json:"orphaned"`
+	// Mismatches lists cross-references between roles which don't match
+	Mismatches []Finding `json:"mismatches"`
+}
+
+// OK reports whether nothing is missing, orphaned or mismatched
+func (r *LoadReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Orphaned) == 0 && len(r.Mismatches) == 0
+}
+
+// metadataFile is a file in the metadata directory
+type metadataFile struct {
+	name    string
+	role    string
+	version int64 // 0 if the file name is not versioned
+}
+
+// loader keeps the state while loading a repository
+type loader struct {
+	dir        string
+	fsys       fs.FS
+	repo       *Repository
+	report     *LoadReport
+	files      map[string][]metadataFile // role name -> files
+	consistent bool
+	used       map[string]bool // metadata file names
+}
+
+// Load opens the repository at dir with the layout written by Write, i.e.
+// <dir>/metadata and <dir>/targets. The latest root is found by its version,
+// snapshot and all targets roles are resolved from timestamp and snapshot,
+// following consistent snapshot file names if enabled in root. Problems which
+// don't prevent loading the repository, such as missing or orphaned files and
+// mismatching cross-references, are listed in the returned report
+func Load(dir string) (*Repository, *LoadReport, error) {
+	return loadFS(os.DirFS(dir), dir)
+}
+
+// loadFS loads the repository in fsys, dir names it in messages
+func loadFS(fsys fs.FS, dir string) (*Repository, *LoadReport, error) {
+	l := &loader{
+		dir:  dir,
+		fsys: fsys,
+		repo: New(),
+		report: &LoadReport{
+			Loaded:     map[string]string{},
+			Missing:    []Finding{},
+			Orphaned:   []Finding{},
+			Mismatches: []Finding{},
+		},
+		files: map[string][]metadataFile{},
+		used:  map[string]bool{},
+	}
+	err := l.scanMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = l.loadRoot()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = l.loadTimestamp()
+	if err != nil {
+		return nil, nil, err
+	}
+	l.loadSnapshot()
+	l.loadTargets()
+	l.checkDelegations()
+	l.checkMetadataFiles()
+	err = l.checkTargetFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Debugf("Loaded repository from %s", dir)
+	return l.repo, l.report, nil
+}
+
+// scanMetadata indexes the files in the metadata directory
+func (l *loader) scanMetadata() error {
+	entries, err := fs.ReadDir(l.fsys, MetadataDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		f, ok := parseMetadataFileName(entry.Name())
+		if !ok {
+			l.orphan(path.Join(MetadataDir, entry.Name()), "", "not a metadata file name")
+			continue
+		}
+		l.files[f.role] = append(l.files[f.role], f)
+	}
+	return nil
+}
+
+// loadRoot loads the root metadata with the highest version
+func (l *loader) loadRoot() error {
+	var latest *metadataFile
+	for i, f := range l.files[metadata.ROOT] {
+		if latest == nil || f.version > latest.version {
+			latest = &l.files[metadata.ROOT][i]
+		}
+	}
+	if latest == nil {
+		return metadata.ErrRepository{Msg: fmt.Sprintf("no root metadata found in %s", filepath.Join(l.dir, MetadataDir))}
+	}
+	data, err := l.readMetadata(latest.name)
+	if err != nil {
+		return err
+	}
+	root, err := metadata.Root().FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", latest.name, err)
+	}
+	l.repo.SetRoot(root)
+	l.consistent = root.Signed.ConsistentSnapshot
+	// every root version is part of the chain clients walk through
+	for _, f := range l.files[metadata.ROOT] {
+		l.used[f.name] = true
+	}
+	l.loaded(metadata.ROOT, latest.name)
+	return nil
+}
+
+// loadTimestamp loads the timestamp metadata, it's never versioned
+func (l *loader) loadTimestamp() error {
+	name := MetadataFileName(metadata.TIMESTAMP, 0, false)
+	data, err := l.readMetadata(name)
+	if err != nil {
+		return err
+	}
+	timestamp, err := metadata.Timestamp().FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", name, err)
+	}
+	l.repo.SetTimestamp(timestamp)
+	l.used[name] = true
+	l.loaded(metadata.TIMESTAMP, name)
+	return nil
+}
+
+// loadSnapshot loads the snapshot referenced by timestamp
+func (l *loader) loadSnapshot() {
+	metaFile, ok := l.repo.Timestamp().Signed.Meta[fmt.Sprintf("%s.json", metadata.SNAPSHOT)]
+	if !ok {
+		l.mismatch(path.Join(MetadataDir, MetadataFileName(metadata.TIMESTAMP, 0, false)), metadata.TIMESTAMP, "snapshot.json is not listed in timestamp meta")
+		return
+	}
+	data, name, ok := l.readReferenced(metadata.SNAPSHOT, metaFile)
+	if !ok {
+		return
+	}
+	snapshot, err := metadata.Snapshot().FromBytes(data)
+	if err != nil {
+		l.mismatch(path.Join(MetadataDir, name), metadata.SNAPSHOT, fmt.Sprintf("invalid snapshot metadata: %v", err))
+		return
+	}
+	if snapshot.Signed.Version != metaFile.Version {
+		l.mismatch(path.Join(MetadataDir, name), metadata.SNAPSHOT, fmt.Sprintf("version %d does not match version %d in timestamp meta", snapshot.Signed.Version, metaFile.Version))
+	}
+	l.repo.SetSnapshot(snapshot)
+	l.loaded(metadata.SNAPSHOT, name)
+}
+
+// loadTargets loads every targets role listed in snapshot
+func (l *loader) loadTargets() {
+	if l.repo.Snapshot() == nil {
+		return
+	}
+	names := make([]string, 0, len(l.repo.Snapshot().Signed.Meta))
+	for name := range l.repo.Snapshot().Signed.Meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, fileName := range names {
+		metaFile := l.repo.Snapshot().Signed.Meta[fileName]
+		roleName := strings.TrimSuffix(fileName, ".json")
+		data, name, ok := l.readReferenced(roleName, metaFile)
+		if !ok {
+			continue
+		}
+		targets, err := metadata.Targets().FromBytes(data)
+		if err != nil {
+			l.mismatch(path.Join(MetadataDir, name), roleName, fmt.Sprintf("invalid targets metadata: %v", err))
+			continue
+		}
+		if targets.Signed.Version != metaFile.Version {
+			l.mismatch(path.Join(MetadataDir, name), roleName, fmt.Sprintf("version %d does not match version %d in snapshot meta", targets.Signed.Version, metaFile.Version))
+		}
+		l.repo.SetTargets(roleName, targets)
+		l.loaded(roleName, name)
+	}
+	if _, ok := l.repo.Snapshot().Signed.Meta[fmt.Sprintf("%s.json", metadata.TARGETS)]; !ok {
+		l.mismatch(path.Join(MetadataDir, l.report.Loaded[metadata.SNAPSHOT]), metadata.SNAPSHOT, "targets.json is not listed in snapshot meta")
+	}
+}
+
+// checkDelegations reports delegated roles which have no loaded metadata
+func (l *loader) checkDelegations() {
+	for _, delegator := range l.repo.TargetsRoles() {
+		delegations := l.repo.Targets(delegator).Signed.Delegations
+		if delegations == nil {
+			continue
+		}
+		delegated := []string{}
+		for _, role := range delegations.Roles {
+			delegated = append(delegated, role.Name)
+		}
+		if delegations.SuccinctRoles != nil {
+			delegated = append(delegated, delegations.SuccinctRoles.GetRoles()...)
+		}
+		for _, name := range delegated {
+			if l.repo.Targets(name) == nil {
+				l.missing(path.Join(MetadataDir, MetadataFileName(name, 1, l.consistent)), name, fmt.Sprintf("delegated by %s but not listed in snapshot", delegator))
+			}
+		}
+	}
+}
+
+// checkMetadataFiles reports metadata files which are not used by the
+// loaded metadata. Older versions of loaded roles are kept as history
+func (l *loader) checkMetadataFiles() {
+	roles := make([]string, 0, len(l.files))
+	for role := range l.files {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		for _, f := range l.files[role] {
+			if l.used[f.name] {
+				continue
+			}
+			current, isLoaded := l.report.Loaded[role]
+			switch {
+			case !isLoaded:
+				l.orphan(path.Join(MetadataDir, f.name), role, "role is not referenced by the repository metadata")
+			case l.consistent && f.version == 0:
+				l.orphan(path.Join(MetadataDir, f.name), role, "unversioned file in a consistent snapshot repository")
+			case !l.consistent && f.version != 0:
+				l.orphan(path.Join(MetadataDir, f.name), role, "versioned file in a repository without consistent snapshots")
+			default:
+				loadedVersion, _ := parseMetadataFileName(current)
+				if f.version > loadedVersion.version {
+					l.orphan(path.Join(MetadataDir, f.name), role, fmt.Sprintf("newer than the referenced version %d", loadedVersion.version))
+				}
+			}
+		}
+	}
+}
+
+// checkTargetFiles reports missing target files and files in the targets
+// directory which are not listed in any loaded targets role
+func (l *loader) checkTargetFiles() error {
+	expected := map[string]bool{}
+	for _, role := range l.repo.TargetsRoles() {
+		targetPaths := make([]string, 0, len(l.repo.Targets(role).Signed.Targets))
+		for targetPath := range l.repo.Targets(role).Signed.Targets {
+			targetPaths = append(targetPaths, targetPath)
+		}
+		sort.Strings(targetPaths)
+		for _, targetPath := range targetPaths {
+			names := targetFileNames(targetPath, l.repo.Targets(role).Signed.Targets[targetPath], l.consistent)
+			// the plain target path is accepted too for clients not using hash prefixes
+			if l.consistent {
+				names = append(names, targetPath)
+			}
+			found := false
+			for _, name := range names {
+				expected[name] = true
+				if _, err := fs.Stat(l.fsys, path.Join(TargetsDir, name)); err == nil {
+					found = true
+				}
+			}
+			if !found {
+				l.missing(path.Join(TargetsDir, names[0]), role, fmt.Sprintf("target file %s is not present", targetPath))
+			}
+		}
+	}
+	if _, err := fs.Stat(l.fsys, TargetsDir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return fs.WalkDir(l.fsys, TargetsDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !expected[strings.TrimPrefix(name, TargetsDir+"/")] {
+			l.orphan(name, "", "not listed in any targets metadata")
+		}
+		return nil
+	})
+}
+
+// readReferenced reads the metadata file of roleName referenced by metaFile
+// and verifies its length and hashes if present. Reports it as missing or
+// mismatching if that fails
+func (l *loader) readReferenced(roleName string, metaFile *metadata.MetaFiles) ([]byte, string, bool) {
+	name := MetadataFileName(roleName, metaFile.Version, l.consistent)
+	data, err := l.readMetadata(name)
+	if err != nil {
+		l.missing(path.Join(MetadataDir, name), roleName, fmt.Sprintf("version %d is referenced but can't be read: %v", metaFile.Version, err))
+		return nil, name, false
+	}
+	l.used[name] = true
+	if metaFile.Length != 0 || len(metaFile.Hashes) != 0 {
+		if err := metaFile.VerifyLengthHashes(data); err != nil {
+			l.mismatch(path.Join(MetadataDir, name), roleName, fmt.Sprintf("length or hashes do not match the referencing meta: %v", err))
+		}
+	}
+	return data, name, true
+}
+
+// readMetadata reads a file from the metadata directory
+func (l *loader) readMetadata(name string) ([]byte, error) {
+	return fs.ReadFile(l.fsys, path.Join(MetadataDir, name))
+}
+
+func (l *loader) loaded(role, name string) {
+	l.report.Loaded[role] = name
+}
+
+func (l *loader) missing(p, role, reason string) {
+	l.report.Missing = append(l.report.Missing, Finding{Path: p, Role: role, Reason: reason})
+}
+
+func (l *loader) orphan(p, role, reason string) {
+	l.report.Orphaned = append(l.report.Orphaned, Finding{Path: p, Role: role, Reason: reason})
+}
+
+func (l *loader) mismatch(p, role, reason string) {
+	l.report.Mismatches = append(l.report.Mismatches, Finding{Path: p, Role: role, Reason: reason})
+}
+
+// parseMetadataFileName splits <version>.<role>.json or <role>.json into the
+// version and unescaped role name
+func parseMetadataFileName(name string) (metadataFile, bool) {
+	res := metadataFile{name: name}
+	base := strings.TrimSuffix(name, ".json")
+	if base == name || base == "" {
+		return res, false
+	}
+	if prefix, rest, found := strings.Cut(base, "."); found {
+		if version, err := strconv.ParseInt(prefix, 10, 64); err == nil && version > 0 {
+			res.version = version
+			base = rest
+		}
+	}
+	role, err := url.QueryUnescape(base)
+	if err != nil || role == "" {
+		return res, false
+	}
+	res.role = role
+	return res, true
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMultiRepoExample(t *testing.T) {
+	repo, report, err := Load(filepath.Join("..", "..", "examples", "multirepo", "repository"))
+	assert.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report)
+	assert.Equal(t, map[string]string{
+		metadata.ROOT:      "1.root.json",
+		metadata.TIMESTAMP: "timestamp.json",
+		metadata.SNAPSHOT:  "1.snapshot.json",
+		metadata.TARGETS:   "1.targets.json",
+	}, report.Loaded)
+	assert.Equal(t, []string{metadata.TARGETS}, repo.TargetsRoles())
+	assert.Contains(t, repo.Targets(metadata.TARGETS).Signed.Targets, "map.json")
+	assert.True(t, repo.Root().Signed.ConsistentSnapshot)
+}
+
+func TestLoadReport(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	_, err := repo.AddTarget(metadata.TARGETS, "a/kept.txt", []byte("kept"))
+	assert.NoError(t, err)
+	_, err = repo.AddTarget(metadata.TARGETS, "a/deleted.txt", []byte("deleted"))
+	assert.NoError(t, err)
+	repo.SetTargets("delegated", metadata.Targets(repo.Targets(metadata.TARGETS).Signed.Expires))
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+
+	// tamper with the written repository
+	deleted := repo.Targets(metadata.TARGETS).Signed.Targets["a/deleted.txt"]
+	assert.NoError(t, os.Remove(filepath.Join(dir, TargetsDir, "a", deleted.Hashes["sha256"].String()+".deleted.txt")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, TargetsDir, "stray.txt"), []byte("stray"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, MetadataDir, "2.snapshot.json"), []byte("{}"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, MetadataDir, "1.delegated.json"), []byte("tampered"), 0644))
+
+	loaded, report, err := Load(dir)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, []Finding{{Path: "targets/a/" + deleted.Hashes["sha256"].String() + ".deleted.txt", Role: metadata.TARGETS, Reason: "target file a/deleted.txt is not present"}}, report.Missing)
+	assert.Equal(t, []Finding{
+		{Path: "metadata/2.snapshot.json", Role: metadata.SNAPSHOT, Reason: "newer than the referenced version 1"},
+		{Path: "targets/stray.txt", Reason: "not listed in any targets metadata"},
+	}, report.Orphaned)
+	assert.Len(t, report.Mismatches, 2)
+	assert.Equal(t, "metadata/1.delegated.json", report.Mismatches[0].Path)
+	assert.Nil(t, loaded.Targets("delegated"))
+	assert.NotNil(t, loaded.Targets(metadata.TARGETS))
+}
@@ -12,60 +12,301 @@
 package repository
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	log "github.com/sirupsen/logrus"
 )
 
-// repositoryType struct for storing metadata
-type repositoryType struct {
+// Repository stores the metadata of a TUF repository together with the
+// signers of each role and the content of the target files added to it
+type Repository struct {
 	root      *metadata.Metadata[metadata.RootType]
 	snapshot  *metadata.Metadata[metadata.SnapshotType]
 	timestamp *metadata.Metadata[metadata.TimestampType]
 	targets   map[string]*metadata.Metadata[metadata.TargetsType]
+	signers   map[string][]signature.Signer
+	// targetData holds the content of the target files by role and path
+	targetData map[string]map[string][]byte
+	opts       GenerateOptions
+	// signOpts are passed to every Metadata[T].Sign call
+	signOpts []metadata.SignOption
+	// verifyOpts are passed to every Metadata[T].VerifyDelegate call
+	verifyOpts []metadata.VerifyOption
+	// changed tracks the hash bins which need to be published
+	changed map[string]bool
 }
 
 // New creates an empty repository instance
-func New() *repositoryType {
-	return &repositoryType{
-		targets: map[string]*metadata.Metadata[metadata.TargetsType]{},
+func New() *Repository {
+	return &Repository{
+		targets:    map[string]*metadata.Metadata[metadata.TargetsType]{},
+		signers:    map[string][]signature.Signer{},
+		targetData: map[string]map[string][]byte{},
+		opts:       DefaultGenerateOptions(),
+		changed:    map[string]bool{},
 	}
 }
 
 // Root returns metadata of type Root
-func (r *repositoryType) Root() *metadata.Metadata[metadata.RootType] {
+func (r *Repository) Root() *metadata.Metadata[metadata.RootType] {
 	return r.root
 }
 
 // SetRoot sets metadata of type Root
-func (r *repositoryType) SetRoot(meta *metadata.Metadata[metadata.RootType]) {
+func (r *Repository) SetRoot(meta *metadata.Metadata[metadata.RootType]) {
 	r.root = meta
 }
 
 // Snapshot returns metadata of type Snapshot
-func (r *repositoryType) Snapshot() *metadata.Metadata[metadata.SnapshotType] {
+func (r *Repository) Snapshot() *metadata.Metadata[metadata.SnapshotType] {
 	return r.snapshot
 }
 
 // SetSnapshot sets metadata of type Snapshot
-func (r *repositoryType) SetSnapshot(meta *metadata.Metadata[metadata.SnapshotType]) {
+func (r *Repository) SetSnapshot(meta *metadata.Metadata[metadata.SnapshotType]) {
 	r.snapshot = meta
 }
 
 // Timestamp returns metadata of type Timestamp
-func (r *repositoryType) Timestamp() *metadata.Metadata[metadata.TimestampType] {
+func (r *Repository) Timestamp() *metadata.Metadata[metadata.TimestampType] {
 	return r.timestamp
 }
 
 // SetTimestamp sets metadata of type Timestamp
-func (r *repositoryType) SetTimestamp(meta *metadata.Metadata[metadata.TimestampType]) {
+func (r *Repository) SetTimestamp(meta *metadata.Metadata[metadata.TimestampType]) {
 	r.timestamp = meta
 }
 
 // Targets returns metadata of type Targets
-func (r *repositoryType) Targets(name string) *metadata.Metadata[metadata.TargetsType] {
+func (r *Repository) Targets(name string) *metadata.Metadata[metadata.TargetsType] {
 	return r.targets[name]
 }
 
 // SetTargets sets metadata of type Targets
-func (r *repositoryType) SetTargets(name string, meta *metadata.Metadata[metadata.TargetsType]) {
+func (r *Repository) SetTargets(name string, meta *metadata.Metadata[metadata.TargetsType]) {
 	r.targets[name] = meta
 }
+
+// TargetsRoles returns the sorted names of the top-level and all delegated
+// targets roles in the repository
+func (r *Repository) TargetsRoles() []string {
+	res := make([]string, 0, len(r.targets))
+	for name := range r.targets {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// AddSigner adds a signer used by Sign for roleName
+func (r *Repository) AddSigner(roleName string, signer signature.Signer) {
+	r.signers[roleName] = append(r.signers[roleName], signer)
+}
+
+// SetSignOptions sets the options of all signatures made by the
+// repository, e.g. metadata.WithSignatureRecorder to record them in a
+// transparency log
+func (r *Repository) SetSignOptions(opts ...metadata.SignOption) {
+	r.signOpts = opts
+}
+
+// SetVerifyOptions sets the verification policy the signatures are
+// checked with, e.g. metadata.WithKeylessTrustRoot for keyless signatures.
+// It should match the policy of the clients
+func (r *Repository) SetVerifyOptions(opts ...metadata.VerifyOption) {
+	r.verifyOpts = opts
+}
+
+// Signers returns the signers configured for roleName
+func (r *Repository) Signers(roleName string) []signature.Signer {
+	return r.signers[roleName]
+}
+
+// AddTarget adds a target file with the given content to the targets role
+// roleName. The content is kept so it is written together with the metadata
+func (r *Repository) AddTarget(roleName, targetPath string, data []byte, hashAlgorithms ...string) (*metadata.TargetFiles, error) {
+	targets, ok := r.targets[roleName]
+	if !ok {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("no targets metadata found for %s", roleName)}
+	}
+	targetFile, err := metadata.TargetFile().FromBytes(targetPath, data, hashAlgorithms...)
+	if err != nil {
+		return nil, err
+	}
+	targets.Signed.Targets[targetPath] = targetFile
+	r.SetTargetData(roleName, targetPath, data)
+	log.Debugf("Added target file %s to %s", targetPath, roleName)
+	return targetFile, nil
+}
+
+// RemoveTarget removes a target file from the targets role roleName
+func (r *Repository) RemoveTarget(roleName, targetPath string) error {
+	targets, ok := r.targets[roleName]
+	if !ok {
+		return metadata.ErrValue{Msg: fmt.Sprintf("no targets metadata found for %s", roleName)}
+	}
+	if _, ok := targets.Signed.Targets[targetPath]; !ok {
+		return metadata.ErrValue{Msg: fmt.Sprintf("target file %s not found in %s", targetPath, roleName)}
+	}
+	delete(targets.Signed.Targets, targetPath)
+	delete(r.targetData[roleName], targetPath)
+	log.Debugf("Removed target file %s from %s", targetPath, roleName)
+	return nil
+}
+
+// TargetData returns the content of a target file added to roleName with
+// AddTarget
+func (r *Repository) TargetData(roleName, targetPath string) ([]byte, bool) {
+	data, ok := r.targetData[roleName][targetPath]
+	return data, ok
+}
+
+// SetTargetData sets the content of a target file of roleName, e.g. of one
+// added in an earlier session, so it is written by Write. The metadata is
+// not changed
+func (r *Repository) SetTargetData(roleName, targetPath string, data []byte) {
+	if r.targetData[roleName] == nil {
+		r.targetData[roleName] = map[string][]byte{}
+	}
+	r.targetData[roleName][targetPath] = append([]byte{}, data...)
+}
+
+// BumpVersion increments the version of roleName and returns the new one.
+// The signatures of the previous version are removed
+func (r *Repository) BumpVersion(roleName string) (int64, error) {
+	switch roleName {
+	case metadata.ROOT:
+		if r.root == nil {
+			break
+		}
+		r.root.Signed.Version++
+		r.root.ClearSignatures()
+		return r.root.Signed.Version, nil
+	case metadata.SNAPSHOT:
+		if r.snapshot == nil {
+			break
+		}
+		r.snapshot.Signed.Version++
+		r.snapshot.ClearSignatures()
+		return r.snapshot.Signed.Version, nil
+	case metadata.TIMESTAMP:
+		if r.timestamp == nil {
+			break
+		}
+		r.timestamp.Signed.Version++
+		r.timestamp.ClearSignatures()
+		return r.timestamp.Signed.Version, nil
+	default:
+		if targets, ok := r.targets[roleName]; ok {
+			targets.Signed.Version++
+			targets.ClearSignatures()
+			return targets.Signed.Version, nil
+		}
+	}
+	return 0, metadata.ErrValue{Msg: fmt.Sprintf("no metadata found for %s", roleName)}
+}
+
+// SetGenerateOptions sets the options used by UpdateSnapshot and
+// UpdateTimestamp
+func (r *Repository) SetGenerateOptions(opts GenerateOptions) {
+	r.opts = opts
+}
+
+// UpdateSnapshot regenerates the snapshot from the current state of all
+// targets roles with GenerateSnapshot. The targets metadata should be signed
+// before calling it as the length and hashes are calculated over the signed
+// metadata. Returns whether the snapshot changed
+func (r *Repository) UpdateSnapshot() (bool, error) {
+	snapshot, changed, err := GenerateSnapshot(r.snapshot, r.targets, r.opts)
+	if err != nil {
+		return false, err
+	}
+	r.snapshot = snapshot
+	return changed, nil
+}
+
+// UpdateTimestamp regenerates the timestamp from the current snapshot with
+// GenerateTimestamp. The snapshot metadata should be signed before calling
+// it. Returns whether the timestamp changed
+func (r *Repository) UpdateTimestamp() (bool, error) {
+	timestamp, changed, err := GenerateTimestamp(r.timestamp, r.snapshot, r.opts)
+	if err != nil {
+		return false, err
+	}
+	r.timestamp = timestamp
+	return changed, nil
+}
+
+// Sign replaces the signatures of roleName with new ones from each of its
+// configured signers
+func (r *Repository) Sign(roleName string) error {
+	signers := r.signers[roleName]
+	if len(signers) == 0 {
+		return metadata.ErrValue{Msg: fmt.Sprintf("no signers configured for %s", roleName)}
+	}
+	sign := func(clear func(), sign func(signature.Signer, ...metadata.SignOption) (*metadata.Signature, error)) error {
+		clear()
+		for _, signer := range signers {
+			if _, err := sign(signer, r.signOpts...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch roleName {
+	case metadata.ROOT:
+		if r.root != nil {
+			return sign(r.root.ClearSignatures, r.root.Sign)
+		}
+	case metadata.SNAPSHOT:
+		if r.snapshot != nil {
+			return sign(r.snapshot.ClearSignatures, r.snapshot.Sign)
+		}
+	case metadata.TIMESTAMP:
+		if r.timestamp != nil {
+			return sign(r.timestamp.ClearSignatures, r.timestamp.Sign)
+		}
+	default:
+		if targets, ok := r.targets[roleName]; ok {
+			return sign(targets.ClearSignatures, targets.Sign)
+		}
+	}
+	return metadata.ErrValue{Msg: fmt.Sprintf("no metadata found for %s", roleName)}
+}
+
+// Publish is the usual sequence after changing targets metadata. It signs
+// the given targets roles, regenerates and signs snapshot and then
+// timestamp. A targets role which was signed before gets a new version
+// first. Snapshot and timestamp are signed only if they changed or were
+// never signed before
+func (r *Repository) Publish(targetsRoles ...string) error {
+	for _, name := range targetsRoles {
+		// only a published, i.e. signed, version needs a new version number
+		if targets, ok := r.targets[name]; ok && len(targets.Signatures) > 0 {
+			targets.Signed.Version++
+		}
+		if err := r.Sign(name); err != nil {
+			return err
+		}
+	}
+	changed, err := r.UpdateSnapshot()
+	if err != nil {
+		return err
+	}
+	if changed || len(r.snapshot.Signatures) == 0 {
+		if err := r.Sign(metadata.SNAPSHOT); err != nil {
+			return err
+		}
+	}
+	changed, err = r.UpdateTimestamp()
+	if err != nil {
+		return err
+	}
+	if changed || len(r.timestamp.Signatures) == 0 {
+		return r.Sign(metadata.TIMESTAMP)
+	}
+	return nil
+}
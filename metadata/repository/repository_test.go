@@ -0,0 +1,217 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/updater"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperNewRepository creates a repository with one ed25519 signer per
+// top-level role and signed root
+func helperNewRepository(t *testing.T) *Repository {
+	expires := time.Now().AddDate(0, 0, 7).UTC()
+	repo := New()
+	repo.SetRoot(metadata.Root(expires))
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(expires))
+	repo.SetSnapshot(metadata.Snapshot(expires))
+	repo.SetTimestamp(metadata.Timestamp(expires))
+	for _, name := range []string{metadata.ROOT, metadata.TARGETS, metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		_, private, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		key, err := metadata.KeyFromPublicKey(private.Public())
+		assert.NoError(t, err)
+		assert.NoError(t, repo.Root().Signed.AddKey(key, name))
+		signer, err := signature.LoadSigner(private, crypto.Hash(0))
+		assert.NoError(t, err)
+		repo.AddSigner(name, signer)
+	}
+	assert.NoError(t, repo.Sign(metadata.ROOT))
+	return repo
+}
+
+// helperRefresh runs the client workflow against the repository in dir
+// and downloads targetPath
+func helperRefresh(t *testing.T, dir string, targetPath string) ([]byte, error) {
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+	rootBytes, err := os.ReadFile(filepath.Join(dir, MetadataDir, "1.root.json"))
+	assert.NoError(t, err)
+	cfg, err := config.New(srv.URL+"/"+MetadataDir, rootBytes)
+	assert.NoError(t, err)
+	cfg.DisableLocalCache = true
+	up, err := updater.New(cfg)
+	assert.NoError(t, err)
+	err = up.Refresh()
+	if err != nil {
+		return nil, err
+	}
+	targetFile, err := up.GetTargetInfo(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := up.DownloadTarget(targetFile, "", srv.URL+"/"+TargetsDir)
+	return data, err
+}
+
+func TestRepositoryPublishAndWrite(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+
+	_, err := repo.AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	_, err = repo.AddTarget(metadata.TARGETS, "files/removed.txt", []byte("removed"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.RemoveTarget(metadata.TARGETS, "files/removed.txt"))
+	assert.ErrorIs(t, repo.RemoveTarget(metadata.TARGETS, "files/removed.txt"), metadata.ErrValue{Msg: "target file files/removed.txt not found in targets"})
+
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+
+	assert.Equal(t, int64(1), repo.Snapshot().Signed.Version)
+	assert.Equal(t, int64(1), repo.Snapshot().Signed.Meta["targets.json"].Version)
+	assert.FileExists(t, filepath.Join(dir, MetadataDir, "1.targets.json"))
+	assert.FileExists(t, filepath.Join(dir, MetadataDir, "timestamp.json"))
+	assert.NoFileExists(t, filepath.Join(dir, TargetsDir, "files", "removed.txt"))
+
+	data, err := helperRefresh(t, dir, "files/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// a second release
+	_, err = repo.AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello again"))
+	assert.NoError(t, err)
+	version, err := repo.BumpVersion(metadata.TARGETS)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+	assert.FileExists(t, filepath.Join(dir, MetadataDir, "2.snapshot.json"))
+
+	data, err = helperRefresh(t, dir, "files/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello again", string(data))
+}
+
+func TestRepositorySignWithoutSigners(t *testing.T) {
+	repo := New()
+	repo.SetTargets("unsigned", metadata.Targets())
+	assert.ErrorIs(t, repo.Sign("unsigned"), metadata.ErrValue{Msg: "no signers configured for unsigned"})
+	_, err := repo.BumpVersion("missing")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "no metadata found for missing"})
+}
+
+func TestRepositoryPublishBumpsVersion(t *testing.T) {
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.Equal(t, int64(1), repo.Targets(metadata.TARGETS).Signed.Version)
+
+	// the signed version is published, a change needs a new one
+	_, err := repo.AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.Equal(t, int64(2), repo.Targets(metadata.TARGETS).Signed.Version)
+	assert.Equal(t, int64(2), repo.Snapshot().Signed.Meta["targets.json"].Version)
+
+	// an explicitly bumped version is not bumped again
+	_, err = repo.BumpVersion(metadata.TARGETS)
+	assert.NoError(t, err)
+	assert.Empty(t, repo.Targets(metadata.TARGETS).Signatures)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.Equal(t, int64(3), repo.Targets(metadata.TARGETS).Signed.Version)
+}
+
+func TestRepositoryWriteExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	_, err := repo.AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+
+	// published versions are kept as they are, even if serialized differently
+	targetsName := filepath.Join(dir, MetadataDir, "1.targets.json")
+	indented, err := repo.Targets(metadata.TARGETS).ToBytes(true)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(targetsName, indented, 0644))
+	assert.NoError(t, repo.Write(dir))
+	data, err := os.ReadFile(targetsName)
+	assert.NoError(t, err)
+	assert.Equal(t, indented, data)
+
+	// a changed role which keeps its version is refused
+	repo.Targets(metadata.TARGETS).Signed.Expires = repo.Targets(metadata.TARGETS).Signed.Expires.Add(time.Hour)
+	assert.NoError(t, repo.Sign(metadata.TARGETS))
+	assert.ErrorIs(t, repo.Write(dir), metadata.ErrRepository{Msg: "metadata/1.targets.json already exists with different content, the version of targets must be bumped"})
+
+	// so is a hash-prefixed target file with different content
+	targetFile := repo.Targets(metadata.TARGETS).Signed.Targets["files/hello.txt"]
+	name := filepath.Join(dir, TargetsDir, "files", fmt.Sprintf("%s.hello.txt", targetFile.Hashes["sha256"]))
+	assert.NoError(t, os.WriteFile(name, []byte("tampered"), 0644))
+	_, err = repo.BumpVersion(metadata.TARGETS)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.ErrorIs(t, repo.Write(dir), metadata.ErrRepository{Msg: fmt.Sprintf("targets/files/%s.hello.txt already exists with different content", targetFile.Hashes["sha256"])})
+}
+
+func TestRepositoryTargetDataByRole(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	key, signer := helperNewKey(t)
+	repo.Targets(metadata.TARGETS).Signed.Delegations = &metadata.Delegations{
+		Keys:  map[string]*metadata.Key{key.ID(): key},
+		Roles: []metadata.DelegatedRole{{Name: "delegated", KeyIDs: []string{key.ID()}, Threshold: 1, Paths: []string{"files/*"}}},
+	}
+	repo.SetTargets("delegated", metadata.Targets(repo.Targets(metadata.TARGETS).Signed.Expires))
+	repo.AddSigner("delegated", signer)
+
+	// the same path in two roles keeps the content of each
+	_, err := repo.AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	_, err = repo.AddTarget("delegated", "files/hello.txt", []byte("hello delegated"))
+	assert.NoError(t, err)
+	_, err = repo.AddTarget("delegated", "files/shared.txt", []byte("shared"))
+	assert.NoError(t, err)
+	_, err = repo.AddTarget(metadata.TARGETS, "files/shared.txt", []byte("shared"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.RemoveTarget("delegated", "files/shared.txt"))
+	data, ok := repo.TargetData(metadata.TARGETS, "files/shared.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "shared", string(data))
+	data, ok = repo.TargetData("delegated", "files/hello.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "hello delegated", string(data))
+
+	assert.NoError(t, repo.Publish(metadata.TARGETS, "delegated"))
+	assert.NoError(t, repo.Write(dir))
+	for _, role := range []string{metadata.TARGETS, "delegated"} {
+		targetFile := repo.Targets(role).Signed.Targets["files/hello.txt"]
+		assert.FileExists(t, filepath.Join(dir, TargetsDir, "files", fmt.Sprintf("%s.hello.txt", targetFile.Hashes["sha256"])))
+	}
+
+	// without consistent snapshots both roles would write the same file
+	repo.Root().Signed.ConsistentSnapshot = false
+	assert.ErrorIs(t, repo.Write(t.TempDir()), metadata.ErrValue{Msg: "target file files/hello.txt has different content in delegated and targets"})
+}
@@ -0,0 +1,518 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"github.com/sigstore/sigstore/pkg/signature"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// SigningRequest asks the holders of offline keys to sign a new version of
+// a role. It is passed around as a file, each key holder signs it with
+// Sign and the signatures are collected back into the metadata with
+// Repository.ApplySigningRequest
+type SigningRequest struct {
+	Role    string `json:"role"`
+	Version int64  `json:"version"`
+	// Payload is the canonical JSON of the signed part of the metadata,
+	// i.e. the bytes which are signed
+	Payload []byte `json:"payload"`
+	// Previous is the canonical JSON of the signed part of the previous
+	// version, empty for a new role
+	Previous []byte `json:"previous,omitempty"`
+	// Diff is a unified diff of the signed part against the previous
+	// version, or the whole signed part for a new role. It is checked
+	// against Payload and Previous when the request is loaded
+	Diff string `json:"diff"`
+	// Signers lists the key sets which have to sign
+	Signers    []RequiredSigners    `json:"signers"`
+	Signatures []metadata.Signature `json:"signatures"`
+	Created    time.Time            `json:"created"`
+	// verifyOpts are the verification policy of the repository applying
+	// the request
+	verifyOpts []metadata.VerifyOption
+}
+
+// RequiredSigners is a key set of which a threshold of keys has to sign a
+// signing request
+type RequiredSigners struct {
+	// Name describes the key set, e.g. "root v2"
+	Name      string                   `json:"name"`
+	Keys      map[string]*metadata.Key `json:"keys"`
+	Threshold int                      `json:"threshold"`
+}
+
+// SignerStatus describes how far a signing request is from meeting the
+// threshold of a key set
+type SignerStatus struct {
+	Name string `json:"name"`
+	ThresholdStatus
+}
+
+// NewSigningRequest returns a request to sign roleName as it is in the
+// repository. The diff is made against previous, e.g. the published
+// repository, which may be nil. Root has to be signed by the keys of the
+// new and, if its version changed, the previous root. Existing valid
+// signatures are kept
+func (r *Repository) NewSigningRequest(roleName string, previous *Repository) (*SigningRequest, error) {
+	meta := r.roleMetadata(roleName)
+	if meta == nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("role %s not found", roleName)}
+	}
+	signers, err := r.requiredKeySets(roleName, previous)
+	if err != nil {
+		return nil, err
+	}
+	signed, version, signatures := signedPart(meta)
+	req := &SigningRequest{
+		Role:       roleName,
+		Version:    version,
+		Signers:    signers,
+		Signatures: []metadata.Signature{},
+		Created:    time.Now().UTC(),
+	}
+	req.Payload, err = cjson.EncodeCanonical(signed)
+	if err != nil {
+		return nil, err
+	}
+	var before any
+	if previous != nil {
+		if prev := previous.roleMetadata(roleName); prev != nil {
+			before, _, _ = signedPart(prev)
+			req.Previous, err = cjson.EncodeCanonical(before)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	req.Diff, err = diff(before, signed, roleName)
+	if err != nil {
+		return nil, err
+	}
+	for _, sig := range signatures {
+		if req.required(sig.KeyID) {
+			req.Signatures = append(req.Signatures, sig)
+		}
+	}
+	// only signatures which still verify are carried over
+	req.Signatures = req.validSignatures()
+	return req, nil
+}
+
+// LoadSigningRequest reads a signing request from the file name
+func LoadSigningRequest(name string) (*SigningRequest, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	req := &SigningRequest{}
+	err = json.Unmarshal(data, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing request %s: %w", name, err)
+	}
+	if _, err := req.metadata(); err != nil {
+		return nil, fmt.Errorf("failed to parse signing request %s: %w", name, err)
+	}
+	// the diff shown to the key holders has to be the one of the payload
+	// they sign
+	if err := req.checkDiff(); err != nil {
+		return nil, fmt.Errorf("invalid signing request %s: %w", name, err)
+	}
+	return req, nil
+}
+
+// Save writes the signing request to the file name
+func (req *SigningRequest) Save(name string) error {
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// Sign signs the payload with signer, replacing a previous signature of the
+// same key. Only keys of one of the required key sets are accepted
+func (req *SigningRequest) Sign(signer signature.Signer, opts ...metadata.SignOption) error {
+	key, err := metadata.SignerKey(signer)
+	if err != nil {
+		return err
+	}
+	keyID := key.ID()
+	if !req.required(keyID) {
+		return metadata.ErrValue{Msg: fmt.Sprintf("key with ID %s is not a required signer of %s v%d", keyID, req.Role, req.Version)}
+	}
+	meta, err := req.metadata()
+	if err != nil {
+		return err
+	}
+	var sig *metadata.Signature
+	switch meta := meta.(type) {
+	case *metadata.Metadata[metadata.RootType]:
+		sig, err = meta.Sign(signer, opts...)
+	case *metadata.Metadata[metadata.TargetsType]:
+		sig, err = meta.Sign(signer, opts...)
+	case *metadata.Metadata[metadata.SnapshotType]:
+		sig, err = meta.Sign(signer, opts...)
+	case *metadata.Metadata[metadata.TimestampType]:
+		sig, err = meta.Sign(signer, opts...)
+	}
+	if err != nil {
+		return err
+	}
+	req.addSignature(*sig)
+	log.Debugf("Signed the signing request of %s v%d with key ID %s", req.Role, req.Version, keyID)
+	return nil
+}
+
+// Merge adds the signatures of other, a copy of the request signed by
+// other key holders
+func (req *SigningRequest) Merge(other *SigningRequest) error {
+	if other.Role != req.Role || other.Version != req.Version || !bytes.Equal(other.Payload, req.Payload) {
+		return metadata.ErrValue{Msg: fmt.Sprintf("signing request of %s v%d can't be merged into the one of %s v%d", other.Role, other.Version, req.Role, req.Version)}
+	}
+	for _, sig := range other.Signatures {
+		if req.required(sig.KeyID) {
+			req.addSignature(sig)
+		}
+	}
+	return nil
+}
+
+// Status returns how far the request is from meeting the threshold of each
+// required key set
+func (req *SigningRequest) Status() []SignerStatus {
+	meta, _ := req.metadata()
+	res := []SignerStatus{}
+	for _, signers := range req.Signers {
+		keyIDs := sortedKeyIDs(signers.Keys)
+		status := ThresholdStatus{Threshold: signers.Threshold, Signed: validSignatures(signers.Keys, keyIDs, meta, req.verifyOpts...), Missing: []string{}}
+		for _, keyID := range keyIDs {
+			if !slices.Contains(status.Signed, keyID) {
+				status.Missing = append(status.Missing, keyID)
+			}
+		}
+		sort.Strings(status.Signed)
+		res = append(res, SignerStatus{Name: signers.Name, ThresholdStatus: status})
+	}
+	return res
+}
+
+// Complete reports whether the thresholds of all required key sets are met
+func (req *SigningRequest) Complete() bool {
+	for _, status := range req.Status() {
+		if status.Needed() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplySigningRequest replaces the signatures of the role of a complete
+// signing request with the valid signatures of the request. The metadata
+// in the repository has to be the one the request was made for and the
+// required key sets are the ones of the repository and, for root,
+// previous, which may be nil, rather than the ones listed in the request
+func (r *Repository) ApplySigningRequest(req *SigningRequest, previous *Repository) error {
+	meta := r.roleMetadata(req.Role)
+	if meta == nil {
+		return metadata.ErrValue{Msg: fmt.Sprintf("role %s not found", req.Role)}
+	}
+	signed, version, _ := signedPart(meta)
+	payload, err := cjson.EncodeCanonical(signed)
+	if err != nil {
+		return err
+	}
+	if version != req.Version || !bytes.Equal(payload, req.Payload) {
+		return metadata.ErrValue{Msg: fmt.Sprintf("signing request of %s v%d doesn't match %s v%d in the repository", req.Role, req.Version, req.Role, version)}
+	}
+	signers, err := r.requiredKeySets(req.Role, previous)
+	if err != nil {
+		return err
+	}
+	if !keySetsEqual(signers, req.Signers) {
+		return metadata.ErrValue{Msg: fmt.Sprintf("signers of the signing request of %s v%d don't match the keys in the repository", req.Role, req.Version)}
+	}
+	// only the key sets of the repository are trusted from here on
+	trusted := *req
+	trusted.Signers = signers
+	trusted.verifyOpts = r.verifyOpts
+	for _, status := range trusted.Status() {
+		if status.Needed() > 0 {
+			return metadata.ErrUnsignedMetadata{Msg: fmt.Sprintf("%s v%d needs %d more signatures from the keys of %s", req.Role, req.Version, status.Needed(), status.Name)}
+		}
+	}
+	signatures := trusted.validSignatures()
+	switch meta := meta.(type) {
+	case *metadata.Metadata[metadata.RootType]:
+		meta.Signatures = signatures
+	case *metadata.Metadata[metadata.TargetsType]:
+		meta.Signatures = signatures
+	case *metadata.Metadata[metadata.SnapshotType]:
+		meta.Signatures = signatures
+	case *metadata.Metadata[metadata.TimestampType]:
+		meta.Signatures = signatures
+	}
+	log.Debugf("Applied the signing request of %s v%d with %d signatures", req.Role, req.Version, len(signatures))
+	return nil
+}
+
+// requiredKeySets returns the key sets which have to sign roleName, for
+// root also the root keys of previous if its version changed
+func (r *Repository) requiredKeySets(roleName string, previous *Repository) ([]RequiredSigners, error) {
+	keys, keyIDs, threshold := r.roleKeys(roleName)
+	if len(keyIDs) == 0 {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("no delegation found for %s", roleName)}
+	}
+	delegator := delegatorName(r, roleName)
+	version := r.root.Signed.Version
+	if delegator != metadata.ROOT {
+		version = r.targets[delegator].Signed.Version
+	}
+	res := []RequiredSigners{requiredSigners(fmt.Sprintf("%s v%d", delegator, version), keys, keyIDs, threshold)}
+	if roleName == metadata.ROOT && previous != nil && previous.root != nil && previous.root.Signed.Version != r.root.Signed.Version {
+		keys, keyIDs, threshold := previous.roleKeys(metadata.ROOT)
+		res = append(res, requiredSigners(fmt.Sprintf("root v%d", previous.root.Signed.Version), keys, keyIDs, threshold))
+	}
+	return res, nil
+}
+
+// keySetsEqual reports whether a and b have the same keys and thresholds
+// in the same order
+func keySetsEqual(a, b []RequiredSigners) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Threshold != b[i].Threshold || len(a[i].Keys) != len(b[i].Keys) {
+			return false
+		}
+		for keyID, key := range a[i].Keys {
+			other, ok := b[i].Keys[keyID]
+			if !ok || other == nil || other.ID() != key.ID() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// required reports whether keyID is in one of the required key sets
+func (req *SigningRequest) required(keyID string) bool {
+	for _, signers := range req.Signers {
+		if _, ok := signers.Keys[keyID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addSignature adds sig, replacing a previous signature of the same key
+func (req *SigningRequest) addSignature(sig metadata.Signature) {
+	signatures := []metadata.Signature{}
+	for _, s := range req.Signatures {
+		if s.KeyID != sig.KeyID {
+			signatures = append(signatures, s)
+		}
+	}
+	req.Signatures = append(signatures, sig)
+}
+
+// validSignatures returns the signatures which verify with the key of a
+// required key set
+func (req *SigningRequest) validSignatures() []metadata.Signature {
+	meta, _ := req.metadata()
+	res := []metadata.Signature{}
+	for _, sig := range req.Signatures {
+		for _, signers := range req.Signers {
+			if _, ok := signers.Keys[sig.KeyID]; ok && len(validSignatures(signers.Keys, []string{sig.KeyID}, meta, req.verifyOpts...)) > 0 {
+				res = append(res, sig)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// metadata returns the metadata of the payload with the signatures of the
+// request
+func (req *SigningRequest) metadata() (any, error) {
+	return decodeMetadata(req.Payload, req.Signatures)
+}
+
+// checkDiff checks that the diff is the one of Previous and Payload
+func (req *SigningRequest) checkDiff() error {
+	meta, err := req.metadata()
+	if err != nil {
+		return err
+	}
+	after, _, _ := signedPart(meta)
+	var before any
+	if len(req.Previous) > 0 {
+		prev, err := decodeMetadata(req.Previous, nil)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprintf("%T", prev) != fmt.Sprintf("%T", meta) {
+			return metadata.ErrValue{Msg: "signing request previous version is of another metadata type"}
+		}
+		before, _, _ = signedPart(prev)
+	}
+	expected, err := diff(before, after, req.Role)
+	if err != nil {
+		return err
+	}
+	if expected != req.Diff {
+		return metadata.ErrValue{Msg: "signing request diff doesn't match its payload"}
+	}
+	return nil
+}
+
+// decodeMetadata returns the metadata with the signed part payload and
+// signatures
+func decodeMetadata(payload []byte, signatures []metadata.Signature) (any, error) {
+	var header struct {
+		Type string `json:"_type"`
+	}
+	err := json.Unmarshal(payload, &header)
+	if err != nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid signing request payload: %s", err)}
+	}
+	switch header.Type {
+	case metadata.ROOT:
+		return decodePayload[metadata.RootType](payload, signatures)
+	case metadata.TARGETS:
+		return decodePayload[metadata.TargetsType](payload, signatures)
+	case metadata.SNAPSHOT:
+		return decodePayload[metadata.SnapshotType](payload, signatures)
+	case metadata.TIMESTAMP:
+		return decodePayload[metadata.TimestampType](payload, signatures)
+	}
+	return nil, metadata.ErrValue{Msg: fmt.Sprintf("unknown metadata type %q in signing request payload", header.Type)}
+}
+
+// decodePayload returns the metadata with the signed part payload. The
+// payload has to be in canonical form so signatures of the metadata are
+// signatures of the payload
+func decodePayload[T metadata.Roles](payload []byte, signatures []metadata.Signature) (*metadata.Metadata[T], error) {
+	meta := &metadata.Metadata[T]{Signatures: append([]metadata.Signature{}, signatures...)}
+	err := json.Unmarshal(payload, &meta.Signed)
+	if err != nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("invalid signing request payload: %s", err)}
+	}
+	canonical, err := cjson.EncodeCanonical(meta.Signed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(canonical, payload) {
+		return nil, metadata.ErrValue{Msg: "signing request payload is not canonical JSON"}
+	}
+	return meta, nil
+}
+
+// signedPart returns the signed part, version and signatures of meta
+func signedPart(meta any) (any, int64, []metadata.Signature) {
+	switch meta := meta.(type) {
+	case *metadata.Metadata[metadata.RootType]:
+		return meta.Signed, meta.Signed.Version, meta.Signatures
+	case *metadata.Metadata[metadata.TargetsType]:
+		return meta.Signed, meta.Signed.Version, meta.Signatures
+	case *metadata.Metadata[metadata.SnapshotType]:
+		return meta.Signed, meta.Signed.Version, meta.Signatures
+	case *metadata.Metadata[metadata.TimestampType]:
+		return meta.Signed, meta.Signed.Version, meta.Signatures
+	}
+	return nil, 0, nil
+}
+
+// requiredSigners returns the key set of keyIDs
+func requiredSigners(name string, keys map[string]*metadata.Key, keyIDs []string, threshold int) RequiredSigners {
+	res := RequiredSigners{Name: name, Keys: map[string]*metadata.Key{}, Threshold: threshold}
+	for _, keyID := range keyIDs {
+		if key, ok := keys[keyID]; ok {
+			res.Keys[keyID] = key
+		}
+	}
+	return res
+}
+
+// delegatorName returns the name of the role delegating to roleName
+func delegatorName(r *Repository, roleName string) string {
+	switch roleName {
+	case metadata.ROOT, metadata.SNAPSHOT, metadata.TIMESTAMP, metadata.TARGETS:
+		return metadata.ROOT
+	}
+	for _, name := range r.TargetsRoles() {
+		delegations := r.targets[name].Signed.Delegations
+		if delegations == nil {
+			continue
+		}
+		for _, role := range delegations.Roles {
+			if role.Name == roleName {
+				return name
+			}
+		}
+		if delegations.SuccinctRoles != nil && delegations.SuccinctRoles.IsDelegatedRole(roleName) {
+			return name
+		}
+	}
+	return ""
+}
+
+// sortedKeyIDs returns the key IDs of keys in order
+func sortedKeyIDs(keys map[string]*metadata.Key) []string {
+	res := make([]string, 0, len(keys))
+	for keyID := range keys {
+		res = append(res, keyID)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// diff returns a unified diff of the indented JSON of before and after
+func diff(before any, after any, roleName string) (string, error) {
+	lines := func(v any) ([]string, error) {
+		if v == nil {
+			return nil, nil
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return difflib.SplitLines(string(data)), nil
+	}
+	a, err := lines(before)
+	if err != nil {
+		return "", err
+	}
+	b, err := lines(after)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: "a/" + roleName,
+		ToFile:   "b/" + roleName,
+		Context:  3,
+	})
+}
@@ -0,0 +1,274 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperOfflineTargets returns the published repository and a working copy
+// in which targets is delegated to three offline keys with threshold 2 and
+// has a new target file
+func helperOfflineTargets(t *testing.T) (*Repository, *Repository, []signature.Signer) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+	published, _, err := Load(dir)
+	assert.NoError(t, err)
+	work, _, err := Load(dir)
+	assert.NoError(t, err)
+
+	root := work.Root()
+	for _, keyID := range root.Signed.Roles[metadata.TARGETS].KeyIDs {
+		assert.NoError(t, root.Signed.RevokeKey(keyID, metadata.TARGETS))
+	}
+	signers := []signature.Signer{}
+	for i := 0; i < 3; i++ {
+		key, signer := helperNewKey(t)
+		assert.NoError(t, root.Signed.AddKey(key, metadata.TARGETS))
+		signers = append(signers, signer)
+	}
+	root.Signed.Roles[metadata.TARGETS].Threshold = 2
+	_, err = work.AddTarget(metadata.TARGETS, "app.txt", []byte("app"))
+	assert.NoError(t, err)
+	_, err = work.BumpVersion(metadata.TARGETS)
+	assert.NoError(t, err)
+	return published, work, signers
+}
+
+func TestSigningRequest(t *testing.T) {
+	published, work, signers := helperOfflineTargets(t)
+	req, err := work.NewSigningRequest(metadata.TARGETS, published)
+	assert.NoError(t, err)
+	assert.Equal(t, metadata.TARGETS, req.Role)
+	assert.Equal(t, int64(2), req.Version)
+	assert.Len(t, req.Signers, 1)
+	assert.Equal(t, "root v1", req.Signers[0].Name)
+	assert.Len(t, req.Signers[0].Keys, 3)
+	assert.Empty(t, req.Signatures)
+	assert.Contains(t, req.Diff, "--- a/targets")
+	assert.Contains(t, req.Diff, `-  "version": 1`)
+	assert.Contains(t, req.Diff, `+  "version": 2`)
+	assert.Contains(t, req.Diff, `+    "app.txt": {`)
+	status := req.Status()
+	assert.Equal(t, 2, status[0].Needed())
+	assert.Len(t, status[0].Missing, 3)
+	assert.False(t, req.Complete())
+
+	// each key holder signs their copy of the request file
+	name := filepath.Join(t.TempDir(), "targets.request.json")
+	assert.NoError(t, req.Save(name))
+	copies := []*SigningRequest{}
+	for _, signer := range signers[:2] {
+		copy, err := LoadSigningRequest(name)
+		assert.NoError(t, err)
+		assert.NoError(t, copy.Sign(signer))
+		assert.Equal(t, 1, copy.Status()[0].Needed())
+		copies = append(copies, copy)
+	}
+
+	// the signatures are collected back into the metadata
+	assert.ErrorIs(t, work.ApplySigningRequest(copies[0], published), metadata.ErrUnsignedMetadata{})
+	assert.NoError(t, copies[0].Merge(copies[1]))
+	assert.True(t, copies[0].Complete())
+	assert.Len(t, copies[0].Status()[0].Signed, 2)
+	assert.NoError(t, work.ApplySigningRequest(copies[0], published))
+	assert.Len(t, work.Targets(metadata.TARGETS).Signatures, 2)
+	assert.NoError(t, work.Root().VerifyDelegate(metadata.TARGETS, work.Targets(metadata.TARGETS)))
+}
+
+func TestSigningRequestDelegatedRole(t *testing.T) {
+	repo := helperNewRepository(t)
+	key, signer := helperNewKey(t)
+	expires := repo.Targets(metadata.TARGETS).Signed.Expires
+	role := metadata.DelegatedRole{Name: "delegated", Threshold: 1, Paths: []string{"delegated/*"}}
+	assert.NoError(t, repo.Targets(metadata.TARGETS).Signed.AddDelegatedRole(role, key))
+	repo.SetTargets("delegated", metadata.Targets(expires))
+	repo.AddSigner("delegated", signer)
+	assert.NoError(t, repo.Publish(metadata.TARGETS, "delegated"))
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+
+	// the key set is named after the version of the delegating role
+	req, err := repo.NewSigningRequest("delegated", nil)
+	assert.NoError(t, err)
+	assert.Len(t, req.Signers, 1)
+	assert.Equal(t, "targets v2", req.Signers[0].Name)
+	assert.Contains(t, req.Signers[0].Keys, key.ID())
+}
+
+func TestSigningRequestSign(t *testing.T) {
+	published, work, signers := helperOfflineTargets(t)
+	req, err := work.NewSigningRequest(metadata.TARGETS, published)
+	assert.NoError(t, err)
+
+	// only required keys may sign
+	_, other := helperNewKey(t)
+	assert.ErrorContains(t, req.Sign(other), "is not a required signer of targets v2")
+	assert.Empty(t, req.Signatures)
+
+	// signing twice replaces the signature
+	assert.NoError(t, req.Sign(signers[0]))
+	assert.NoError(t, req.Sign(signers[0]))
+	assert.Len(t, req.Signatures, 1)
+
+	// signatures of other requests are not merged
+	otherReq, err := published.NewSigningRequest(metadata.TARGETS, nil)
+	assert.NoError(t, err)
+	assert.Error(t, req.Merge(otherReq))
+
+	// invalid signatures don't count
+	req.Signatures[0].Signature[0] ^= 0xff
+	assert.Equal(t, 2, req.Status()[0].Needed())
+	assert.NoError(t, req.Sign(signers[1]))
+	assert.NoError(t, req.Sign(signers[2]))
+	assert.True(t, req.Complete())
+	assert.NoError(t, work.ApplySigningRequest(req, published))
+	assert.Len(t, work.Targets(metadata.TARGETS).Signatures, 2)
+}
+
+func TestSigningRequestStale(t *testing.T) {
+	published, work, signers := helperOfflineTargets(t)
+	req, err := work.NewSigningRequest(metadata.TARGETS, published)
+	assert.NoError(t, err)
+	assert.NoError(t, req.Sign(signers[0]))
+	assert.NoError(t, req.Sign(signers[1]))
+
+	// the metadata changed after the request was made
+	signatures := work.Targets(metadata.TARGETS).Signatures
+	_, err = work.AddTarget(metadata.TARGETS, "other.txt", []byte("other"))
+	assert.NoError(t, err)
+	assert.ErrorContains(t, work.ApplySigningRequest(req, published), "doesn't match targets v2 in the repository")
+	assert.Equal(t, signatures, work.Targets(metadata.TARGETS).Signatures)
+
+	_, err = work.NewSigningRequest("unknown", published)
+	assert.ErrorContains(t, err, "role unknown not found")
+}
+
+func TestSigningRequestRoot(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+	published, _, err := Load(dir)
+	assert.NoError(t, err)
+	oldKeyID := repo.Root().Signed.Roles[metadata.ROOT].KeyIDs[0]
+	oldSigner := repo.Signers(metadata.ROOT)[0]
+
+	// root v2 is signed by the root keys of v1 and v2
+	key, signer := helperNewKey(t)
+	rotation, err := RotateRoot(repo.Root(), map[string]RoleKeys{metadata.ROOT: {Keys: []*metadata.Key{key}, Threshold: 1}}, time.Now().AddDate(1, 0, 0))
+	assert.NoError(t, err)
+	repo.SetRoot(rotation.Next())
+	req, err := repo.NewSigningRequest(metadata.ROOT, published)
+	assert.NoError(t, err)
+	assert.Len(t, req.Signers, 2)
+	assert.Equal(t, "root v2", req.Signers[0].Name)
+	assert.Equal(t, "root v1", req.Signers[1].Name)
+	assert.Contains(t, req.Signers[1].Keys, oldKeyID)
+	name := filepath.Join(t.TempDir(), "root.request.json")
+	assert.NoError(t, req.Save(name))
+	req, err = LoadSigningRequest(name)
+	assert.NoError(t, err)
+
+	assert.NoError(t, req.Sign(signer))
+	assert.False(t, req.Complete())
+	assert.ErrorContains(t, repo.ApplySigningRequest(req, published), "needs 1 more signatures from the keys of root v1")
+	assert.NoError(t, req.Sign(oldSigner))
+	assert.True(t, req.Complete())
+	assert.NoError(t, repo.ApplySigningRequest(req, published))
+	assert.NoError(t, published.Root().VerifyDelegate(metadata.ROOT, repo.Root()))
+	assert.NoError(t, repo.Root().VerifyDelegate(metadata.ROOT, repo.Root()))
+
+	// a request for the current signatures keeps them
+	again, err := repo.NewSigningRequest(metadata.ROOT, published)
+	assert.NoError(t, err)
+	assert.Len(t, again.Signatures, 2)
+	assert.True(t, again.Complete())
+}
+
+func TestSigningRequestForgedSigners(t *testing.T) {
+	published, work, signers := helperOfflineTargets(t)
+	req, err := work.NewSigningRequest(metadata.TARGETS, published)
+	assert.NoError(t, err)
+	assert.NoError(t, req.Sign(signers[0]))
+
+	// a request file listing another key with threshold 1 is complete on
+	// its own but its signers are not the ones of the repository
+	key, other := helperNewKey(t)
+	req.Signers[0].Keys[key.ID()] = key
+	req.Signers[0].Threshold = 1
+	assert.NoError(t, req.Sign(other))
+	assert.True(t, req.Complete())
+	signatures := work.Targets(metadata.TARGETS).Signatures
+	assert.ErrorContains(t, work.ApplySigningRequest(req, published), "don't match the keys in the repository")
+	assert.Equal(t, signatures, work.Targets(metadata.TARGETS).Signatures)
+
+	// so is a request for root leaving out the keys of the previous root
+	repo := helperNewRepository(t)
+	dir := t.TempDir()
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+	previous, _, err := Load(dir)
+	assert.NoError(t, err)
+	rootKey, rootSigner := helperNewKey(t)
+	rotation, err := RotateRoot(repo.Root(), map[string]RoleKeys{metadata.ROOT: {Keys: []*metadata.Key{rootKey}, Threshold: 1}}, time.Now().AddDate(1, 0, 0))
+	assert.NoError(t, err)
+	repo.SetRoot(rotation.Next())
+	rootReq, err := repo.NewSigningRequest(metadata.ROOT, previous)
+	assert.NoError(t, err)
+	rootReq.Signers = rootReq.Signers[:1]
+	assert.NoError(t, rootReq.Sign(rootSigner))
+	assert.True(t, rootReq.Complete())
+	assert.ErrorContains(t, repo.ApplySigningRequest(rootReq, previous), "don't match the keys in the repository")
+}
+
+func TestLoadSigningRequest(t *testing.T) {
+	published, work, _ := helperOfflineTargets(t)
+	req, err := work.NewSigningRequest(metadata.TARGETS, published)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+
+	// the payload has to be canonical
+	req.Payload = append(req.Payload, ' ')
+	assert.NoError(t, req.Save(filepath.Join(dir, "spaces.json")))
+	_, err = LoadSigningRequest(filepath.Join(dir, "spaces.json"))
+	assert.ErrorContains(t, err, "not canonical JSON")
+
+	req.Payload = []byte(`{"_type":"unknown"}`)
+	assert.NoError(t, req.Save(filepath.Join(dir, "unknown.json")))
+	_, err = LoadSigningRequest(filepath.Join(dir, "unknown.json"))
+	assert.ErrorContains(t, err, `unknown metadata type "unknown"`)
+
+	// the diff has to be the one of the payload
+	req, err = work.NewSigningRequest(metadata.TARGETS, published)
+	assert.NoError(t, err)
+	assert.NoError(t, req.Save(filepath.Join(dir, "valid.json")))
+	_, err = LoadSigningRequest(filepath.Join(dir, "valid.json"))
+	assert.NoError(t, err)
+	req.Diff = strings.ReplaceAll(req.Diff, "app.txt", "docs.txt")
+	assert.NoError(t, req.Save(filepath.Join(dir, "diff.json")))
+	_, err = LoadSigningRequest(filepath.Join(dir, "diff.json"))
+	assert.ErrorContains(t, err, "diff doesn't match its payload")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "invalid.json"), []byte("{"), 0644))
+	_, err = LoadSigningRequest(filepath.Join(dir, "invalid.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// RoleKeys is the desired key set and threshold of a top-level role
+type RoleKeys struct {
+	Keys      []*metadata.Key
+	Threshold int
+}
+
+// ThresholdStatus describes how far a root is from meeting a threshold
+type ThresholdStatus struct {
+	Threshold int `json:"threshold"`
+	// Signed lists the key IDs with a valid signature
+	Signed []string `json:"signed"`
+	// Missing lists the key IDs which can still sign
+	Missing []string `json:"missing"`
+}
+
+// Needed returns the number of signatures still required
+func (s ThresholdStatus) Needed() int {
+	if len(s.Signed) >= s.Threshold {
+		return 0
+	}
+	return s.Threshold - len(s.Signed)
+}
+
+// RotationStatus describes which signatures a root rotation still needs.
+// The new root must be signed by the threshold of the current root so
+// clients trust it and by its own threshold so it is self-consistent
+type RotationStatus struct {
+	Old ThresholdStatus `json:"old"`
+	New ThresholdStatus `json:"new"`
+}
+
+// Complete reports whether both thresholds are met
+func (s *RotationStatus) Complete() bool {
+	return s.Old.Needed() == 0 && s.New.Needed() == 0
+}
+
+// RootRotation rotates the keys of a root from version N to N+1
+type RootRotation struct {
+	current *metadata.Metadata[metadata.RootType]
+	next    *metadata.Metadata[metadata.RootType]
+	// verifyOpts are the verification policy of the repository
+	verifyOpts []metadata.VerifyOption
+}
+
+// RotateRoot starts a rotation of current to the key sets in roles, keyed by
+// top-level role name. Roles which are not listed keep their keys and keys no
+// longer used by any role are removed. The new root has version N+1, expires
+// at expires or keeps the current expiration date if zero, and is unsigned
+func RotateRoot(current *metadata.Metadata[metadata.RootType], roles map[string]RoleKeys, expires time.Time) (*RootRotation, error) {
+	if current == nil {
+		return nil, metadata.ErrValue{Msg: "no root metadata found"}
+	}
+	next, err := clone(current)
+	if err != nil {
+		return nil, err
+	}
+	for name, roleKeys := range roles {
+		if _, ok := next.Signed.Roles[name]; !ok {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("role %s doesn't exist", name)}
+		}
+		keyIDs := []string{}
+		for _, key := range roleKeys.Keys {
+			if !slices.Contains(keyIDs, key.ID()) {
+				keyIDs = append(keyIDs, key.ID())
+			}
+			next.Signed.Keys[key.ID()] = key
+		}
+		if roleKeys.Threshold < 1 || roleKeys.Threshold > len(keyIDs) {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("threshold of %s must be between 1 and %d, got %d", name, len(keyIDs), roleKeys.Threshold)}
+		}
+		next.Signed.Roles[name].KeyIDs = keyIDs
+		next.Signed.Roles[name].Threshold = roleKeys.Threshold
+	}
+	// drop the keys which are no longer used by any role
+	for keyID := range next.Signed.Keys {
+		used := false
+		for _, role := range next.Signed.Roles {
+			if slices.Contains(role.KeyIDs, keyID) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			delete(next.Signed.Keys, keyID)
+		}
+	}
+	next.Signed.Version = current.Signed.Version + 1
+	if !expires.IsZero() {
+		next.Signed.Expires = expires
+	}
+	log.Debugf("Started rotation of root v%d to v%d", current.Signed.Version, next.Signed.Version)
+	return &RootRotation{current: current, next: next}, nil
+}
+
+// Current returns the root which is rotated
+func (r *RootRotation) Current() *metadata.Metadata[metadata.RootType] {
+	return r.current
+}
+
+// Next returns the new root. It must not be modified once signing started
+func (r *RootRotation) Next() *metadata.Metadata[metadata.RootType] {
+	return r.next
+}
+
+// Sign signs the new root with signer, replacing a previous signature of
+// the same key. Only keys of the root role of the current or the new root
+// are accepted as other signatures don't count towards any threshold
+func (r *RootRotation) Sign(signer signature.Signer, opts ...metadata.SignOption) error {
+	key, err := metadata.SignerKey(signer)
+	if err != nil {
+		return err
+	}
+	keyID := key.ID()
+	if !slices.Contains(r.current.Signed.Roles[metadata.ROOT].KeyIDs, keyID) && !slices.Contains(r.next.Signed.Roles[metadata.ROOT].KeyIDs, keyID) {
+		return metadata.ErrValue{Msg: fmt.Sprintf("key with ID %s is neither a current nor a new root key", keyID)}
+	}
+	signatures := []metadata.Signature{}
+	for _, sig := range r.next.Signatures {
+		if sig.KeyID != keyID {
+			signatures = append(signatures, sig)
+		}
+	}
+	r.next.Signatures = signatures
+	_, err = r.next.Sign(signer, opts...)
+	return err
+}
+
+// Status returns which old and new root keys still need to sign
+func (r *RootRotation) Status() *RotationStatus {
+	return &RotationStatus{
+		Old: thresholdStatus(r.current, r.next, r.verifyOpts...),
+		New: thresholdStatus(r.next, r.next, r.verifyOpts...),
+	}
+}
+
+// Finalize returns the new root once clients following
+// TrustedMetadata.UpdateRoot from the current root would accept it and it
+// isn't expired. The result can be stored with Repository.SetRoot
+func (r *RootRotation) Finalize() (*metadata.Metadata[metadata.RootType], error) {
+	status := r.Status()
+	if !status.Complete() {
+		return nil, metadata.ErrUnsignedMetadata{Msg: fmt.Sprintf("root v%d needs %d more signatures from the current and %d from the new root keys", r.next.Signed.Version, status.Old.Needed(), status.New.Needed())}
+	}
+	currentData, err := r.current.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	nextData, err := r.next.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := trustedmetadata.New(currentData, r.verifyOpts...)
+	if err != nil {
+		return nil, err
+	}
+	next, err := trusted.UpdateRoot(nextData)
+	if err != nil {
+		return nil, err
+	}
+	if next.Signed.IsExpired(time.Now().UTC()) {
+		return nil, metadata.ErrExpiredMetadata{Msg: fmt.Sprintf("root v%d is expired", next.Signed.Version)}
+	}
+	log.Debugf("Finalized rotation of root v%d to v%d", r.current.Signed.Version, next.Signed.Version)
+	return next, nil
+}
+
+// RotateRoot starts a rotation of the repository root and signs it with
+// the configured root signers which belong to the current or new root. Any
+// new root signers should be added with AddSigner as well so the new root
+// can be signed with Sign later on
+func (r *Repository) RotateRoot(roles map[string]RoleKeys, expires time.Time) (*RootRotation, error) {
+	rotation, err := RotateRoot(r.root, roles, expires)
+	if err != nil {
+		return nil, err
+	}
+	rotation.verifyOpts = r.verifyOpts
+	for _, signer := range r.signers[metadata.ROOT] {
+		err := rotation.Sign(signer, r.signOpts...)
+		if err != nil {
+			log.Debugf("Skipped root signer: %v", err)
+		}
+	}
+	return rotation, nil
+}
+
+// thresholdStatus returns how far root is from meeting the root threshold
+// of delegator. Each key is checked on its own with VerifyDelegate so a
+// signature counts exactly when clients would count it
+func thresholdStatus(delegator, root *metadata.Metadata[metadata.RootType], opts ...metadata.VerifyOption) ThresholdStatus {
+	role := delegator.Signed.Roles[metadata.ROOT]
+	status := ThresholdStatus{Threshold: role.Threshold, Signed: validSignatures(delegator.Signed.Keys, role.KeyIDs, root, opts...), Missing: []string{}}
+	for _, keyID := range role.KeyIDs {
+		if !slices.Contains(status.Signed, keyID) {
+			status.Missing = append(status.Missing, keyID)
+		}
+	}
+	sort.Strings(status.Signed)
+	sort.Strings(status.Missing)
+	return status
+}
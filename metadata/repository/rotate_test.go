@@ -0,0 +1,91 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperNewKey returns a new ed25519 key and its signer
+func helperNewKey(t *testing.T) (*metadata.Key, signature.Signer) {
+	_, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	key, err := metadata.KeyFromPublicKey(private.Public())
+	assert.NoError(t, err)
+	signer, err := signature.LoadSigner(private, crypto.Hash(0))
+	assert.NoError(t, err)
+	return key, signer
+}
+
+func TestRotateRoot(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+	oldKeyID := repo.Root().Signed.Roles[metadata.ROOT].KeyIDs[0]
+
+	key1, signer1 := helperNewKey(t)
+	key2, signer2 := helperNewKey(t)
+	rotation, err := repo.RotateRoot(map[string]RoleKeys{
+		metadata.ROOT: {Keys: []*metadata.Key{key1, key2}, Threshold: 2},
+	}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rotation.Next().Signed.Version)
+	assert.NotContains(t, rotation.Next().Signed.Keys, oldKeyID)
+
+	// the current root key signed already, both new keys are missing
+	status := rotation.Status()
+	assert.Equal(t, []string{oldKeyID}, status.Old.Signed)
+	assert.Equal(t, 2, status.New.Needed())
+	assert.False(t, status.Complete())
+	_, err = rotation.Finalize()
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{Msg: "root v2 needs 0 more signatures from the current and 2 from the new root keys"})
+
+	_, other := helperNewKey(t)
+	assert.ErrorContains(t, rotation.Sign(other), "is neither a current nor a new root key")
+	assert.NoError(t, rotation.Sign(signer1))
+	assert.NoError(t, rotation.Sign(signer1))
+	assert.Equal(t, 1, rotation.Status().New.Needed())
+	assert.NoError(t, rotation.Sign(signer2))
+	assert.True(t, rotation.Status().Complete())
+
+	root, err := rotation.Finalize()
+	assert.NoError(t, err)
+	repo.SetRoot(root)
+	assert.NoError(t, repo.Write(dir))
+	report, err := Verify(dir, time.Time{})
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+}
+
+func TestRotateRootInvalid(t *testing.T) {
+	repo := helperNewRepository(t)
+	key, _ := helperNewKey(t)
+	_, err := repo.RotateRoot(map[string]RoleKeys{"mirror": {Keys: []*metadata.Key{key}, Threshold: 1}}, time.Time{})
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "role mirror doesn't exist"})
+	_, err = repo.RotateRoot(map[string]RoleKeys{metadata.TIMESTAMP: {Keys: []*metadata.Key{key}, Threshold: 2}}, time.Time{})
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "threshold of timestamp must be between 1 and 1, got 2"})
+
+	// an expired root is never accepted by clients
+	rotation, err := repo.RotateRoot(map[string]RoleKeys{metadata.TIMESTAMP: {Keys: []*metadata.Key{key}, Threshold: 1}}, time.Now().AddDate(0, 0, -1))
+	assert.NoError(t, err)
+	assert.True(t, rotation.Status().Complete())
+	_, err = rotation.Finalize()
+	assert.ErrorIs(t, err, metadata.ErrExpiredMetadata{Msg: "root v2 is expired"})
+}
@@ -0,0 +1,623 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing/fstest"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// Stage is a working copy of a published repository. Changes are made to
+// the working copy, kept in a staging directory between sessions and only
+// become visible to clients once they are committed
+type Stage struct {
+	dir       string
+	stagedDir string
+	// repo is the published repository with the staged changes applied
+	repo *Repository
+	// published is the published repository, nil if nothing was committed yet
+	published *Repository
+	// staged lists the roles with staged metadata
+	staged map[string]bool
+}
+
+// RoleStatus describes the state of a role in the working copy
+type RoleStatus struct {
+	Role string `json:"role"`
+	// Version is the version in the working copy
+	Version int64 `json:"version"`
+	// PublishedVersion is the published version, 0 if it's not published
+	PublishedVersion int64 `json:"published_version"`
+	// Staged is true if the role has changes which are not committed
+	Staged bool `json:"staged"`
+	// Signatures is the number of valid signatures by keys of the role
+	Signatures int `json:"signatures"`
+	// Threshold is the number of signatures required by the delegator
+	Threshold int       `json:"threshold"`
+	Expires   time.Time `json:"expires"`
+	// Problems lists what prevents committing the role
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OpenStage opens the published repository in dir with the changes staged
+// in stagedDir. The working copy is empty if neither exists yet
+func OpenStage(dir, stagedDir string) (*Stage, error) {
+	s := &Stage{
+		dir:       dir,
+		stagedDir: stagedDir,
+		repo:      New(),
+		staged:    map[string]bool{},
+	}
+	if _, err := os.Stat(filepath.Join(dir, MetadataDir)); err == nil {
+		published, report, err := Load(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !report.OK() {
+			log.Warnf("The published repository in %s has missing, orphaned or mismatching files", dir)
+		}
+		s.published = published
+		// a second copy is used as the working copy
+		s.repo, _, err = Load(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Repository returns the working copy
+func (s *Stage) Repository() *Repository {
+	return s.repo
+}
+
+// Published returns the published repository, nil if nothing was committed
+func (s *Stage) Published() *Repository {
+	return s.published
+}
+
+// IsStaged reports whether roleName has staged changes
+func (s *Stage) IsStaged(roleName string) bool {
+	return s.staged[roleName]
+}
+
+// Staged returns the names of the roles with staged changes
+func (s *Stage) Staged() []string {
+	res := make([]string, 0, len(s.staged))
+	for name := range s.staged {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// SetStaged marks roleName as changed without modifying it, e.g. after
+// adding its metadata to the working copy or signing it
+func (s *Stage) SetStaged(roleName string) {
+	s.staged[roleName] = true
+}
+
+// MarkChanged stages roleName and removes its signatures as its content is
+// about to change. If its current version was published already, the
+// version is bumped and the expiration date is set to expires
+func (s *Stage) MarkChanged(roleName string, expires time.Time) error {
+	version, _ := roleState(s.repo, roleName)
+	if version == 0 {
+		return metadata.ErrValue{Msg: fmt.Sprintf("no metadata found for %s", roleName)}
+	}
+	bump := false
+	if s.published != nil {
+		publishedVersion, _ := roleState(s.published, roleName)
+		bump = publishedVersion >= version
+	}
+	update := func(version *int64, expiry *time.Time, clear func()) {
+		if bump {
+			*version++
+			*expiry = expires
+		}
+		clear()
+	}
+	switch roleName {
+	case metadata.ROOT:
+		update(&s.repo.root.Signed.Version, &s.repo.root.Signed.Expires, s.repo.root.ClearSignatures)
+	case metadata.SNAPSHOT:
+		update(&s.repo.snapshot.Signed.Version, &s.repo.snapshot.Signed.Expires, s.repo.snapshot.ClearSignatures)
+	case metadata.TIMESTAMP:
+		update(&s.repo.timestamp.Signed.Version, &s.repo.timestamp.Signed.Expires, s.repo.timestamp.ClearSignatures)
+	default:
+		targets := s.repo.targets[roleName]
+		update(&targets.Signed.Version, &targets.Signed.Expires, targets.ClearSignatures)
+	}
+	s.staged[roleName] = true
+	return nil
+}
+
+// Status returns the state of every role in the working copy
+func (s *Stage) Status() []RoleStatus {
+	res := []RoleStatus{}
+	if s.repo.root == nil {
+		return res
+	}
+	roles := []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT}
+	for _, name := range append(roles, s.repo.TargetsRoles()...) {
+		status := RoleStatus{Role: name, Staged: s.staged[name]}
+		status.Version, _ = roleState(s.repo, name)
+		if s.published != nil {
+			status.PublishedVersion, _ = roleState(s.published, name)
+		}
+		status.Expires = roleExpires(s.repo, name)
+		status.Signatures, status.Threshold = s.repo.signatureStatus(name)
+		if status.Staged {
+			status.Problems = s.problems(status)
+		}
+		res = append(res, status)
+	}
+	return res
+}
+
+// problems returns what prevents committing a staged role
+func (s *Stage) problems(status RoleStatus) []string {
+	res := []string{}
+	if status.Threshold == 0 {
+		res = append(res, "not delegated by any role")
+	} else if status.Signatures == 0 {
+		res = append(res, "unsigned")
+	} else if status.Signatures < status.Threshold {
+		res = append(res, fmt.Sprintf("below threshold, signed by %d of %d required keys", status.Signatures, status.Threshold))
+	}
+	if status.PublishedVersion != 0 && status.Version <= status.PublishedVersion {
+		res = append(res, fmt.Sprintf("version %d is not newer than the published version %d", status.Version, status.PublishedVersion))
+	}
+	if status.Role == metadata.ROOT && s.published != nil && status.Version != status.PublishedVersion {
+		if status.Version != status.PublishedVersion+1 {
+			res = append(res, fmt.Sprintf("version %d does not follow the published version %d", status.Version, status.PublishedVersion))
+		}
+		old := thresholdStatus(s.published.root, s.repo.root, s.repo.verifyOpts...)
+		if old.Needed() > 0 {
+			res = append(res, fmt.Sprintf("below threshold of the published root, signed by %d of %d required keys", len(old.Signed), old.Threshold))
+		}
+	}
+	return res
+}
+
+// Commit publishes the staged changes. Every staged role must be signed by
+// the threshold of its keys, snapshot and timestamp must be up to date and
+// the published repository with the staged files must verify with Verify
+// before any file is written. The files are written atomically in the
+// order target files, targets metadata, snapshot, root and timestamp and
+// only the ones of staged roles are written. If writing fails, the written
+// files are restored so the published repository is left untouched
+func (s *Stage) Commit() error {
+	if len(s.staged) == 0 {
+		return metadata.ErrValue{Msg: "nothing to commit"}
+	}
+	for _, status := range s.Status() {
+		if len(status.Problems) > 0 {
+			return metadata.ErrValue{Msg: fmt.Sprintf("%s can not be committed: %s", status.Role, strings.Join(status.Problems, ", "))}
+		}
+	}
+	_, changed, err := GenerateSnapshot(s.repo.snapshot, s.repo.targets, s.repo.opts)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return metadata.ErrValue{Msg: "snapshot is not up to date with the targets metadata"}
+	}
+	_, changed, err = GenerateTimestamp(s.repo.timestamp, s.repo.snapshot, s.repo.opts)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return metadata.ErrValue{Msg: "timestamp is not up to date with the snapshot metadata"}
+	}
+	files, err := s.repo.files()
+	if err != nil {
+		return err
+	}
+	writes := []repositoryFile{}
+	staged := fstest.MapFS{}
+	for _, f := range files {
+		if f.role != "" && !s.staged[f.role] {
+			continue
+		}
+		skip, err := f.written(filepath.Join(s.dir, filepath.FromSlash(f.name)))
+		if err != nil {
+			return err
+		}
+		if !skip {
+			writes = append(writes, f)
+			staged[f.name] = &fstest.MapFile{Data: f.data, Mode: 0644}
+		}
+	}
+	// clients must never see files which don't verify, so the published
+	// repository is verified with the staged files in memory
+	report, err := verifyFS(overlayFS{base: os.DirFS(s.dir), files: staged}, s.dir, time.Time{}, s.repo.verifyOpts...)
+	if err == nil && !report.OK {
+		err = metadata.ErrRepository{Msg: fmt.Sprintf("the repository in %s does not verify with the staged changes", s.dir)}
+	}
+	if err != nil {
+		return err
+	}
+	journal := &journal{}
+	for _, f := range writes {
+		err := journal.write(filepath.Join(s.dir, filepath.FromSlash(f.name)), f.data)
+		if err != nil {
+			journal.rollback()
+			return err
+		}
+	}
+	err = os.RemoveAll(s.stagedDir)
+	if err != nil {
+		return err
+	}
+	s.staged = map[string]bool{}
+	s.published, _, err = Load(s.dir)
+	if err != nil {
+		return err
+	}
+	// target files are part of the published repository now
+	s.repo.targetData = map[string]map[string][]byte{}
+	log.Debugf("Committed %d files to %s", len(journal.entries), s.dir)
+	return nil
+}
+
+// Save writes the staged metadata and target files to the staging directory
+func (s *Stage) Save() error {
+	err := os.MkdirAll(s.stagedDir, 0755)
+	if err != nil {
+		return err
+	}
+	for name := range s.staged {
+		var data []byte
+		var err error
+		switch name {
+		case metadata.ROOT:
+			data, err = s.repo.root.ToBytes(true)
+		case metadata.SNAPSHOT:
+			data, err = s.repo.snapshot.ToBytes(true)
+		case metadata.TIMESTAMP:
+			data, err = s.repo.timestamp.ToBytes(true)
+		default:
+			data, err = s.repo.targets[name].ToBytes(true)
+		}
+		if err != nil {
+			return err
+		}
+		err = storage.WriteFileAtomic(filepath.Join(s.stagedDir, url.QueryEscape(name)+".json"), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	// target files are staged as targets/<role>/<path>
+	targetsDir := filepath.Join(s.stagedDir, TargetsDir)
+	for roleName, files := range s.repo.targetData {
+		for targetPath, data := range files {
+			err := writeFile(filepath.Join(targetsDir, url.QueryEscape(roleName), filepath.FromSlash(targetPath)), data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// remove the target files which were removed from the working copy
+	return filepath.WalkDir(targetsDir, func(name string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		roleName, targetPath, err := stagedTargetFile(targetsDir, name)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.repo.targetData[roleName][targetPath]; !ok {
+			return os.Remove(name)
+		}
+		return nil
+	})
+}
+
+// load applies the staged metadata and target files to the working copy
+func (s *Stage) load() error {
+	entries, err := os.ReadDir(s.stagedDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		roleName, err := url.QueryUnescape(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(s.stagedDir, entry.Name())
+		switch roleName {
+		case metadata.ROOT:
+			s.repo.root, err = metadata.Root().FromFile(name)
+		case metadata.SNAPSHOT:
+			s.repo.snapshot, err = metadata.Snapshot().FromFile(name)
+		case metadata.TIMESTAMP:
+			s.repo.timestamp, err = metadata.Timestamp().FromFile(name)
+		default:
+			s.repo.targets[roleName], err = metadata.Targets().FromFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		s.staged[roleName] = true
+	}
+	targetsDir := filepath.Join(s.stagedDir, TargetsDir)
+	return filepath.WalkDir(targetsDir, func(name string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		roleName, targetPath, err := stagedTargetFile(targetsDir, name)
+		if err != nil {
+			return err
+		}
+		s.repo.SetTargetData(roleName, targetPath, data)
+		return nil
+	})
+}
+
+// stagedTargetFile returns the role name and target path of the staged
+// target file name in targetsDir
+func stagedTargetFile(targetsDir, name string) (string, string, error) {
+	rel, err := filepath.Rel(targetsDir, name)
+	if err != nil {
+		return "", "", err
+	}
+	escapedRole, targetPath, ok := strings.Cut(filepath.ToSlash(rel), "/")
+	if !ok {
+		return "", "", metadata.ErrValue{Msg: fmt.Sprintf("staged target file %s is not in a role directory", name)}
+	}
+	roleName, err := url.QueryUnescape(escapedRole)
+	if err != nil {
+		return "", "", err
+	}
+	return roleName, targetPath, nil
+}
+
+// overlayFS shows files on top of a base file system, e.g. the files about
+// to be committed on top of the published repository
+type overlayFS struct {
+	base  fs.FS
+	files fstest.MapFS
+}
+
+// Open opens name from the files on top or the base file system
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if _, ok := o.files[name]; ok {
+		return o.files.Open(name)
+	}
+	return o.base.Open(name)
+}
+
+// ReadDir lists the entries of both file systems, the entries on top take
+// precedence
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := map[string]fs.DirEntry{}
+	base, err := fs.ReadDir(o.base, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	top, topErr := fs.ReadDir(o.files, name)
+	if err != nil && topErr != nil {
+		return nil, err
+	}
+	for _, entry := range append(base, top...) {
+		entries[entry.Name()] = entry
+	}
+	res := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+	return res, nil
+}
+
+// Stat returns the file info of name in the files on top or the base file
+// system
+func (o overlayFS) Stat(name string) (fs.FileInfo, error) {
+	if info, err := fs.Stat(o.files, name); err == nil {
+		return info, nil
+	}
+	return fs.Stat(o.base, name)
+}
+
+// signatureStatus returns the number of valid signatures of roleName and
+// the threshold required by its delegator
+func (r *Repository) signatureStatus(roleName string) (int, int) {
+	keys, keyIDs, threshold := r.roleKeys(roleName)
+	return len(validSignatures(keys, keyIDs, r.roleMetadata(roleName), r.verifyOpts...)), threshold
+}
+
+// roleKeys returns the keys, key IDs and threshold the delegator of
+// roleName assigns to it
+func (r *Repository) roleKeys(roleName string) (map[string]*metadata.Key, []string, int) {
+	var keys map[string]*metadata.Key
+	var keyIDs []string
+	var threshold int
+	switch roleName {
+	case metadata.ROOT, metadata.SNAPSHOT, metadata.TIMESTAMP, metadata.TARGETS:
+		role := r.root.Signed.Roles[roleName]
+		keys, keyIDs, threshold = r.root.Signed.Keys, role.KeyIDs, role.Threshold
+	default:
+		for _, delegator := range r.TargetsRoles() {
+			delegations := r.targets[delegator].Signed.Delegations
+			if delegations == nil {
+				continue
+			}
+			for _, role := range delegations.Roles {
+				if role.Name == roleName {
+					keys, keyIDs, threshold = delegations.Keys, role.KeyIDs, role.Threshold
+				}
+			}
+			if delegations.SuccinctRoles != nil && delegations.SuccinctRoles.IsDelegatedRole(roleName) {
+				keys, keyIDs, threshold = delegations.Keys, delegations.SuccinctRoles.KeyIDs, delegations.SuccinctRoles.Threshold
+			}
+		}
+	}
+	return keys, keyIDs, threshold
+}
+
+// roleMetadata returns the metadata of roleName, nil if it doesn't exist
+func (r *Repository) roleMetadata(roleName string) any {
+	switch roleName {
+	case metadata.ROOT:
+		if r.root != nil {
+			return r.root
+		}
+	case metadata.SNAPSHOT:
+		if r.snapshot != nil {
+			return r.snapshot
+		}
+	case metadata.TIMESTAMP:
+		if r.timestamp != nil {
+			return r.timestamp
+		}
+	default:
+		if targets, ok := r.targets[roleName]; ok {
+			return targets
+		}
+	}
+	return nil
+}
+
+// validSignatures returns the key IDs out of keyIDs with a valid signature
+// of meta. Each key is checked on its own with VerifyDelegate and opts so a
+// signature counts exactly when clients would count it
+func validSignatures(keys map[string]*metadata.Key, keyIDs []string, meta any, opts ...metadata.VerifyOption) []string {
+	res := []string{}
+	for _, keyID := range keyIDs {
+		single := &metadata.Metadata[metadata.RootType]{
+			Signed: metadata.RootType{
+				Keys:  keys,
+				Roles: map[string]*metadata.Role{"role": {KeyIDs: []string{keyID}, Threshold: 1}},
+			},
+		}
+		if single.VerifyDelegate("role", meta, opts...) == nil && !slices.Contains(res, keyID) {
+			res = append(res, keyID)
+		}
+	}
+	return res
+}
+
+// roleState returns the version and number of signatures of roleName in
+// repo, the version is 0 if the role doesn't exist
+func roleState(repo *Repository, roleName string) (int64, int) {
+	switch roleName {
+	case metadata.ROOT:
+		if repo.root != nil {
+			return repo.root.Signed.Version, len(repo.root.Signatures)
+		}
+	case metadata.SNAPSHOT:
+		if repo.snapshot != nil {
+			return repo.snapshot.Signed.Version, len(repo.snapshot.Signatures)
+		}
+	case metadata.TIMESTAMP:
+		if repo.timestamp != nil {
+			return repo.timestamp.Signed.Version, len(repo.timestamp.Signatures)
+		}
+	default:
+		if targets, ok := repo.targets[roleName]; ok {
+			return targets.Signed.Version, len(targets.Signatures)
+		}
+	}
+	return 0, 0
+}
+
+// roleExpires returns the expiration date of roleName in repo
+func roleExpires(repo *Repository, roleName string) time.Time {
+	switch roleName {
+	case metadata.ROOT:
+		return repo.root.Signed.Expires
+	case metadata.SNAPSHOT:
+		return repo.snapshot.Signed.Expires
+	case metadata.TIMESTAMP:
+		return repo.timestamp.Signed.Expires
+	default:
+		return repo.targets[roleName].Signed.Expires
+	}
+}
+
+// journal records the files written during a commit so they can be restored
+type journal struct {
+	entries []journalEntry
+}
+
+// journalEntry is the previous state of a written file
+type journalEntry struct {
+	name    string
+	existed bool
+	data    []byte
+}
+
+// write records the current state of name and writes data to it
+func (j *journal) write(name string, data []byte) error {
+	entry := journalEntry{name: name}
+	previous, err := os.ReadFile(name)
+	switch {
+	case err == nil:
+		entry.existed = true
+		entry.data = previous
+	case !os.IsNotExist(err):
+		return err
+	}
+	j.entries = append(j.entries, entry)
+	return writeFile(name, data)
+}
+
+// rollback restores the recorded files in reverse order
+func (j *journal) rollback() {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		entry := j.entries[i]
+		var err error
+		if entry.existed {
+			err = storage.WriteFileAtomic(entry.name, entry.data, 0644)
+		} else {
+			err = os.Remove(entry.name)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to restore %s: %v", entry.name, err)
+		}
+	}
+	log.Debugf("Rolled back %d files", len(j.entries))
+}
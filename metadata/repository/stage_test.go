@@ -0,0 +1,213 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keyless"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/tlog"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperDirContents returns the content of every file in dir
+func helperDirContents(t *testing.T, dir string) map[string]string {
+	res := map[string]string{}
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		res[name] = string(data)
+		return err
+	})
+	assert.NoError(t, err)
+	return res
+}
+
+// helperOpenStage publishes a new repository and opens a stage for it with
+// the signers of the published repository
+func helperOpenStage(t *testing.T) (*Stage, string, string) {
+	dir, stagedDir := t.TempDir(), filepath.Join(t.TempDir(), "staged")
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+	stage, err := OpenStage(dir, stagedDir)
+	assert.NoError(t, err)
+	for _, name := range []string{metadata.ROOT, metadata.TARGETS, metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		stage.Repository().AddSigner(name, repo.Signers(name)[0])
+	}
+	return stage, dir, stagedDir
+}
+
+func TestStageCommit(t *testing.T) {
+	stage, dir, stagedDir := helperOpenStage(t)
+	for _, status := range stage.Status() {
+		assert.False(t, status.Staged)
+		assert.Equal(t, int64(1), status.PublishedVersion)
+		assert.Equal(t, 1, status.Signatures)
+	}
+	assert.Error(t, stage.Commit())
+
+	// staged changes survive reopening the stage
+	assert.NoError(t, stage.MarkChanged(metadata.TARGETS, stage.Repository().Targets(metadata.TARGETS).Signed.Expires))
+	_, err := stage.Repository().AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, stage.Save())
+	reopened, err := OpenStage(dir, stagedDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{metadata.TARGETS}, reopened.Staged())
+	data, ok := reopened.Repository().TargetData(metadata.TARGETS, "files/hello.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(data))
+
+	status := stage.Status()[3]
+	assert.Equal(t, metadata.TARGETS, status.Role)
+	assert.Equal(t, int64(2), status.Version)
+	assert.Equal(t, []string{"unsigned"}, status.Problems)
+
+	assert.NoError(t, stage.Repository().Publish(metadata.TARGETS))
+	stage.SetStaged(metadata.SNAPSHOT)
+	stage.SetStaged(metadata.TIMESTAMP)
+	assert.NoError(t, stage.Commit())
+	assert.Empty(t, stage.Staged())
+	assert.NoDirExists(t, stagedDir)
+	assert.Equal(t, int64(2), stage.Published().Timestamp().Signed.Version)
+
+	data, err = helperRefresh(t, dir, "files/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestStageCommitRollback(t *testing.T) {
+	stage, dir, _ := helperOpenStage(t)
+	published := helperDirContents(t, dir)
+
+	// snapshot and timestamp are not up to date
+	assert.NoError(t, stage.MarkChanged(metadata.TARGETS, stage.Repository().Targets(metadata.TARGETS).Signed.Expires))
+	_, err := stage.Repository().AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, stage.Repository().Sign(metadata.TARGETS))
+	assert.ErrorContains(t, stage.Commit(), "snapshot is not up to date")
+	assert.Equal(t, published, helperDirContents(t, dir))
+
+	// the target file doesn't match its metadata, so verification fails
+	// before anything is written, not even temporarily
+	timestamp, err := os.Stat(filepath.Join(dir, MetadataDir, "timestamp.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, stage.Repository().Publish(metadata.TARGETS))
+	stage.SetStaged(metadata.SNAPSHOT)
+	stage.SetStaged(metadata.TIMESTAMP)
+	stage.Repository().SetTargetData(metadata.TARGETS, "files/hello.txt", []byte("tampered"))
+	assert.ErrorContains(t, stage.Commit(), "does not verify")
+	assert.Equal(t, published, helperDirContents(t, dir))
+	current, err := os.Stat(filepath.Join(dir, MetadataDir, "timestamp.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, timestamp.ModTime(), current.ModTime())
+	assert.Equal(t, []string{metadata.SNAPSHOT, metadata.TARGETS, metadata.TIMESTAMP}, stage.Staged())
+}
+
+// helperKeylessSigner returns a keyless signer certified by a local CA and
+// recording in an in-memory log, and the trust root trusting both
+func helperKeylessSigner(t *testing.T) (*keyless.Signer, *metadata.KeylessTrustRoot) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, caKey.Public(), caKey)
+	assert.NoError(t, err)
+	ca, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	issuer, err := asn1.Marshal("https://issuer.example.com")
+	assert.NoError(t, err)
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{"maintainer@example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}, Value: issuer}},
+	}, ca, private.Public(), caKey)
+	assert.NoError(t, err)
+
+	log, err := tlog.NewMemory("test log")
+	assert.NoError(t, err)
+	signer, err := keyless.New(context.Background(), private, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), log)
+	assert.NoError(t, err)
+	trustRoot := &metadata.KeylessTrustRoot{Roots: x509.NewCertPool()}
+	trustRoot.Roots.AddCert(ca)
+	assert.NoError(t, trustRoot.AddTransparencyLog(log.PublicKey()))
+	return signer, trustRoot
+}
+
+func TestStageKeylessRole(t *testing.T) {
+	stage, dir, _ := helperOpenStage(t)
+	repo := stage.Repository()
+	signer, trustRoot := helperKeylessSigner(t)
+
+	// a delegated role signed keyless
+	expires := repo.Targets(metadata.TARGETS).Signed.Expires
+	assert.NoError(t, stage.MarkChanged(metadata.TARGETS, expires))
+	role := metadata.DelegatedRole{Name: "keyless", Threshold: 1, Paths: []string{"keyless/*"}}
+	assert.NoError(t, repo.Targets(metadata.TARGETS).Signed.AddDelegatedRole(role, signer.Key()))
+	repo.SetTargets("keyless", metadata.Targets(expires))
+	repo.AddSigner("keyless", signer)
+	_, err := repo.AddTarget("keyless", "keyless/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Publish(metadata.TARGETS, "keyless"))
+	for _, name := range []string{"keyless", metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		stage.SetStaged(name)
+	}
+
+	// without the trust root the keyless signature doesn't count
+	assert.ErrorContains(t, stage.Commit(), "keyless can not be committed: unsigned")
+
+	repo.SetVerifyOptions(metadata.WithKeylessTrustRoot(trustRoot))
+	for _, status := range stage.Status() {
+		assert.Empty(t, status.Problems, status.Role)
+	}
+	assert.NoError(t, stage.Commit())
+
+	report, err := Verify(dir, time.Time{}, metadata.WithKeylessTrustRoot(trustRoot))
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Empty(t, helperFailedChecks(report))
+	report, err = Verify(dir, time.Time{})
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, []string{"metadata/1.keyless.json"}, helperFailedChecks(report))
+}
@@ -0,0 +1,265 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of verification checks
+const (
+	CheckRoot       = "root"
+	CheckTimestamp  = "timestamp"
+	CheckSnapshot   = "snapshot"
+	CheckTargets    = "targets"
+	CheckTargetFile = "target-file"
+)
+
+// VerifyCheck is the result of verifying a single file
+type VerifyCheck struct {
+	Check string `json:"check"`
+	Role  string `json:"role,omitempty"`
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+// VerifyReport is the machine-readable result of Verify
+type VerifyReport struct {
+	OK      bool          `json:"ok"`
+	RefTime time.Time     `json:"ref_time"`
+	Checks  []VerifyCheck `json:"checks"`
+	Load    *LoadReport   `json:"load"`
+}
+
+// verifier keeps the state while verifying a repository
+type verifier struct {
+	fsys       fs.FS
+	consistent bool
+	trusted    *trustedmetadata.TrustedMetadata
+	opts       []metadata.VerifyOption
+	report     *VerifyReport
+}
+
+// Verify runs the client update workflow against the repository at dir
+// using trustedmetadata, so the repository and its clients agree on what is
+// valid. It verifies every root in the version chain, timestamp, snapshot,
+// all reachable targets roles including delegated ones and the length and
+// hashes of every target file on disk. Expiration is checked at refTime,
+// zero means now. Verification stops at the first invalid metadata of the
+// chain as clients would, while target files are always checked. The report
+// is OK if every check passed and nothing is missing or mismatching in the
+// repository layout, orphaned files are only listed. The signatures are
+// verified with opts, which should be the verification policy of the clients
+func Verify(dir string, refTime time.Time, opts ...metadata.VerifyOption) (*VerifyReport, error) {
+	return verifyFS(os.DirFS(dir), dir, refTime, opts...)
+}
+
+// verifyFS verifies the repository in fsys, dir names it in messages
+func verifyFS(fsys fs.FS, dir string, refTime time.Time, opts ...metadata.VerifyOption) (*VerifyReport, error) {
+	if refTime.IsZero() {
+		refTime = time.Now().UTC()
+	}
+	repo, loadReport, err := loadFS(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	v := &verifier{
+		fsys:       fsys,
+		opts:       opts,
+		consistent: repo.Root().Signed.ConsistentSnapshot,
+		report: &VerifyReport{
+			RefTime: refTime,
+			Checks:  []VerifyCheck{},
+			Load:    loadReport,
+		},
+	}
+	if v.verifyRootChain(refTime) && v.verifyTimestamp() && v.verifySnapshot() {
+		v.verifyTargets()
+	}
+	v.verifyTargetFiles(repo)
+	v.report.OK = len(loadReport.Missing) == 0 && len(loadReport.Mismatches) == 0
+	for _, check := range v.report.Checks {
+		if check.Error != "" {
+			v.report.OK = false
+		}
+	}
+	log.Debugf("Verified repository %s, ok: %t", dir, v.report.OK)
+	return v.report, nil
+}
+
+// verifyRootChain verifies every root version starting from the oldest one
+func (v *verifier) verifyRootChain(refTime time.Time) bool {
+	versions, err := v.rootVersions()
+	if err != nil || len(versions) == 0 {
+		v.add(CheckRoot, metadata.ROOT, MetadataDir, fmt.Errorf("no root metadata found: %v", err))
+		return false
+	}
+	for i, version := range versions {
+		name := MetadataFileName(metadata.ROOT, version, true)
+		data, err := v.readMetadata(name)
+		if err == nil {
+			if i == 0 {
+				v.trusted, err = trustedmetadata.New(data, v.opts...)
+			} else if version != versions[i-1]+1 {
+				err = metadata.ErrBadVersionNumber{Msg: fmt.Sprintf("root version %d is missing", versions[i-1]+1)}
+			} else {
+				_, err = v.trusted.UpdateRoot(data)
+			}
+		}
+		v.add(CheckRoot, metadata.ROOT, path.Join(MetadataDir, name), err)
+		if err != nil {
+			return false
+		}
+	}
+	v.trusted.RefTime = refTime
+	return true
+}
+
+// verifyTimestamp verifies timestamp against the final root
+func (v *verifier) verifyTimestamp() bool {
+	name := MetadataFileName(metadata.TIMESTAMP, 0, false)
+	data, err := v.readMetadata(name)
+	if err == nil {
+		_, err = v.trusted.UpdateTimestamp(data)
+	}
+	v.add(CheckTimestamp, metadata.TIMESTAMP, path.Join(MetadataDir, name), err)
+	return err == nil
+}
+
+// verifySnapshot verifies the snapshot referenced by timestamp
+func (v *verifier) verifySnapshot() bool {
+	version := v.trusted.Timestamp.Signed.Meta[fmt.Sprintf("%s.json", metadata.SNAPSHOT)].Version
+	name := MetadataFileName(metadata.SNAPSHOT, version, v.consistent)
+	data, err := v.readMetadata(name)
+	if err == nil {
+		_, err = v.trusted.UpdateSnapshot(data, false)
+	}
+	v.add(CheckSnapshot, metadata.SNAPSHOT, path.Join(MetadataDir, name), err)
+	return err == nil
+}
+
+// verifyTargets verifies the top-level targets and walks the delegations
+// breadth-first, verifying each delegated role by its delegator
+func (v *verifier) verifyTargets() {
+	type delegation struct {
+		role      string
+		delegator string
+	}
+	queue := []delegation{{role: metadata.TARGETS, delegator: metadata.ROOT}}
+	visited := map[string]bool{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if visited[current.role] {
+			continue
+		}
+		visited[current.role] = true
+		metaFile, ok := v.trusted.Snapshot.Signed.Meta[fmt.Sprintf("%s.json", current.role)]
+		if !ok {
+			v.add(CheckTargets, current.role, MetadataDir, metadata.ErrRepository{Msg: fmt.Sprintf("snapshot does not contain information for %s delegated by %s", current.role, current.delegator)})
+			continue
+		}
+		name := MetadataFileName(current.role, metaFile.Version, v.consistent)
+		data, err := v.readMetadata(name)
+		var targets *metadata.Metadata[metadata.TargetsType]
+		if err == nil {
+			targets, err = v.trusted.UpdateDelegatedTargets(data, current.role, current.delegator)
+		}
+		v.add(CheckTargets, current.role, path.Join(MetadataDir, name), err)
+		if err != nil || targets.Signed.Delegations == nil {
+			continue
+		}
+		for _, role := range targets.Signed.Delegations.Roles {
+			queue = append(queue, delegation{role: role.Name, delegator: current.role})
+		}
+		if targets.Signed.Delegations.SuccinctRoles != nil {
+			for _, role := range targets.Signed.Delegations.SuccinctRoles.GetRoles() {
+				queue = append(queue, delegation{role: role, delegator: current.role})
+			}
+		}
+	}
+}
+
+// verifyTargetFiles verifies the length and hashes of every target file
+// listed in the verified targets metadata or, if the metadata chain could
+// not be verified, in the loaded one
+func (v *verifier) verifyTargetFiles(repo *Repository) {
+	roles := map[string]*metadata.Metadata[metadata.TargetsType]{}
+	for _, name := range repo.TargetsRoles() {
+		roles[name] = repo.Targets(name)
+	}
+	if v.trusted != nil && len(v.trusted.Targets) > 0 {
+		roles = v.trusted.Targets
+	}
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, role := range names {
+		targetPaths := make([]string, 0, len(roles[role].Signed.Targets))
+		for targetPath := range roles[role].Signed.Targets {
+			targetPaths = append(targetPaths, targetPath)
+		}
+		sort.Strings(targetPaths)
+		for _, targetPath := range targetPaths {
+			targetFile := roles[role].Signed.Targets[targetPath]
+			for _, name := range targetFileNames(targetPath, targetFile, v.consistent) {
+				data, err := fs.ReadFile(v.fsys, path.Join(TargetsDir, name))
+				if err == nil {
+					err = targetFile.VerifyLengthHashes(data)
+				}
+				v.add(CheckTargetFile, role, path.Join(TargetsDir, name), err)
+			}
+		}
+	}
+}
+
+// rootVersions returns the sorted versions of all N.root.json files
+func (v *verifier) rootVersions() ([]int64, error) {
+	entries, err := fs.ReadDir(v.fsys, MetadataDir)
+	if err != nil {
+		return nil, err
+	}
+	res := []int64{}
+	for _, entry := range entries {
+		f, ok := parseMetadataFileName(entry.Name())
+		if ok && f.role == metadata.ROOT && f.version > 0 {
+			res = append(res, f.version)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// readMetadata reads a file from the metadata directory
+func (v *verifier) readMetadata(name string) ([]byte, error) {
+	return fs.ReadFile(v.fsys, path.Join(MetadataDir, name))
+}
+
+// add records the result of a check
+func (v *verifier) add(check, role, p string, err error) {
+	result := VerifyCheck{Check: check, Role: role, Path: p}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	v.report.Checks = append(v.report.Checks, result)
+}
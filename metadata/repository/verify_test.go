@@ -0,0 +1,91 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperFailedChecks returns the paths of all failed checks
+func helperFailedChecks(report *VerifyReport) []string {
+	res := []string{}
+	for _, check := range report.Checks {
+		if check.Error != "" {
+			res = append(res, check.Path)
+		}
+	}
+	return res
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	targetFile, err := repo.AddTarget(metadata.TARGETS, "files/hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+
+	report, err := Verify(dir, time.Time{})
+	assert.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Empty(t, helperFailedChecks(report))
+	// root, timestamp, snapshot, targets and the target file
+	assert.Len(t, report.Checks, 5)
+
+	// clients would reject the metadata once it expired
+	report, err = Verify(dir, time.Now().AddDate(0, 0, 8))
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, []string{"metadata/timestamp.json"}, helperFailedChecks(report))
+
+	// a tampered target file
+	name := filepath.Join(dir, TargetsDir, "files", targetFile.Hashes["sha256"].String()+".hello.txt")
+	assert.NoError(t, os.WriteFile(name, []byte("tampered"), 0644))
+	report, err = Verify(dir, time.Time{})
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, []string{"targets/files/" + targetFile.Hashes["sha256"].String() + ".hello.txt"}, helperFailedChecks(report))
+}
+
+func TestVerifyRootChain(t *testing.T) {
+	dir := t.TempDir()
+	repo := helperNewRepository(t)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	assert.NoError(t, repo.Write(dir))
+
+	// a second root signed only by a new key breaks the chain
+	_, err := repo.BumpVersion(metadata.ROOT)
+	assert.NoError(t, err)
+	repo.signers[metadata.ROOT] = nil
+	other := helperNewRepository(t)
+	repo.AddSigner(metadata.ROOT, other.Signers(metadata.ROOT)[0])
+	assert.NoError(t, repo.Sign(metadata.ROOT))
+	assert.NoError(t, repo.Write(dir))
+
+	report, err := Verify(dir, time.Time{})
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Equal(t, []string{"metadata/2.root.json"}, helperFailedChecks(report))
+}
+
+func TestVerifyExpiredExample(t *testing.T) {
+	report, err := Verify(filepath.Join("..", "..", "examples", "multirepo", "repository"), time.Time{})
+	assert.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.True(t, report.Load.OK())
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// MetadataDir is the directory holding the metadata files in a repository layout
+	MetadataDir = "metadata"
+	// TargetsDir is the directory holding the target files in a repository layout
+	TargetsDir = "targets"
+)
+
+// Write writes the repository to dir as <dir>/metadata and <dir>/targets.
+// With consistent snapshots enabled in root all metadata except timestamp is
+// written as <version>.<role>.json and target files are prefixed with their
+// hashes. Each file is written atomically and in the order target files,
+// targets metadata, snapshot, root and timestamp last, so clients which start
+// from timestamp never see a partially written repository. Versioned
+// metadata and hash-prefixed target files which already exist are never
+// rewritten, writing different content to one of them fails
+func (r *Repository) Write(dir string) error {
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := filepath.Join(dir, filepath.FromSlash(f.name))
+		skip, err := f.written(name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		err = writeFile(name, f.data)
+		if err != nil {
+			return err
+		}
+	}
+	log.Debugf("Wrote repository to %s", dir)
+	return nil
+}
+
+// repositoryFile is a file of the repository layout
+type repositoryFile struct {
+	// name is the slash separated path relative to the repository directory
+	name string
+	// role is the name of the role for metadata files, empty for target files
+	role string
+	data []byte
+	// immutable is true for versioned metadata and hash-prefixed target
+	// files, which must never change once written
+	immutable bool
+}
+
+// written reports whether the immutable file f already exists as name. An
+// existing metadata file counts as written if it carries the same
+// signatures, even if it's serialized differently
+func (f repositoryFile) written(name string) (bool, error) {
+	if !f.immutable {
+		return false, nil
+	}
+	existing, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(existing, f.data) || (f.role != "" && sameSignatures(existing, f.data)) {
+		return true, nil
+	}
+	if f.role != "" {
+		return false, metadata.ErrRepository{Msg: fmt.Sprintf("%s already exists with different content, the version of %s must be bumped", f.name, f.role)}
+	}
+	return false, metadata.ErrRepository{Msg: fmt.Sprintf("%s already exists with different content", f.name)}
+}
+
+// sameSignatures reports whether the metadata files a and b carry the same
+// non-empty set of signatures, i.e. they hold the same signed content
+func sameSignatures(a, b []byte) bool {
+	type signatures struct {
+		Signatures []metadata.Signature `json:"signatures"`
+	}
+	var sigsA, sigsB signatures
+	if json.Unmarshal(a, &sigsA) != nil || json.Unmarshal(b, &sigsB) != nil {
+		return false
+	}
+	if len(sigsA.Signatures) == 0 || len(sigsA.Signatures) != len(sigsB.Signatures) {
+		return false
+	}
+	for i, sig := range sigsA.Signatures {
+		if sig.KeyID != sigsB.Signatures[i].KeyID || !bytes.Equal(sig.Signature, sigsB.Signatures[i].Signature) {
+			return false
+		}
+	}
+	return true
+}
+
+// files returns all files of the repository in the order they are written
+func (r *Repository) files() ([]repositoryFile, error) {
+	if r.root == nil || r.snapshot == nil || r.timestamp == nil {
+		return nil, metadata.ErrValue{Msg: "root, snapshot and timestamp metadata must be set before writing the repository"}
+	}
+	res := []repositoryFile{}
+	consistent := r.root.Signed.ConsistentSnapshot
+	addMetadata := func(roleName string, version int64, consistent bool, toBytes func(bool) ([]byte, error)) error {
+		// the same compact serialization used for the snapshot and timestamp
+		// meta is used so lengths and hashes match the files on disk
+		data, err := toBytes(false)
+		if err != nil {
+			return err
+		}
+		res = append(res, repositoryFile{name: path.Join(MetadataDir, MetadataFileName(roleName, version, consistent)), role: roleName, data: data, immutable: consistent})
+		return nil
+	}
+	// target files, the same file may be added to several roles
+	roles := make([]string, 0, len(r.targetData))
+	for roleName := range r.targetData {
+		roles = append(roles, roleName)
+	}
+	sort.Strings(roles)
+	added := map[string]string{} // file name -> role name
+	for _, roleName := range roles {
+		paths := make([]string, 0, len(r.targetData[roleName]))
+		for targetPath := range r.targetData[roleName] {
+			paths = append(paths, targetPath)
+		}
+		sort.Strings(paths)
+		for _, targetPath := range paths {
+			var targetFile *metadata.TargetFiles
+			if targets, ok := r.targets[roleName]; ok {
+				targetFile = targets.Signed.Targets[targetPath]
+			}
+			data := r.targetData[roleName][targetPath]
+			for _, name := range targetFileNames(targetPath, targetFile, consistent) {
+				name = path.Join(TargetsDir, name)
+				if other, ok := added[name]; ok {
+					if !bytes.Equal(r.targetData[other][targetPath], data) {
+						return nil, metadata.ErrValue{Msg: fmt.Sprintf("target file %s has different content in %s and %s", targetPath, other, roleName)}
+					}
+					continue
+				}
+				added[name] = roleName
+				res = append(res, repositoryFile{name: name, data: data, immutable: consistent && targetFile != nil})
+			}
+		}
+	}
+	// targets metadata
+	for _, name := range r.TargetsRoles() {
+		err := addMetadata(name, r.targets[name].Signed.Version, consistent, r.targets[name].ToBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// snapshot
+	err := addMetadata(metadata.SNAPSHOT, r.snapshot.Signed.Version, consistent, r.snapshot.ToBytes)
+	if err != nil {
+		return nil, err
+	}
+	// root is always versioned so clients can walk the chain of root versions
+	err = addMetadata(metadata.ROOT, r.root.Signed.Version, true, r.root.ToBytes)
+	if err != nil {
+		return nil, err
+	}
+	// timestamp is never versioned
+	err = addMetadata(metadata.TIMESTAMP, r.timestamp.Signed.Version, false, r.timestamp.ToBytes)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MetadataFileName returns the file name of the metadata of roleName at
+// version as found in the metadata directory
+func MetadataFileName(roleName string, version int64, consistent bool) string {
+	if consistent {
+		return fmt.Sprintf("%d.%s.json", version, url.QueryEscape(roleName))
+	}
+	return fmt.Sprintf("%s.json", url.QueryEscape(roleName))
+}
+
+// targetFileNames returns the slash separated file names of targetPath
+// relative to the targets directory, i.e. one hash-prefixed name per hash
+// with consistent snapshots or the target path itself otherwise
+func targetFileNames(targetPath string, targetFile *metadata.TargetFiles, consistent bool) []string {
+	if !consistent || targetFile == nil {
+		return []string{targetPath}
+	}
+	res := []string{}
+	dirName, baseName := path.Split(targetPath)
+	for _, digest := range targetFile.Hashes {
+		res = append(res, path.Join(dirName, fmt.Sprintf("%s.%s", digest, baseName)))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// writeFile atomically writes data to name creating its parent directories
+func writeFile(name string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(name, data, 0644)
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	awskms "github.com/sigstore/sigstore/pkg/signature/kms/aws"
+)
+
+// AWSKMSScheme is the prefix of AWS KMS key references,
+// awskms://[ENDPOINT]/[ID|ALIAS|ARN], e.g. awskms:///alias/timestamp or
+// awskms://localhost:4566/1234abcd-12ab-34cd-56ef-1234567890ab. The
+// credentials and the region are resolved by the AWS SDK default chain,
+// e.g. $AWS_ACCESS_KEY_ID, $AWS_REGION or ~/.aws/config. The ENDPOINT is
+// called over https, Options.Endpoint replaces it with a URL including the
+// scheme, e.g. http://localhost:4566
+const AWSKMSScheme = awskms.ReferenceScheme
+
+func init() {
+	Register(AWSKMSScheme, loadAWSKMS)
+}
+
+// loadAWSKMS returns the signer of an awskms:// reference
+func loadAWSKMS(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+	var configOpts []func(*config.LoadOptions) error
+	if opts.HTTPClient != nil {
+		configOpts = append(configOpts, config.WithHTTPClient(opts.HTTPClient))
+	}
+	// the ENDPOINT is resolved here so the requests are signed for the region
+	endpoint := opts.Endpoint
+	host, keyID, _ := strings.Cut(strings.TrimPrefix(ref, AWSKMSScheme), "/")
+	if endpoint == "" && host != "" {
+		endpoint = "https://" + host
+	}
+	if endpoint != "" {
+		configOpts = append(configOpts, config.WithEndpointResolverWithOptions(
+			aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...any) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: endpoint, SigningRegion: region}, nil
+			})))
+	}
+	sv, err := awskms.LoadSignerVerifier(ctx, AWSKMSScheme+"/"+keyID, configOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return kmsSigner(ctx, sv)
+}
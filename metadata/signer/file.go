@@ -0,0 +1,52 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+)
+
+// FileScheme is the prefix of private key file references, e.g.
+// file:///etc/tuf/timestamp.pem. The file can be in any of the formats
+// supported by keys.Load
+const FileScheme = "file://"
+
+func init() {
+	Register(FileScheme, loadFile)
+}
+
+// fileSigner is the private key of a file together with its TUF key, which
+// may differ from the one derived from the public key for securesystemslib
+// keys
+type fileSigner struct {
+	crypto.Signer
+	key *metadata.Key
+}
+
+// Key returns the TUF key of the file
+func (s *fileSigner) Key() *metadata.Key {
+	return s.key
+}
+
+// loadFile returns the signer of a file:// reference
+func loadFile(_ context.Context, ref string, opts Options) (crypto.Signer, error) {
+	key, err := keys.LoadFile(strings.TrimPrefix(ref, FileScheme), opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSigner{Signer: key.Private, key: key.Public}, nil
+}
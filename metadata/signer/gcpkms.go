@@ -0,0 +1,63 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+	"os"
+
+	gcpkms "github.com/sigstore/sigstore/pkg/signature/kms/gcp"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// GCPKMSScheme is the prefix of Google Cloud KMS key references,
+// gcpkms://projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V.
+// The OAuth access token is read from Options.Token or
+// $GOOGLE_OAUTH_ACCESS_TOKEN, e.g. the output of gcloud auth
+// print-access-token, otherwise the application default credentials are
+// used
+const GCPKMSScheme = gcpkms.ReferenceScheme
+
+func init() {
+	Register(GCPKMSScheme, loadGCPKMS)
+}
+
+// loadGCPKMS returns the signer of a gcpkms:// reference
+func loadGCPKMS(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+	var clientOpts []option.ClientOption
+	if opts.Endpoint != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint(opts.Endpoint))
+	}
+	token := opts.Token
+	if token == "" {
+		token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	if token != "" {
+		clientOpts = append(clientOpts, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))
+	}
+	// the API is called over gRPC, only the TLS configuration of the client applies
+	if opts.HTTPClient != nil {
+		if transport, ok := opts.HTTPClient.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+			clientOpts = append(clientOpts, option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(transport.TLSClientConfig))))
+		}
+	}
+	sv, err := gcpkms.LoadSignerVerifier(ctx, ref, clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return kmsSigner(ctx, sv)
+}
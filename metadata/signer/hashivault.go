@@ -0,0 +1,54 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature/kms/hashivault"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+)
+
+// HashiVaultScheme is the prefix of HashiCorp Vault transit key references,
+// hashivault://KEYNAME. The Vault address is read from Options.Endpoint or
+// $VAULT_ADDR, the token from Options.Token, $VAULT_TOKEN or ~/.vault-token
+// and the mount path of the transit secrets engine from
+// $TRANSIT_SECRET_ENGINE_PATH, transit by default. The key must be an
+// ecdsa-p256 key, Vault signs rsa keys with PSS by default
+const HashiVaultScheme = hashivault.ReferenceScheme
+
+func init() {
+	Register(HashiVaultScheme, loadHashiVault)
+}
+
+// loadHashiVault returns the signer of a hashivault:// reference. It signs
+// with the latest version of the key
+func loadHashiVault(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+	sv, err := hashivault.LoadSignerVerifier(ref, crypto.SHA256,
+		options.WithContext(ctx),
+		options.WithRPCAuthOpts(options.RPCAuth{Address: opts.Endpoint, Token: opts.Token}))
+	if err != nil {
+		return nil, err
+	}
+	s, err := kmsSigner(ctx, sv)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.Public().(*ecdsa.PublicKey); !ok {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported HashiCorp Vault key %s, only ecdsa-p256 keys are supported", ref)}
+	}
+	return s, nil
+}
@@ -0,0 +1,292 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build cgo
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// the PKCS#11 3.0 ed25519 constants are not defined by the pkcs11 package
+const (
+	ckkECEdwards = 0x40
+	ckmEdDSA     = 0x1057
+)
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	// sha256DigestInfo is the DER prefix of a SHA-256 PKCS#1 v1.5 DigestInfo
+	sha256DigestInfo = []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+)
+
+func init() {
+	Register(PKCS11Scheme, loadPKCS11)
+}
+
+// pkcs11Signer signs with a private key of a PKCS#11 token. The session is
+// kept open until Close
+type pkcs11Signer struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	private pkcs11.ObjectHandle
+	keyType uint
+	public  crypto.PublicKey
+}
+
+// loadPKCS11 returns the signer of a pkcs11: reference
+func loadPKCS11(_ context.Context, ref string, _ Options) (crypto.Signer, error) {
+	uri, err := parsePKCS11URI(ref)
+	if err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(uri.module)
+	if ctx == nil {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("failed to load the PKCS#11 module %s", uri.module)}
+	}
+	err = ctx.Initialize()
+	if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, err
+	}
+	s := &pkcs11Signer{ctx: ctx}
+	err = s.open(uri)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open logs into the token of uri and finds the key pair
+func (s *pkcs11Signer) open(uri *pkcs11URI) error {
+	slot, err := s.findSlot(uri)
+	if err != nil {
+		return err
+	}
+	s.session, err = s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return err
+	}
+	if uri.pin != "" {
+		err = s.ctx.Login(s.session, pkcs11.CKU_USER, uri.pin)
+		if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return err
+		}
+	}
+	s.private, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, uri)
+	if err != nil {
+		return err
+	}
+	public, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, uri)
+	if err != nil {
+		return err
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, s.private, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return err
+	}
+	for _, keyType := range []uint{pkcs11.CKK_EC, pkcs11.CKK_RSA, ckkECEdwards} {
+		// CK_ULONG values are encoded in the byte order of the platform
+		if bytes.Equal(attrs[0].Value, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType).Value) {
+			s.keyType = keyType
+			s.public, err = s.publicKey(public)
+			return err
+		}
+	}
+	return metadata.ErrValue{Msg: "unsupported PKCS#11 key type, supported are EC, RSA and EC Edwards keys"}
+}
+
+// findSlot returns the slot of the token matching uri
+func (s *pkcs11Signer) findSlot(uri *pkcs11URI) (uint, error) {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		if uri.slotID != nil && *uri.slotID != slot {
+			continue
+		}
+		info, err := s.ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, err
+		}
+		if uri.token != "" && uri.token != strings.TrimSpace(info.Label) {
+			continue
+		}
+		if uri.serial != "" && uri.serial != strings.TrimSpace(info.SerialNumber) {
+			continue
+		}
+		return slot, nil
+	}
+	return 0, metadata.ErrValue{Msg: "no PKCS#11 token found matching the URI"}
+}
+
+// findObject returns the only object of class matching uri
+func (s *pkcs11Signer) findObject(class uint, uri *pkcs11URI) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if uri.object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, uri.object))
+	}
+	if uri.id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, uri.id))
+	}
+	err := s.ctx.FindObjectsInit(s.session, template)
+	if err != nil {
+		return 0, err
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 2)
+	finalErr := s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, err
+	}
+	if finalErr != nil {
+		return 0, finalErr
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(objects) {
+	case 0:
+		return 0, metadata.ErrValue{Msg: fmt.Sprintf("no PKCS#11 %s key found matching the URI", kind)}
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, metadata.ErrValue{Msg: fmt.Sprintf("more than one PKCS#11 %s key found matching the URI", kind)}
+	}
+}
+
+// publicKey reads the public key object
+func (s *pkcs11Signer) publicKey(object pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	if s.keyType == pkcs11.CKK_RSA {
+		attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(attrs[1].Value)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, metadata.ErrValue{Msg: "unsupported RSA public exponent"}
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(exponent.Int64())}, nil
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.session, object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// CKA_EC_POINT is a DER encoded OCTET STRING, some modules omit it
+	point := attrs[1].Value
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+	if s.keyType == ckkECEdwards {
+		if len(point) != ed25519.PublicKeySize {
+			return nil, metadata.ErrValue{Msg: "unsupported EC Edwards curve, only ed25519 is supported"}
+		}
+		return ed25519.PublicKey(point), nil
+	}
+	// x509 validates the curve parameters and the point
+	spki, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: attrs[0].Value}},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(spki)
+}
+
+// Public returns the public key of the token key
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs digest, or the message for ed25519 keys, with the token key
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism uint
+	data := digest
+	switch s.keyType {
+	case pkcs11.CKK_EC:
+		mechanism = pkcs11.CKM_ECDSA
+	case pkcs11.CKK_RSA:
+		mechanism = pkcs11.CKM_RSA_PKCS
+		data = append(append([]byte{}, sha256DigestInfo...), digest...)
+	case ckkECEdwards:
+		mechanism = ckmEdDSA
+	}
+	if s.keyType != ckkECEdwards && opts.HashFunc() != crypto.SHA256 {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported hash %s for PKCS#11", opts.HashFunc())}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, s.private)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, err
+	}
+	if s.keyType != pkcs11.CKK_EC {
+		return sig, nil
+	}
+	// CKM_ECDSA returns r || s, Metadata[T].VerifyDelegate expects ASN.1
+	half := len(sig) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])})
+}
+
+// Close logs out and closes the session
+func (s *pkcs11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx == nil {
+		return nil
+	}
+	if s.session != 0 {
+		_ = s.ctx.Logout(s.session)
+		_ = s.ctx.CloseSession(s.session)
+	}
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	s.ctx = nil
+	return err
+}
@@ -0,0 +1,33 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build !cgo
+
+package signer
+
+import (
+	"context"
+	"crypto"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+func init() {
+	Register(PKCS11Scheme, loadPKCS11)
+}
+
+// loadPKCS11 reports that PKCS#11 requires a build with cgo
+func loadPKCS11(_ context.Context, ref string, _ Options) (crypto.Signer, error) {
+	if _, err := parsePKCS11URI(ref); err != nil {
+		return nil, err
+	}
+	return nil, metadata.ErrValue{Msg: "PKCS#11 keys are not supported by builds without cgo"}
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+//go:build cgo
+
+package signer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperSoftHSM initializes a SoftHSM token labeled tuf with user PIN 1234
+// and returns the module path, the test is skipped if SoftHSM is missing
+func helperSoftHSM(t *testing.T) string {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		for _, path := range []string{
+			"/usr/lib/softhsm/libsofthsm2.so",
+			"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+			"/usr/lib64/pkcs11/libsofthsm2.so",
+			"/usr/local/lib/softhsm/libsofthsm2.so",
+			"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+		} {
+			if _, err := os.Stat(path); err == nil {
+				module = path
+				break
+			}
+		}
+	}
+	util, err := exec.LookPath("softhsm2-util")
+	if module == "" || err != nil {
+		t.Skip("SoftHSM not found, set SOFTHSM2_MODULE to the path of libsofthsm2.so")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "softhsm2.conf")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "tokens"), 0700))
+	assert.NoError(t, os.WriteFile(config, []byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", filepath.Join(dir, "tokens"))), 0600))
+	t.Setenv("SOFTHSM2_CONF", config)
+	out, err := exec.Command(util, "--init-token", "--free", "--label", "tuf", "--pin", "1234", "--so-pin", "5678").CombinedOutput()
+	assert.NoError(t, err, string(out))
+	return module
+}
+
+// helperGenerateKeyPair generates a key pair labeled label on the tuf token
+func helperGenerateKeyPair(t *testing.T, module string, label string, mechanism uint, public []*pkcs11.Attribute) {
+	ctx := pkcs11.New(module)
+	assert.NoError(t, ctx.Initialize())
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+	slots, err := ctx.GetSlotList(true)
+	assert.NoError(t, err)
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		assert.NoError(t, err)
+		if info.Label != "tuf" {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		assert.NoError(t, err)
+		defer ctx.CloseSession(session)
+		assert.NoError(t, ctx.Login(session, pkcs11.CKU_USER, "1234"))
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		)
+		private := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		}
+		_, _, err = ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private)
+		assert.NoError(t, err)
+		return
+	}
+	t.Fatal("SoftHSM token tuf not found")
+}
+
+func TestLoadPKCS11(t *testing.T) {
+	module := helperSoftHSM(t)
+	// DER encoded OID of P-256
+	p256 := []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	helperGenerateKeyPair(t, module, "timestamp", pkcs11.CKM_EC_KEY_PAIR_GEN, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256),
+	})
+	helperGenerateKeyPair(t, module, "root", pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+	})
+
+	for _, object := range []string{"timestamp", "root"} {
+		ref := fmt.Sprintf("pkcs11:token=tuf;object=%s?module-path=%s&pin-value=1234", object, module)
+		signer, public, err := Load(context.Background(), ref, Options{})
+		assert.NoError(t, err, object)
+		helperVerifySigner(t, signer, public)
+		assert.NoError(t, signer.(*Signer).Close())
+	}
+
+	_, _, err := Load(context.Background(), fmt.Sprintf("pkcs11:token=tuf;object=missing?module-path=%s&pin-value=1234", module), Options{})
+	assert.ErrorContains(t, err, "no PKCS#11 private key found")
+	_, _, err = Load(context.Background(), fmt.Sprintf("pkcs11:token=other;object=root?module-path=%s&pin-value=1234", module), Options{})
+	assert.ErrorContains(t, err, "no PKCS#11 token found")
+}
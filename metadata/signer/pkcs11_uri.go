@@ -0,0 +1,116 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// PKCS11Scheme is the prefix of RFC 7512 PKCS#11 key references, e.g.
+// pkcs11:token=tuf;object=timestamp?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234.
+// The token is selected with the token, serial or slot-id attributes, the
+// key with the object and id attributes. The module-path query attribute
+// is required, the PIN is given with pin-value or read from the file of
+// pin-source
+const PKCS11Scheme = "pkcs11:"
+
+// pkcs11URI holds the attributes of a PKCS#11 URI used to find a key
+type pkcs11URI struct {
+	token  string
+	serial string
+	slotID *uint
+	object string
+	id     []byte
+	module string
+	pin    string
+}
+
+// parsePKCS11URI parses a PKCS#11 URI and reads the PIN of pin-source
+func parsePKCS11URI(ref string) (*pkcs11URI, error) {
+	invalid := func(msg string) error {
+		return metadata.ErrValue{Msg: fmt.Sprintf("invalid PKCS#11 URI %s: %s", ref, msg)}
+	}
+	if !strings.HasPrefix(ref, PKCS11Scheme) {
+		return nil, invalid("missing pkcs11: scheme")
+	}
+	path, query, _ := strings.Cut(strings.TrimPrefix(ref, PKCS11Scheme), "?")
+	res := &pkcs11URI{}
+	attributes := map[string]string{}
+	var parts []string
+	if path != "" {
+		parts = append(parts, strings.Split(path, ";")...)
+	}
+	if query != "" {
+		parts = append(parts, strings.Split(query, "&")...)
+	}
+	for _, attr := range parts {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			return nil, invalid(fmt.Sprintf("attribute %q has no value", attr))
+		}
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, invalid(err.Error())
+		}
+		if _, ok := attributes[name]; ok {
+			return nil, invalid(fmt.Sprintf("duplicate attribute %s", name))
+		}
+		attributes[name] = value
+	}
+	if _, ok := attributes["pin-value"]; ok {
+		if _, ok := attributes["pin-source"]; ok {
+			return nil, invalid("pin-value and pin-source are mutually exclusive")
+		}
+	}
+	for name, value := range attributes {
+		switch name {
+		case "token":
+			res.token = value
+		case "serial":
+			res.serial = value
+		case "slot-id":
+			slotID, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return nil, invalid(fmt.Sprintf("slot-id %s is not a number", value))
+			}
+			id := uint(slotID)
+			res.slotID = &id
+		case "object":
+			res.object = value
+		case "id":
+			res.id = []byte(value)
+		case "module-path":
+			res.module = value
+		case "pin-value":
+			res.pin = value
+		case "pin-source":
+			data, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the PIN: %w", err)
+			}
+			res.pin = strings.TrimRight(string(data), "\r\n")
+		}
+	}
+	if res.module == "" {
+		return nil, invalid("the module-path query attribute is required")
+	}
+	if res.object == "" && res.id == nil {
+		return nil, invalid("an object or id attribute is required")
+	}
+	return res, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/kms"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+)
+
+// Options configure how key references are resolved
+type Options struct {
+	// HTTPClient is used by the awskms provider, the gcpkms provider uses
+	// its TLS configuration
+	HTTPClient *http.Client
+	// Endpoint overrides the API address of the awskms (the ENDPOINT of the
+	// reference), gcpkms (cloudkms.googleapis.com:443) and hashivault
+	// ($VAULT_ADDR) providers
+	Endpoint string
+	// Token is the bearer token of the gcpkms provider and the token of the
+	// hashivault provider, $GOOGLE_OAUTH_ACCESS_TOKEN and $VAULT_TOKEN if empty
+	Token string
+	// Passphrase decrypts encrypted file:// keys
+	Passphrase []byte
+}
+
+// Provider returns the crypto.Signer of the key referenced by ref. The
+// signer follows the crypto.Signer conventions: it signs SHA-256 digests
+// for ECDSA and RSA (PKCS#1 v1.5) keys and messages for ed25519 keys and
+// returns ASN.1 DER encoded ECDSA signatures
+type Provider func(ctx context.Context, ref string, opts Options) (crypto.Signer, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// Register makes a provider available for references starting with prefix,
+// e.g. "awskms://". It replaces any provider registered for the prefix
+func Register(prefix string, provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[prefix] = provider
+}
+
+// Prefixes returns the reference prefixes of all registered providers
+func Prefixes() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	res := make([]string, 0, len(providers))
+	for prefix := range providers {
+		res = append(res, prefix)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Load resolves ref with the provider registered for the longest prefix of
+// it and returns a signer for Metadata[T].Sign together with the TUF key of
+// its public key. The signer implements metadata.KeyedSigner and io.Closer,
+// it should be closed once it is no longer needed
+func Load(ctx context.Context, ref string, opts Options) (signature.Signer, *metadata.Key, error) {
+	providersMu.RLock()
+	var provider Provider
+	longest := -1
+	for prefix, p := range providers {
+		if strings.HasPrefix(ref, prefix) && len(prefix) > longest {
+			provider, longest = p, len(prefix)
+		}
+	}
+	providersMu.RUnlock()
+	if provider == nil {
+		return nil, nil, metadata.ErrValue{Msg: fmt.Sprintf("no signer provider found for %s, supported are %s", ref, strings.Join(Prefixes(), ", "))}
+	}
+	cryptoSigner, err := provider(ctx, ref, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := New(cryptoSigner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, s.key, nil
+}
+
+// Signer adapts a crypto.Signer to a signature.Signer producing the
+// signatures Metadata[T].VerifyDelegate accepts for its key type
+type Signer struct {
+	signer crypto.Signer
+	key    *metadata.Key
+	hash   crypto.Hash
+}
+
+// New returns the signature.Signer of a crypto.Signer for ed25519, ECDSA
+// P-256 or RSA keys. The TUF key is derived from the public key unless the
+// crypto.Signer has a Key() *metadata.Key method
+func New(s crypto.Signer) (*Signer, error) {
+	hash := crypto.SHA256
+	switch public := s.Public().(type) {
+	case ed25519.PublicKey:
+		hash = crypto.Hash(0)
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported ecdsa curve %s", public.Curve.Params().Name)}
+		}
+	case *rsa.PublicKey:
+	default:
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("unsupported public key type %T", public)}
+	}
+	// providers may know the TUF key, e.g. with its keyid_hash_algorithms
+	if keyed, ok := s.(interface{ Key() *metadata.Key }); ok {
+		return &Signer{signer: s, key: keyed.Key(), hash: hash}, nil
+	}
+	key, err := metadata.KeyFromPublicKey(s.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{signer: s, key: key, hash: hash}, nil
+}
+
+// Key returns the TUF key of the signer
+func (s *Signer) Key() *metadata.Key {
+	return s.key
+}
+
+// PublicKey returns the public key of the signer
+func (s *Signer) PublicKey(_ ...signature.PublicKeyOption) (crypto.PublicKey, error) {
+	return s.signer.Public(), nil
+}
+
+// SignMessage signs the content of message
+func (s *Signer) SignMessage(message io.Reader, opts ...signature.SignOption) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	digest := data
+	if s.hash != crypto.Hash(0) {
+		h := s.hash.New()
+		h.Write(data)
+		digest = h.Sum(nil)
+	}
+	rnd := io.Reader(rand.Reader)
+	for _, opt := range opts {
+		opt.ApplyRand(&rnd)
+	}
+	return s.signer.Sign(rnd, digest, s.hash)
+}
+
+// Close releases the resources of the underlying signer, e.g. a PKCS#11
+// session
+func (s *Signer) Close() error {
+	if closer, ok := s.signer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ensure the Signer satisfies the interfaces it is used through
+var (
+	_ signature.Signer     = (*Signer)(nil)
+	_ metadata.KeyedSigner = (*Signer)(nil)
+)
+
+// kmsSigner returns the crypto.Signer of a sigstore KMS signer. The public
+// key is fetched first since the crypto.Signer can't report its errors
+func kmsSigner(ctx context.Context, sv kms.SignerVerifier) (crypto.Signer, error) {
+	if _, err := sv.PublicKey(options.WithContext(ctx)); err != nil {
+		return nil, err
+	}
+	s, _, err := sv.CryptoSigner(ctx, nil)
+	return s, err
+}
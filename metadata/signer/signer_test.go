@@ -0,0 +1,381 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// helperVerifySigner checks metadata signed by the signer verifies with key
+func helperVerifySigner(t *testing.T, signer signature.Signer, key *metadata.Key) {
+	root := metadata.Root(time.Now().AddDate(0, 0, 1))
+	assert.NoError(t, root.Signed.AddKey(key, metadata.ROOT))
+	sig, err := root.Sign(signer)
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID(), sig.KeyID)
+	assert.NoError(t, root.VerifyDelegate(metadata.ROOT, root))
+}
+
+// helperGenerate returns a new private key of keyType
+func helperGenerate(t *testing.T, keyType string) *keys.PrivateKey {
+	key, err := keys.Generate(keyType)
+	assert.NoError(t, err)
+	return key
+}
+
+// helperServe starts a TLS server with handler and returns options to use it
+func helperServe(t *testing.T, handler http.HandlerFunc) (*httptest.Server, Options) {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	return server, Options{HTTPClient: server.Client(), Endpoint: server.URL, Token: "token"}
+}
+
+// helperWriteJSON writes v as JSON response
+func helperWriteJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestLoadUnknownScheme(t *testing.T) {
+	_, _, err := Load(context.Background(), "azurekms://vault/key", Options{})
+	assert.ErrorContains(t, err, "no signer provider found for azurekms://vault/key")
+	assert.Contains(t, Prefixes(), AWSKMSScheme)
+}
+
+func TestLoadLongestPrefix(t *testing.T) {
+	general := helperGenerate(t, metadata.KeyTypeEd25519)
+	special := helperGenerate(t, metadata.KeyTypeEd25519)
+	Register("test://", func(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+		return general.Private.(crypto.Signer), nil
+	})
+	Register("test://special/", func(ctx context.Context, ref string, opts Options) (crypto.Signer, error) {
+		return special.Private.(crypto.Signer), nil
+	})
+	for i := 0; i < 10; i++ {
+		_, public, err := Load(context.Background(), "test://special/key", Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, special.ID(), public.ID())
+		_, public, err = Load(context.Background(), "test://key", Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, general.ID(), public.ID())
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	passphrase := []byte("secret")
+	for _, keyType := range []string{metadata.KeyTypeEd25519, metadata.KeyTypeECDSA_SHA2_P256} {
+		key := helperGenerate(t, keyType)
+		for _, format := range []keys.Format{keys.FormatPEM, keys.FormatEncryptedPEM, keys.FormatSSLib} {
+			data, err := key.Export(format, passphrase)
+			assert.NoError(t, err)
+			name := filepath.Join(dir, fmt.Sprintf("%s-%s", keyType, format))
+			assert.NoError(t, os.WriteFile(name, data, 0600))
+
+			signer, public, err := Load(context.Background(), FileScheme+name, Options{Passphrase: passphrase})
+			assert.NoError(t, err, "%s %s", keyType, format)
+			assert.Equal(t, key.ID(), public.ID(), "%s %s", keyType, format)
+			helperVerifySigner(t, signer, public)
+		}
+	}
+	_, _, err := Load(context.Background(), FileScheme+filepath.Join(dir, "missing"), Options{})
+	assert.Error(t, err)
+}
+
+// helperAWSEnv isolates the AWS SDK default chain from the environment
+func helperAWSEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CA_BUNDLE", "")
+}
+
+// helperAWSKMS returns a handler serving the AWS KMS JSON API for key
+func helperAWSKMS(t *testing.T, key *keys.PrivateKey, algorithm string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+			helperWriteJSON(w, map[string]string{"__type": "AccessDeniedException", "message": "bad signature"})
+			return
+		}
+		var in struct {
+			KeyID            string `json:"KeyId"`
+			Message          []byte
+			MessageType      string
+			SigningAlgorithm string
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		if in.KeyID != "alias/timestamp" {
+			w.WriteHeader(http.StatusBadRequest)
+			helperWriteJSON(w, map[string]string{"__type": "NotFoundException", "message": "no such key"})
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.DescribeKey":
+			helperWriteJSON(w, map[string]any{"KeyMetadata": map[string]any{
+				"KeyId":             in.KeyID,
+				"KeyUsage":          "SIGN_VERIFY",
+				"SigningAlgorithms": []string{algorithm},
+			}})
+		case "TrentService.GetPublicKey":
+			der, err := x509.MarshalPKIXPublicKey(key.Private.Public())
+			assert.NoError(t, err)
+			helperWriteJSON(w, map[string]any{"KeyId": in.KeyID, "PublicKey": der})
+		case "TrentService.Sign":
+			assert.Equal(t, "DIGEST", in.MessageType)
+			assert.Equal(t, algorithm, in.SigningAlgorithm)
+			sig, err := key.Private.Sign(rand.Reader, in.Message, crypto.SHA256)
+			assert.NoError(t, err)
+			helperWriteJSON(w, map[string]any{"KeyId": in.KeyID, "Signature": sig, "SigningAlgorithm": in.SigningAlgorithm})
+		}
+	}
+}
+
+func TestLoadAWSKMS(t *testing.T) {
+	helperAWSEnv(t)
+	algorithms := map[string]string{
+		metadata.KeyTypeECDSA_SHA2_P256:   "ECDSA_SHA_256",
+		metadata.KeyTypeRSASSA_PSS_SHA256: "RSASSA_PKCS1_V1_5_SHA_256",
+	}
+	for keyType, algorithm := range algorithms {
+		key := helperGenerate(t, keyType)
+		server, opts := helperServe(t, helperAWSKMS(t, key, algorithm))
+		host := strings.TrimPrefix(server.URL, "https://")
+
+		signer, public, err := Load(context.Background(), AWSKMSScheme+host+"/alias/timestamp", opts)
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID(), public.ID())
+		helperVerifySigner(t, signer, public)
+
+		_, _, err = Load(context.Background(), AWSKMSScheme+host+"/alias/missing", opts)
+		assert.ErrorContains(t, err, "NotFoundException: no such key")
+	}
+	_, _, err := Load(context.Background(), AWSKMSScheme+"localhost", Options{})
+	assert.ErrorContains(t, err, "kms specification should be in the format awskms://")
+}
+
+func TestLoadAWSKMSEndpoint(t *testing.T) {
+	helperAWSEnv(t)
+	key := helperGenerate(t, metadata.KeyTypeECDSA_SHA2_P256)
+	server := httptest.NewServer(helperAWSKMS(t, key, "ECDSA_SHA_256"))
+	t.Cleanup(server.Close)
+
+	signer, public, err := Load(context.Background(), AWSKMSScheme+"/alias/timestamp", Options{Endpoint: server.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID(), public.ID())
+	helperVerifySigner(t, signer, public)
+
+	t.Setenv("AWS_SESSION_TOKEN", "expired")
+	_, _, err = Load(context.Background(), AWSKMSScheme+"/alias/timestamp", Options{Endpoint: server.URL})
+	assert.ErrorContains(t, err, "AccessDeniedException: bad signature")
+}
+
+// fakeGCPKMS serves the Cloud KMS API for a single key version
+type fakeGCPKMS struct {
+	kmspb.UnimplementedKeyManagementServiceServer
+	t         *testing.T
+	key       *keys.PrivateKey
+	name      string
+	algorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+}
+
+func (f *fakeGCPKMS) GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest) (*kmspb.CryptoKey, error) {
+	if !strings.HasPrefix(f.name, req.Name+"/") {
+		return nil, status.Error(codes.NotFound, "no such key")
+	}
+	return &kmspb.CryptoKey{Name: req.Name, Purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN}, nil
+}
+
+func (f *fakeGCPKMS) GetCryptoKeyVersion(ctx context.Context, req *kmspb.GetCryptoKeyVersionRequest) (*kmspb.CryptoKeyVersion, error) {
+	if req.Name != f.name {
+		return nil, status.Error(codes.NotFound, "no such key version")
+	}
+	return &kmspb.CryptoKeyVersion{Name: f.name, Algorithm: f.algorithm, State: kmspb.CryptoKeyVersion_ENABLED}, nil
+}
+
+func (f *fakeGCPKMS) GetPublicKey(ctx context.Context, req *kmspb.GetPublicKeyRequest) (*kmspb.PublicKey, error) {
+	pem, err := cryptoutils.MarshalPublicKeyToPEM(f.key.Private.Public())
+	assert.NoError(f.t, err)
+	return &kmspb.PublicKey{Name: req.Name, Pem: string(pem), Algorithm: f.algorithm}, nil
+}
+
+func (f *fakeGCPKMS) AsymmetricSign(ctx context.Context, req *kmspb.AsymmetricSignRequest) (*kmspb.AsymmetricSignResponse, error) {
+	sig, err := f.key.Private.Sign(rand.Reader, req.Digest.GetSha256(), crypto.SHA256)
+	assert.NoError(f.t, err)
+	table := crc32.MakeTable(crc32.Castagnoli)
+	return &kmspb.AsymmetricSignResponse{
+		Name:                 req.Name,
+		Signature:            sig,
+		SignatureCrc32C:      wrapperspb.Int64(int64(crc32.Checksum(sig, table))),
+		VerifiedDigestCrc32C: req.DigestCrc32C.GetValue() == int64(crc32.Checksum(req.Digest.GetSha256(), table)),
+	}, nil
+}
+
+func TestLoadGCPKMS(t *testing.T) {
+	name := "projects/tuf/locations/global/keyRings/repo/cryptoKeys/timestamp/cryptoKeyVersions/1"
+	algorithms := map[string]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+		metadata.KeyTypeECDSA_SHA2_P256:   kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+		metadata.KeyTypeRSASSA_PSS_SHA256: kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256,
+	}
+	for keyType, algorithm := range algorithms {
+		key := helperGenerate(t, keyType)
+		// the TLS configuration of the test server secures the gRPC server
+		server, opts := helperServe(t, nil)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(server.TLS.Clone())),
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				md, _ := grpcmetadata.FromIncomingContext(ctx)
+				if !slices.Contains(md.Get("authorization"), "Bearer token") {
+					return nil, status.Error(codes.Unauthenticated, "invalid token")
+				}
+				return handler(ctx, req)
+			}))
+		kmspb.RegisterKeyManagementServiceServer(grpcServer, &fakeGCPKMS{t: t, key: key, name: name, algorithm: algorithm})
+		go func() { _ = grpcServer.Serve(listener) }()
+		t.Cleanup(grpcServer.Stop)
+		opts.Endpoint = listener.Addr().String()
+
+		signer, public, err := Load(context.Background(), GCPKMSScheme+name, opts)
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID(), public.ID())
+		helperVerifySigner(t, signer, public)
+
+		opts.Token = "expired"
+		_, _, err = Load(context.Background(), GCPKMSScheme+name, opts)
+		assert.ErrorContains(t, err, "invalid token")
+	}
+	_, _, err := Load(context.Background(), GCPKMSScheme+"projects/tuf/keys/timestamp", Options{Token: "token"})
+	assert.ErrorContains(t, err, "kms specification should be in the format gcpkms://")
+}
+
+func TestLoadHashiVault(t *testing.T) {
+	t.Setenv("TRANSIT_SECRET_ENGINE_PATH", "")
+	t.Setenv("VAULT_KEY_PREFIX", "")
+	vaultTypes := map[string]string{
+		metadata.KeyTypeECDSA_SHA2_P256:   "ecdsa-p256",
+		metadata.KeyTypeRSASSA_PSS_SHA256: "rsa-2048",
+	}
+	for keyType, vaultType := range vaultTypes {
+		key := helperGenerate(t, keyType)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Vault-Token") != "token" {
+				w.WriteHeader(http.StatusForbidden)
+				helperWriteJSON(w, map[string][]string{"errors": {"permission denied"}})
+				return
+			}
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/timestamp":
+				pem, err := cryptoutils.MarshalPublicKeyToPEM(key.Private.Public())
+				assert.NoError(t, err)
+				helperWriteJSON(w, map[string]any{"data": map[string]any{
+					"type":           vaultType,
+					"latest_version": 2,
+					"keys":           map[string]any{"2": map[string]string{"public_key": string(pem)}},
+				}})
+			case r.Method == http.MethodPut && r.URL.Path == "/v1/transit/sign/timestamp/sha2-256":
+				var in struct {
+					Input     []byte `json:"input"`
+					Prehashed bool   `json:"prehashed"`
+				}
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+				assert.True(t, in.Prehashed)
+				sig, err := key.Private.Sign(rand.Reader, in.Input, crypto.SHA256)
+				assert.NoError(t, err)
+				helperWriteJSON(w, map[string]any{"data": map[string]string{"signature": "vault:v2:" + base64.StdEncoding.EncodeToString(sig)}})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				helperWriteJSON(w, map[string][]string{"errors": {}})
+			}
+		}))
+		t.Cleanup(server.Close)
+		opts := Options{Endpoint: server.URL, Token: "token"}
+
+		signer, public, err := Load(context.Background(), HashiVaultScheme+"timestamp", opts)
+		if vaultType != "ecdsa-p256" {
+			assert.ErrorContains(t, err, "only ecdsa-p256 keys are supported")
+			continue
+		}
+		assert.NoError(t, err, vaultType)
+		assert.Equal(t, key.ID(), public.ID())
+		helperVerifySigner(t, signer, public)
+
+		_, _, err = Load(context.Background(), HashiVaultScheme+"missing", opts)
+		assert.ErrorContains(t, err, "could not read data from transit key path")
+		opts.Token = "other"
+		_, _, err = Load(context.Background(), HashiVaultScheme+"timestamp", opts)
+		assert.ErrorContains(t, err, "permission denied")
+	}
+	t.Setenv("VAULT_ADDR", "")
+	_, _, err := Load(context.Background(), HashiVaultScheme+"timestamp", Options{})
+	assert.ErrorContains(t, err, "VAULT_ADDR is not set")
+}
+
+func TestParsePKCS11URI(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	assert.NoError(t, os.WriteFile(pinFile, []byte("1234\n"), 0600))
+
+	uri, err := parsePKCS11URI("pkcs11:token=my%20token;object=timestamp;id=%01%02;slot-id=3?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:" + pinFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "my token", uri.token)
+	assert.Equal(t, "timestamp", uri.object)
+	assert.Equal(t, []byte{1, 2}, uri.id)
+	assert.Equal(t, uint(3), *uri.slotID)
+	assert.Equal(t, "/usr/lib/softhsm/libsofthsm2.so", uri.module)
+	assert.Equal(t, "1234", uri.pin)
+
+	for ref, msg := range map[string]string{
+		"pkcs11:object=timestamp":                                     "module-path query attribute is required",
+		"pkcs11:token=tuf?module-path=/lib.so":                        "an object or id attribute is required",
+		"pkcs11:object=a;object=b?module-path=/lib.so":                "duplicate attribute object",
+		"pkcs11:object=a;slot-id=x?module-path=/lib.so":               "slot-id x is not a number",
+		"pkcs11:object=a?module-path=/lib.so&pin-value=1&pin-source=": "mutually exclusive",
+	} {
+		_, err := parsePKCS11URI(ref)
+		assert.ErrorContains(t, err, msg, ref)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	log "github.com/sirupsen/logrus"
+)
+
+// FileSystem stores metadata and target files in local directories.
+// Metadata is stored as <MetadataDir>/<url-escaped-role-name>.json and
+// target files as <TargetsDir>/<url-escaped-target-path>
+type FileSystem struct {
+	MetadataDir string
+	TargetsDir  string
+}
+
+// NewFileSystem creates a file system Storage and makes sure that the given
+// directories exist. An empty directory means that the corresponding kind
+// of files can not be stored
+func NewFileSystem(metadataDir, targetsDir string) (*FileSystem, error) {
+	for _, path := range []string{metadataDir, targetsDir} {
+		if path == "" {
+			continue
+		}
+		err := os.MkdirAll(path, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FileSystem{
+		MetadataDir: metadataDir,
+		TargetsDir:  targetsDir,
+	}, nil
+}
+
+// ReadMetadata reads the local <roleName>.json file
+func (f *FileSystem) ReadMetadata(roleName string) ([]byte, error) {
+	name, err := f.metadataPath(roleName)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(name)
+}
+
+// WriteMetadata writes the local <roleName>.json file atomically
+func (f *FileSystem) WriteMetadata(roleName string, data []byte) error {
+	name, err := f.metadataPath(roleName)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(name, data, 0644)
+}
+
+// DeleteMetadata removes the local <roleName>.json file
+func (f *FileSystem) DeleteMetadata(roleName string) error {
+	name, err := f.metadataPath(roleName)
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// ReadTarget reads the local target file for targetPath
+func (f *FileSystem) ReadTarget(targetPath string) ([]byte, error) {
+	if f.TargetsDir == "" {
+		return nil, metadata.ErrValue{Msg: "targets directory must be set to read target files"}
+	}
+	return os.ReadFile(f.TargetLocation(targetPath))
+}
+
+// WriteTarget writes the local target file for targetPath atomically
+func (f *FileSystem) WriteTarget(targetPath string, data []byte) error {
+	if f.TargetsDir == "" {
+		return metadata.ErrValue{Msg: "targets directory must be set to write target files"}
+	}
+	return WriteFileAtomic(f.TargetLocation(targetPath), data, 0644)
+}
+
+// DeleteTarget removes the local target file for targetPath
+func (f *FileSystem) DeleteTarget(targetPath string) error {
+	if f.TargetsDir == "" {
+		return metadata.ErrValue{Msg: "targets directory must be set to delete target files"}
+	}
+	return os.Remove(f.TargetLocation(targetPath))
+}
+
+// TargetLocation returns the path of the local target file for targetPath.
+// The URL encoded target path is used as a file name
+func (f *FileSystem) TargetLocation(targetPath string) string {
+	return filepath.Join(f.TargetsDir, url.QueryEscape(targetPath))
+}
+
+// metadataPath returns the path of the local metadata file for roleName
+func (f *FileSystem) metadataPath(roleName string) (string, error) {
+	if f.MetadataDir == "" {
+		return "", metadata.ErrValue{Msg: "metadata directory must be set to access metadata files"}
+	}
+	return filepath.Join(f.MetadataDir, fmt.Sprintf("%s.json", url.QueryEscape(roleName))), nil
+}
+
+// WriteFileAtomic writes data to name so that the file either has its old
+// content or the new one, even if the process crashes mid-way. The data is
+// written to a temporary file in the same directory (so the rename does not
+// cross devices), synced to disk and then renamed over name
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+	// create a temporary file next to the destination
+	file, err := os.CreateTemp(dir, fmt.Sprintf(".%s.tmp", filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+	// make sure the temporary file is gone if anything fails
+	cleanup := func(err error) error {
+		_ = file.Close()
+		errRemove := os.Remove(tmpName)
+		if errRemove != nil && !os.IsNotExist(errRemove) {
+			log.Debugf("Failed to delete temporary file: %s", tmpName)
+		}
+		return err
+	}
+	// write the data content to the temporary file
+	if _, err := file.Write(data); err != nil {
+		return cleanup(err)
+	}
+	// flush the content to disk before it becomes visible under the new name
+	if err := file.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := file.Chmod(perm); err != nil {
+		return cleanup(err)
+	}
+	if err := file.Close(); err != nil {
+		return cleanup(err)
+	}
+	// if all okay, rename the temporary file to the desired one
+	if err := os.Rename(tmpName, name); err != nil {
+		return cleanup(err)
+	}
+	// sync the directory so the rename itself is persisted
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entry changes to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// not every platform/file system supports syncing a directory, so we don't
+	// treat that as a failure as the file content itself is already synced
+	if err := d.Sync(); err != nil {
+		log.Debugf("Failed to sync directory %s: %v", dir, err)
+	}
+	return nil
+}
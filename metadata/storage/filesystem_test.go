@@ -0,0 +1,119 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperDirEntries returns the names of the entries of dir
+func helperDirEntries(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	res := []string{}
+	for _, entry := range entries {
+		res = append(res, entry.Name())
+	}
+	return res
+}
+
+func TestFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	metadataDir := filepath.Join(dir, "metadata")
+	targetsDir := filepath.Join(dir, "targets")
+	fsStorage, err := NewFileSystem(metadataDir, targetsDir)
+	assert.NoError(t, err)
+	assert.DirExists(t, metadataDir)
+	assert.DirExists(t, targetsDir)
+
+	// role names and target paths are escaped into a single file name
+	assert.NoError(t, fsStorage.WriteMetadata("a/b", []byte("role")))
+	assert.FileExists(t, filepath.Join(metadataDir, "a%2Fb.json"))
+	data, err := fsStorage.ReadMetadata("a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "role", string(data))
+	assert.NoError(t, fsStorage.WriteTarget("files/hello.txt", []byte("hello")))
+	assert.Equal(t, filepath.Join(targetsDir, "files%2Fhello.txt"), fsStorage.TargetLocation("files/hello.txt"))
+	data, err = fsStorage.ReadTarget("files/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// writes replace the previous content
+	assert.NoError(t, fsStorage.WriteMetadata("a/b", []byte("new role")))
+	data, err = fsStorage.ReadMetadata("a/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "new role", string(data))
+
+	assert.NoError(t, fsStorage.DeleteMetadata("a/b"))
+	assert.NoError(t, fsStorage.DeleteTarget("files/hello.txt"))
+	_, err = fsStorage.ReadMetadata("a/b")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fsStorage.ReadTarget("files/hello.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorIs(t, fsStorage.DeleteTarget("files/hello.txt"), fs.ErrNotExist)
+	assert.Empty(t, helperDirEntries(t, metadataDir))
+	assert.Empty(t, helperDirEntries(t, targetsDir))
+}
+
+func TestFileSystemWithoutDirs(t *testing.T) {
+	fsStorage, err := NewFileSystem("", "")
+	assert.NoError(t, err)
+	_, err = fsStorage.ReadMetadata("root")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "metadata directory must be set to access metadata files"})
+	assert.ErrorIs(t, fsStorage.WriteMetadata("root", nil), metadata.ErrValue{Msg: "metadata directory must be set to access metadata files"})
+	assert.ErrorIs(t, fsStorage.DeleteMetadata("root"), metadata.ErrValue{Msg: "metadata directory must be set to access metadata files"})
+	_, err = fsStorage.ReadTarget("hello.txt")
+	assert.ErrorIs(t, err, metadata.ErrValue{Msg: "targets directory must be set to read target files"})
+	assert.ErrorIs(t, fsStorage.WriteTarget("hello.txt", nil), metadata.ErrValue{Msg: "targets directory must be set to write target files"})
+	assert.ErrorIs(t, fsStorage.DeleteTarget("hello.txt"), metadata.ErrValue{Msg: "targets directory must be set to delete target files"})
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.json")
+	assert.NoError(t, WriteFileAtomic(name, []byte("first"), 0600))
+	assert.NoError(t, WriteFileAtomic(name, []byte("second"), 0600))
+	data, err := os.ReadFile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	info, err := os.Stat(name)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// no temporary file is left behind
+	assert.Equal(t, []string{"file.json"}, helperDirEntries(t, dir))
+}
+
+func TestWriteFileAtomicErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	// the temporary file can't be created
+	err := WriteFileAtomic(filepath.Join(dir, "missing", "file.json"), []byte("data"), 0644)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Empty(t, helperDirEntries(t, dir))
+
+	// the rename fails as the destination is a non-empty directory, the
+	// temporary file is removed and the destination is left untouched
+	name := filepath.Join(dir, "file.json")
+	assert.NoError(t, os.MkdirAll(filepath.Join(name, "child"), 0755))
+	err = WriteFileAtomic(name, []byte("data"), 0644)
+	var linkErr *os.LinkError
+	assert.True(t, errors.As(err, &linkErr))
+	assert.Equal(t, []string{"file.json"}, helperDirEntries(t, dir))
+	assert.DirExists(t, filepath.Join(name, "child"))
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package storage
+
+import (
+	"sync"
+)
+
+// MemoryStore is an in-memory KeyValueStore. It is safe for concurrent use
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemory creates a Storage which keeps everything in memory, i.e. nothing
+// survives the lifetime of the process
+func NewMemory() Storage {
+	return NewKeyValue(NewMemoryStore())
+}
+
+// NewMemoryStore creates an empty in-memory key-value store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]map[string][]byte{},
+	}
+}
+
+// Get returns a copy of the value stored for key in bucket
+func (m *MemoryStore) Get(bucket, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, errNotExist(bucket, key)
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Put stores a copy of value for key in bucket
+func (m *MemoryStore) Put(bucket, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[bucket]; !ok {
+		m.buckets[bucket] = map[string][]byte{}
+	}
+	m.buckets[bucket][key] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete removes key from bucket
+func (m *MemoryStore) Delete(bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.buckets[bucket][key]; !ok {
+		return errNotExist(bucket, key)
+	}
+	delete(m.buckets[bucket], key)
+	return nil
+}
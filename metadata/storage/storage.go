@@ -0,0 +1,106 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+)
+
+// Storage interface for the local cache of trusted metadata and target files.
+// Implementations must make writes atomic, i.e. a reader sees either the old
+// or the new content of a role or target file but never a partially written one.
+// Reading something that does not exist must return an error wrapping fs.ErrNotExist
+type Storage interface {
+	// ReadMetadata returns the locally stored metadata for roleName
+	ReadMetadata(roleName string) ([]byte, error)
+	// WriteMetadata stores the metadata for roleName
+	WriteMetadata(roleName string, data []byte) error
+	// DeleteMetadata removes the locally stored metadata for roleName
+	DeleteMetadata(roleName string) error
+	// ReadTarget returns the locally stored target file for targetPath
+	ReadTarget(targetPath string) ([]byte, error)
+	// WriteTarget stores the target file for targetPath
+	WriteTarget(targetPath string, data []byte) error
+	// DeleteTarget removes the locally stored target file for targetPath
+	DeleteTarget(targetPath string) error
+	// TargetLocation returns where the target file for targetPath is stored.
+	// For the file system storage this is a path on disk
+	TargetLocation(targetPath string) string
+}
+
+// KeyValueStore is the minimal interface an embedded key-value database
+// (bbolt, badger, pebble, etc.) has to implement in order to be used as a
+// Storage through NewKeyValue. Values are grouped in buckets, one for
+// metadata and one for target files
+type KeyValueStore interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+}
+
+// Bucket names used by the key-value storage
+const (
+	MetadataBucket = "metadata"
+	TargetsBucket  = "targets"
+)
+
+// keyValueStorage adapts a KeyValueStore to the Storage interface
+type keyValueStorage struct {
+	kv KeyValueStore
+}
+
+// NewKeyValue creates a Storage backed by the given key-value store
+func NewKeyValue(kv KeyValueStore) Storage {
+	return &keyValueStorage{kv: kv}
+}
+
+// ReadMetadata returns the stored metadata for roleName
+func (s *keyValueStorage) ReadMetadata(roleName string) ([]byte, error) {
+	return s.kv.Get(MetadataBucket, roleName)
+}
+
+// WriteMetadata stores the metadata for roleName
+func (s *keyValueStorage) WriteMetadata(roleName string, data []byte) error {
+	return s.kv.Put(MetadataBucket, roleName, data)
+}
+
+// DeleteMetadata removes the stored metadata for roleName
+func (s *keyValueStorage) DeleteMetadata(roleName string) error {
+	return s.kv.Delete(MetadataBucket, roleName)
+}
+
+// ReadTarget returns the stored target file for targetPath
+func (s *keyValueStorage) ReadTarget(targetPath string) ([]byte, error) {
+	return s.kv.Get(TargetsBucket, targetPath)
+}
+
+// WriteTarget stores the target file for targetPath
+func (s *keyValueStorage) WriteTarget(targetPath string, data []byte) error {
+	return s.kv.Put(TargetsBucket, targetPath, data)
+}
+
+// DeleteTarget removes the stored target file for targetPath
+func (s *keyValueStorage) DeleteTarget(targetPath string) error {
+	return s.kv.Delete(TargetsBucket, targetPath)
+}
+
+// TargetLocation returns the bucket and key of the target file as a path
+func (s *keyValueStorage) TargetLocation(targetPath string) string {
+	return fmt.Sprintf("%s/%s", TargetsBucket, url.QueryEscape(targetPath))
+}
+
+// errNotExist returns an error wrapping fs.ErrNotExist for the given name
+func errNotExist(bucket, key string) error {
+	return fmt.Errorf("%s/%s: %w", bucket, key, fs.ErrNotExist)
+}
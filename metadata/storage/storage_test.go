@@ -0,0 +1,103 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingStore is a KeyValueStore which records the calls it receives
+type recordingStore struct {
+	*MemoryStore
+	calls []string
+}
+
+func (r *recordingStore) Get(bucket, key string) ([]byte, error) {
+	r.calls = append(r.calls, fmt.Sprintf("get %s %s", bucket, key))
+	return r.MemoryStore.Get(bucket, key)
+}
+
+func (r *recordingStore) Put(bucket, key string, value []byte) error {
+	r.calls = append(r.calls, fmt.Sprintf("put %s %s", bucket, key))
+	return r.MemoryStore.Put(bucket, key, value)
+}
+
+func (r *recordingStore) Delete(bucket, key string) error {
+	r.calls = append(r.calls, fmt.Sprintf("delete %s %s", bucket, key))
+	return r.MemoryStore.Delete(bucket, key)
+}
+
+func TestKeyValue(t *testing.T) {
+	store := &recordingStore{MemoryStore: NewMemoryStore()}
+	kv := NewKeyValue(store)
+
+	assert.NoError(t, kv.WriteMetadata("root", []byte("root")))
+	assert.NoError(t, kv.WriteTarget("files/hello.txt", []byte("hello")))
+	data, err := kv.ReadMetadata("root")
+	assert.NoError(t, err)
+	assert.Equal(t, "root", string(data))
+	data, err = kv.ReadTarget("files/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.NoError(t, kv.DeleteMetadata("root"))
+	assert.NoError(t, kv.DeleteTarget("files/hello.txt"))
+	assert.Equal(t, []string{
+		"put metadata root",
+		"put targets files/hello.txt",
+		"get metadata root",
+		"get targets files/hello.txt",
+		"delete metadata root",
+		"delete targets files/hello.txt",
+	}, store.calls)
+	assert.Equal(t, "targets/files%2Fhello.txt", kv.TargetLocation("files/hello.txt"))
+}
+
+func TestMemory(t *testing.T) {
+	memory := NewMemory()
+
+	// metadata and target files don't share keys
+	assert.NoError(t, memory.WriteMetadata("same", []byte("metadata")))
+	assert.NoError(t, memory.WriteTarget("same", []byte("target")))
+	data, err := memory.ReadMetadata("same")
+	assert.NoError(t, err)
+	assert.Equal(t, "metadata", string(data))
+	data, err = memory.ReadTarget("same")
+	assert.NoError(t, err)
+	assert.Equal(t, "target", string(data))
+
+	// the stored content is a copy in both directions
+	written := []byte("copy")
+	assert.NoError(t, memory.WriteMetadata("copy", written))
+	written[0] = 'X'
+	data, err = memory.ReadMetadata("copy")
+	assert.NoError(t, err)
+	assert.Equal(t, "copy", string(data))
+	data[0] = 'X'
+	data, err = memory.ReadMetadata("copy")
+	assert.NoError(t, err)
+	assert.Equal(t, "copy", string(data))
+
+	// missing entries are reported like missing files
+	assert.NoError(t, memory.DeleteMetadata("same"))
+	_, err = memory.ReadMetadata("same")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorContains(t, err, "metadata/same")
+	assert.ErrorIs(t, memory.DeleteMetadata("same"), fs.ErrNotExist)
+	assert.ErrorIs(t, memory.DeleteTarget("missing"), fs.ErrNotExist)
+	data, err = memory.ReadTarget("same")
+	assert.NoError(t, err)
+	assert.Equal(t, "target", string(data))
+}
@@ -0,0 +1,329 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package metadata
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+)
+
+// Signatures can be recorded in a Rekor style transparency log when they are
+// made, see WithSignatureRecorder. The log entry and its inclusion proof are
+// stored in the tlog_entry field of the signature
+
+// InclusionProof proves a log entry is included in the tree of a signed
+// checkpoint (RFC 6962 Merkle tree)
+type InclusionProof struct {
+	LogIndex int64 `json:"log_index"`
+	TreeSize int64 `json:"tree_size"`
+	// RootHash is the hex encoded root hash of the tree
+	RootHash string `json:"root_hash"`
+	// Hashes are the hex encoded hashes of the audit path
+	Hashes []string `json:"hashes"`
+	// Checkpoint is the signed note committing the log to the tree
+	Checkpoint string `json:"checkpoint"`
+}
+
+// TlogPolicy configures the verification of the transparency log entries
+// of signatures
+type TlogPolicy struct {
+	// Logs are the public keys of the trusted logs by log ID, see LogID
+	Logs map[string]crypto.PublicKey
+	// Origins are the checkpoint origins of the trusted logs by log ID,
+	// e.g. "rekor.sigstore.dev - 2605736670972794746"
+	Origins map[string]string
+	// Required rejects signatures without a valid inclusion proof in one
+	// of the trusted logs
+	Required bool
+}
+
+// SignatureRecorder records signatures made by Metadata[T].Sign, e.g. in a
+// transparency log
+type SignatureRecorder interface {
+	RecordSignature(sig *Signature, payload []byte, key *Key) error
+}
+
+// SignOption configures Metadata[T].Sign
+type SignOption func(*signOptions)
+
+// signOptions are the options of a Sign call
+type signOptions struct {
+	recorders []SignatureRecorder
+}
+
+// WithSignatureRecorder records the signature with recorder before it is
+// added to the metadata
+func WithSignatureRecorder(recorder SignatureRecorder) SignOption {
+	return func(opts *signOptions) {
+		opts.recorders = append(opts.recorders, recorder)
+	}
+}
+
+// WithTlogPolicy configures the transparency log verification of the
+// signatures checked by Metadata[T].VerifyDelegate, nil disables it
+func WithTlogPolicy(policy *TlogPolicy) VerifyOption {
+	return func(opts *verifyOptions) {
+		opts.tlogPolicy = policy
+	}
+}
+
+// AddLog trusts the log with the public key whose checkpoints are
+// signed with origin
+func (policy *TlogPolicy) AddLog(publicKey crypto.PublicKey, origin string) error {
+	logID, err := LogID(publicKey)
+	if err != nil {
+		return err
+	}
+	if policy.Logs == nil {
+		policy.Logs = map[string]crypto.PublicKey{}
+	}
+	if policy.Origins == nil {
+		policy.Origins = map[string]string{}
+	}
+	policy.Logs[logID] = publicKey
+	policy.Origins[logID] = origin
+	return nil
+}
+
+// TlogEntry returns the transparency log entry of the signature. Keyless
+// signatures have theirs in the bundle
+func (s *Signature) TlogEntry() (*TlogEntry, error) {
+	value, ok := s.UnrecognizedFields["tlog_entry"]
+	if !ok {
+		if _, ok := s.UnrecognizedFields["bundle"]; ok {
+			bundle, err := s.KeylessBundle()
+			if err != nil {
+				return nil, err
+			}
+			if bundle.TlogEntry != nil {
+				return bundle.TlogEntry, nil
+			}
+		}
+		return nil, ErrValue{Msg: fmt.Sprintf("signature of key ID %s has no transparency log entry", s.KeyID)}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	entry := &TlogEntry{}
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, ErrValue{Msg: fmt.Sprintf("invalid transparency log entry in signature of key ID %s: %s", s.KeyID, err)}
+	}
+	return entry, nil
+}
+
+// SetTlogEntry stores entry in the signature
+func (s *Signature) SetTlogEntry(entry *TlogEntry) error {
+	return s.setField("tlog_entry", entry)
+}
+
+// setField stores value as unrecognized field name, the way it is
+// unmarshalled so signatures compare equal
+func (s *Signature) setField(name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var field map[string]any
+	err = json.Unmarshal(data, &field)
+	if err != nil {
+		return err
+	}
+	if s.UnrecognizedFields == nil {
+		s.UnrecognizedFields = map[string]any{}
+	}
+	s.UnrecognizedFields[name] = field
+	return nil
+}
+
+// verifyTlog checks the signature is included in a trusted log if the
+// policy requires it
+func verifyTlog(policy *TlogPolicy, key *Key, sig Signature, payload []byte) error {
+	if policy == nil || !policy.Required {
+		return nil
+	}
+	entry, err := sig.TlogEntry()
+	if err != nil {
+		return err
+	}
+	publicKey, ok := policy.Logs[entry.LogID]
+	if !ok {
+		return ErrValue{Msg: fmt.Sprintf("transparency log %s is not trusted", entry.LogID)}
+	}
+	origin, ok := policy.Origins[entry.LogID]
+	if !ok {
+		return ErrValue{Msg: fmt.Sprintf("no checkpoint origin configured for transparency log %s", entry.LogID)}
+	}
+	if entry.InclusionProof == nil {
+		return ErrValue{Msg: fmt.Sprintf("signature of key ID %s has no inclusion proof", sig.KeyID)}
+	}
+	err = entry.InclusionProof.Verify(entry.Body, origin, publicKey)
+	if err != nil {
+		return err
+	}
+	// the entry has to be the one of this signature
+	var logged HashedRekord
+	err = json.Unmarshal(entry.Body, &logged)
+	if err != nil {
+		return ErrValue{Msg: fmt.Sprintf("invalid transparency log entry: %s", err)}
+	}
+	expected := NewHashedRekord(payload, sig.Signature, nil)
+	if logged.Kind != expected.Kind || logged.Spec.Data.Hash != expected.Spec.Data.Hash || !bytes.Equal(logged.Spec.Signature.Content, sig.Signature) {
+		return ErrValue{Msg: "transparency log entry does not match the signature"}
+	}
+	// keyless signatures log their certificate which is checked on its own
+	if key.Type == KeyTypeSigstoreOIDC {
+		return nil
+	}
+	loggedKey, err := cryptoutils.UnmarshalPEMToPublicKey(logged.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return ErrValue{Msg: fmt.Sprintf("invalid public key in transparency log entry: %s", err)}
+	}
+	publicKeyOfKey, err := key.ToPublicKey()
+	if err != nil {
+		return err
+	}
+	if err := cryptoutils.EqualKeys(loggedKey, publicKeyOfKey); err != nil {
+		return ErrValue{Msg: "transparency log entry is for a different key"}
+	}
+	return nil
+}
+
+// Verify checks body is included in the tree committed to by the
+// checkpoint signed by the log with origin and publicKey
+func (p *InclusionProof) Verify(body []byte, origin string, publicKey crypto.PublicKey) error {
+	treeSize, rootHash, err := VerifyCheckpoint(p.Checkpoint, origin, publicKey)
+	if err != nil {
+		return err
+	}
+	if treeSize != p.TreeSize || hex.EncodeToString(rootHash) != p.RootHash {
+		return ErrValue{Msg: "inclusion proof does not match its checkpoint"}
+	}
+	if p.LogIndex < 0 || p.TreeSize < 0 {
+		return ErrValue{Msg: "invalid inclusion proof"}
+	}
+	hashes := make([][]byte, 0, len(p.Hashes))
+	for _, h := range p.Hashes {
+		decoded, err := hex.DecodeString(h)
+		if err != nil {
+			return ErrValue{Msg: fmt.Sprintf("invalid inclusion proof hash %s", h)}
+		}
+		hashes = append(hashes, decoded)
+	}
+	err = proof.VerifyInclusion(rfc6962.DefaultHasher, uint64(p.LogIndex), uint64(p.TreeSize), MerkleLeafHash(body), hashes, rootHash)
+	if err != nil {
+		return ErrValue{Msg: fmt.Sprintf("invalid inclusion proof: %s", err)}
+	}
+	return nil
+}
+
+// MerkleLeafHash returns the RFC 6962 hash of a leaf
+func MerkleLeafHash(data []byte) []byte {
+	return rfc6962.DefaultHasher.HashLeaf(data)
+}
+
+// MerkleNodeHash returns the RFC 6962 hash of an inner node
+func MerkleNodeHash(left []byte, right []byte) []byte {
+	return rfc6962.DefaultHasher.HashChildren(left, right)
+}
+
+// SignCheckpoint returns the signed note of a tree of size leaves with
+// rootHash, as published by Rekor
+func SignCheckpoint(origin string, size int64, rootHash []byte, signer signature.Signer) (string, error) {
+	publicKey, err := signer.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	logID, err := LogID(publicKey)
+	if err != nil {
+		return "", err
+	}
+	body := fmt.Sprintf("%s\n%d\n%s\n", origin, size, base64.StdEncoding.EncodeToString(rootHash))
+	sig, err := signer.SignMessage(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	// the signature is prefixed with the first 4 bytes of the log ID and
+	// named after the host name at the start of the origin, as Rekor does
+	hint, _ := hex.DecodeString(logID[:keyHintLen*2])
+	name, _, _ := strings.Cut(origin, " ")
+	return fmt.Sprintf("%s\n— %s %s\n", body, name, base64.StdEncoding.EncodeToString(append(hint, sig...))), nil
+}
+
+// keyHintLen is the length of the key hint of a checkpoint signature, the
+// first bytes of the log ID
+const keyHintLen = 4
+
+// VerifyCheckpoint checks the signed note of the log with origin and
+// publicKey and returns the size and root hash of the tree. Only the
+// signatures with the key hint of the log are verified
+func VerifyCheckpoint(checkpoint string, origin string, publicKey crypto.PublicKey) (int64, []byte, error) {
+	invalid := ErrValue{Msg: "invalid transparency log checkpoint"}
+	body, sigs, ok := strings.Cut(checkpoint, "\n\n")
+	if !ok {
+		return 0, nil, invalid
+	}
+	body += "\n"
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) < 3 {
+		return 0, nil, invalid
+	}
+	if lines[0] != origin {
+		return 0, nil, ErrValue{Msg: fmt.Sprintf("transparency log checkpoint has origin %q, expected %q", lines[0], origin)}
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, nil, invalid
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return 0, nil, invalid
+	}
+	logID, err := LogID(publicKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	hint, err := hex.DecodeString(logID[:keyHintLen*2])
+	if err != nil {
+		return 0, nil, err
+	}
+	verifier, err := signature.LoadVerifier(publicKey, crypto.SHA256)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(sigs, "\n"), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "— "))
+		if !strings.HasPrefix(line, "— ") || len(fields) != 2 {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(sig) <= keyHintLen || !bytes.Equal(sig[:keyHintLen], hint) {
+			continue
+		}
+		if verifier.VerifySignature(bytes.NewReader(sig[keyHintLen:]), strings.NewReader(body)) == nil {
+			return size, rootHash, nil
+		}
+	}
+	return 0, nil, ErrValue{Msg: "transparency log checkpoint is not signed by the log"}
+}
@@ -0,0 +1,172 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package tlog
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"github.com/sigstore/sigstore/pkg/signature"
+)
+
+// Memory is an in-memory transparency log which behaves like Rekor, e.g.
+// for tests. Its signing key is generated when it is created
+type Memory struct {
+	mu     sync.Mutex
+	origin string
+	logID  string
+	signer signature.Signer
+	public crypto.PublicKey
+	// leaves are the leaf hashes of the entries
+	leaves [][]byte
+	// Now returns the integrated time of new entries, time.Now if nil
+	Now func() time.Time
+}
+
+// NewMemory returns an empty log named origin
+func NewMemory(origin string) (*Memory, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := signature.LoadSigner(private, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	logID, err := metadata.LogID(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Memory{origin: origin, logID: logID, signer: signer, public: private.Public()}, nil
+}
+
+// PublicKey returns the public key of the log
+func (m *Memory) PublicKey() crypto.PublicKey {
+	return m.public
+}
+
+// Origin returns the origin of the checkpoints of the log
+func (m *Memory) Origin() string {
+	return m.origin
+}
+
+// Size returns the number of entries in the log
+func (m *Memory) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.leaves))
+}
+
+// Upload adds entry to the log
+func (m *Memory) Upload(_ context.Context, entry *metadata.HashedRekord) (*metadata.TlogEntry, error) {
+	body, err := cjson.EncodeCanonical(entry)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if m.Now != nil {
+		now = m.Now
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := &metadata.TlogEntry{
+		LogID:          m.logID,
+		LogIndex:       int64(len(m.leaves)),
+		IntegratedTime: now().Unix(),
+		Body:           body,
+	}
+	m.leaves = append(m.leaves, metadata.MerkleLeafHash(body))
+	payload, err := metadata.SignedEntryTimestampPayload(res)
+	if err != nil {
+		return nil, err
+	}
+	res.SignedEntryTimestamp, err = m.signer.SignMessage(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	res.InclusionProof, err = m.inclusionProof(res.LogIndex)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// InclusionProof returns the proof of the entry at index for the current
+// tree
+func (m *Memory) InclusionProof(index int64) (*metadata.InclusionProof, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inclusionProof(index)
+}
+
+// inclusionProof returns the proof of the entry at index, m.mu is held
+func (m *Memory) inclusionProof(index int64) (*metadata.InclusionProof, error) {
+	if index < 0 || index >= int64(len(m.leaves)) {
+		return nil, metadata.ErrValue{Msg: fmt.Sprintf("no entry with index %d in the log", index)}
+	}
+	rootHash := merkleRoot(m.leaves)
+	checkpoint, err := metadata.SignCheckpoint(m.origin, int64(len(m.leaves)), rootHash, m.signer)
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for _, h := range merklePath(index, m.leaves) {
+		hashes = append(hashes, hex.EncodeToString(h))
+	}
+	return &metadata.InclusionProof{
+		LogIndex:   index,
+		TreeSize:   int64(len(m.leaves)),
+		RootHash:   hex.EncodeToString(rootHash),
+		Hashes:     hashes,
+		Checkpoint: checkpoint,
+	}, nil
+}
+
+// merkleRoot returns the RFC 6962 root hash of a non-empty tree
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return metadata.MerkleNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merklePath returns the RFC 6962 audit path of the leaf at index
+func merklePath(index int64, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if index < int64(k) {
+		return append(merklePath(index, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(index-int64(k), leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
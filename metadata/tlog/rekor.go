@@ -0,0 +1,105 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package tlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+)
+
+// RekorURL is the address of the public good Rekor instance
+const RekorURL = "https://rekor.sigstore.dev"
+
+// Rekor is a client of a Rekor transparency log
+type Rekor struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// Upload adds entry to the log
+func (r *Rekor) Upload(ctx context.Context, entry *metadata.HashedRekord) (*metadata.TlogEntry, error) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(r.URL, "/") + "/api/v1/log/entries"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("POST %s failed with status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	// the response maps the UUID of the new entry to the entry
+	var out map[string]struct {
+		Body           []byte `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+		Verification   struct {
+			SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+			InclusionProof       *struct {
+				LogIndex   int64    `json:"logIndex"`
+				TreeSize   int64    `json:"treeSize"`
+				RootHash   string   `json:"rootHash"`
+				Hashes     []string `json:"hashes"`
+				Checkpoint string   `json:"checkpoint"`
+			} `json:"inclusionProof"`
+		} `json:"verification"`
+	}
+	err = json.Unmarshal(data, &out)
+	if err != nil {
+		return nil, err
+	}
+	for _, logged := range out {
+		res := &metadata.TlogEntry{
+			LogID:                logged.LogID,
+			LogIndex:             logged.LogIndex,
+			IntegratedTime:       logged.IntegratedTime,
+			Body:                 logged.Body,
+			SignedEntryTimestamp: logged.Verification.SignedEntryTimestamp,
+		}
+		if proof := logged.Verification.InclusionProof; proof != nil {
+			res.InclusionProof = &metadata.InclusionProof{
+				LogIndex:   proof.LogIndex,
+				TreeSize:   proof.TreeSize,
+				RootHash:   proof.RootHash,
+				Hashes:     proof.Hashes,
+				Checkpoint: proof.Checkpoint,
+			}
+		}
+		return res, nil
+	}
+	return nil, metadata.ErrValue{Msg: "no entry in the Rekor response"}
+}
@@ -0,0 +1,65 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package tlog
+
+import (
+	"context"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+)
+
+// Log is a Rekor style transparency log
+type Log interface {
+	// Upload adds entry to the log and returns the logged entry together
+	// with its inclusion proof
+	Upload(ctx context.Context, entry *metadata.HashedRekord) (*metadata.TlogEntry, error)
+}
+
+// Recorder records the signatures of Metadata[T].Sign in a log and stores
+// the log entry in the tlog_entry field of the signature, see
+// metadata.WithSignatureRecorder. Rekor accepts entries of ECDSA and RSA
+// keys only
+type Recorder struct {
+	Log Log
+	// Context is used for the uploads, context.Background if nil
+	Context context.Context
+}
+
+// RecordSignature uploads the hashedrekord entry of sig made by key over
+// payload to the log
+func (r *Recorder) RecordSignature(sig *metadata.Signature, payload []byte, key *metadata.Key) error {
+	// keyless signers record their signatures themselves
+	if key.Type == metadata.KeyTypeSigstoreOIDC {
+		return nil
+	}
+	publicKey, err := key.ToPublicKey()
+	if err != nil {
+		return err
+	}
+	pemKey, err := cryptoutils.MarshalPublicKeyToPEM(publicKey)
+	if err != nil {
+		return err
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	entry, err := r.Log.Upload(ctx, metadata.NewHashedRekord(payload, sig.Signature, pemKey))
+	if err != nil {
+		return err
+	}
+	return sig.SetTlogEntry(entry)
+}
+
+// ensure the Recorder can be passed to metadata.WithSignatureRecorder
+var _ metadata.SignatureRecorder = (*Recorder)(nil)
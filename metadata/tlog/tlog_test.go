@@ -0,0 +1,330 @@
+// Copyright 2022-2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package tlog
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/keys"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+)
+
+// helperKey generates a key of keyType and returns it with its signer
+func helperKey(t *testing.T, keyType string) (*metadata.Key, signature.Signer) {
+	private, err := keys.Generate(keyType)
+	assert.NoError(t, err)
+	signer, err := private.Signer()
+	assert.NoError(t, err)
+	return private.Public, signer
+}
+
+// helperPolicy returns the option requiring inclusion proofs of log for all
+// signatures
+func helperPolicy(t *testing.T, log *Memory) metadata.VerifyOption {
+	policy := &metadata.TlogPolicy{Required: true}
+	assert.NoError(t, policy.AddLog(log.PublicKey(), log.Origin()))
+	return metadata.WithTlogPolicy(policy)
+}
+
+// helperSign signs targets metadata for a role delegated to key
+func helperSign(t *testing.T, key *metadata.Key, signer signature.Signer, opts ...metadata.SignOption) (*metadata.Metadata[metadata.RootType], *metadata.Metadata[metadata.TargetsType]) {
+	root := metadata.Root(time.Now().AddDate(0, 0, 1))
+	assert.NoError(t, root.Signed.AddKey(key, metadata.TARGETS))
+	targets := metadata.Targets(time.Now().AddDate(0, 0, 1))
+	_, err := targets.Sign(signer, opts...)
+	assert.NoError(t, err)
+	return root, targets
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	recorder := metadata.WithSignatureRecorder(&Recorder{Log: log})
+	for _, keyType := range []string{metadata.KeyTypeEd25519, metadata.KeyTypeECDSA_SHA2_P256, metadata.KeyTypeRSASSA_PSS_SHA256} {
+		key, signer := helperKey(t, keyType)
+		root, targets := helperSign(t, key, signer, recorder)
+		entry, err := targets.Signatures[0].TlogEntry()
+		assert.NoError(t, err)
+		assert.NotNil(t, entry.InclusionProof)
+		assert.Equal(t, log.Size()-1, entry.LogIndex)
+
+		// the entry survives a round trip and is verified when required
+		data, err := targets.ToBytes(false)
+		assert.NoError(t, err)
+		targets, err = metadata.Targets().FromBytes(data)
+		assert.NoError(t, err)
+		policy := helperPolicy(t, log)
+		assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, policy))
+	}
+	assert.Equal(t, int64(3), log.Size())
+}
+
+func TestVerifyWithoutEntry(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	root, targets := helperSign(t, key, signer)
+	_, err = targets.Signatures[0].TlogEntry()
+	assert.Error(t, err)
+
+	// signatures are only checked against the log if the policy requires it
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets))
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, metadata.WithTlogPolicy(&metadata.TlogPolicy{Required: false})))
+	policy := helperPolicy(t, log)
+	assert.ErrorContains(t, root.VerifyDelegate(metadata.TARGETS, targets, policy), "Verifying targets failed")
+}
+
+func TestVerifyUntrustedLog(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	other, err := NewMemory("other.log")
+	assert.NoError(t, err)
+	key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	root, targets := helperSign(t, key, signer, metadata.WithSignatureRecorder(&Recorder{Log: other}))
+	policy := helperPolicy(t, log)
+	assert.Error(t, root.VerifyDelegate(metadata.TARGETS, targets, policy))
+}
+
+func TestVerifyTamperedProof(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	recorder := &Recorder{Log: log}
+	// a few entries so the audit path is not empty
+	for i := 0; i < 4; i++ {
+		_, err := log.Upload(context.Background(), metadata.NewHashedRekord([]byte(fmt.Sprint(i)), []byte("sig"), []byte("key")))
+		assert.NoError(t, err)
+	}
+	key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	policy := helperPolicy(t, log)
+	tests := map[string]func(entry *metadata.TlogEntry){
+		"hash": func(entry *metadata.TlogEntry) {
+			entry.InclusionProof.Hashes[0] = hex.EncodeToString(make([]byte, sha256.Size))
+		},
+		"index": func(entry *metadata.TlogEntry) {
+			entry.InclusionProof.LogIndex--
+		},
+		"root hash": func(entry *metadata.TlogEntry) {
+			entry.InclusionProof.RootHash = hex.EncodeToString(make([]byte, sha256.Size))
+		},
+		"checkpoint": func(entry *metadata.TlogEntry) {
+			entry.InclusionProof.Checkpoint = entry.InclusionProof.Checkpoint[:len(entry.InclusionProof.Checkpoint)-8] + "AAAAAAA\n"
+		},
+		"no proof": func(entry *metadata.TlogEntry) {
+			entry.InclusionProof = nil
+		},
+		"body": func(entry *metadata.TlogEntry) {
+			other, err := log.Upload(context.Background(), metadata.NewHashedRekord([]byte("other"), []byte("sig"), []byte("key")))
+			assert.NoError(t, err)
+			*entry = *other
+		},
+	}
+	for name, tamper := range tests {
+		root, targets := helperSign(t, key, signer, metadata.WithSignatureRecorder(recorder))
+		assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, policy), name)
+		entry, err := targets.Signatures[0].TlogEntry()
+		assert.NoError(t, err)
+		tamper(entry)
+		assert.NoError(t, targets.Signatures[0].SetTlogEntry(entry))
+		assert.Error(t, root.VerifyDelegate(metadata.TARGETS, targets, policy), name)
+	}
+}
+
+func TestVerifyMismatchedKey(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	other, _ := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	root, targets := helperSign(t, key, signer)
+	payload, err := targets.Signed.MarshalJSON()
+	assert.NoError(t, err)
+	sig := &targets.Signatures[0]
+	// log the signature with another public key
+	publicKey, err := other.ToPublicKey()
+	assert.NoError(t, err)
+	pemKey, err := cryptoutils.MarshalPublicKeyToPEM(publicKey)
+	assert.NoError(t, err)
+	entry, err := log.Upload(context.Background(), metadata.NewHashedRekord(payload, sig.Signature, pemKey))
+	assert.NoError(t, err)
+	assert.NoError(t, sig.SetTlogEntry(entry))
+	policy := helperPolicy(t, log)
+	assert.Error(t, root.VerifyDelegate(metadata.TARGETS, targets, policy))
+
+	// the same entry made with the right key verifies
+	_, targets = helperSign(t, key, signer, metadata.WithSignatureRecorder(&Recorder{Log: log}))
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, policy))
+}
+
+func TestMerkleProofs(t *testing.T) {
+	leaves := [][]byte{}
+	for size := 1; size <= 17; size++ {
+		leaves = append(leaves, metadata.MerkleLeafHash([]byte(fmt.Sprint(size))))
+		root := merkleRoot(leaves)
+		for index := int64(0); index < int64(size); index++ {
+			path := merklePath(index, leaves)
+			assert.NoError(t, proof.VerifyInclusion(rfc6962.DefaultHasher, uint64(index), uint64(size), leaves[index], path, root), "size %d index %d", size, index)
+			// a proof is only valid for its leaf and index
+			assert.Error(t, proof.VerifyInclusion(rfc6962.DefaultHasher, uint64(index), uint64(size), metadata.MerkleLeafHash([]byte("x")), path, root))
+			if size > 1 {
+				assert.Error(t, proof.VerifyInclusion(rfc6962.DefaultHasher, uint64((index+1)%int64(size)), uint64(size), leaves[index], path, root))
+			}
+		}
+	}
+	// RFC 6962 root of two leaves
+	assert.Equal(t, metadata.MerkleNodeHash(leaves[0], leaves[1]), merkleRoot(leaves[:2]))
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	publicKey, err := key.ToPublicKey()
+	assert.NoError(t, err)
+	rootHash := metadata.MerkleLeafHash([]byte("root"))
+	checkpoint, err := metadata.SignCheckpoint("log.example.com - 1", 3, rootHash, signer)
+	assert.NoError(t, err)
+	assert.Contains(t, checkpoint, "\n— log.example.com ")
+	size, got, err := metadata.VerifyCheckpoint(checkpoint, "log.example.com - 1", publicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+	assert.Equal(t, rootHash, got)
+
+	// the checkpoint has to be of the trusted log
+	_, _, err = metadata.VerifyCheckpoint(checkpoint, "log.example.com - 2", publicKey)
+	assert.ErrorContains(t, err, `transparency log checkpoint has origin "log.example.com - 1", expected "log.example.com - 2"`)
+
+	// signatures with the key hint of another key are not verified
+	body, line, _ := strings.Cut(checkpoint, "\n— ")
+	fields := strings.Fields(line)
+	sig, err := base64.StdEncoding.DecodeString(fields[1])
+	assert.NoError(t, err)
+	sig[0] ^= 0xff
+	tampered := fmt.Sprintf("%s\n— %s %s\n", body, fields[0], base64.StdEncoding.EncodeToString(sig))
+	_, _, err = metadata.VerifyCheckpoint(tampered, "log.example.com - 1", publicKey)
+	assert.ErrorContains(t, err, "transparency log checkpoint is not signed by the log")
+
+	// a trusted log needs its origin
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	root, targets := helperSign(t, key, signer, metadata.WithSignatureRecorder(&Recorder{Log: log}))
+	logID, err := metadata.LogID(log.PublicKey())
+	assert.NoError(t, err)
+	policy := &metadata.TlogPolicy{Required: true, Logs: map[string]crypto.PublicKey{logID: log.PublicKey()}}
+	err = root.VerifyDelegate(metadata.TARGETS, targets, metadata.WithTlogPolicy(policy))
+	assert.ErrorContains(t, err, "Verifying targets failed")
+	policy.Origins = map[string]string{logID: log.Origin()}
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, metadata.WithTlogPolicy(policy)))
+}
+
+func TestMemoryInclusionProof(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	_, err = log.InclusionProof(0)
+	assert.Error(t, err)
+	bodies := [][]byte{}
+	for i := 0; i < 5; i++ {
+		entry, err := log.Upload(context.Background(), metadata.NewHashedRekord([]byte(fmt.Sprint(i)), []byte("sig"), []byte("key")))
+		assert.NoError(t, err)
+		assert.NoError(t, entry.InclusionProof.Verify(entry.Body, log.Origin(), log.PublicKey()))
+		bodies = append(bodies, entry.Body)
+	}
+	// proofs of old entries for the current tree
+	for i, body := range bodies {
+		proof, err := log.InclusionProof(int64(i))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), proof.TreeSize)
+		assert.NoError(t, proof.Verify(body, log.Origin(), log.PublicKey()))
+		assert.Error(t, proof.Verify(bodies[(i+1)%len(bodies)], log.Origin(), log.PublicKey()))
+	}
+	other, err := NewMemory("other.log")
+	assert.NoError(t, err)
+	proof, err := log.InclusionProof(0)
+	assert.NoError(t, err)
+	assert.Error(t, proof.Verify(bodies[0], other.Origin(), other.PublicKey()))
+}
+
+func TestRepositorySignOptions(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	expires := time.Now().AddDate(0, 0, 7).UTC()
+	repo := repository.New()
+	repo.SetRoot(metadata.Root(expires))
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(expires))
+	for _, name := range []string{metadata.ROOT, metadata.TARGETS} {
+		key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+		assert.NoError(t, repo.Root().Signed.AddKey(key, name))
+		repo.AddSigner(name, signer)
+	}
+	repo.SetSignOptions(metadata.WithSignatureRecorder(&Recorder{Log: log}))
+	assert.NoError(t, repo.Sign(metadata.ROOT))
+	assert.NoError(t, repo.Sign(metadata.TARGETS))
+	assert.Equal(t, int64(2), log.Size())
+	policy := helperPolicy(t, log)
+	assert.NoError(t, repo.Root().VerifyDelegate(metadata.ROOT, repo.Root(), policy))
+	assert.NoError(t, repo.Root().VerifyDelegate(metadata.TARGETS, repo.Targets(metadata.TARGETS), policy))
+}
+
+func TestRekorUpload(t *testing.T) {
+	log, err := NewMemory("test.log")
+	assert.NoError(t, err)
+	// a Rekor backed by the in-memory log
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/log/entries", r.URL.Path)
+		var entry metadata.HashedRekord
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&entry))
+		logged, err := log.Upload(r.Context(), &entry)
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"24296fb24b8ad77a": map[string]any{
+			"body":           logged.Body,
+			"integratedTime": logged.IntegratedTime,
+			"logID":          logged.LogID,
+			"logIndex":       logged.LogIndex,
+			"verification": map[string]any{
+				"signedEntryTimestamp": logged.SignedEntryTimestamp,
+				"inclusionProof": map[string]any{
+					"checkpoint": logged.InclusionProof.Checkpoint,
+					"hashes":     logged.InclusionProof.Hashes,
+					"logIndex":   logged.InclusionProof.LogIndex,
+					"rootHash":   logged.InclusionProof.RootHash,
+					"treeSize":   logged.InclusionProof.TreeSize,
+				},
+			},
+		}})
+	}))
+	defer srv.Close()
+	key, signer := helperKey(t, metadata.KeyTypeECDSA_SHA2_P256)
+	root, targets := helperSign(t, key, signer, metadata.WithSignatureRecorder(&Recorder{Log: &Rekor{URL: srv.URL}}))
+	policy := helperPolicy(t, log)
+	assert.NoError(t, root.VerifyDelegate(metadata.TARGETS, targets, policy))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry already exists", http.StatusConflict)
+	}))
+	defer failing.Close()
+	_, err = targets.Sign(signer, metadata.WithSignatureRecorder(&Recorder{Log: &Rekor{URL: failing.URL}}))
+	assert.ErrorContains(t, err, "entry already exists")
+}
@@ -26,16 +26,20 @@ type TrustedMetadata struct {
 	Timestamp *metadata.Metadata[metadata.TimestampType]
 	Targets   map[string]*metadata.Metadata[metadata.TargetsType]
 	RefTime   time.Time
+	// verifyOpts are passed to every Metadata[T].VerifyDelegate call
+	verifyOpts []metadata.VerifyOption
 }
 
 // New creates a new TrustedMetadata instance which ensures that the
 // collection of metadata in it is valid and trusted through the whole
 // client update workflow. It provides easy ways to update the metadata
-// with the caller making decisions on what is updated
-func New(rootData []byte) (*TrustedMetadata, error) {
+// with the caller making decisions on what is updated. The signatures are
+// verified with opts
+func New(rootData []byte, opts ...metadata.VerifyOption) (*TrustedMetadata, error) {
 	res := &TrustedMetadata{
-		Targets: map[string]*metadata.Metadata[metadata.TargetsType]{},
-		RefTime: time.Now().UTC(),
+		Targets:    map[string]*metadata.Metadata[metadata.TargetsType]{},
+		RefTime:    time.Now().UTC(),
+		verifyOpts: opts,
 	}
 	// load and validate the local root metadata
 	// valid initial trusted root metadata is required
@@ -64,7 +68,7 @@ func (trusted *TrustedMetadata) UpdateRoot(rootData []byte) (*metadata.Metadata[
 		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.ROOT, newRoot.Signed.Type)}
 	}
 	// verify that new root is signed by trusted root
-	err = trusted.Root.VerifyDelegate(metadata.ROOT, newRoot)
+	err = trusted.Root.VerifyDelegate(metadata.ROOT, newRoot, trusted.verifyOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +77,7 @@ func (trusted *TrustedMetadata) UpdateRoot(rootData []byte) (*metadata.Metadata[
 		return nil, metadata.ErrBadVersionNumber{Msg: fmt.Sprintf("bad version number, expected %d, got %d", trusted.Root.Signed.Version+1, newRoot.Signed.Version)}
 	}
 	// verify that new root is signed by itself
-	err = newRoot.VerifyDelegate(metadata.ROOT, newRoot)
+	err = newRoot.VerifyDelegate(metadata.ROOT, newRoot, trusted.verifyOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +113,7 @@ func (trusted *TrustedMetadata) UpdateTimestamp(timestampData []byte) (*metadata
 		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.TIMESTAMP, newTimestamp.Signed.Type)}
 	}
 	// verify that new timestamp is signed by trusted root
-	err = trusted.Root.VerifyDelegate(metadata.TIMESTAMP, newTimestamp)
+	err = trusted.Root.VerifyDelegate(metadata.TIMESTAMP, newTimestamp, trusted.verifyOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -195,7 +199,7 @@ func (trusted *TrustedMetadata) UpdateSnapshot(snapshotData []byte, isTrusted bo
 		return nil, metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.SNAPSHOT, newSnapshot.Signed.Type)}
 	}
 	// verify that new snapshot is signed by trusted root
-	err = trusted.Root.VerifyDelegate(metadata.SNAPSHOT, newSnapshot)
+	err = trusted.Root.VerifyDelegate(metadata.SNAPSHOT, newSnapshot, trusted.verifyOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -294,12 +298,12 @@ func (trusted *TrustedMetadata) UpdateDelegatedTargets(targetsData []byte, roleN
 	}
 	// get delegator metadata and verify the new delegatee
 	if delegatorName == metadata.ROOT {
-		err = trusted.Root.VerifyDelegate(roleName, newDelegate)
+		err = trusted.Root.VerifyDelegate(roleName, newDelegate, trusted.verifyOpts...)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		err = trusted.Targets[delegatorName].VerifyDelegate(roleName, newDelegate)
+		err = trusted.Targets[delegatorName].VerifyDelegate(roleName, newDelegate, trusted.verifyOpts...)
 		if err != nil {
 			return nil, err
 		}
@@ -331,7 +335,7 @@ func (trusted *TrustedMetadata) loadTrustedRoot(rootData []byte) error {
 		return metadata.ErrRepository{Msg: fmt.Sprintf("expected %s, got %s", metadata.ROOT, newRoot.Signed.Type)}
 	}
 	// verify root by itself
-	err = newRoot.VerifyDelegate(metadata.ROOT, newRoot)
+	err = newRoot.VerifyDelegate(metadata.ROOT, newRoot, trusted.verifyOpts...)
 	if err != nil {
 		return err
 	}
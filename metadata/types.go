@@ -105,7 +105,11 @@ type Key struct {
 }
 
 type KeyVal struct {
-	PublicKey          string         `json:"public"`
+	PublicKey string `json:"public"`
+	// Identity and Issuer identify the signers of sigstore-oidc keys which
+	// have no public key
+	Identity           string         `json:"identity,omitempty"`
+	Issuer             string         `json:"issuer,omitempty"`
 	UnrecognizedFields map[string]any `json:"-"`
 }
 
@@ -156,6 +160,10 @@ type DelegatedRole struct {
 	UnrecognizedFields map[string]any `json:"-"`
 }
 
+// MaxBitLength is the largest bit length of succinct roles, so metadata
+// can't make a client enumerate more than 2^16 delegated roles
+const MaxBitLength = 16
+
 // SuccinctRoles represents a delegation graph that covers all targets,
 // distributing them uniformly over the delegated roles (i.e. bins) in the graph.
 type SuccinctRoles struct {
@@ -16,15 +16,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/rdimitrov/go-tuf-metadata/metadata"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/fetcher"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/storage"
 	"github.com/rdimitrov/go-tuf-metadata/metadata/trustedmetadata"
 	log "github.com/sirupsen/logrus"
 )
@@ -54,6 +56,8 @@ import (
 type Updater struct {
 	trusted *trustedmetadata.TrustedMetadata
 	cfg     *config.UpdaterConfig
+	storage storage.Storage
+	fetcher fetcher.Fetcher
 }
 
 type roleParentTuple struct {
@@ -68,7 +72,7 @@ func New(config *config.UpdaterConfig) (*Updater, error) {
 		return nil, fmt.Errorf("no initial trusted root metadata or remote URL provided")
 	}
 	// create a new trusted metadata instance using the trusted root.json
-	trustedMetadataSet, err := trustedmetadata.New(config.LocalTrustedRoot)
+	trustedMetadataSet, err := trustedmetadata.New(config.LocalTrustedRoot, config.VerifyOptions()...)
 	if err != nil {
 		return nil, err
 	}
@@ -76,11 +80,18 @@ func New(config *config.UpdaterConfig) (*Updater, error) {
 	updater := &Updater{
 		cfg:     config,
 		trusted: trustedMetadataSet, // save trusted metadata set
-	}
-	// ensure paths exist, doesn't do anything if caching is disabled
-	err = updater.cfg.EnsurePathsExist()
-	if err != nil {
-		return nil, err
+		fetcher: fetcher.Chain(config.Fetcher, config.FetcherMiddlewares...),
+	}
+	// set up the local cache, doesn't do anything if caching is disabled
+	if !updater.cfg.DisableLocalCache {
+		updater.storage = updater.cfg.Storage
+		if updater.storage == nil {
+			// default to the file system, this also ensures the paths exist
+			updater.storage, err = storage.NewFileSystem(updater.cfg.LocalMetadataDir, updater.cfg.LocalTargetsDir)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	// persist the initial root metadata to the local metadata folder
 	err = updater.persistMetadata(metadata.ROOT, updater.cfg.LocalTrustedRoot)
@@ -140,22 +151,17 @@ func (update *Updater) GetTargetInfo(targetPath string) (*metadata.TargetFiles,
 	return update.preOrderDepthFirstWalk(targetPath)
 }
 
-// DownloadTarget downloads the target file specified by targetFile
+// DownloadTarget downloads the target file specified by targetFile.
+// If filePath is empty the target file is stored in the local cache,
+// otherwise it is written to filePath
 func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath, targetBaseURL string) (string, []byte, error) {
-	var err error
-	if filePath == "" {
-		filePath, err = update.generateTargetFilePath(targetFile)
-		if err != nil {
-			return "", nil, err
-		}
-	}
+	// mirrors are used only if no explicit targetBaseURL is given
+	targetBaseURLs := []string{targetBaseURL}
 	if targetBaseURL == "" {
 		if update.cfg.RemoteTargetsURL == "" {
 			return "", nil, metadata.ErrValue{Msg: "targetBaseURL must be set in either DownloadTarget() or the Updater struct"}
 		}
-		targetBaseURL = ensureTrailingSlash(update.cfg.RemoteTargetsURL)
-	} else {
-		targetBaseURL = ensureTrailingSlash(targetBaseURL)
+		targetBaseURLs = append([]string{update.cfg.RemoteTargetsURL}, update.cfg.RemoteTargetsMirrors...)
 	}
 	targetFilePath := targetFile.Path
 	consistentSnapshot := update.trusted.Root.Signed.ConsistentSnapshot
@@ -175,8 +181,7 @@ func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath
 			targetFilePath = fmt.Sprintf("%s/%s.%s", dirName, hashes, baseName)
 		}
 	}
-	fullURL := fmt.Sprintf("%s%s", targetBaseURL, targetFilePath)
-	data, err := update.cfg.Fetcher.DownloadFile(fullURL, targetFile.Length)
+	data, err := update.downloadFromMirrors(targetBaseURLs, targetFilePath, targetFile.Length)
 	if err != nil {
 		return "", nil, err
 	}
@@ -187,7 +192,17 @@ func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath
 
 	// do not persist the target file if cache is disabled
 	if !update.cfg.DisableLocalCache {
-		err = os.WriteFile(filePath, data, 0644)
+		if filePath == "" && update.cfg.TargetCache != nil {
+			err = update.cfg.TargetCache.Put(targetFile, data)
+			if err == nil {
+				filePath, err = update.cfg.TargetCache.Location(targetFile.Hashes)
+			}
+		} else if filePath == "" {
+			err = update.storage.WriteTarget(targetFile.Path, data)
+			filePath = update.storage.TargetLocation(targetFile.Path)
+		} else {
+			err = storage.WriteFileAtomic(filePath, data, 0644)
+		}
 		if err != nil {
 			return "", nil, err
 		}
@@ -199,22 +214,31 @@ func (update *Updater) DownloadTarget(targetFile *metadata.TargetFiles, filePath
 // FindCachedTarget checks whether a local file is an up to date target
 func (update *Updater) FindCachedTarget(targetFile *metadata.TargetFiles, filePath string) (string, []byte, error) {
 	var err error
+	var data []byte
 	targetFilePath := ""
 	// do not look for cached target file if cache is disabled
 	if update.cfg.DisableLocalCache {
 		return "", nil, nil
 	}
-	// get its path if not provided
-	if filePath == "" {
-		targetFilePath, err = update.generateTargetFilePath(targetFile)
+	// get file content either from the local cache or from the provided path
+	if filePath == "" && update.cfg.TargetCache != nil {
+		// the content-addressed cache verifies the content on its own
+		data, err = update.cfg.TargetCache.Get(targetFile)
+		if err != nil {
+			return "", nil, nil
+		}
+		targetFilePath, err = update.cfg.TargetCache.Location(targetFile.Hashes)
 		if err != nil {
 			return "", nil, err
 		}
+		return targetFilePath, data, nil
+	} else if filePath == "" {
+		targetFilePath = update.storage.TargetLocation(targetFile.Path)
+		data, err = update.storage.ReadTarget(targetFile.Path)
 	} else {
 		targetFilePath = filePath
+		data, err = readFile(targetFilePath)
 	}
-	// get file content
-	data, err := readFile(targetFilePath)
 	if err != nil {
 		// do not want to return err, instead we say that there's no cached target available
 		return "", nil, nil
@@ -232,7 +256,7 @@ func (update *Updater) FindCachedTarget(targetFile *metadata.TargetFiles, filePa
 // loadTimestamp load local and remote timestamp metadata
 func (update *Updater) loadTimestamp() error {
 	// try to read local timestamp
-	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.TIMESTAMP))
+	data, err := update.loadLocalMetadata(metadata.TIMESTAMP)
 	if err != nil {
 		// this means there's no existing local timestamp so we should proceed downloading it without the need to UpdateTimestamp
 		log.Debug("Local timestamp does not exist")
@@ -279,7 +303,7 @@ func (update *Updater) loadTimestamp() error {
 // loadSnapshot load local (and if needed remote) snapshot metadata
 func (update *Updater) loadSnapshot() error {
 	// try to read local snapshot
-	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, metadata.SNAPSHOT))
+	data, err := update.loadLocalMetadata(metadata.SNAPSHOT)
 	if err != nil {
 		// this means there's no existing local snapshot so we should proceed downloading it without the need to UpdateSnapshot
 		log.Debug("Local snapshot does not exist")
@@ -336,6 +360,48 @@ func (update *Updater) loadSnapshot() error {
 	return nil
 }
 
+// LoadAllTargets loads the targets metadata of every delegated role
+// reachable from the top-level targets, so the trusted metadata set lists
+// all target files of the repository. Refresh() has to be called first
+func (update *Updater) LoadAllTargets() error {
+	if update.trusted.Snapshot == nil {
+		return fmt.Errorf("trusted snapshot not set")
+	}
+	visited := map[string]bool{}
+	toVisit := []roleParentTuple{{Role: metadata.TARGETS, Parent: metadata.ROOT}}
+	for len(toVisit) > 0 {
+		delegation := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		// skip visited roles to prevent cycles
+		if visited[delegation.Role] {
+			continue
+		}
+		visited[delegation.Role] = true
+		// only roles listed in the trusted snapshot can be loaded
+		if _, ok := update.trusted.Snapshot.Signed.Meta[fmt.Sprintf("%s.json", delegation.Role)]; !ok {
+			return fmt.Errorf("role %s delegated by %s is not listed in snapshot", delegation.Role, delegation.Parent)
+		}
+		targets, err := update.loadTargets(delegation.Role, delegation.Parent)
+		if err != nil {
+			return err
+		}
+		delegations := targets.Signed.Delegations
+		if delegations == nil {
+			continue
+		}
+		for _, role := range delegations.Roles {
+			toVisit = append(toVisit, roleParentTuple{Role: role.Name, Parent: delegation.Role})
+		}
+		if delegations.SuccinctRoles != nil {
+			for _, name := range delegations.SuccinctRoles.GetRoles() {
+				toVisit = append(toVisit, roleParentTuple{Role: name, Parent: delegation.Role})
+			}
+		}
+	}
+	log.Debugf("Loaded the targets metadata of %d roles", len(visited))
+	return nil
+}
+
 // loadTargets load local (and if needed remote) metadata for roleName
 func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metadata[metadata.TargetsType], error) {
 	// avoid loading "roleName" more than once during "GetTargetInfo"
@@ -344,7 +410,7 @@ func (update *Updater) loadTargets(roleName, parentName string) (*metadata.Metad
 		return role, nil
 	}
 	// try to read local targets
-	data, err := update.loadLocalMetadata(filepath.Join(update.cfg.LocalMetadataDir, roleName))
+	data, err := update.loadLocalMetadata(roleName)
 	if err != nil {
 		// this means there's no existing local target file so we should proceed downloading it without the need to UpdateDelegatedTargets
 		log.Debugf("Local %s does not exist", roleName)
@@ -506,66 +572,61 @@ func (update *Updater) preOrderDepthFirstWalk(targetFilePath string) (*metadata.
 	return nil, fmt.Errorf("target %s not found", targetFilePath)
 }
 
-// persistMetadata writes metadata to disk atomically to avoid data loss
+// persistMetadata writes metadata to the local cache, the storage
+// implementation makes sure this is done atomically to avoid data loss
 func (update *Updater) persistMetadata(roleName string, data []byte) error {
 	// do not persist the metadata if we have disabled local caching
 	if update.cfg.DisableLocalCache {
 		return nil
 	}
 	// caching enabled, proceed with persisting the metadata locally
-	fileName := filepath.Join(update.cfg.LocalMetadataDir, fmt.Sprintf("%s.json", url.QueryEscape(roleName)))
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
-	}
-	// create a temporary file
-	file, err := os.CreateTemp(cwd, "tuf_tmp")
-	if err != nil {
-		return err
-	}
-	// write the data content to the temporary file
-	err = os.WriteFile(file.Name(), data, 0644)
-	if err != nil {
-		// delete the temporary file if there was an error while writing
-		errRemove := os.Remove(file.Name())
-		if errRemove != nil {
-			log.Debugf("Failed to delete temporary file: %s", file.Name())
-		}
-		return err
-	}
-	// if all okay, rename the temporary file to the desired one
-	err = os.Rename(file.Name(), fileName)
-	if err != nil {
-		return err
-	}
-	return nil
+	return update.storage.WriteMetadata(roleName, data)
 }
 
 // downloadMetadata download a metadata file and return it as bytes
 func (update *Updater) downloadMetadata(roleName string, length int64, version string) ([]byte, error) {
-	urlPath := ensureTrailingSlash(update.cfg.RemoteMetadataURL)
 	// build urlPath
-	if version == "" {
-		urlPath = fmt.Sprintf("%s%s.json", urlPath, url.QueryEscape(roleName))
-	} else {
-		urlPath = fmt.Sprintf("%s%s.%s.json", urlPath, version, url.QueryEscape(roleName))
+	urlPath := fmt.Sprintf("%s.json", url.QueryEscape(roleName))
+	if version != "" {
+		urlPath = fmt.Sprintf("%s.%s.json", version, url.QueryEscape(roleName))
 	}
-	return update.cfg.Fetcher.DownloadFile(urlPath, length)
+	baseURLs := append([]string{update.cfg.RemoteMetadataURL}, update.cfg.RemoteMetadataMirrors...)
+	return update.downloadFromMirrors(baseURLs, urlPath, length)
 }
 
-// generateTargetFilePath generates path from TargetFiles
-func (update *Updater) generateTargetFilePath(tf *metadata.TargetFiles) (string, error) {
-	// LocalTargetsDir can be omitted if caching is disabled
-	if update.cfg.LocalTargetsDir == "" && !update.cfg.DisableLocalCache {
-		return "", metadata.ErrValue{Msg: "LocalTargetsDir must be set if filepath is not given"}
-	}
-	// Use URL encoded target path as filename
-	return url.JoinPath(update.cfg.LocalTargetsDir, url.QueryEscape(tf.Path))
+// downloadFromMirrors downloads urlPath relative to each of the base URLs
+// in order and returns the first successful download. If all of them fail
+// it returns the first 404/403 error, so a missing file isn't masked by a
+// failing mirror, or the last error otherwise
+func (update *Updater) downloadFromMirrors(baseURLs []string, urlPath string, length int64) ([]byte, error) {
+	var err, notFound error
+	for _, baseURL := range baseURLs {
+		var data []byte
+		fullURL := fmt.Sprintf("%s%s", ensureTrailingSlash(baseURL), urlPath)
+		data, err = update.fetcher.DownloadFile(fullURL, length)
+		if err == nil {
+			return data, nil
+		}
+		log.Debugf("Failed to download %s: %v", fullURL, err)
+		var errHTTP metadata.ErrDownloadHTTP
+		if notFound == nil && errors.As(err, &errHTTP) &&
+			(errHTTP.StatusCode == http.StatusNotFound || errHTTP.StatusCode == http.StatusForbidden) {
+			notFound = err
+		}
+	}
+	if notFound != nil {
+		return nil, notFound
+	}
+	return nil, err
 }
 
-// loadLocalMetadata reads a local <roleName>.json file and returns its bytes
+// loadLocalMetadata reads the metadata for roleName from the local cache
 func (update *Updater) loadLocalMetadata(roleName string) ([]byte, error) {
-	return readFile(fmt.Sprintf("%s.json", roleName))
+	// there's nothing to load if we have disabled local caching
+	if update.cfg.DisableLocalCache {
+		return nil, fmt.Errorf("local cache is disabled: %w", fs.ErrNotExist)
+	}
+	return update.storage.ReadMetadata(roleName)
 }
 
 // GetTopLevelTargets returns the top-level target files
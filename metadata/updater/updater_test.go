@@ -0,0 +1,121 @@
+// Copyright 2023 VMware, Inc.
+//
+// This product is licensed to you under the BSD-2 license (the "License").
+// You may not use this product except in compliance with the BSD-2 License.
+// This product may include a number of subcomponents with separate copyright
+// notices and license terms. Your use of these subcomponents is subject to
+// the terms and conditions of the subcomponent's license, as noted in the
+// LICENSE file.
+//
+// SPDX-License-Identifier: BSD-2-Clause
+
+package updater_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rdimitrov/go-tuf-metadata/metadata"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/config"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/repository"
+	"github.com/rdimitrov/go-tuf-metadata/metadata/updater"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+// helperRepository writes a published repository with one ed25519 signer
+// per top-level role to a new directory and returns it
+func helperRepository(t *testing.T) string {
+	expires := time.Now().AddDate(0, 0, 7).UTC()
+	repo := repository.New()
+	repo.SetRoot(metadata.Root(expires))
+	repo.SetTargets(metadata.TARGETS, metadata.Targets(expires))
+	repo.SetSnapshot(metadata.Snapshot(expires))
+	repo.SetTimestamp(metadata.Timestamp(expires))
+	for _, name := range []string{metadata.ROOT, metadata.TARGETS, metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		_, private, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		key, err := metadata.KeyFromPublicKey(private.Public())
+		assert.NoError(t, err)
+		assert.NoError(t, repo.Root().Signed.AddKey(key, name))
+		signer, err := signature.LoadSigner(private, crypto.Hash(0))
+		assert.NoError(t, err)
+		repo.AddSigner(name, signer)
+	}
+	assert.NoError(t, repo.Sign(metadata.ROOT))
+	_, err := repo.AddTarget(metadata.TARGETS, "hello.txt", []byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Publish(metadata.TARGETS))
+	dir := t.TempDir()
+	assert.NoError(t, repo.Write(dir))
+	return dir
+}
+
+func TestRefreshMirrors(t *testing.T) {
+	dir := helperRepository(t)
+	rootBytes, err := os.ReadFile(filepath.Join(dir, repository.MetadataDir, "1.root.json"))
+	assert.NoError(t, err)
+	primary := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer primary.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	for _, tt := range []struct {
+		name    string
+		primary string
+		mirrors []string
+	}{
+		// the 404 of the next root version ends the root update even if
+		// the mirrors fail
+		{name: "failing mirror", primary: primary.URL, mirrors: []string{failing.URL}},
+		{name: "failing primary", primary: failing.URL, mirrors: []string{primary.URL}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.New(tt.primary+"/"+repository.MetadataDir, rootBytes)
+			assert.NoError(t, err)
+			cfg.DisableLocalCache = true
+			for _, mirror := range tt.mirrors {
+				cfg.RemoteMetadataMirrors = append(cfg.RemoteMetadataMirrors, mirror+"/"+repository.MetadataDir)
+			}
+			up, err := updater.New(cfg)
+			assert.NoError(t, err)
+			assert.NoError(t, up.Refresh())
+			targetInfo, err := up.GetTargetInfo("hello.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len("hello")), targetInfo.Length)
+		})
+	}
+}
+
+func TestVerifyPolicy(t *testing.T) {
+	dir := helperRepository(t)
+	rootBytes, err := os.ReadFile(filepath.Join(dir, repository.MetadataDir, "1.root.json"))
+	assert.NoError(t, err)
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+	newConfig := func() *config.UpdaterConfig {
+		cfg, err := config.New(srv.URL+"/"+repository.MetadataDir, rootBytes)
+		assert.NoError(t, err)
+		cfg.DisableLocalCache = true
+		return cfg
+	}
+
+	// the policy is the one of each updater's configuration
+	required := newConfig()
+	required.TlogPolicy = &metadata.TlogPolicy{Required: true}
+	_, err = updater.New(required)
+	assert.ErrorIs(t, err, metadata.ErrUnsignedMetadata{})
+	strict := newConfig()
+	strict.KeyIDMode = metadata.KeyIDModeStrict
+	up, err := updater.New(strict)
+	assert.NoError(t, err)
+	assert.NoError(t, up.Refresh())
+}